@@ -1,9 +0,0 @@
-package v1alpha3
-
-import cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
-
-// Config is an alias pointing to the actual v1alpha3.Config type.
-type Config = cfg.Config
-
-// Version is an alias pointing to the actual v1alpha3.Version type.
-const Version = cfg.Version
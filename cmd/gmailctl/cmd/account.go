@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// accountsDir is the directory, inside the config root, containing the
+// config directories of the named accounts.
+const accountsDir = "accounts"
+
+// accountConfigDir returns the config directory of the given account.
+//
+// Every account has its own configuration, credentials and token, stored in
+// a subdirectory of the config root. An empty account selects the config
+// root itself.
+func accountConfigDir(root, account string) (string, error) {
+	if account == "" {
+		return root, nil
+	}
+	if account == "." || account == ".." || strings.ContainsAny(account, `/\`) {
+		return "", fmt.Errorf("invalid account name %q", account)
+	}
+	return path.Join(root, accountsDir, account), nil
+}
+
+// checkAccount returns an error if the selected account was never
+// authenticated.
+func checkAccount() error {
+	if accountName == "" {
+		return nil
+	}
+	ok, err := authenticated(cfgDir)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.WithDetails(
+			fmt.Errorf("account %q has never been authenticated", accountName),
+			fmt.Sprintf("The account can be initialized with '%s'", initCommandLine()),
+		)
+	}
+	return nil
+}
+
+// authenticated returns whether the config directory has a token, if the
+// API provider can tell (see AuthChecker), or otherwise whether it exists.
+func authenticated(dir string) (bool, error) {
+	if c, ok := APIProvider.(AuthChecker); ok {
+		return c.Authenticated(dir)
+	}
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// initCommandLine returns the command initializing the selected account.
+func initCommandLine() string {
+	if accountName == "" {
+		return "gmailctl init"
+	}
+	return fmt.Sprintf("gmailctl init --account %s", accountName)
+}
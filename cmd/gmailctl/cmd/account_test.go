@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func TestAccountConfigDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		want    string
+		err     bool
+	}{
+		{name: "default", account: "", want: "/home/me/.gmailctl"},
+		{name: "named", account: "work", want: "/home/me/.gmailctl/accounts/work"},
+		{name: "dots in name", account: "client.example.com", want: "/home/me/.gmailctl/accounts/client.example.com"},
+		{name: "slash", account: "a/b", err: true},
+		{name: "backslash", account: `a\b`, err: true},
+		{name: "parent", account: "..", err: true},
+		{name: "current", account: ".", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := accountConfigDir("/home/me/.gmailctl", tc.account)
+			if tc.err {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCheckAccount(t *testing.T) {
+	root := t.TempDir()
+	oldDir, oldAccount := cfgDir, accountName
+	defer func() { cfgDir, accountName = oldDir, oldAccount }()
+
+	// The default account is never checked.
+	cfgDir, accountName = root, ""
+	assert.Nil(t, checkAccount())
+
+	accountName = "work"
+	var err error
+	cfgDir, err = accountConfigDir(root, accountName)
+	require.Nil(t, err)
+
+	err = checkAccount()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `account "work" has never been authenticated`)
+	assert.Contains(t, errors.Details(err), "gmailctl init --account work")
+
+	require.Nil(t, os.MkdirAll(path.Join(root, "accounts", "work"), 0700))
+	assert.Nil(t, checkAccount())
+}
+
+// tokenProvider is an API provider keeping the tokens in memory, by config
+// directory.
+type tokenProvider struct {
+	GmailAPIProvider
+	tokens map[string]bool
+}
+
+func (p tokenProvider) InitConfig(cfgDir string) error {
+	p.tokens[cfgDir] = true
+	return nil
+}
+
+func (p tokenProvider) Authenticated(cfgDir string) (bool, error) {
+	return p.tokens[cfgDir], nil
+}
+
+func TestCheckAccountToken(t *testing.T) {
+	root := t.TempDir()
+	oldDir, oldAccount, oldProvider := cfgDir, accountName, APIProvider
+	defer func() { cfgDir, accountName, APIProvider = oldDir, oldAccount, oldProvider }()
+	provider := tokenProvider{tokens: map[string]bool{}}
+	APIProvider = provider
+
+	selectAccount := func(name string) {
+		t.Helper()
+		var err error
+		accountName = name
+		cfgDir, err = accountConfigDir(root, name)
+		require.Nil(t, err)
+	}
+
+	// An existing directory without a token is not enough.
+	selectAccount("work")
+	require.Nil(t, os.MkdirAll(cfgDir, 0700))
+	err := checkAccount()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `account "work" has never been authenticated`)
+
+	// The token of an account doesn't authenticate the others.
+	require.Nil(t, provider.InitConfig(cfgDir))
+	assert.Nil(t, checkAccount())
+	selectAccount("home")
+	require.Nil(t, os.MkdirAll(cfgDir, 0700))
+	err = checkAccount()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `account "home" has never been authenticated`)
+	assert.Contains(t, errors.Details(err), "gmailctl init --account home")
+}
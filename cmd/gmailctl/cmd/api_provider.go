@@ -7,6 +7,7 @@ import (
 	"google.golang.org/api/gmail/v1"
 
 	"github.com/mbrt/gmailctl/internal/engine/api"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 // APIProvider is the APIProvider used by all gmailctl commands.
@@ -37,13 +38,65 @@ type TokenRefresher interface {
 	RefreshToken(ctx context.Context, cfgDir string) error
 }
 
+// MessagesAPIProvider is the interface implemented by API providers able to
+// authorize the modification of messages, needed to apply filters to the
+// existing ones.
+type MessagesAPIProvider interface {
+	// MessagesService returns a GMail API service allowed to modify
+	// messages, asking for the authorization if needed.
+	MessagesService(ctx context.Context, cfgDir string) (*gmail.Service, error)
+}
+
+// AuthChecker is the interface implemented by API providers able to tell
+// whether a config directory has been authenticated.
+type AuthChecker interface {
+	// Authenticated returns whether the token of the config directory is
+	// present.
+	Authenticated(cfgDir string) (bool, error)
+}
+
 func openAPI() (*api.GmailAPI, error) {
+	if err := checkAccount(); err != nil {
+		return nil, err
+	}
+	if apiRetries < 0 || apiBackoff < 0 {
+		return nil, errors.New("--api-retries and --api-backoff must not be negative")
+	}
 	srv, err := APIProvider.Service(context.Background(), cfgDir)
 	if err != nil {
-		return nil, fmt.Errorf("in Authenticator.Service: %w", err)
+		err = fmt.Errorf("in Authenticator.Service: %w", err)
+		if accountName != "" {
+			err = errors.WithDetails(
+				fmt.Errorf("account %q: %w", accountName, err),
+				fmt.Sprintf("Make sure the account is authenticated with '%s'", initCommandLine()),
+			)
+		}
+		return nil, err
 	}
+	return newAPI(srv), nil
+}
+
+// openMessagesAPI is like openAPI, but the API is also allowed to modify
+// messages.
+func openMessagesAPI() (*api.GmailAPI, error) {
+	mprov, ok := APIProvider.(MessagesAPIProvider)
+	if !ok {
+		return nil, errors.New("the API provider doesn't support modifying messages")
+	}
+	srv, err := mprov.MessagesService(context.Background(), cfgDir)
+	if err != nil {
+		return nil, fmt.Errorf("in Authenticator.MessagesService: %w", err)
+	}
+	return newAPI(srv), nil
+}
+
+func newAPI(srv *gmail.Service) *api.GmailAPI {
+	var res *api.GmailAPI
 	if kprov, ok := APIProvider.(APIKeyProvider); ok {
-		return api.NewWithAPIKey(srv, kprov.APIKey()), nil
+		res = api.NewWithAPIKey(srv, kprov.APIKey())
+	} else {
+		res = api.NewFromService(srv)
 	}
-	return api.NewFromService(srv), nil
+	res.SetRetries(apiRetries, apiBackoff)
+	return res
 }
@@ -1,19 +1,43 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/mbrt/gmailctl"
+	"github.com/mbrt/gmailctl/internal/engine/api"
 	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
 	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 var (
 	applyFilename     string
 	applyYes          bool
+	applyInteractive  bool
 	applyRemoveLabels bool
+	applyPruneLabels  bool
 	applySkipTests    bool
+	applyDryRun       bool
+	applyOut          string
+	applyBatchSize    int
+	applyRate         float64
+	applyStrictCase   bool
+	applyOnlyFilters  bool
+	applyConfirmOver  int
+	applyPruneMatch   string
+	applyMaxFilters   int
+	applyQuiet        bool
+	applyToExisting   bool
+	applyPlan         string
 )
 
 const renameLabelWarning = `Warning: You are going to delete labels. This operation is
@@ -30,14 +54,120 @@ var applyCmd = &cobra.Command{
 	Long: `The apply command applies minimal changes to your Gmail settings
 to make them match your local configuration file.
 
+With --prune-labels, after the changes are applied, labels that are
+not referenced by the configuration and contain no messages are
+deleted. Labels still applied to some messages are kept.
+
+With --interactive, every filter to be added or removed is shown and
+has to be approved individually: answer 'y' to apply it, 'n' to skip
+it, or 'q' to skip it and all the remaining ones. Label changes are not
+asked for. The skipped changes are listed at the end, and a following
+apply shows them again.
+
+With --dry-run, nothing is changed: the ordered list of Gmail API
+operations that would be performed is written as JSON to the file
+given by --out, for auditing purposes.
+
+With --plan, the operations of a plan written by --dry-run are executed
+as they are, without reading the configuration. This allows reviewing
+a plan before applying it. The plan records the Gmail settings it was
+made for: if they changed in the meantime, nothing is applied and a new
+plan has to be made.
+
+Gmail filters only act on new messages. With --to-existing, after the
+new filters are created, their actions are also performed on the
+existing messages matching them, like adding labels or archiving. Since
+this changes the messages, the number of matching messages is shown and
+has to be confirmed by typing 'yes', unless --yes is specified. It also
+requires the permission to modify messages, which is asked for the
+first time, separately from the one granted with 'init'.
+
+With --log-format json, every planned and executed change is logged on
+stderr as a JSON object per line, with the operation, the affected
+filter or label, the index of the rule generating the filter and the
+outcome. Errors are logged in the same way.
+
+Large changes can hit the Gmail API quota. Calls failed because of it,
+or because of transient server errors, are retried with exponential
+backoff (see --api-retries and --api-backoff), and --rate limits the
+number of calls per second. Filters and labels are created again only
+after quota errors, as after a server error they could exist already.
+
+While the changes are applied, the progress is shown on stderr, e.g.
+'applying 37/120', unless --quiet is specified or stderr is not a
+terminal. With --batch-size, changes are applied in batches and the
+progress is reported after each one instead, unless --quiet is
+specified.
+
+A failed change doesn't stop the others: all the failures are listed at
+the end and apply exits with an error. Labels are not deleted if any
+other change failed. Running apply again only performs the remaining
+changes.
+
+To protect from applying the wrong configuration, deleting more than
+--confirm-over filters (10 by default) requires an explicit
+confirmation: typing 'yes' when asked, or --yes. Modified filters
+don't count as deleted. A negative value disables the check.
+
+Gmail limits the number of filters in an account, so apply fails before
+changing anything if the changes would exceed --max-filters (1000 by
+default), counting the existing filters not managed by the
+configuration as well. A negative value disables the check.
+
+With --prune-filters-matching, only the existing filters whose Gmail
+query matches the given regular expression are managed: the others are
+never deleted, even if they are not in the configuration. This is useful
+to migrate a subset of hand-made filters at a time.
+
+With --diff-only-filters, labels are left as they are: only the
+filters are applied, and the labels they use must already exist. This
+is useful to manage the labels with the Gmail UI.
+
+Gmail label names are case sensitive: a label of the configuration
+differing only by case from an existing one (e.g. 'work' and 'Work')
+is replaced by the existing label, with a warning. With
+--strict-label-case this is an error instead.
+
 By default apply uses the configuration file inside the config
-directory [config.jsonnet].`,
+directory [config.jsonnet].
+
+With '-f -' the configuration is read from stdin instead. Its format is
+detected from the content, or it can be given with --input-format. Since
+the answers can't be read from stdin as well, this requires --yes or
+--dry-run.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		f := applyFilename
 		if f == "" {
 			f = configFilenameFromDir(cfgDir)
 		}
-		if err := apply(f, !applyYes, !applySkipTests); err != nil {
+		if applyDryRun && applyOut == "" {
+			fatal(errors.New("--dry-run requires --out"))
+		}
+		if applyInteractive && applyYes {
+			fatal(errors.New("--interactive and --yes are mutually exclusive"))
+		}
+		if f == stdinPath && !applyYes && !applyDryRun {
+			fatal(errors.New("reading the configuration from stdin requires --yes or --dry-run"))
+		}
+		if applyOnlyFilters && applyPruneLabels {
+			fatal(errors.New("--prune-labels is not supported with --diff-only-filters"))
+		}
+		if applyDryRun && applyPruneLabels {
+			fatal(errors.New("--prune-labels is not supported with --dry-run"))
+		}
+		if applyDryRun && applyToExisting {
+			fatal(errors.New("--to-existing is not supported with --dry-run"))
+		}
+		if applyPlan != "" {
+			if err := checkPlanFlags(cmd); err != nil {
+				fatal(err)
+			}
+			if err := executePlan(applyPlan, !applyYes); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		if err := apply(f, !applyYes && !applyInteractive, !applySkipTests); err != nil {
 			fatal(err)
 		}
 	}}
@@ -47,12 +177,31 @@ func init() {
 
 	// Flags and configuration settings
 	applyCmd.PersistentFlags().StringVarP(&applyFilename, "filename", "f", "", "configuration file")
+	addInputFormatFlag(applyCmd)
 	applyCmd.Flags().BoolVarP(&applyYes, "yes", "y", false, "don't ask for confirmation, just apply")
+	applyCmd.Flags().BoolVarP(&applyInteractive, "interactive", "i", false, "ask for the approval of every filter change")
 	applyCmd.Flags().BoolVarP(&applyRemoveLabels, "remove-labels", "r", false, "allow removing labels")
+	applyCmd.Flags().BoolVarP(&applyPruneLabels, "prune-labels", "", false, "delete empty labels not referenced by the configuration")
 	applyCmd.Flags().BoolVarP(&applySkipTests, "yolo", "", false, "skip configuration tests")
+	applyCmd.Flags().BoolVarP(&applyDryRun, "dry-run", "", false, "don't apply, write the planned API operations to --out")
+	applyCmd.Flags().StringVarP(&applyOut, "out", "", "", "output file of the planned operations, with --dry-run")
+	applyCmd.Flags().StringVarP(&applyPlan, "plan", "", "", "execute the operations of a plan written by --dry-run")
+	applyCmd.Flags().IntVarP(&applyBatchSize, "batch-size", "", 0, "maximum number of changes per batch (0 for no batching)")
+	applyCmd.Flags().Float64VarP(&applyRate, "rate", "", 0, "maximum number of Gmail API calls per second (0 for no limit)")
+	applyCmd.Flags().BoolVarP(&applyOnlyFilters, "diff-only-filters", "", false, "ignore labels, apply only the filters")
+	applyCmd.Flags().IntVarP(&applyConfirmOver, "confirm-over", "", 10, "require confirmation to delete more than this number of filters")
+	applyCmd.Flags().IntVarP(&applyMaxFilters, "max-filters", "", papply.DefaultMaxFilters, "maximum number of filters allowed by Gmail (negative to disable the check)")
+	applyCmd.Flags().StringVarP(&applyPruneMatch, "prune-filters-matching", "", "", "only delete the existing filters whose query matches this regular expression")
+	applyCmd.Flags().BoolVarP(&applyToExisting, "to-existing", "", false, "also apply the new filters to the existing messages matching them")
+	applyCmd.Flags().BoolVarP(&applyQuiet, "quiet", "q", false, "don't show the progress while applying the changes")
+	applyCmd.Flags().BoolVarP(&applyStrictCase, "strict-label-case", "", false, "fail on labels differing only by case from existing ones")
 }
 
 func apply(path string, interactive, test bool) error {
+	managed, err := managedFilters(applyPruneMatch)
+	if err != nil {
+		return err
+	}
 	parseRes, err := parseConfig(path, "", test)
 	if err != nil {
 		return err
@@ -67,20 +216,59 @@ func apply(path string, interactive, test bool) error {
 	if err != nil {
 		return err
 	}
+	// Unmanaged filters are not in the diff, but they count for the limit.
+	existing := len(upstream.Filters)
+	fingerprint := papply.Fingerprint(upstream)
+
+	local, err := guardedConfig(parseRes, upstream)
+	if err != nil {
+		return err
+	}
+	local, err = matchLabelCase(local, upstream, applyStrictCase)
+	if err != nil {
+		return err
+	}
+	if applyOnlyFilters {
+		if local, err = filtersOnly(local, upstream); err != nil {
+			return err
+		}
+	}
+	if managed != nil {
+		upstream = papply.ManagedFilters(local, upstream, managed)
+	}
 
-	diff, err := papply.Diff(parseRes.Res.GmailConfig, upstream)
+	diff, err := papply.Diff(local, upstream)
 	if err != nil {
 		return fmt.Errorf("cannot compare upstream with local config: %w", err)
 	}
 
 	if diff.Empty() {
 		fmt.Println("No changes have been made.")
+		if applyDryRun {
+			return writePlan(diff, fingerprint, applyOut, nil)
+		}
+		if applyPruneLabels {
+			return pruneLabels(local, gmailapi)
+		}
 		return nil
 	}
 
+	var declined filter.FiltersDiff
+	if applyInteractive {
+		diff, declined = selectChanges(stdin, os.Stdout, diff)
+		defer printDeclined(os.Stdout, declined)
+		if diff.Empty() {
+			fmt.Println("No changes have been made.")
+			return nil
+		}
+	}
+
 	fmt.Printf("You are going to apply the following changes to your settings:\n\n%s\n", diff)
 
-	if err := diff.Validate(); err != nil {
+	if err := gmailctl.Check(diff, gmailapi); err != nil {
+		return err
+	}
+	if err := papply.CheckFilterLimit(diff, existing, applyMaxFilters); err != nil {
 		return err
 	}
 
@@ -93,14 +281,330 @@ func apply(path string, interactive, test bool) error {
 		}
 	}
 
+	var events *eventLogger
+	if logFormat == logFormatJSON {
+		events = newEventLogger(os.Stderr, diff, ruleIndexes(parseRes.Res))
+	}
+	if applyDryRun {
+		return writePlan(diff, fingerprint, applyOut, events)
+	}
+
+	// Filters approved one by one are already confirmed.
+	if err := confirmDeletes(stdin, os.Stdout, diff, applyConfirmOver, applyYes || applyInteractive); err != nil {
+		return err
+	}
+
 	if interactive && !askYN("Do you want to apply them?") {
 		return nil
 	}
 
 	fmt.Println("Applying the changes...")
-	return papply.Apply(diff, gmailapi, applyRemoveLabels)
+	gmailapi.SetRate(applyRate)
+	var target gmailctl.Writer = gmailapi
+	if events != nil {
+		ops, err := plannedOperations(diff)
+		if err != nil {
+			return err
+		}
+		events.planned(ops)
+		target = events.writer(gmailapi)
+	}
+	// The progress would be mixed with the JSON logs.
+	quiet := applyQuiet || events != nil
+	var progress *progressReporter
+	switch {
+	case applyBatchSize > 0:
+		var pf papply.ProgressFunc
+		if !quiet {
+			pf = printProgress
+		}
+		target = papply.NewBatchedAPI(target, applyBatchSize, pf)
+	case showProgress(os.Stderr, quiet):
+		progress = newProgressReporter(os.Stderr, countChanges(diff, applyRemoveLabels))
+		gmailapi.SetProgress(progress.step)
+	}
+	opts := gmailctl.Options{AllowRemoveLabels: applyRemoveLabels}
+	res, err := gmailctl.ApplyDiff(context.Background(), diff, target, opts)
+	if progress != nil {
+		// The following changes are not part of the diff.
+		gmailapi.SetProgress(nil)
+		progress.finish()
+	}
+	if err != nil {
+		return err
+	}
+	if applyToExisting {
+		if err := applyExisting(res.Operations, stdin, os.Stdout, applyYes); err != nil {
+			return err
+		}
+	}
+	if applyPruneLabels {
+		return pruneLabels(local, gmailapi)
+	}
+	return nil
+}
+
+// managedFilters returns whether an existing filter is managed, based on
+// its query matching the given regular expression. Nil is returned for an
+// empty expression, meaning that all filters are managed.
+func managedFilters(expr string) (func(filter.Filter) bool, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --prune-filters-matching: %w", err)
+	}
+	return func(f filter.Filter) bool {
+		return re.MatchString(f.Criteria.ToGmailSearch())
+	}, nil
+}
+
+// confirmDeletes asks to confirm the deletion of more than max filters,
+// reading the answer from in. Filters replaced by a modified one don't count
+// as deleted. An error is returned if not confirmed, unless yes is true.
+func confirmDeletes(in *bufio.Reader, out io.Writer, diff papply.ConfigDiff, max int, yes bool) error {
+	n := len(diff.FiltersDiff.Removed) - diff.FiltersDiff.Modified()
+	if max < 0 || n <= max || yes {
+		return nil
+	}
+	fmt.Fprintf(out, "You are going to delete %d filters, more than %d.\n", n, max)
+	fmt.Fprint(out, "Type 'yes' to confirm: ")
+	choice, _ := in.ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(choice)) == "yes" {
+		return nil
+	}
+	fmt.Fprintln(out)
+	return errors.WithDetails(fmt.Errorf("deleting %d filters was not confirmed, no changes have been made", n),
+		"Make sure you are applying the right configuration. To delete\n"+
+			"the filters anyway, confirm with --yes or raise --confirm-over.\n")
+}
+
+// selectChanges asks to approve every filter change in the diff, reading the
+// answers from in. It returns the approved changes and the declined ones.
+func selectChanges(in *bufio.Reader, out io.Writer, diff papply.ConfigDiff) (papply.ConfigDiff, filter.FiltersDiff) {
+	return papply.SelectFilters(diff, func(f filter.Filter, added bool) papply.Decision {
+		change := "Remove"
+		if added {
+			change = "Add"
+		}
+		fmt.Fprintf(out, "%s filter:\n%s\n", change, f)
+		return askDecision(in, out)
+	})
+}
+
+func askDecision(r *bufio.Reader, out io.Writer) papply.Decision {
+	for {
+		fmt.Fprint(out, "Apply this change? [y/n/q]: ")
+		choice, err := r.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(choice)) {
+		case "y", "yes":
+			return papply.Approve
+		case "n", "no":
+			return papply.Decline
+		case "q", "quit":
+			return papply.Quit
+		}
+		if err != nil {
+			// No more answers: nothing else can be approved.
+			fmt.Fprintln(out)
+			return papply.Quit
+		}
+		fmt.Fprintln(out, "invalid choice")
+	}
+}
+
+func printDeclined(out io.Writer, declined filter.FiltersDiff) {
+	if declined.Empty() {
+		return
+	}
+	fmt.Fprintf(out, "\nSkipped changes (%d filters to add, %d to remove):\n",
+		len(declined.Added), len(declined.Removed))
+	for _, f := range declined.Removed {
+		fmt.Fprintf(out, "  - remove: %s\n", f.Criteria.ToGmailSearch())
+	}
+	for _, f := range declined.Added {
+		fmt.Fprintf(out, "  - add: %s\n", f.Criteria.ToGmailSearch())
+	}
+}
+
+// applyExisting performs the actions of the filters created by the
+// operations on the existing messages matching them. Unless yes is true, the
+// confirmation is read from in.
+func applyExisting(ops []papply.Operation, in *bufio.Reader, out io.Writer, yes bool) error {
+	var fs filter.Filters
+	for _, op := range ops {
+		if op.Kind == papply.OperationAddFilters {
+			fs = append(fs, op.Filters...)
+		}
+	}
+	if len(fs) == 0 {
+		return nil
+	}
+	mapi, err := openMessagesAPI()
+	if err != nil {
+		return fmt.Errorf("cannot connect to Gmail: %w", err)
+	}
+	mapi.SetRate(applyRate)
+	return applyToMessages(fs, mapi, in, out, yes)
+}
+
+func applyToMessages(fs filter.Filters, mapi papply.MessagesAPI, in *bufio.Reader, out io.Writer, yes bool) error {
+	ms, err := papply.FindExisting(fs, mapi)
+	if err != nil {
+		return err
+	}
+	n := papply.CountMessages(ms)
+	if n == 0 {
+		fmt.Fprintln(out, "No existing messages match the new filters.")
+		return nil
+	}
+	fmt.Fprintf(out, "You are going to apply %d new filters to %d existing messages:\n", len(ms), n)
+	for _, m := range ms {
+		fmt.Fprintf(out, "  - %s: %d messages\n", m.Filter.Criteria.ToGmailSearch(), len(m.IDs))
+	}
+	if !yes {
+		fmt.Fprint(out, "Type 'yes' to confirm: ")
+		choice, _ := in.ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(choice)) != "yes" {
+			fmt.Fprintln(out, "\nThe existing messages have not been changed.")
+			return nil
+		}
+	}
+	if err := papply.ApplyToExisting(ms, mapi, papply.MaxMessagesBatchSize); err != nil {
+		return fmt.Errorf("applying the filters to existing messages: %w", err)
+	}
+	fmt.Fprintf(out, "Applied the new filters to %d existing messages.\n", n)
+	return nil
+}
+
+// printProgress prints the progress of the batches on stderr.
+func printProgress(kind papply.OperationKind, done, total int) {
+	stderrPrintf("  %s: %d/%d done\n", kind, done, total)
+}
+
+// plannedOperations returns the Gmail API operations applying the diff.
+func plannedOperations(diff papply.ConfigDiff) ([]papply.Operation, error) {
+	opts := gmailctl.Options{AllowRemoveLabels: applyRemoveLabels, DryRun: true}
+	res, err := gmailctl.ApplyDiff(context.Background(), diff, nil, opts)
+	return res.Operations, err
+}
+
+func writePlan(diff papply.ConfigDiff, upstream, path string, events *eventLogger) error {
+	ops, err := plannedOperations(diff)
+	if err != nil {
+		return err
+	}
+	if events != nil {
+		events.planned(ops)
+	}
+	plan := papply.Plan{Upstream: upstream, Operations: ops}
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding the plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing the plan: %w", err)
+	}
+	fmt.Printf("The planned operations have been written to %s.\n", path)
+	return nil
+}
+
+// notPlanFlags are the flags of apply that affect how the changes are
+// planned, so they can't be combined with --plan.
+var notPlanFlags = []string{
+	"filename", "input-format", "interactive", "remove-labels", "prune-labels",
+	"yolo", "dry-run", "out", "batch-size", "diff-only-filters", "max-filters",
+	"prune-filters-matching", "to-existing", "strict-label-case",
+}
+
+func checkPlanFlags(cmd *cobra.Command) error {
+	for _, name := range notPlanFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s is not supported with --plan", name)
+		}
+	}
+	return nil
+}
+
+// executePlan executes the plan written in the file, after checking that
+// the Gmail settings didn't change since it was made.
+func executePlan(path string, interactive bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening the plan: %w", err)
+	}
+	defer f.Close()
+	plan, err := papply.ReadPlan(f)
+	if err != nil {
+		return err
+	}
+
+	gmailapi, err := openAPI()
+	if err != nil {
+		return configurationError(fmt.Errorf("cannot connect to Gmail: %w", err))
+	}
+	upstream, err := upstreamConfig(gmailapi)
+	if err != nil {
+		return err
+	}
+	gmailapi.SetRate(applyRate)
+
+	var target gmailctl.Writer = gmailapi
+	if logFormat == logFormatJSON {
+		events := newEventLogger(os.Stderr, plan.Diff(upstream), nil)
+		events.planned(plan.Operations)
+		target = events.writer(gmailapi)
+	}
+	confirm := func() bool {
+		return !interactive || askYN("Do you want to apply them?")
+	}
+	return runPlan(plan, upstream, target, stdin, os.Stdout, applyConfirmOver, applyYes, confirm)
+}
+
+// runPlan replays the plan on w, after checking that the upstream config
+// didn't change and confirming the deletion of more than confirmOver
+// filters, unless yes is true (see confirmDeletes).
+func runPlan(plan papply.Plan, upstream papply.GmailConfig, w gmailctl.Writer, in *bufio.Reader, out io.Writer,
+	confirmOver int, yes bool, confirm func() bool) error {
+	if err := plan.CheckUpstream(upstream); err != nil {
+		return err
+	}
+	diff := plan.Diff(upstream)
+	if diff.Empty() {
+		fmt.Fprintln(out, "No changes have been made.")
+		return nil
+	}
+	fmt.Fprintf(out, "You are going to apply the following changes to your settings:\n\n%s\n", diff)
+	if err := confirmDeletes(in, out, diff, confirmOver, yes); err != nil {
+		return err
+	}
+	if !confirm() {
+		return nil
+	}
+	fmt.Fprintln(out, "Applying the changes...")
+	return plan.Replay(w)
+}
+
+func pruneLabels(local papply.GmailConfig, gmailapi *api.GmailAPI) error {
+	// Labels have to be fetched again, as they might have changed.
+	upstream, err := gmailapi.ListLabels()
+	if err != nil {
+		return fmt.Errorf("listing labels from Gmail: %w", err)
+	}
+	res, err := papply.PruneLabels(local, upstream, gmailapi)
+	if err != nil {
+		return fmt.Errorf("pruning labels: %w", err)
+	}
+	for _, l := range res.NotEmpty {
+		stderrPrintf("WARNING: Label %q is not referenced, but it's not deleted because it has messages.\n", l.Name)
+	}
+	for _, l := range res.Deleted {
+		fmt.Printf("Deleted unused label %q.\n", l.Name)
+	}
+	return nil
 }
 
 func configurationError(err error) error {
-	return errors.WithDetails(err, "The configuration can be initialized with 'gmailctl init'")
+	return errors.WithDetails(err, fmt.Sprintf("The configuration can be initialized with '%s'", initCommandLine()))
 }
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// input returns a reader of the given answers to the prompts.
+func input(answers string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(answers))
+}
+
+func TestSelectChanges(t *testing.T) {
+	mkFilter := func(id, from string) filter.Filter {
+		return filter.Filter{
+			ID:       id,
+			Criteria: filter.Criteria{From: from},
+			Action:   filter.Actions{Archive: true},
+		}
+	}
+	diff := papply.ConfigDiff{
+		FiltersDiff: filter.FiltersDiff{
+			Added:   filter.Filters{mkFilter("", "a"), mkFilter("", "b"), mkFilter("", "c")},
+			Removed: filter.Filters{mkFilter("id1", "x")},
+		},
+		LabelsDiff: label.LabelsDiff{Added: label.Labels{{Name: "new"}}},
+	}
+	// Changes are asked in order: remove x, add a, add b, add c.
+	in := input("y\nn\nmaybe\ny\nq\n")
+	var out bytes.Buffer
+
+	selected, declined := selectChanges(in, &out, diff)
+	assert.Contains(t, out.String(), "Remove filter:\n* Criteria:\n    from: x\n")
+	assert.Contains(t, out.String(), "invalid choice")
+	assert.Equal(t, filter.Filters{mkFilter("", "a"), mkFilter("", "c")}, declined.Added)
+	assert.Empty(t, declined.Removed)
+
+	var plan papply.Plan
+	require.Nil(t, papply.Apply(selected, &plan, false))
+	assert.Equal(t, []papply.Operation{
+		{Kind: papply.OperationAddLabels, Labels: label.Labels{{Name: "new"}}},
+		{Kind: papply.OperationAddFilters, Filters: filter.Filters{mkFilter("", "b")}},
+		{Kind: papply.OperationDeleteFilters, IDs: []string{"id1"}},
+	}, plan.Operations)
+
+	out.Reset()
+	printDeclined(&out, declined)
+	assert.Equal(t, `
+Skipped changes (2 filters to add, 0 to remove):
+  - add: from:a
+  - add: from:c
+`, out.String())
+}
+
+func TestSelectChangesEndOfInput(t *testing.T) {
+	diff := papply.ConfigDiff{
+		FiltersDiff: filter.FiltersDiff{
+			Added: filter.Filters{
+				{Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{Star: true}},
+				{Criteria: filter.Criteria{From: "b"}, Action: filter.Actions{Star: true}},
+			},
+		},
+	}
+	// The last answer has no newline and the input ends before the second
+	// change: it's declined.
+	selected, declined := selectChanges(input("y"), &bytes.Buffer{}, diff)
+	assert.Len(t, selected.FiltersDiff.Added, 1)
+	assert.Len(t, declined.Added, 1)
+}
+
+func TestConfirmDeletes(t *testing.T) {
+	var removed filter.Filters
+	for i := 0; i < 11; i++ {
+		removed = append(removed, filter.Filter{
+			ID:       fmt.Sprintf("id%d", i),
+			Criteria: filter.Criteria{From: fmt.Sprintf("a%d", i)},
+		})
+	}
+	diff := papply.ConfigDiff{FiltersDiff: filter.FiltersDiff{Removed: removed}}
+	var out bytes.Buffer
+
+	// No answer aborts.
+	err := confirmDeletes(input(""), &out, diff, 10, false)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "deleting 11 filters was not confirmed")
+	assert.Contains(t, out.String(), "You are going to delete 11 filters, more than 10.")
+
+	// Anything but 'yes' aborts.
+	err = confirmDeletes(input("y\n"), &out, diff, 10, false)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, confirmDeletes(input("yes\n"), &out, diff, 10, false))
+
+	// No questions are asked with --yes, under the limit or when disabled.
+	out.Reset()
+	assert.Nil(t, confirmDeletes(input(""), &out, diff, 10, true))
+	assert.Nil(t, confirmDeletes(input(""), &out, diff, 11, false))
+	assert.Nil(t, confirmDeletes(input(""), &out, diff, -1, false))
+	assert.Empty(t, out.String())
+
+	// A modified filter is not deleted.
+	added := filter.Filters{{Criteria: filter.Criteria{From: "a0 b"}}}
+	diff = papply.ConfigDiff{FiltersDiff: filter.NewMinimalFiltersDiff(added, removed)}
+	assert.Nil(t, confirmDeletes(input(""), &out, diff, 10, false))
+	assert.Empty(t, out.String())
+	err = confirmDeletes(input(""), &out, diff, 9, false)
+	assert.ErrorContains(t, err, "deleting 10 filters was not confirmed")
+}
+
+func TestPromptsShareInput(t *testing.T) {
+	var removed filter.Filters
+	for i := 0; i < 2; i++ {
+		removed = append(removed, filter.Filter{ID: fmt.Sprintf("id%d", i), Criteria: filter.Criteria{From: "x"}})
+	}
+	diff := papply.ConfigDiff{FiltersDiff: filter.FiltersDiff{Removed: removed}}
+	mapi := &fakeMessagesAPI{messages: map[string][]string{"from:a": {"m1"}}}
+	fs := filter.Filters{{Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{Archive: true}}}
+
+	// The answers to all the prompts are read from the same input.
+	in := input("yes\nyes\n")
+	var out bytes.Buffer
+	require.Nil(t, confirmDeletes(in, &out, diff, 1, false))
+	require.Nil(t, applyToMessages(fs, mapi, in, &out, false))
+	assert.Equal(t, [][]string{{"m1"}}, mapi.modified)
+}
+
+func TestManagedFilters(t *testing.T) {
+	managed, err := managedFilters("")
+	require.Nil(t, err)
+	assert.Nil(t, managed)
+
+	managed, err = managedFilters(`from:\S*@work\.com`)
+	require.Nil(t, err)
+	assert.True(t, managed(filter.Filter{Criteria: filter.Criteria{From: "boss@work.com"}}))
+	assert.True(t, managed(filter.Filter{Criteria: filter.Criteria{Query: "from:{a@work.com b@work.com}"}}))
+	assert.False(t, managed(filter.Filter{Criteria: filter.Criteria{To: "me@work.com"}}))
+
+	_, err = managedFilters("(")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid --prune-filters-matching")
+}
+
+// fakeMessagesAPI returns the messages matching the queries and records the
+// modified ones.
+type fakeMessagesAPI struct {
+	messages map[string][]string
+	modified [][]string
+	actions  []filter.Actions
+}
+
+func (f *fakeMessagesAPI) SearchMessages(query string) ([]string, error) {
+	return f.messages[query], nil
+}
+
+func (f *fakeMessagesAPI) ModifyMessages(ids []string, a filter.Actions) error {
+	f.modified = append(f.modified, ids)
+	f.actions = append(f.actions, a)
+	return nil
+}
+
+func TestApplyToMessages(t *testing.T) {
+	fs := filter.Filters{
+		{Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{Archive: true}},
+		{Criteria: filter.Criteria{From: "b"}, Action: filter.Actions{Star: true}},
+	}
+	mapi := &fakeMessagesAPI{messages: map[string][]string{"from:a": {"m1", "m2"}}}
+	var out bytes.Buffer
+
+	// Not confirmed.
+	require.Nil(t, applyToMessages(fs, mapi, input("no\n"), &out, false))
+	assert.Contains(t, out.String(), "You are going to apply 1 new filters to 2 existing messages:\n"+
+		"  - from:a: 2 messages\n")
+	assert.Contains(t, out.String(), "The existing messages have not been changed.")
+	assert.Empty(t, mapi.modified)
+
+	out.Reset()
+	require.Nil(t, applyToMessages(fs, mapi, input("yes\n"), &out, false))
+	assert.Equal(t, [][]string{{"m1", "m2"}}, mapi.modified)
+	assert.Equal(t, []filter.Actions{{Archive: true}}, mapi.actions)
+	assert.Contains(t, out.String(), "Applied the new filters to 2 existing messages.")
+}
+
+func TestRunPlan(t *testing.T) {
+	upstream := papply.GmailConfig{
+		Labels: label.Labels{{ID: "l1", Name: "old"}},
+		Filters: filter.Filters{{
+			ID:       "f1",
+			Criteria: filter.Criteria{From: "x"},
+			Action:   filter.Actions{AddLabel: "old"},
+		}},
+	}
+	plan := papply.Plan{
+		Upstream: papply.Fingerprint(upstream),
+		Operations: []papply.Operation{
+			{Kind: papply.OperationAddFilters, Filters: filter.Filters{{
+				Criteria: filter.Criteria{From: "a"},
+				Action:   filter.Actions{Archive: true},
+			}}},
+			{Kind: papply.OperationDeleteFilters, IDs: []string{"f1"}},
+		},
+	}
+	confirm := func() bool { return true }
+
+	var (
+		executed papply.Plan
+		out      bytes.Buffer
+	)
+	require.Nil(t, runPlan(plan, upstream, &executed, input(""), &out, 1, false, confirm))
+	assert.Equal(t, plan.Operations, executed.Operations)
+	assert.Contains(t, out.String(), "-    from: x\n+    from: a\n")
+
+	// Deleting more filters than allowed has to be confirmed.
+	executed = papply.Plan{}
+	err := runPlan(plan, upstream, &executed, input("no\n"), &out, 0, false, confirm)
+	assert.ErrorContains(t, err, "deleting 1 filters was not confirmed")
+	assert.Empty(t, executed.Operations)
+	require.Nil(t, runPlan(plan, upstream, &executed, input(""), &out, 0, true, confirm))
+	assert.Equal(t, plan.Operations, executed.Operations)
+
+	// A filter was changed in the meantime.
+	drifted := papply.GmailConfig{
+		Labels: upstream.Labels,
+		Filters: filter.Filters{{
+			ID:       "f1",
+			Criteria: filter.Criteria{From: "y"},
+			Action:   filter.Actions{AddLabel: "old"},
+		}},
+	}
+	executed = papply.Plan{}
+	err = runPlan(plan, drifted, &executed, input(""), &out, 1, false, confirm)
+	assert.ErrorContains(t, err, "settings changed since the plan was made")
+	assert.Empty(t, executed.Operations)
+}
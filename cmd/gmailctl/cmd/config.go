@@ -2,16 +2,27 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
 
 	papply "github.com/mbrt/gmailctl/internal/engine/apply"
 	"github.com/mbrt/gmailctl/internal/engine/cfgtest"
 	"github.com/mbrt/gmailctl/internal/engine/config"
 	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
 	"github.com/mbrt/gmailctl/internal/errors"
 )
 
+// stdinPath is the configuration path that reads from standard input.
+const stdinPath = "-"
+
+// inputFormat is the format of a configuration read from standard input.
+var inputFormat string
+
 type parseResult struct {
 	Config v1alpha3.Config
 	Res    papply.ConfigParseRes
@@ -29,7 +40,7 @@ func parseConfig(path, originalPath string, test bool) (parseResult, error) {
 	var res parseResult
 	var err error
 
-	res.Config, err = config.ReadFile(path, originalPath)
+	res.Config, err = readConfig(path, originalPath, stdin)
 	if err != nil {
 		if errors.Is(err, config.ErrNotFound) {
 			return res, configurationError(err)
@@ -44,22 +55,105 @@ func parseConfig(path, originalPath string, test bool) (parseResult, error) {
 			config.LatestVersion)
 	}
 
-	res.Res, err = papply.FromConfig(res.Config)
+	res.Res, err = papply.FromConfigWithOptions(res.Config, parseOptions())
 	if err != nil {
 		return res, err
 	}
-	if test && len(res.Config.Tests) > 0 {
-		ts, err := cfgtest.NewFromParserRules(res.Res.Rules)
-		if err != nil {
-			stderrPrintf("WARNING: %d filters are excluded from the tests:\n", len(errors.Errors(err)))
-			stderrPrintf("%+v\n", err)
+	for _, w := range res.Res.DepthWarnings {
+		stderrPrintf("WARNING: %s.\n", w)
+	}
+	for _, c := range res.Res.MergeConflicts {
+		stderrPrintf("WARNING: %s.\n", c)
+	}
+	for _, i := range res.Res.CatchAll {
+		stderrPrintf("WARNING: rule #%d is a catch-all: its actions apply to EVERY incoming message.\n", i)
+	}
+	if test {
+		err = runTests(res.Res.Rules, res.Config.Tests)
+	}
+
+	return res, err
+}
+
+// readConfig reads the configuration file at the given path, or from stdin if
+// the path is "-". YAML configs upgraded in memory are reported.
+func readConfig(path, originalPath string, stdin io.Reader) (v1alpha3.Config, error) {
+	var (
+		cfg v1alpha3.Config
+		m   *config.Migration
+		err error
+	)
+	if path != stdinPath {
+		cfg, m, err = config.ReadFile(path, originalPath, readOptions())
+	} else {
+		// Libraries are looked up in the config directory.
+		cfg, m, err = config.Read(stdin, config.InputFormat(inputFormat), configFilenameFromDir(cfgDir), readOptions())
+	}
+	if err == nil && m != nil {
+		reportMigration(path, *m)
+	}
+	return cfg, err
+}
+
+// parseExtVars parses the external variables given as '<name>=<value>'. With
+// only '<name>', the value is taken from the environment variable with that
+// name, like the jsonnet command does.
+func parseExtVars(strs, codes []string, lookupEnv func(string) (string, bool)) (config.ExtVars, error) {
+	parse := func(flag string, vars []string) (map[string]string, error) {
+		if len(vars) == 0 {
+			return nil, nil
 		}
-		tres := ts.ExecTests(res.Config.Tests)
-		if !tres.OK {
-			stderrPrintf("Test results: %s\n", tres)
-			return res, fmt.Errorf("%d/%d config tests failed", len(tres.Failed), tres.NumTests)
+		res := map[string]string{}
+		for _, v := range vars {
+			name, value := v, ""
+			if i := strings.Index(v, "="); i >= 0 {
+				name, value = v[:i], v[i+1:]
+			} else {
+				var ok bool
+				if value, ok = lookupEnv(name); !ok {
+					return nil, fmt.Errorf("--%s %s: environment variable %q is not set", flag, v, name)
+				}
+			}
+			if name == "" {
+				return nil, fmt.Errorf("--%s %s: missing variable name", flag, v)
+			}
+			res[name] = value
 		}
+		return res, nil
 	}
 
-	return res, err
+	var res config.ExtVars
+	var err error
+	if res.Str, err = parse("ext-str", strs); err != nil {
+		return res, err
+	}
+	if res.Code, err = parse("ext-code", codes); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// addInputFormatFlag adds the flag to choose the format of a configuration
+// read from stdin.
+func addInputFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&inputFormat, "input-format", "",
+		"format of the configuration read from stdin with '-f -' (jsonnet, json or yaml, detected by default)")
+}
+
+// runTests executes the given tests against the rules.
+func runTests(rules []parser.Rule, tests []v1alpha3.Test) error {
+	if len(tests) == 0 {
+		return nil
+	}
+	ts, err := cfgtest.NewFromParserRules(rules)
+	if err != nil {
+		stderrPrintf("WARNING: %d filters are excluded from the tests:\n", len(errors.Errors(err)))
+		stderrPrintf("%+v\n", err)
+	}
+	tres := ts.ExecTests(tests)
+	if !tres.OK {
+		stderrPrintf("Test results: %s\n", tres)
+		return fmt.Errorf("%d/%d config tests failed", len(tres.Failed), tres.NumTests)
+	}
+	return nil
 }
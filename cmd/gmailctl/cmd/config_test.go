@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+func TestReadConfigStdin(t *testing.T) {
+	stdin := strings.NewReader(`{
+  "version": "v1alpha3",
+  "rules": [
+    {"filter": {"list": "dev@lists.com"}, "actions": {"archive": true}}
+  ]
+}`)
+	cfg, err := readConfig(stdinPath, "", stdin)
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Rule{
+		{
+			Filter:  v1alpha3.FilterNode{List: "dev@lists.com"},
+			Actions: v1alpha3.Actions{Archive: true},
+		},
+	}, cfg.Rules)
+
+	stdin = strings.NewReader("version: v0\nrules: []\n")
+	_, err = readConfig(stdinPath, "", stdin)
+	assert.EqualError(t, err, "migrating the YAML config: unsupported config version: v0")
+}
+
+func TestParseExtVars(t *testing.T) {
+	env := map[string]string{"DOMAIN": "work.com"}
+	lookupEnv := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	vars, err := parseExtVars(
+		[]string{"domain=home.com", "DOMAIN", "empty="},
+		[]string{"archive=true", "list=['a', 'b=c']"},
+		lookupEnv)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"domain": "home.com", "DOMAIN": "work.com", "empty": ""}, vars.Str)
+	assert.Equal(t, map[string]string{"archive": "true", "list": "['a', 'b=c']"}, vars.Code)
+
+	vars, err = parseExtVars(nil, nil, lookupEnv)
+	require.Nil(t, err)
+	assert.Equal(t, config.ExtVars{}, vars)
+
+	_, err = parseExtVars([]string{"MISSING"}, nil, lookupEnv)
+	require.NotNil(t, err)
+	assert.Equal(t, `--ext-str MISSING: environment variable "MISSING" is not set`, err.Error())
+
+	_, err = parseExtVars(nil, []string{"=1"}, lookupEnv)
+	require.NotNil(t, err)
+	assert.Equal(t, "--ext-code =1: missing variable name", err.Error())
+}
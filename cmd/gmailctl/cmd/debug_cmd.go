@@ -2,12 +2,18 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"net/url"
+	"os"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
 	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 var (
@@ -22,6 +28,10 @@ var debugCmd = &cobra.Command{
 with handy URLs to Gmail search that can be used to test that the
 filter applies to the intended emails.
 
+Every rule is numbered as the config rule it comes from: a config
+rule can generate multiple rules, and rules with the same criteria
+are merged together.
+
 By default debug uses the configuration file inside the config
 directory config.jsonnet].`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -29,7 +39,29 @@ directory config.jsonnet].`,
 		if f == "" {
 			f = configFilenameFromDir(cfgDir)
 		}
-		if err := debug(f); err != nil {
+		if err := debug(f, os.Stdout); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// debugQueryCmd represents the debug query command
+var debugQueryCmd = &cobra.Command{
+	Use:   "query <filter>",
+	Short: "Shows the Gmail query generated by a filter",
+	Long: `The query command shows the Gmail search query generated by the
+given filter, without reading the configuration or connecting to Gmail.
+
+The filter is a Jsonnet or JSON expression, in the same format as the
+'filter' field of a rule. For example:
+
+  gmailctl debug query "{ or: [{ from: 'a@b.com' }, { subject: 'hi' }] }"
+
+The filter may generate more than one Gmail filter, in which case every
+query is printed on its own line.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := debugQuery(args[0], os.Stdout); err != nil {
 			fatal(err)
 		}
 	},
@@ -37,42 +69,68 @@ directory config.jsonnet].`,
 
 func init() {
 	rootCmd.AddCommand(debugCmd)
+	debugCmd.AddCommand(debugQueryCmd)
 
 	// Flags and configuration settings
 	debugCmd.PersistentFlags().StringVarP(&debugFilename, "filename", "f", "", "configuration file")
 }
 
-func debug(path string) error {
+// debugQuery writes the Gmail queries generated by the given filter.
+func debugQuery(expr string, out io.Writer) error {
+	// Libraries are looked up in the config directory.
+	node, err := config.ReadFilter(configFilenameFromDir(cfgDir), []byte(expr), readOptions())
+	if err != nil {
+		return fmt.Errorf("syntax error in filter: %w", err)
+	}
+	rules, err := parser.ParseWithOptions(v1alpha3.Config{
+		// The action is irrelevant, but it's required.
+		Rules: []v1alpha3.Rule{{Filter: node, Actions: v1alpha3.Actions{Archive: true}}},
+	}, parseOptions())
+	if err != nil {
+		var rerr parser.RuleError
+		if errors.As(err, &rerr) {
+			err = rerr.Err
+		}
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	fs, err := filter.FromRules(rules)
+	if err != nil {
+		// Drop the index of the rule.
+		if cause := errors.Unwrap(err); cause != nil {
+			err = cause
+		}
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	for _, f := range fs {
+		fmt.Fprintln(out, f.Criteria.ToGmailSearch())
+	}
+	return nil
+}
+
+// debug writes the rules generated by the config in path, numbered as the
+// config rules they come from. A config rule can generate multiple rules and
+// rules with the same criteria are merged together.
+func debug(path string, out io.Writer) error {
 	parseRes, err := parseConfig(path, "", false)
 	if err != nil {
 		return err
 	}
-	configRules := parseRes.Config.Rules
-	parsedRules := parseRes.Res.Rules
-
-	if exp, got := len(configRules), len(parsedRules); exp != got {
-		return fmt.Errorf(
-			"unexpected number of generated rules: got %d, expected %d",
-			got, exp,
-		)
-	}
 
-	for i := 0; i < len(parsedRules); i++ {
-		parsed := parsedRules[i]
+	for i, parsed := range parseRes.Res.Rules {
 		criteria, err := filter.GenerateCriteria(parsed.Criteria)
 		if err != nil {
 			return fmt.Errorf("generating criteria: %w", err)
 		}
 		search := criteria.ToGmailSearch()
 
-		fmt.Printf("# Search: %s\n", search)
-		fmt.Printf("# URL: %s\n", toGmailURL(search))
-		cfg := parsedRules[i]
-		b, err := yaml.Marshal(cfg)
+		fmt.Fprintf(out, "# Rule: #%d\n", parseRes.Res.RuleIndexes[i])
+		fmt.Fprintf(out, "# Search: %s\n", search)
+		fmt.Fprintf(out, "# URL: %s\n", toGmailURL(search))
+		b, err := yaml.Marshal(parsed)
 		if err != nil {
 			return fmt.Errorf("marshalling rule: %w", err)
 		}
-		fmt.Println(string(b))
+		fmt.Fprintln(out, string(b))
 	}
 
 	return nil
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   string
+	}{
+		{
+			name:   "leaf",
+			filter: `{ from: 'a@b.com' }`,
+			want:   "from:a@b.com\n",
+		},
+		{
+			name:   "json",
+			filter: `{"subject": "hello world"}`,
+			want:   "subject:\"hello world\"\n",
+		},
+		{
+			name: "nested",
+			filter: `{
+  and: [
+    { or: [{ list: 'dev@lists.com' }, { list: 'users@lists.com' }] },
+    { not: { subject: 'digest' } },
+  ],
+}`,
+			want: "list:dev@lists.com -subject:digest\nlist:users@lists.com -subject:digest\n",
+		},
+		{
+			name:   "raw",
+			filter: `{ and: [{ from: 'a@b.com' }, { rawQuery: 'x OR y' }] }`,
+			want:   "from:a@b.com x OR y\n",
+		},
+		{
+			name:   "library",
+			filter: `local lib = import 'gmailctl.libsonnet'; lib.fromDomain('b.com')`,
+			want:   "from:@b.com\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			require.Nil(t, debugQuery(tc.filter, &out))
+			assert.Equal(t, tc.want, out.String())
+		})
+	}
+}
+
+func TestDebugQueryErrors(t *testing.T) {
+	var out bytes.Buffer
+	err := debugQuery(`{ from: `, &out)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "syntax error in filter")
+
+	err = debugQuery(`{ from: 'a', to: 'b' }`, &out)
+	require.NotNil(t, err)
+	assert.Equal(t, "invalid filter: parsing criteria: multiple fields specified in the same filter node: from,to", err.Error())
+
+	err = debugQuery(`{ size: '5M' }`, &out)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `unknown field "size"`)
+	assert.Empty(t, out.String())
+}
+
+func TestDebugMergedRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.jsonnet")
+	require.Nil(t, os.WriteFile(path, []byte(`{
+  version: 'v1alpha3',
+  rules: [
+    { filter: { from: 'a' }, actions: { archive: true } },
+    { filter: { from: 'a' }, actions: { markRead: true } },
+    { filter: { from: 'b' }, actionGroups: [{ labels: ['l1'] }, { labels: ['l2'] }] },
+  ],
+}`), 0o600))
+
+	var out bytes.Buffer
+	require.Nil(t, debug(path, &out))
+	var headers []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "# Rule: ") || strings.HasPrefix(line, "# Search: ") {
+			headers = append(headers, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"# Rule: #0", "# Search: from:a",
+		"# Rule: #2", "# Search: from:b",
+		"# Rule: #2", "# Search: from:b",
+	}, headers)
+}
@@ -1,15 +1,40 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/spf13/cobra"
 
 	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 var (
-	diffFilename string
+	diffFilename    string
+	diffFormat      string
+	diffExitZero    bool
+	diffOnlyAdded   bool
+	diffOnlyRemoved bool
+	diffContext     int
+	diffSummaryOnly bool
+	diffOnlyFilters bool
+	diffWordDiff    bool
+	diffVerbose     bool
+	diffFailOn      []string
+	diffPruneMatch  string
+)
+
+// changeKind is a kind of change in a diff, selected by --fail-on.
+type changeKind string
+
+// Kinds of changes.
+const (
+	changeAdded    changeKind = "added"
+	changeRemoved  changeKind = "removed"
+	changeModified changeKind = "modified"
 )
 
 // diffCmd represents the diff command
@@ -20,15 +45,75 @@ var diffCmd = &cobra.Command{
 configuration and the current Gmail settings of your account.
 
 By default diff uses the configuration file inside the config
-directory [config.jsonnet].`,
+directory [config.jsonnet].
+
+With '-f -' the configuration is read from stdin instead. Its format is
+detected from the content, or it can be given with --input-format.
+
+Like every command, diff exits with 0 on success and 1 on errors. If
+there are changes to apply, it exits with 2 instead, unless --exit-zero
+is specified. With --fail-on, only the given kinds of changes make it
+exit with 2, e.g. '--fail-on removed' allows new filters and labels but
+not deleting them. A filter replaced by a new one with the same criteria
+or actions, shown next to it in the diff, is modified, as well as a
+label with a new color. A filter replaced by an unrelated one counts as
+added and removed. With --format json, the diff is printed in a
+machine-readable format, suitable for CI.
+
+With --only-added or --only-removed, only the filters and labels to be
+created, or to be deleted, are shown. The summary always counts all
+the changes.
+
+With --context N, up to N unchanged filters around each change are shown
+as well, in the order of the configuration, to help locating the changes.
+Like in any unified diff, unchanged lines have no +/- marker, and '...'
+marks the unchanged filters that have been left out.
+
+With --diff-only-filters, labels are ignored: only the filters are
+compared, and the labels they use must already exist.
+
+With --prune-filters-matching, only the existing filters whose Gmail
+query matches the given regular expression are compared, like in
+apply: the others are not shown as deleted.
+
+With --word-diff, the criteria of the changed filters are compared term
+by term, instead of line by line: removed terms are marked as [-term-]
+and added ones as {+term+}. Filters with different actions are still
+shown as a regular diff.
+
+With --verbose, the Gmail query generated for every filter is shown under
+it, exactly as it's going to be stored in Gmail, to verify how the
+criteria are translated.
+
+With --summary-only, only the counts of the changes are printed, in a
+single line like '+12 filters, -3 filters, +2 labels'. With --format
+json, they are printed as a JSON object.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		f := diffFilename
 		if f == "" {
 			f = configFilenameFromDir(cfgDir)
 		}
-		if err := diff(f); err != nil {
+		failOn, err := parseFailOn(diffFailOn)
+		if err != nil {
 			fatal(err)
 		}
+		changes, err := diff(f, diffOptions{
+			format:      diffFormat,
+			onlyAdded:   diffOnlyAdded,
+			onlyRemoved: diffOnlyRemoved,
+			context:     diffContext,
+			summaryOnly: diffSummaryOnly,
+			onlyFilters: diffOnlyFilters,
+			wordDiff:    diffWordDiff,
+			verbose:     diffVerbose,
+			pruneMatch:  diffPruneMatch,
+		})
+		if err != nil {
+			fatal(err)
+		}
+		if code := diffExitCode(changes, failOn, diffExitZero); code != exitOK {
+			os.Exit(code)
+		}
 	},
 }
 
@@ -37,29 +122,177 @@ func init() {
 
 	// Flags and configuration settings
 	diffCmd.PersistentFlags().StringVarP(&diffFilename, "filename", "f", "", "configuration file")
+	addInputFormatFlag(diffCmd)
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "output format (text or json)")
+	diffCmd.Flags().BoolVar(&diffExitZero, "exit-zero", false, "exit with zero even if there are changes")
+	diffCmd.Flags().BoolVar(&diffExitZero, "no-exit-code", false, "exit with zero even if there are changes")
+	_ = diffCmd.Flags().MarkDeprecated("no-exit-code", "use --exit-zero instead")
+	diffCmd.Flags().BoolVar(&diffOnlyAdded, "only-added", false, "show only the filters and labels to be created")
+	diffCmd.Flags().BoolVar(&diffOnlyRemoved, "only-removed", false, "show only the filters and labels to be deleted")
+	diffCmd.Flags().IntVar(&diffContext, "context", 0, "number of unchanged filters to show around each change")
+	diffCmd.Flags().BoolVar(&diffSummaryOnly, "summary-only", false, "print only the number of changes")
+	diffCmd.Flags().BoolVar(&diffOnlyFilters, "diff-only-filters", false, "ignore labels, compare only the filters")
+	diffCmd.Flags().BoolVar(&diffWordDiff, "word-diff", false, "show the changes to the criteria term by term")
+	diffCmd.Flags().BoolVar(&diffVerbose, "verbose", false, "show the Gmail query of every filter")
+	diffCmd.Flags().StringVar(&diffPruneMatch, "prune-filters-matching", "", "only compare the existing filters whose query matches this regular expression")
+	diffCmd.Flags().StringSliceVar(&diffFailOn, "fail-on", []string{"added", "removed", "modified"},
+		"kinds of changes that make diff exit with 2 (added, removed, modified)")
 }
 
-func diff(path string) error {
+// diffOptions control how the diff is computed and shown.
+type diffOptions struct {
+	format      string
+	onlyAdded   bool
+	onlyRemoved bool
+	context     int
+	summaryOnly bool
+	onlyFilters bool
+	wordDiff    bool
+	verbose     bool
+	pruneMatch  string
+}
+
+func diff(path string, opts diffOptions) (map[changeKind]bool, error) {
+	if opts.format != "text" && opts.format != "json" {
+		return nil, fmt.Errorf("unsupported format %q", opts.format)
+	}
+	if opts.onlyAdded && opts.onlyRemoved {
+		return nil, errors.New("--only-added and --only-removed are mutually exclusive")
+	}
+	if opts.context < 0 {
+		return nil, errors.New("--context must not be negative")
+	}
+	if opts.summaryOnly && (opts.onlyAdded || opts.onlyRemoved || opts.context > 0) {
+		return nil, errors.New("--summary-only can't be used with --only-added, --only-removed or --context")
+	}
+	if opts.wordDiff && (opts.format == "json" || opts.summaryOnly || opts.context > 0) {
+		return nil, errors.New("--word-diff can't be used with --format json, --summary-only or --context")
+	}
+	if opts.verbose && (opts.format == "json" || opts.summaryOnly) {
+		return nil, errors.New("--verbose can't be used with --format json or --summary-only")
+	}
+	managed, err := managedFilters(opts.pruneMatch)
+	if err != nil {
+		return nil, err
+	}
+	side := papply.BothSides
+	if opts.onlyAdded {
+		side = papply.AddedOnly
+	} else if opts.onlyRemoved {
+		side = papply.RemovedOnly
+	}
+
 	parseRes, err := parseConfig(path, "", false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	gmailapi, err := openAPI()
 	if err != nil {
-		return configurationError(fmt.Errorf("cannot connect to Gmail: %w", err))
+		return nil, configurationError(fmt.Errorf("cannot connect to Gmail: %w", err))
 	}
 
 	upstream, err := upstreamConfig(gmailapi)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	diff, err := papply.Diff(parseRes.Res.GmailConfig, upstream)
+	local, err := guardedConfig(parseRes, upstream)
 	if err != nil {
-		return fmt.Errorf("cannot compare upstream with local config: %w", err)
+		return nil, err
 	}
+	local, err = matchLabelCase(local, upstream, false)
+	if err != nil {
+		return nil, err
+	}
+	if opts.onlyFilters {
+		if local, err = filtersOnly(local, upstream); err != nil {
+			return nil, err
+		}
+	}
+	if managed != nil {
+		upstream = papply.ManagedFilters(local, upstream, managed)
+	}
+
+	diff, err := papply.Diff(local, upstream)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compare upstream with local config: %w", err)
+	}
+
+	if opts.summaryOnly {
+		return diffChanges(diff), writeSummary(os.Stdout, diff, opts.format)
+	}
+
+	if opts.format == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(papply.NewJSONDiff(diff, side)); err != nil {
+			return nil, fmt.Errorf("encoding diff: %w", err)
+		}
+		return diffChanges(diff), nil
+	}
+
+	if opts.context > 0 {
+		diff.FiltersDiff = diff.FiltersDiff.WithContext(parseRes.Res.Filters, opts.context)
+	}
+	if opts.wordDiff {
+		diff.FiltersDiff = diff.FiltersDiff.WithWordDiff()
+	}
+	if opts.verbose {
+		diff.FiltersDiff = diff.FiltersDiff.WithQueries()
+	}
+	fmt.Print(diff.Render(side))
+	return diffChanges(diff), nil
+}
 
-	fmt.Print(diff)
-	return nil
+// diffChanges returns the kinds of changes in the diff.
+//
+// Added and removed filters are paired in the diff: the pairs with the same
+// criteria or actions are modified filters (see FiltersDiff.Modified), while
+// the others are added and removed.
+func diffChanges(d papply.ConfigDiff) map[changeKind]bool {
+	m := d.FiltersDiff.Modified()
+	return map[changeKind]bool{
+		changeAdded:    len(d.FiltersDiff.Added) > m || len(d.LabelsDiff.Added) > 0,
+		changeRemoved:  len(d.FiltersDiff.Removed) > m || len(d.LabelsDiff.Removed) > 0,
+		changeModified: m > 0 || len(d.LabelsDiff.Modified) > 0,
+	}
+}
+
+// parseFailOn parses the kinds of changes given to --fail-on.
+func parseFailOn(values []string) (map[changeKind]bool, error) {
+	res := map[changeKind]bool{}
+	for _, v := range values {
+		switch k := changeKind(v); k {
+		case changeAdded, changeRemoved, changeModified:
+			res[k] = true
+		default:
+			return nil, fmt.Errorf("unsupported --fail-on value %q, expected added, removed or modified", v)
+		}
+	}
+	return res, nil
+}
+
+// diffExitCode returns the exit code of diff, given the kinds of changes to
+// apply and the ones that make it fail.
+func diffExitCode(changes, failOn map[changeKind]bool, exitZero bool) int {
+	if exitZero {
+		return exitOK
+	}
+	for k := range failOn {
+		if changes[k] {
+			return exitChanges
+		}
+	}
+	return exitOK
+}
+
+// writeSummary writes the number of changes in the diff, in the given format.
+func writeSummary(w io.Writer, d papply.ConfigDiff, format string) error {
+	if format == "json" {
+		if err := json.NewEncoder(w).Encode(d.Summary()); err != nil {
+			return fmt.Errorf("encoding summary: %w", err)
+		}
+		return nil
+	}
+	_, err := fmt.Fprintln(w, d.Summary())
+	return err
 }
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestWriteSummary(t *testing.T) {
+	upstream := papply.GmailConfig{
+		Labels: label.Labels{{ID: "1", Name: "old"}},
+		Filters: filter.Filters{
+			{
+				ID:       "f1",
+				Criteria: filter.Criteria{From: "spam@example.com"},
+				Action:   filter.Actions{Delete: true},
+			},
+		},
+	}
+	local := papply.GmailConfig{
+		Labels: label.Labels{{Name: "work"}, {Name: "family"}},
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "boss@work.com"},
+				Action:   filter.Actions{AddLabel: "work"},
+			},
+			{
+				Criteria: filter.Criteria{From: "mom@home.com"},
+				Action:   filter.Actions{AddLabel: "family"},
+			},
+		},
+	}
+	d, err := papply.Diff(local, upstream)
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	require.Nil(t, writeSummary(&buf, d, "text"))
+	assert.Equal(t, "+2 filters, -1 filters, +2 labels, -1 labels\n", buf.String())
+
+	buf.Reset()
+	require.Nil(t, writeSummary(&buf, d, "json"))
+	assert.JSONEq(t, `{
+		"filtersAdded": 2,
+		"filtersRemoved": 1,
+		"labelsAdded": 2,
+		"labelsRemoved": 1,
+		"labelsModified": 0
+	}`, buf.String())
+}
+
+func TestWriteSummaryEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, writeSummary(&buf, papply.ConfigDiff{}, "text"))
+	assert.Equal(t, "no changes\n", buf.String())
+}
+
+func TestDiffExitCode(t *testing.T) {
+	all := map[changeKind]bool{changeAdded: true, changeRemoved: true, changeModified: true}
+	tests := []struct {
+		name     string
+		changes  map[changeKind]bool
+		failOn   map[changeKind]bool
+		exitZero bool
+		want     int
+	}{
+		{name: "no changes", failOn: all, want: exitOK},
+		{name: "changes", changes: map[changeKind]bool{changeAdded: true}, failOn: all, want: exitChanges},
+		{name: "changes exit zero", changes: all, failOn: all, exitZero: true, want: exitOK},
+		{name: "no changes exit zero", failOn: all, exitZero: true, want: exitOK},
+		{
+			name:    "added allowed",
+			changes: map[changeKind]bool{changeAdded: true},
+			failOn:  map[changeKind]bool{changeRemoved: true},
+			want:    exitOK,
+		},
+		{
+			name:    "removed forbidden",
+			changes: map[changeKind]bool{changeAdded: true, changeRemoved: true},
+			failOn:  map[changeKind]bool{changeRemoved: true},
+			want:    exitChanges,
+		},
+		{
+			name:    "modified allowed",
+			changes: map[changeKind]bool{changeModified: true},
+			failOn:  map[changeKind]bool{changeAdded: true, changeRemoved: true},
+			want:    exitOK,
+		},
+		{
+			name:    "modified forbidden",
+			changes: map[changeKind]bool{changeModified: true},
+			failOn:  map[changeKind]bool{changeModified: true},
+			want:    exitChanges,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, diffExitCode(tc.changes, tc.failOn, tc.exitZero))
+		})
+	}
+}
+
+func TestDiffChanges(t *testing.T) {
+	mkFilters := func(froms ...string) filter.Filters {
+		var res filter.Filters
+		for _, f := range froms {
+			res = append(res, filter.Filter{
+				Criteria: filter.Criteria{From: f},
+				Action:   filter.Actions{Archive: true},
+			})
+		}
+		return res
+	}
+	tests := []struct {
+		name string
+		diff papply.ConfigDiff
+		want []changeKind
+	}{
+		{name: "empty"},
+		{
+			name: "only added",
+			diff: papply.ConfigDiff{
+				FiltersDiff: filter.FiltersDiff{Added: mkFilters("a", "b")},
+				LabelsDiff:  label.LabelsDiff{Added: label.Labels{{Name: "new"}}},
+			},
+			want: []changeKind{changeAdded},
+		},
+		{
+			name: "only removed",
+			diff: papply.ConfigDiff{
+				LabelsDiff: label.LabelsDiff{Removed: label.Labels{{Name: "old"}}},
+			},
+			want: []changeKind{changeRemoved},
+		},
+		{
+			name: "replaced",
+			diff: papply.ConfigDiff{
+				FiltersDiff: filter.FiltersDiff{Added: mkFilters("a"), Removed: mkFilters("b")},
+			},
+			want: []changeKind{changeModified},
+		},
+		{
+			name: "replaced and added",
+			diff: papply.ConfigDiff{
+				FiltersDiff: filter.FiltersDiff{Added: mkFilters("a", "c"), Removed: mkFilters("b")},
+			},
+			want: []changeKind{changeAdded, changeModified},
+		},
+		{
+			name: "unrelated replaced",
+			diff: papply.ConfigDiff{
+				FiltersDiff: filter.FiltersDiff{
+					Added:   filter.Filters{{Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{Star: true}}},
+					Removed: mkFilters("b"),
+				},
+			},
+			want: []changeKind{changeAdded, changeRemoved},
+		},
+		{
+			name: "unrelated replaced and modified",
+			diff: papply.ConfigDiff{
+				FiltersDiff: filter.FiltersDiff{
+					Added:   append(mkFilters("a"), filter.Filter{Criteria: filter.Criteria{From: "c"}, Action: filter.Actions{Star: true}}),
+					Removed: mkFilters("b", "d"),
+				},
+			},
+			want: []changeKind{changeAdded, changeRemoved, changeModified},
+		},
+		{
+			name: "label color",
+			diff: papply.ConfigDiff{
+				LabelsDiff: label.LabelsDiff{Modified: []label.ModifiedLabel{{}}},
+			},
+			want: []changeKind{changeModified},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []changeKind
+			for _, k := range []changeKind{changeAdded, changeRemoved, changeModified} {
+				if diffChanges(tc.diff)[k] {
+					got = append(got, k)
+				}
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseFailOn(t *testing.T) {
+	got, err := parseFailOn([]string{"removed", "modified"})
+	require.Nil(t, err)
+	assert.Equal(t, map[changeKind]bool{changeRemoved: true, changeModified: true}, got)
+
+	_, err = parseFailOn([]string{"deleted"})
+	assert.EqualError(t, err, `unsupported --fail-on value "deleted", expected added, removed or modified`)
+}
+
+func TestDiffExitZeroFlags(t *testing.T) {
+	defer func() { diffExitZero = false }()
+
+	for _, name := range []string{"exit-zero", "no-exit-code"} {
+		diffExitZero = false
+		require.Nil(t, diffCmd.Flags().Set(name, "true"))
+		assert.True(t, diffExitZero, name)
+	}
+}
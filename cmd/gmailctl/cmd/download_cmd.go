@@ -4,10 +4,19 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
-
+	"gopkg.in/yaml.v3"
+
+	"github.com/mbrt/gmailctl/internal/engine/api"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
 	"github.com/mbrt/gmailctl/internal/engine/rimport"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 const downloadHeader = `// Auto-imported filters by 'gmailctl download'.
@@ -20,8 +29,23 @@ const downloadHeader = `// Auto-imported filters by 'gmailctl download'.
 // local lib = import 'gmailctl.libsonnet';
 `
 
+// downloadYAMLHeader is the header of the configs downloaded in v1alpha2,
+// which are written in YAML.
+const downloadYAMLHeader = `# Auto-imported filters by 'gmailctl download'.
+#
+# WARNING: This functionality is experimental. Before making any
+# changes, check that no diff is detected with the remote filters by
+# using the 'diff' command.
+`
+
 var (
-	downloadOutput string
+	downloadOutput      string
+	downloadFiltersOnly bool
+	downloadLabelsOnly  bool
+	downloadMatching    string
+	downloadLabel       string
+	downloadSince       string
+	downloadVersion     string
 )
 
 // downloadCmd represents the import command
@@ -35,10 +59,47 @@ The resulting config won't be pretty, but it should be a good starting
 point if your filters have been managed by other means and you want to
 move to gmailctl.
 
+With --filters-only, labels are left out of the config, so they are not
+managed by gmailctl. With --labels-only, only labels are downloaded and
+the config contains no rules: applying it as is would delete all the
+existing filters.
+
+With --matching, only the filters whose search query contains the given
+text (case insensitive) are downloaded, and with --label, only the ones
+applying the given label. Both can be combined, to capture only the
+filters relevant to a given project. Filters have no creation time in
+the Gmail API, so they can't be selected by date. Note that applying a
+subset as is would delete all the other filters.
+
+With --config-version, the config is written in the given version of
+the configuration format, for compatibility with existing configs. The
+latest version is used by default. With v1alpha2 the config is written
+in YAML, without labels, as v1alpha2 configs don't manage them, and the
+download fails if any filter uses features that v1alpha2 doesn't
+support, like forwarding.
+
 WARNING: This functionality is experimental. After downloading, verify
 that no diff is detected with the remote filters by using the 'diff'
 command.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if downloadFiltersOnly && downloadLabelsOnly {
+			fatal(errors.New("--filters-only and --labels-only are mutually exclusive"))
+		}
+		if downloadSince != "" {
+			fatal(errors.WithDetails(
+				errors.New("filters can't be selected by date, because Gmail doesn't record when they were created"),
+				"Use --matching <text> or --label <name> to download only the filters of a project",
+			))
+		}
+		if downloadLabelsOnly && (downloadMatching != "" || downloadLabel != "") {
+			fatal(errors.New("--labels-only can't be used with --matching or --label"))
+		}
+		if err := config.CheckVersion(downloadVersion); err != nil {
+			fatal(err)
+		}
+		if downloadLabelsOnly && downloadVersion == v1alpha2.Version {
+			fatal(errors.New("--labels-only can't be used with v1alpha2 configs, which don't manage labels"))
+		}
 		if err := download(downloadOutput); err != nil {
 			fatal(err)
 		}
@@ -50,6 +111,43 @@ func init() {
 
 	// Flags and configuration settings
 	downloadCmd.PersistentFlags().StringVarP(&downloadOutput, "output", "o", "", "output file (default to stdout)")
+	downloadCmd.Flags().BoolVarP(&downloadFiltersOnly, "filters-only", "", false, "download only the filters, without labels")
+	downloadCmd.Flags().BoolVarP(&downloadLabelsOnly, "labels-only", "", false, "download only the labels, without filters")
+	downloadCmd.Flags().StringVar(&downloadMatching, "matching", "", "download only the filters whose search query contains the given text")
+	downloadCmd.Flags().StringVar(&downloadLabel, "label", "", "download only the filters applying the given label")
+	downloadCmd.Flags().StringVar(&downloadSince, "since", "", "not supported, filters have no creation time")
+	_ = downloadCmd.Flags().MarkHidden("since")
+	downloadCmd.Flags().StringVar(&downloadVersion, "config-version", config.LatestVersion, "version of the configuration format to write")
+}
+
+// filterSelector selects a subset of the filters to download. The zero
+// value selects all of them.
+type filterSelector struct {
+	// Matching is a case insensitive substring of the search query.
+	Matching string
+	// Label is the name of a label applied by the filter.
+	Label string
+}
+
+func (s filterSelector) selects(f filter.Filter) bool {
+	if s.Matching != "" &&
+		!strings.Contains(strings.ToLower(f.Criteria.ToGmailSearch()), strings.ToLower(s.Matching)) {
+		return false
+	}
+	return s.Label == "" || f.Action.AddLabel == s.Label
+}
+
+func (s filterSelector) apply(fs filter.Filters) filter.Filters {
+	if s == (filterSelector{}) {
+		return fs
+	}
+	var res filter.Filters
+	for _, f := range fs {
+		if s.selects(f) {
+			res = append(res, f)
+		}
+	}
+	return res
 }
 
 func download(outputPath string) (err error) {
@@ -78,20 +176,63 @@ func downloadWithOut(out io.Writer) error {
 	if err != nil {
 		return configurationError(fmt.Errorf("connecting to Gmail: %w", err))
 	}
+	sel := filterSelector{Matching: downloadMatching, Label: downloadLabel}
+	return downloadConfig(gmailapi, out, downloadFiltersOnly, downloadLabelsOnly, sel, downloadVersion)
+}
 
-	upstream, err := upstreamConfig(gmailapi)
-	if err != nil {
-		return err
+func downloadConfig(gmailapi *api.GmailAPI, out io.Writer, filtersOnly, labelsOnly bool, sel filterSelector, version string) error {
+	var (
+		upstream papply.GmailConfig
+		err      error
+	)
+	if labelsOnly {
+		// Filters are not needed at all, so we don't fetch them.
+		upstream.Labels, err = gmailapi.ListLabels()
+		if err != nil {
+			return fmt.Errorf("listing labels from Gmail: %w", err)
+		}
+	} else {
+		upstream, err = upstreamConfig(gmailapi)
+		if err != nil {
+			return err
+		}
+	}
+	if filtersOnly {
+		upstream.Labels = nil
 	}
+	upstream.Filters = sel.apply(upstream.Filters)
 
 	cfg, err := rimport.Import(upstream.Filters, upstream.Labels)
 	if err != nil {
 		return err
 	}
+	if cfg.Rules == nil {
+		// Rules are mandatory in the config.
+		cfg.Rules = []v1alpha3.Rule{}
+	}
 
-	err = rimport.MarshalJsonnet(cfg, out, downloadHeader)
+	res, err := config.ConvertTo(cfg, version)
+	if err != nil {
+		return err
+	}
+	if version == v1alpha2.Version {
+		return writeYAMLConfig(res, out)
+	}
+	err = rimport.MarshalJsonnet(res, out, downloadHeader)
 	if err != nil {
 		return fmt.Errorf("converting to Jsonnet: %w", err)
 	}
 	return nil
 }
+
+func writeYAMLConfig(cfg interface{}, out io.Writer) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("converting to YAML: %w", err)
+	}
+	if _, err := io.WriteString(out, downloadYAMLHeader); err != nil {
+		return err
+	}
+	_, err = out.Write(b)
+	return err
+}
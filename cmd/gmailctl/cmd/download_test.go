@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gmailv1 "google.golang.org/api/gmail/v1"
+
+	"github.com/mbrt/gmailctl/internal/engine/api"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/fakegmail"
+)
+
+func TestDownloadSubset(t *testing.T) {
+	gmailapi := api.NewFromService(fakegmail.NewService(context.Background(), t))
+	require.Nil(t, gmailapi.AddLabels(label.Labels{{Name: "work"}, {Name: "family"}}))
+	require.Nil(t, gmailapi.AddFilters(filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "boss@work.com"},
+			Action:   filter.Actions{AddLabel: "work"},
+		},
+		{
+			Criteria: filter.Criteria{From: "spam@example.com"},
+			Action:   filter.Actions{Delete: true},
+		},
+	}))
+
+	tests := []struct {
+		name        string
+		filtersOnly bool
+		labelsOnly  bool
+		wantFilters int
+		wantLabels  int
+	}{
+		{name: "all", wantFilters: 2, wantLabels: 2},
+		{name: "filters only", filtersOnly: true, wantFilters: 2, wantLabels: 0},
+		{name: "labels only", labelsOnly: true, wantFilters: 0, wantLabels: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := downloadConfig(gmailapi, &buf, tc.filtersOnly, tc.labelsOnly, filterSelector{}, config.LatestVersion)
+			require.Nil(t, err)
+
+			// The result has to be a valid config.
+			cfg, err := config.ReadJsonnet("", buf.Bytes(), config.ReadOptions{})
+			require.Nil(t, err)
+			res, err := papply.FromConfig(cfg)
+			require.Nil(t, err)
+			assert.Len(t, res.Filters, tc.wantFilters)
+			assert.Len(t, cfg.Labels, tc.wantLabels)
+		})
+	}
+}
+
+func TestDownloadUnsupportedFilters(t *testing.T) {
+	svc := fakegmail.NewService(context.Background(), t)
+	gmailapi := api.NewFromService(svc)
+	// A filter created by another tool, removing the star, which is not
+	// modeled by gmailctl.
+	_, err := svc.Users.Settings.Filters.Create("me", &gmailv1.Filter{
+		Criteria: &gmailv1.FilterCriteria{From: "foo@bar.com"},
+		Action:   &gmailv1.FilterAction{RemoveLabelIds: []string{"STARRED"}},
+	}).Do()
+	require.Nil(t, err)
+
+	// The unsupported filter alone doesn't fail the download.
+	var buf bytes.Buffer
+	require.Nil(t, downloadConfig(gmailapi, &buf, false, false, filterSelector{}, config.LatestVersion))
+	cfg, err := config.ReadJsonnet("", buf.Bytes(), config.ReadOptions{})
+	require.Nil(t, err)
+	assert.Empty(t, cfg.Rules)
+
+	// The supported filters are downloaded.
+	require.Nil(t, gmailapi.AddFilters(filter.Filters{{
+		Criteria: filter.Criteria{From: "spam@example.com"},
+		Action:   filter.Actions{Delete: true},
+	}}))
+	buf.Reset()
+	require.Nil(t, downloadConfig(gmailapi, &buf, false, false, filterSelector{}, config.LatestVersion))
+	cfg, err = config.ReadJsonnet("", buf.Bytes(), config.ReadOptions{})
+	require.Nil(t, err)
+	assert.Len(t, cfg.Rules, 1)
+}
+
+func TestFilterSelector(t *testing.T) {
+	fs := filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "boss@work.com"},
+			Action:   filter.Actions{AddLabel: "work"},
+		},
+		{
+			Criteria: filter.Criteria{Query: "list:ci@Work.com"},
+			Action:   filter.Actions{AddLabel: "ci"},
+		},
+		{
+			Criteria: filter.Criteria{From: "spam@example.com"},
+			Action:   filter.Actions{Delete: true},
+		},
+	}
+
+	tests := []struct {
+		name string
+		sel  filterSelector
+		want filter.Filters
+	}{
+		{name: "all", want: fs},
+		{
+			name: "matching case insensitive",
+			sel:  filterSelector{Matching: "WORK.com"},
+			want: fs[:2],
+		},
+		{
+			name: "matching the operator",
+			sel:  filterSelector{Matching: "list:"},
+			want: fs[1:2],
+		},
+		{
+			name: "label",
+			sel:  filterSelector{Label: "work"},
+			want: fs[:1],
+		},
+		{
+			name: "both",
+			sel:  filterSelector{Matching: "work.com", Label: "ci"},
+			want: fs[1:2],
+		},
+		{
+			name: "none",
+			sel:  filterSelector{Matching: "family"},
+			want: nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.sel.apply(fs))
+		})
+	}
+}
+
+func TestDownloadSelected(t *testing.T) {
+	gmailapi := api.NewFromService(fakegmail.NewService(context.Background(), t))
+	require.Nil(t, gmailapi.AddLabels(label.Labels{{Name: "work"}}))
+	require.Nil(t, gmailapi.AddFilters(filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "boss@work.com"},
+			Action:   filter.Actions{AddLabel: "work"},
+		},
+		{
+			Criteria: filter.Criteria{From: "spam@example.com"},
+			Action:   filter.Actions{Delete: true},
+		},
+	}))
+
+	var buf bytes.Buffer
+	err := downloadConfig(gmailapi, &buf, false, false, filterSelector{Matching: "boss"}, config.LatestVersion)
+	require.Nil(t, err)
+	cfg, err := config.ReadJsonnet("", buf.Bytes(), config.ReadOptions{})
+	require.Nil(t, err)
+	res, err := papply.FromConfig(cfg)
+	require.Nil(t, err)
+	require.Len(t, res.Filters, 1)
+	assert.Equal(t, "boss@work.com", res.Filters[0].Criteria.From)
+}
+
+func TestDownloadConfigVersion(t *testing.T) {
+	gmailapi := api.NewFromService(fakegmail.NewService(context.Background(), t))
+	require.Nil(t, gmailapi.AddLabels(label.Labels{{Name: "work"}}))
+	require.Nil(t, gmailapi.AddFilters(filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "spam@example.com"},
+			Action:   filter.Actions{Delete: true},
+		},
+		{
+			Criteria: filter.Criteria{From: "boss@work.com"},
+			Action:   filter.Actions{AddLabel: "work"},
+		},
+	}))
+
+	var buf bytes.Buffer
+	require.Nil(t, downloadConfig(gmailapi, &buf, false, false, filterSelector{}, "v1alpha3"))
+	cfg, err := config.ReadJsonnet("", buf.Bytes(), config.ReadOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "v1alpha3", cfg.Version)
+
+	// Older configs are written in YAML and read through the migration.
+	buf.Reset()
+	require.Nil(t, downloadConfig(gmailapi, &buf, false, false, filterSelector{}, "v1alpha2"))
+	cfg, m, err := config.Read(&buf, config.InputAuto, "", config.ReadOptions{})
+	require.Nil(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, "v1alpha2", m.From)
+	assert.Empty(t, cfg.Labels)
+	require.Len(t, cfg.Rules, 2)
+	assert.Equal(t, []string{"work"}, cfg.Rules[1].Actions.Labels)
+
+	buf.Reset()
+	err = downloadConfig(gmailapi, &buf, false, false, filterSelector{}, "v1alpha1")
+	assert.ErrorContains(t, err, "unsupported config version: v1alpha1")
+	assert.Empty(t, buf.String())
+}
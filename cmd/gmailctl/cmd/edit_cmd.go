@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/mbrt/gmailctl"
 	"github.com/mbrt/gmailctl/internal/engine/api"
 	papply "github.com/mbrt/gmailctl/internal/engine/apply"
 	"github.com/mbrt/gmailctl/internal/engine/config"
@@ -196,7 +198,16 @@ func applyEdited(path, originalPath string, test bool, gmailapi *api.GmailAPI) e
 		return err
 	}
 
-	diff, err := papply.Diff(parseRes.Res.GmailConfig, upstream)
+	local, err := guardedConfig(parseRes, upstream)
+	if err != nil {
+		return err
+	}
+	local, err = matchLabelCase(local, upstream, false)
+	if err != nil {
+		return err
+	}
+
+	diff, err := papply.Diff(local, upstream)
 	if err != nil {
 		return errors.New("comparing upstream with local config")
 	}
@@ -208,7 +219,10 @@ func applyEdited(path, originalPath string, test bool, gmailapi *api.GmailAPI) e
 
 	fmt.Printf("You are going to apply the following changes to your settings:\n\n%s\n", diff)
 
-	if err := diff.Validate(); err != nil {
+	if err := gmailctl.Check(diff, gmailapi); err != nil {
+		return err
+	}
+	if err := papply.CheckFilterLimit(diff, len(upstream.Filters), papply.DefaultMaxFilters); err != nil {
 		return err
 	}
 
@@ -228,5 +242,6 @@ func applyEdited(path, originalPath string, test bool, gmailapi *api.GmailAPI) e
 	}
 
 	fmt.Println("Applying the changes...")
-	return papply.Apply(diff, gmailapi, true)
+	_, err = gmailctl.ApplyDiff(context.Background(), diff, gmailapi, gmailctl.Options{AllowRemoveLabels: true})
+	return err
 }
@@ -7,12 +7,15 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/mbrt/gmailctl/internal/engine/export/structured"
+	"github.com/mbrt/gmailctl/internal/engine/export/terraform"
 	"github.com/mbrt/gmailctl/internal/engine/export/xml"
 )
 
 var (
 	exportFilename  string
 	exportOutput    string
+	exportFormat    string
 	exportSkipTests bool
 )
 
@@ -24,14 +27,26 @@ var exportCmd = &cobra.Command{
 This allows to import them from within the Gmail settings or to share
 them with other people.
 
+With '--format terraform' the filters are exported instead as Terraform
+resources: a 'google_gmail_filter' for each filter and a
+'google_gmail_label' for each label referenced by them.
+
+With '--format json' or '--format yaml' the filters are exported as a
+JSON or YAML document, in the order of the rules generating them and
+with sorted keys. The output is deterministic, so it can be kept in
+version control and diffed.
+
 By default export uses the configuration file inside the config
-directory [config.jsonnet].`,
+directory [config.jsonnet].
+
+With '-f -' the configuration is read from stdin instead. Its format is
+detected from the content, or it can be given with --input-format.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		f := exportFilename
 		if f == "" {
 			f = configFilenameFromDir(cfgDir)
 		}
-		if err := export(f, exportOutput, !exportSkipTests); err != nil {
+		if err := export(f, exportOutput, exportFormat, !exportSkipTests); err != nil {
 			fatal(err)
 		}
 	},
@@ -42,11 +57,19 @@ func init() {
 
 	// Flags and configuration settings
 	exportCmd.PersistentFlags().StringVarP(&exportFilename, "filename", "f", "", "configuration file")
+	addInputFormatFlag(exportCmd)
 	exportCmd.PersistentFlags().StringVarP(&exportOutput, "output", "o", "", "output file (default to stdout)")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "", "xml", "output format (xml, terraform, json or yaml)")
 	exportCmd.Flags().BoolVarP(&exportSkipTests, "yolo", "", false, "skip configuration tests")
 }
 
-func export(inputPath, outputPath string, test bool) (err error) {
+func export(inputPath, outputPath, format string, test bool) (err error) {
+	switch format {
+	case "xml", "terraform", "json", "yaml":
+	default:
+		return fmt.Errorf("unsupported format %q: expected 'xml', 'terraform', 'json' or 'yaml'", format)
+	}
+
 	var out io.Writer
 	if outputPath == "" {
 		out = os.Stdout
@@ -64,13 +87,19 @@ func export(inputPath, outputPath string, test bool) (err error) {
 		}()
 		out = f
 	}
-	return exportWithOut(inputPath, out, test)
+	return exportWithOut(inputPath, out, format, test)
 }
 
-func exportWithOut(path string, out io.Writer, test bool) error {
+func exportWithOut(path string, out io.Writer, format string, test bool) error {
 	pres, err := parseConfig(path, "", test)
 	if err != nil {
 		return err
 	}
+	switch format {
+	case "terraform":
+		return terraform.Export(pres.Res.Filters, out)
+	case "json", "yaml":
+		return structured.Export(pres.Res.Filters, structured.Format(format), out)
+	}
 	return xml.DefaultExporter().Export(pres.Config.Author, pres.Res.Filters, out)
 }
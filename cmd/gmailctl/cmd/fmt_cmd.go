@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+var (
+	fmtFilename string
+	fmtOutput   string
+)
+
+// fmtCmd represents the fmt command
+var fmtCmd = &cobra.Command{
+	Use:   "fmt",
+	Short: "Rewrites the configuration in canonical form",
+	Long: `The fmt command reads the configuration and writes it back in a
+canonical form: labels are sorted by name, rules by their criteria and
+actions, and the indentation is normalized. Running it more than once
+yields the same result, so it can be used to keep configurations edited
+by many people consistent.
+
+JSON configuration files are rewritten in place. Jsonnet files can't be
+rewritten without losing comments, variables and imports, so in that
+case the evaluated configuration is written to stdout instead, unless
+an output file is specified.
+
+By default fmt uses the configuration file inside the config
+directory [config.jsonnet].`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f := fmtFilename
+		if f == "" {
+			f = configFilenameFromDir(cfgDir)
+		}
+		if err := fmtConfig(f, fmtOutput); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fmtCmd)
+
+	// Flags and configuration settings
+	fmtCmd.PersistentFlags().StringVarP(&fmtFilename, "filename", "f", "", "configuration file")
+	fmtCmd.PersistentFlags().StringVarP(&fmtOutput, "output", "o", "", "output file (default to the input for JSON files, stdout otherwise)")
+}
+
+func fmtConfig(path, outputPath string) error {
+	cfg, m, err := config.ReadFile(path, "", readOptions())
+	if err != nil {
+		if errors.Is(err, config.ErrNotFound) {
+			return configurationError(err)
+		}
+		return fmt.Errorf("syntax error in config file: %w", err)
+	}
+	if m != nil {
+		reportMigration(path, *m)
+	}
+
+	var buf bytes.Buffer
+	if err := config.Format(cfg, &buf); err != nil {
+		return fmt.Errorf("formatting config: %w", err)
+	}
+
+	if filepath.Ext(path) != ".json" {
+		stderrPrintf("WARNING: %s is a Jsonnet file: comments, variables and imports\n", path)
+		stderrPrintf("  are lost in the formatted config, which can't replace the original.\n\n")
+	} else if outputPath == "" {
+		outputPath = path
+	}
+	if outputPath == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(outputPath, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/rimport"
+)
+
+const importHeader = `// Auto-imported filters by 'gmailctl import'.
+//
+// WARNING: This functionality is experimental. Review the rules before
+// applying them and use the 'diff' command to check the changes.
+
+// Uncomment if you want to use the standard library.
+// local lib = import 'gmailctl.libsonnet';
+`
+
+var (
+	importFormat string
+	importOutput string
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <rules file>",
+	Short: "Import filters from a desktop email client or Gmail",
+	Long: `The import command converts the filters exported by a desktop
+email client, or by the Gmail settings, into a gmailctl configuration
+file.
+
+The supported formats are:
+- 'thunderbird': Thunderbird's msgFilterRules.dat, which can be found in
+  the directory of every account in the Thunderbird profile. Moving or
+  copying to a folder is translated into a label (moved messages are
+  also archived).
+- 'xml': the mailFilters.xml file exported from the Gmail settings
+  (Settings > Filters and Blocked Addresses > Export).
+
+Conditions and actions that have no gmailctl equivalent are reported as
+warnings and in the header of the config.
+
+The resulting config is meant to be a starting point: merge it with
+your existing one before applying it, or the filters already configured
+on Gmail would be deleted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := importRules(args[0], importFormat, importOutput); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	// Flags and configuration settings
+	importCmd.PersistentFlags().StringVarP(&importFormat, "format", "", "thunderbird", "format of the rules file ('thunderbird' or 'xml')")
+	importCmd.PersistentFlags().StringVarP(&importOutput, "output", "o", "", "output file (default to stdout)")
+}
+
+func importRules(path, format, outputPath string) error {
+	var importFunc func(io.Reader) (v1alpha3.Config, []string, error)
+	switch format {
+	case "thunderbird":
+		importFunc = rimport.ImportThunderbird
+	case "xml":
+		importFunc = rimport.ImportGmailXML
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening rules file: %w", err)
+	}
+	defer f.Close()
+
+	cfg, warnings, err := importFunc(f)
+	if err != nil {
+		return fmt.Errorf("parsing rules file: %w", err)
+	}
+	if cfg.Rules == nil {
+		// Rules are mandatory in the config.
+		cfg.Rules = []v1alpha3.Rule{}
+	}
+
+	header := importHeader
+	if len(warnings) > 0 {
+		var b strings.Builder
+		b.WriteString(importHeader)
+		b.WriteString("\n// Not imported:\n")
+		for _, w := range warnings {
+			stderrPrintf("WARNING: %s\n", w)
+			fmt.Fprintf(&b, "// - %s\n", w)
+		}
+		header = b.String()
+	}
+
+	var buf bytes.Buffer
+	if err := rimport.MarshalJsonnet(cfg, &buf, header); err != nil {
+		return fmt.Errorf("converting to Jsonnet: %w", err)
+	}
+	if outputPath == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(outputPath, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
@@ -15,6 +15,8 @@ var (
 	initReset          bool
 	initRefreshExpired bool
 	initUpdateLib      bool
+	initWithExamples   bool
+	initForce          bool
 )
 
 // initCmd represents the init command
@@ -23,7 +25,14 @@ var initCmd = &cobra.Command{
 	Short: "Initialize the Gmail configuration",
 	Long: `The init command initialize the Gmail configuration, asking
 you for details and guiding you through the process of
-setting up the API authorizations and initial settings.`,
+setting up the API authorizations and initial settings.
+
+With --with-examples, only the config directory is created, with a
+commented starter config.jsonnet and the standard library. The config
+imports the library and contains a few example rules and tests, to be
+adapted before applying it. Existing files are left untouched, unless
+--force is given. Run 'gmailctl init' afterwards to set up the API
+authorizations.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
 		if initReset {
@@ -32,6 +41,8 @@ setting up the API authorizations and initial settings.`,
 			err = refreshToken()
 		} else if initUpdateLib {
 			err = updateLib()
+		} else if initWithExamples {
+			err = scaffold(cfgDir, initForce)
 		} else {
 			err = continueConfig()
 		}
@@ -48,6 +59,8 @@ func init() {
 	initCmd.Flags().BoolVar(&initReset, "reset", false, "Reset the configuration.")
 	initCmd.Flags().BoolVar(&initRefreshExpired, "refresh-expired", false, "Refresh auth token if expired.")
 	initCmd.Flags().BoolVar(&initUpdateLib, "update-lib", false, "Update the library file.")
+	initCmd.Flags().BoolVar(&initWithExamples, "with-examples", false, "Only create a starter config with example rules.")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite the existing files with --with-examples.")
 }
 
 func resetConfig() error {
@@ -69,6 +82,56 @@ func continueConfig() error {
 	return nil
 }
 
+// scaffold creates the config directory with the starter config and the
+// library. Existing files are only overwritten if force is true.
+func scaffold(dir string, force bool) error {
+	written, err := writeScaffold(dir, force)
+	if err != nil {
+		return err
+	}
+	if len(written) == 0 {
+		fmt.Printf("The config already exists in %s, use --force to overwrite it.\n", dir)
+		return nil
+	}
+	for _, f := range written {
+		fmt.Printf("Created %s\n", f)
+	}
+	fmt.Println("\nEdit the config and run 'gmailctl init' to set up the API authorizations.")
+	return nil
+}
+
+// writeScaffold writes the scaffold files and returns the paths of the
+// ones written.
+func writeScaffold(dir string, force bool) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating the config directory: %w", err)
+	}
+	files := []struct {
+		name     string
+		contents string
+	}{
+		{"config.jsonnet", data.ExampleConfig()},
+		{"gmailctl.libsonnet", data.GmailctlLib()},
+	}
+
+	var res []string
+	for _, f := range files {
+		p := path.Join(dir, f.name)
+		if !force {
+			if _, err := os.Stat(p); err == nil {
+				continue
+			} else if !os.IsNotExist(err) {
+				return res, err
+			}
+		}
+		if err := createFile(p, f.contents); err != nil {
+			return res, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
 func refreshToken() error {
 	if rt, ok := APIProvider.(TokenRefresher); ok {
 		return rt.RefreshToken(context.Background(), cfgDir)
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/data"
+)
+
+func TestWriteScaffold(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "gmailctl")
+	cfgPath := filepath.Join(dir, "config.jsonnet")
+	libPath := filepath.Join(dir, "gmailctl.libsonnet")
+
+	written, err := writeScaffold(dir, false)
+	require.Nil(t, err)
+	assert.Equal(t, []string{cfgPath, libPath}, written)
+	b, err := os.ReadFile(cfgPath)
+	require.Nil(t, err)
+	assert.Equal(t, data.ExampleConfig(), string(b))
+
+	// The starter config is valid and its tests pass.
+	_, err = parseConfig(cfgPath, "", true)
+	require.Nil(t, err)
+
+	// Running again without force leaves the files untouched.
+	require.Nil(t, os.WriteFile(cfgPath, []byte("edited"), 0o600))
+	written, err = writeScaffold(dir, false)
+	require.Nil(t, err)
+	assert.Empty(t, written)
+	b, err = os.ReadFile(cfgPath)
+	require.Nil(t, err)
+	assert.Equal(t, "edited", string(b))
+
+	// Only the missing files are created.
+	require.Nil(t, os.Remove(libPath))
+	written, err = writeScaffold(dir, false)
+	require.Nil(t, err)
+	assert.Equal(t, []string{libPath}, written)
+
+	// With force, everything is overwritten.
+	written, err = writeScaffold(dir, true)
+	require.Nil(t, err)
+	assert.Equal(t, []string{cfgPath, libPath}, written)
+	b, err = os.ReadFile(cfgPath)
+	require.Nil(t, err)
+	assert.Equal(t, data.ExampleConfig(), string(b))
+}
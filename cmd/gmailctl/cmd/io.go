@@ -6,14 +6,28 @@ import (
 	"os"
 	"strings"
 
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
 	"github.com/mbrt/gmailctl/internal/errors"
 )
 
+// Exit codes of the commands.
+const (
+	// exitOK is returned on success, with no changes to apply.
+	exitOK = 0
+	// exitError is returned when the command fails.
+	exitError = 1
+	// exitChanges is returned by diff when there are changes to apply.
+	exitChanges = 2
+)
+
+// stdin is shared by all the prompts, as a reader of its own could buffer
+// the answers to the next ones.
+var stdin = bufio.NewReader(os.Stdin)
+
 func askYN(prompt string) bool {
-	r := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Printf("%s [y/N]: ", prompt)
-		if choice, err := r.ReadString('\n'); err == nil {
+		if choice, err := stdin.ReadString('\n'); err == nil {
 			switch strings.ToLower(strings.TrimRight(choice, "\r\n")) {
 			case "y", "yes":
 				return true
@@ -43,7 +57,7 @@ func askOptions(prompt string, choices []string) int {
 		fmt.Printf("> ")
 
 		var choice string
-		if _, err := fmt.Scanln(&choice); err == nil {
+		if _, err := fmt.Fscanln(stdin, &choice); err == nil {
 			choice = strings.ToLower(choice)
 			for i, c := range choices {
 				if strings.HasPrefix(c, choice) {
@@ -57,11 +71,15 @@ func askOptions(prompt string, choices []string) int {
 }
 
 func fatal(err error) {
+	if logFormat == logFormatJSON {
+		newEventLogger(os.Stderr, papply.ConfigDiff{}, nil).failed(err)
+		os.Exit(exitError)
+	}
 	stderrPrintf("Error: %v\n", err)
 	if det := errors.Details(err); det != "" {
 		stderrPrintf("\nNote: %s\n", det)
 	}
-	os.Exit(1)
+	os.Exit(exitError)
 }
 
 func stderrPrintf(format string, a ...interface{}) {
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/lint"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+var (
+	lintFilename      string
+	lintStrict        bool
+	lintMinTermLength int
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Reports overlapping rules in the configuration",
+	Long: `The lint command analyzes the rules in the configuration and
+reports pairs where the criteria of one rule are a strict subset of
+another's. In that case every email matched by the more specific rule
+is also matched by the other one and the actions of both apply, which
+is often a hint that the rules could be consolidated.
+
+Rules are compared after simplification, so rules that are logically
+equivalent are detected regardless of how they were written.
+
+Rules using relative dates (newerThan, olderThan) are reported as well,
+because filters only apply to incoming emails.
+
+Rules matching on a 'subject' or 'has' term that is a common word, like
+'re', or shorter than --min-term-length, are reported too, because they
+likely match a lot of emails. Terms narrowed down by others, like in
+'from:boss subject:re', are not reported.
+
+Rules matching on a 'from' address that is only a domain, like
+'from:example.com', are reported as well, with the address matching
+anyone at the domain to use instead, like 'from:@example.com'. With
+--normalize-from-domains, available to all the commands, such
+addresses are rewritten automatically.
+
+By default lint uses the configuration file inside the config
+directory [config.jsonnet].`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f := lintFilename
+		if f == "" {
+			f = configFilenameFromDir(cfgDir)
+		}
+		if err := lintConfig(f, lintStrict, os.Stdout); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+
+	// Flags and configuration settings
+	lintCmd.PersistentFlags().StringVarP(&lintFilename, "filename", "f", "", "configuration file")
+	lintCmd.Flags().BoolVarP(&lintStrict, "strict", "", false, "exit with an error if any warning is found")
+	lintCmd.Flags().IntVar(&lintMinTermLength, "min-term-length", lint.DefaultMinTermLength,
+		"minimum length of the 'subject' and 'has' terms not reported as too broad")
+}
+
+func lintConfig(path string, strict bool, out io.Writer) error {
+	parseRes, err := parseConfig(path, "", false)
+	if err != nil {
+		return err
+	}
+	rules := parseRes.Res.Rules
+
+	warnings := lint.LintWithMinTermLength(rules, lintMinTermLength)
+	for _, w := range warnings {
+		r := rules[w.Rule]
+		// Rules are reported as the config rules they come from.
+		w.Rule = parseRes.Res.RuleIndexes[w.Rule]
+		rule, err := ruleRef(w.Rule, r)
+		if err != nil {
+			return err
+		}
+		if w.Kind == lint.KindShadowed {
+			s := rules[w.Shadowed]
+			w.Shadowed = parseRes.Res.RuleIndexes[w.Shadowed]
+			shadowed, err := ruleRef(w.Shadowed, s)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Rule %s overlaps with rule %s:\n", rule, shadowed)
+		} else {
+			fmt.Fprintf(out, "Rule %s:\n", rule)
+		}
+		fmt.Fprintf(out, "  %s\n", w.Explanation())
+	}
+
+	if strict && len(warnings) > 0 {
+		return fmt.Errorf("found %d lint warnings", len(warnings))
+	}
+	return nil
+}
+
+// ruleRef identifies a rule by its index, name (if any) and search query.
+func ruleRef(i int, r parser.Rule) (string, error) {
+	search, err := ruleSearch(r)
+	if err != nil {
+		return "", err
+	}
+	if r.Name != "" {
+		return fmt.Sprintf("#%d %q (%s)", i, r.Name, search), nil
+	}
+	return fmt.Sprintf("#%d (%s)", i, search), nil
+}
+
+func ruleSearch(r parser.Rule) (string, error) {
+	criteria, err := filter.GenerateCriteria(r.Criteria)
+	if err != nil {
+		return "", fmt.Errorf("generating criteria: %w", err)
+	}
+	return criteria.ToGmailSearch(), nil
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintConfigIndexes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.jsonnet")
+	require.Nil(t, os.WriteFile(path, []byte(`{
+  version: 'v1alpha3',
+  rules: [
+    { filter: { from: 'x' }, actionGroups: [{ labels: ['l1'] }, { labels: ['l2'] }] },
+    { filter: { from: 'a' }, actions: { archive: true } },
+    { filter: { and: [{ from: 'a' }, { to: 'b' }] }, actions: { markRead: true } },
+  ],
+}`), 0o600))
+
+	var out bytes.Buffer
+	require.Nil(t, lintConfig(path, false, &out))
+	// The split rule doesn't change the numbering.
+	assert.Equal(t, "Rule #1 (from:a) overlaps with rule #2 (from:a to:b):\n"+
+		"  every email matched by rule #2 is also matched by rule #1, "+
+		"so the actions of both rules apply to it: consider consolidating them\n", out.String())
+}
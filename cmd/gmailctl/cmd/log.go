@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mbrt/gmailctl"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// Formats of the logs, selected with --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Kinds of the logged events.
+const (
+	eventPlanned  = "planned"
+	eventExecuted = "executed"
+	eventError    = "error"
+)
+
+// Outcomes of the logged events.
+const (
+	outcomePending = "pending"
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// logEvent is a significant event, logged as a single JSON object per line.
+//
+// Events about operations refer to a single label or filter: the index of
+// the config rule is present for the filters generated by the config.
+type logEvent struct {
+	Time      time.Time            `json:"time"`
+	Event     string               `json:"event"`
+	Operation papply.OperationKind `json:"operation,omitempty"`
+	Rule      *int                 `json:"rule,omitempty"`
+	Filter    string               `json:"filter,omitempty"`
+	Label     string               `json:"label,omitempty"`
+	ID        string               `json:"id,omitempty"`
+	Outcome   string               `json:"outcome"`
+	Error     string               `json:"error,omitempty"`
+	Details   string               `json:"details,omitempty"`
+}
+
+// eventLogger writes the events about the operations of a diff.
+type eventLogger struct {
+	w   io.Writer
+	now func() time.Time
+	// rules maps the Gmail queries to the index of the rule generating them.
+	rules map[string]int
+	// filters and labels map the IDs of the removed ones to their query and
+	// name, respectively.
+	filters, labels map[string]string
+}
+
+func newEventLogger(w io.Writer, d papply.ConfigDiff, rules map[string]int) *eventLogger {
+	res := &eventLogger{
+		w:       w,
+		now:     time.Now,
+		rules:   rules,
+		filters: map[string]string{},
+		labels:  map[string]string{},
+	}
+	for _, f := range d.FiltersDiff.Removed {
+		res.filters[f.ID] = f.Criteria.ToGmailSearch()
+	}
+	for _, l := range d.LabelsDiff.Removed {
+		res.labels[l.ID] = l.Name
+	}
+	return res
+}
+
+func (l *eventLogger) log(e logEvent) {
+	e.Time = l.now().UTC()
+	/* #nosec */
+	_ = json.NewEncoder(l.w).Encode(e)
+}
+
+// planned logs the operations that are going to be performed.
+func (l *eventLogger) planned(ops []papply.Operation) {
+	for _, op := range ops {
+		for _, e := range l.operationEvents(op) {
+			e.Event = eventPlanned
+			e.Outcome = outcomePending
+			l.log(e)
+		}
+	}
+}
+
+// executed logs the outcome of every item of an operation, given the error
+// it returned. Failures are attributed to their item by their index (see
+// errors.WithIndex). If any is not, all the items are logged as failed.
+func (l *eventLogger) executed(op papply.Operation, err error) {
+	failures := map[int]error{}
+	for _, e := range errors.Errors(err) {
+		i, ok := errors.Index(e)
+		if !ok {
+			failures = nil
+			break
+		}
+		failures[i] = e
+	}
+	for i, e := range l.operationEvents(op) {
+		e.Event = eventExecuted
+		e.Outcome = outcomeSuccess
+		ierr := err
+		if failures != nil {
+			ierr = failures[i]
+		}
+		if ierr != nil {
+			e.Outcome = outcomeFailure
+			e.Error = ierr.Error()
+		}
+		l.log(e)
+	}
+}
+
+// failed logs an error stopping the command.
+func (l *eventLogger) failed(err error) {
+	l.log(logEvent{
+		Event:   eventError,
+		Outcome: outcomeFailure,
+		Error:   err.Error(),
+		Details: errors.Details(err),
+	})
+}
+
+// operationEvents returns an event for every label or filter of the
+// operation.
+func (l *eventLogger) operationEvents(op papply.Operation) []logEvent {
+	var res []logEvent
+	for _, lb := range op.Labels {
+		res = append(res, logEvent{Operation: op.Kind, Label: lb.Name, ID: lb.ID})
+	}
+	for _, f := range op.Filters {
+		e := logEvent{Operation: op.Kind, Filter: f.Criteria.ToGmailSearch()}
+		if i, ok := l.rules[e.Filter]; ok {
+			e.Rule = &i
+		}
+		res = append(res, e)
+	}
+	for _, id := range op.IDs {
+		e := logEvent{Operation: op.Kind, ID: id}
+		if op.Kind == papply.OperationDeleteLabels {
+			e.Label = l.labels[id]
+		} else {
+			e.Filter = l.filters[id]
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// writer returns a gmailctl.Writer logging the outcome of every call made to
+// the given one.
+func (l *eventLogger) writer(w gmailctl.Writer) gmailctl.Writer {
+	return loggingWriter{w: w, log: l}
+}
+
+type loggingWriter struct {
+	w   gmailctl.Writer
+	log *eventLogger
+}
+
+func (w loggingWriter) AddLabels(lbs gmailctl.Labels) error {
+	err := w.w.AddLabels(lbs)
+	w.log.executed(papply.Operation{Kind: papply.OperationAddLabels, Labels: lbs}, err)
+	return err
+}
+
+func (w loggingWriter) AddFilters(fs gmailctl.Filters) error {
+	err := w.w.AddFilters(fs)
+	w.log.executed(papply.Operation{Kind: papply.OperationAddFilters, Filters: fs}, err)
+	return err
+}
+
+func (w loggingWriter) UpdateLabels(lbs gmailctl.Labels) error {
+	err := w.w.UpdateLabels(lbs)
+	w.log.executed(papply.Operation{Kind: papply.OperationUpdateLabels, Labels: lbs}, err)
+	return err
+}
+
+func (w loggingWriter) DeleteFilters(ids []string) error {
+	err := w.w.DeleteFilters(ids)
+	w.log.executed(papply.Operation{Kind: papply.OperationDeleteFilters, IDs: ids}, err)
+	return err
+}
+
+func (w loggingWriter) DeleteLabels(ids []string) error {
+	err := w.w.DeleteLabels(ids)
+	w.log.executed(papply.Operation{Kind: papply.OperationDeleteLabels, IDs: ids}, err)
+	return err
+}
+
+// ruleIndexes maps the Gmail queries of the filters generated by the config
+// to the index of the config rule generating them. Merged rules are mapped to
+// the first one.
+func ruleIndexes(parseRes papply.ConfigParseRes) map[string]int {
+	res := map[string]int{}
+	for i, r := range parseRes.Rules {
+		fs, err := filter.FromRules([]parser.Rule{r})
+		if err != nil {
+			continue
+		}
+		for _, f := range fs {
+			q := f.Criteria.ToGmailSearch()
+			if _, ok := res[q]; !ok {
+				res[q] = parseRes.RuleIndexes[i]
+			}
+		}
+	}
+	return res
+}
+
+// checkLogFormat returns an error if the format is not supported.
+func checkLogFormat(format string) error {
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("invalid --log-format %q, expected %q or %q", format, logFormatText, logFormatJSON)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// failingDeleteWriter fails the deletion of the filters.
+type failingDeleteWriter struct{}
+
+func (failingDeleteWriter) AddLabels(label.Labels) error     { return nil }
+func (failingDeleteWriter) AddFilters(filter.Filters) error  { return nil }
+func (failingDeleteWriter) UpdateLabels(label.Labels) error  { return nil }
+func (failingDeleteWriter) DeleteLabels([]string) error      { return nil }
+func (failingDeleteWriter) DeleteFilters(ids []string) error { return errors.New("not found") }
+
+func TestEventLogger(t *testing.T) {
+	config := v1alpha3.Config{
+		Rules: []v1alpha3.Rule{
+			// Split into one rule per label.
+			{
+				Filter:       v1alpha3.FilterNode{From: "a"},
+				ActionGroups: []v1alpha3.Actions{{Labels: []string{"l1"}}, {Labels: []string{"l2"}}},
+			},
+			{Filter: v1alpha3.FilterNode{From: "b"}, Actions: v1alpha3.Actions{Labels: []string{"new"}}},
+		},
+	}
+	parseRes, err := papply.FromConfig(config)
+	require.Nil(t, err)
+	diff := papply.ConfigDiff{
+		FiltersDiff: filter.FiltersDiff{
+			Added: filter.Filters{{
+				Criteria: filter.Criteria{From: "b"},
+				Action:   filter.Actions{AddLabel: "new"},
+			}},
+			Removed: filter.Filters{{
+				ID:       "id1",
+				Criteria: filter.Criteria{From: "x"},
+				Action:   filter.Actions{Archive: true},
+			}},
+		},
+		LabelsDiff: label.LabelsDiff{Added: label.Labels{{Name: "new"}}},
+	}
+
+	var out bytes.Buffer
+	events := newEventLogger(&out, diff, ruleIndexes(parseRes))
+	events.now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	ops, err := plannedOperations(diff)
+	require.Nil(t, err)
+	events.planned(ops)
+	_, err = gmailctl.ApplyDiff(context.Background(), diff, events.writer(failingDeleteWriter{}), gmailctl.Options{})
+	require.NotNil(t, err)
+	events.failed(errors.WithDetails(errors.New("applying failed"), "Try again."))
+
+	assert.Equal(t, []string{
+		`{"time":"2020-01-02T03:04:05Z","event":"planned","operation":"addLabels","label":"new","outcome":"pending"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"planned","operation":"addFilters","rule":1,"filter":"from:b","outcome":"pending"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"planned","operation":"deleteFilters","filter":"from:x","id":"id1","outcome":"pending"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"addLabels","label":"new","outcome":"success"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"addFilters","rule":1,"filter":"from:b","outcome":"success"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"deleteFilters","filter":"from:x","id":"id1","outcome":"failure","error":"not found"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"error","outcome":"failure","error":"applying failed","details":"\n  - Try again."}`,
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
+
+func TestEventLoggerFailedItem(t *testing.T) {
+	op := papply.Operation{
+		Kind: papply.OperationAddFilters,
+		Filters: filter.Filters{
+			{Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{Archive: true}},
+			{Criteria: filter.Criteria{From: "b"}, Action: filter.Actions{Archive: true}},
+		},
+	}
+	var out bytes.Buffer
+	events := newEventLogger(&out, papply.ConfigDiff{}, nil)
+	events.now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	// Only the failed filter is logged as such.
+	w := events.writer(&failingFilterWriter{from: "b"})
+	require.NotNil(t, w.AddFilters(op.Filters))
+	// Without the index of the failed item, all of them failed.
+	events.executed(op, errors.New("failed"))
+
+	assert.Equal(t, []string{
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"addFilters","filter":"from:a","outcome":"success"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"addFilters","filter":"from:b","outcome":"failure","error":"creating filter \"b\": failed"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"addFilters","filter":"from:a","outcome":"failure","error":"failed"}`,
+		`{"time":"2020-01-02T03:04:05Z","event":"executed","operation":"addFilters","filter":"from:b","outcome":"failure","error":"failed"}`,
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
+
+func TestCheckLogFormat(t *testing.T) {
+	assert.Nil(t, checkLogFormat("text"))
+	assert.Nil(t, checkLogFormat("json"))
+	assert.NotNil(t, checkLogFormat("xml"))
+}
+
+// failingFilterWriter fails to add the filters from the given address.
+type failingFilterWriter struct {
+	papply.Plan
+	from string
+}
+
+func (w *failingFilterWriter) AddFilters(fs filter.Filters) error {
+	var errs []error
+	for i, f := range fs {
+		if f.Criteria.From == w.from {
+			errs = append(errs, errors.WithIndex(fmt.Errorf("creating filter %q: failed", f.Criteria.From), i))
+		}
+	}
+	return errors.Combine(errs...)
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/rimport"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+const migrateHeader = `// This file is generated by 'gmailctl migrate'.
+//
+// WARNING: This functionality is experimental. Before making any
+// changes, check that no diff is detected with the remote filters by
+// using the 'gmailctl diff' command.
+`
+
+var (
+	migrateFilename string
+	migrateOutput   string
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert a YAML configuration to the latest Jsonnet format",
+	Long: `The migrate command converts a YAML configuration, written in any
+version of the format (v1alpha1, v1alpha2 or v1alpha3), to a Jsonnet
+configuration in the latest version.
+
+The parts of the configuration that changed form or meaning are listed
+on stderr: for example named filters and constants are replaced by their
+definition, and queries that the latest version would reject are kept
+as raw queries, so that the filters stay the same.
+
+YAML configurations are also upgraded in memory by the other commands,
+with a warning, but comments and structure are only preserved as far as
+possible by migrating them once and for all. Check that no diff is
+detected with the 'diff' command afterwards.
+
+By default migrate uses the configuration file inside the config
+directory [config.yaml] and writes the result to stdout.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f := migrateFilename
+		if f == "" {
+			f = path.Join(cfgDir, "config.yaml")
+		}
+		if err := migrate(f, migrateOutput); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	// Flags and configuration settings
+	migrateCmd.PersistentFlags().StringVarP(&migrateFilename, "filename", "f", "", "YAML configuration file")
+	migrateCmd.PersistentFlags().StringVarP(&migrateOutput, "output", "o", "", "output file (default to stdout)")
+}
+
+func migrate(path, outputPath string) error {
+	/* #nosec */
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return configurationError(fmt.Errorf("reading the config: %w", err))
+	}
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("opening output: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	return migrateConfig(b, out, os.Stderr)
+}
+
+// migrateConfig converts the YAML config to Jsonnet, writing the notes about
+// the migration to notes.
+func migrateConfig(b []byte, out, notes io.Writer) error {
+	cfg, m, err := config.Migrate(b)
+	if err != nil {
+		return errors.WithDetails(fmt.Errorf("migrating the config: %w", err),
+			"Fix the YAML config and try again.")
+	}
+	fmt.Fprintf(notes, "Migrated the config from %s to %s.\n", m.From, config.LatestVersion)
+	for _, n := range m.Notes {
+		fmt.Fprintf(notes, "  - %s\n", n)
+	}
+	if err := rimport.MarshalJsonnet(cfg, out, migrateHeader); err != nil {
+		return fmt.Errorf("converting to Jsonnet: %w", err)
+	}
+	return nil
+}
+
+// reportMigration warns about a YAML config upgraded in memory.
+func reportMigration(path string, m config.Migration) {
+	stderrPrintf("WARNING: %s is a deprecated YAML config, upgraded in memory from %s.\n", path, m.From)
+	for _, n := range m.Notes {
+		stderrPrintf("  - %s\n", n)
+	}
+	stderrPrintf("  Convert it to Jsonnet with 'gmailctl migrate -f %s'.\n", path)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	cfg := `version: v1alpha2
+filters:
+  - name: me
+    query:
+      to: pippo@gmail.com
+rules:
+  - filter:
+      name: me
+    actions:
+      archive: true
+`
+	var out, notes bytes.Buffer
+	require.Nil(t, migrateConfig([]byte(cfg), &out, &notes))
+	assert.Equal(t, "Migrated the config from v1alpha2 to v1alpha3.\n"+
+		"  - the named 'filters' are replaced by their definition in the rules using them\n",
+		notes.String())
+
+	got, err := config.ReadJsonnet("config.jsonnet", out.Bytes(), config.ReadOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Rule{{
+		Filter:  v1alpha3.FilterNode{To: "pippo@gmail.com"},
+		Actions: v1alpha3.Actions{Archive: true},
+	}}, got.Rules)
+
+	assert.NotNil(t, migrateConfig([]byte("version: v0\n"), &out, &notes))
+}
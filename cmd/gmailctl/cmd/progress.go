@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+)
+
+// progressReporter prints the overall progress of the changes being applied,
+// on a single line that is updated as the operations complete.
+type progressReporter struct {
+	w       io.Writer
+	total   int
+	done    int
+	printed bool
+}
+
+func newProgressReporter(w io.Writer, total int) *progressReporter {
+	return &progressReporter{
+		w:     w,
+		total: total,
+	}
+}
+
+// step counts an item changed and prints the progress.
+func (p *progressReporter) step() {
+	p.done++
+	fmt.Fprintf(p.w, "\rapplying %d/%d", p.done, p.total)
+	p.printed = true
+}
+
+// finish terminates the progress line, if anything has been printed.
+func (p *progressReporter) finish() {
+	if p.printed {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// showProgress returns whether the progress should be printed to the given
+// file: only if it's a terminal and it's not disabled with quiet.
+func showProgress(f *os.File, quiet bool) bool {
+	if quiet {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// countChanges returns the number of filters and labels changed by applying
+// the diff.
+func countChanges(d papply.ConfigDiff, allowRemoveLabels bool) int {
+	res := len(d.LabelsDiff.Added) + len(d.FiltersDiff.Added) +
+		len(d.LabelsDiff.Modified) + len(d.FiltersDiff.Removed)
+	if allowRemoveLabels {
+		res += len(d.LabelsDiff.Removed)
+	}
+	return res
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestProgressReporter(t *testing.T) {
+	mkFilter := func(id, from string) filter.Filter {
+		return filter.Filter{
+			ID:       id,
+			Criteria: filter.Criteria{From: from},
+			Action:   filter.Actions{Archive: true},
+		}
+	}
+	diff := papply.ConfigDiff{
+		FiltersDiff: filter.FiltersDiff{
+			Added:   filter.Filters{mkFilter("", "a"), mkFilter("", "b")},
+			Removed: filter.Filters{mkFilter("id1", "x")},
+		},
+		LabelsDiff: label.LabelsDiff{
+			Added:   label.Labels{{Name: "new"}},
+			Removed: label.Labels{{ID: "l1", Name: "old"}},
+		},
+	}
+	// Labels are not removed unless allowed.
+	assert.Equal(t, 4, countChanges(diff, false))
+	require.Equal(t, 5, countChanges(diff, true))
+
+	var out bytes.Buffer
+	p := newProgressReporter(&out, countChanges(diff, true))
+	for i := 0; i < 3; i++ {
+		p.step()
+	}
+	p.finish()
+	assert.Equal(t, "\rapplying 1/5\rapplying 2/5\rapplying 3/5\n", out.String())
+}
+
+func TestProgressReporterEmpty(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgressReporter(&out, 0)
+	p.finish()
+	assert.Empty(t, out.String())
+}
+
+func TestShowProgress(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.Nil(t, err)
+	defer f.Close()
+
+	// Not a terminal.
+	assert.False(t, showProgress(f, false))
+	assert.False(t, showProgress(f, true))
+
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		assert.True(t, showProgress(tty, false))
+		assert.False(t, showProgress(tty, true))
+	}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl"
+	"github.com/mbrt/gmailctl/internal/engine/api"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+var (
+	restoreYes          bool
+	restoreRemoveLabels bool
+)
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <snapshot file>",
+	Short: "Restore filters and labels from a snapshot",
+	Long: `The restore command changes the Gmail settings to make them match
+a snapshot taken with 'gmailctl snapshot'. This works exactly like
+apply, with the snapshot as the desired state: filters created after
+the snapshot are deleted and the ones deleted are created again.
+
+Labels created after the snapshot are deleted only with
+--remove-labels, because deleting a label also removes it from the
+messages.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := restore(args[0], !restoreYes); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	// Flags and configuration settings
+	restoreCmd.Flags().BoolVarP(&restoreYes, "yes", "y", false, "don't ask for confirmation, just restore")
+	restoreCmd.Flags().BoolVarP(&restoreRemoveLabels, "remove-labels", "r", false, "allow removing labels")
+}
+
+func restore(path string, interactive bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening the snapshot: %w", err)
+	}
+	defer f.Close()
+	snap, err := papply.ReadSnapshot(f)
+	if err != nil {
+		return err
+	}
+
+	gmailapi, err := openAPI()
+	if err != nil {
+		return configurationError(fmt.Errorf("cannot connect to Gmail: %w", err))
+	}
+	return restoreSnapshot(snap, gmailapi, interactive, restoreRemoveLabels)
+}
+
+func restoreSnapshot(snap papply.Snapshot, gmailapi *api.GmailAPI, interactive, removeLabels bool) error {
+	upstream, err := upstreamConfig(gmailapi)
+	if err != nil {
+		return err
+	}
+	diff, err := papply.Diff(snap.Config(), upstream)
+	if err != nil {
+		return fmt.Errorf("cannot compare upstream with the snapshot: %w", err)
+	}
+	if diff.Empty() {
+		fmt.Println("The settings already match the snapshot.")
+		return nil
+	}
+
+	fmt.Printf("You are going to restore the snapshot of %s with the following changes:\n\n%s\n",
+		snap.Time.Format("2006-01-02 15:04:05"), diff)
+
+	if err := gmailctl.Check(diff, gmailapi); err != nil {
+		return err
+	}
+	if err := papply.CheckFilterLimit(diff, len(upstream.Filters), papply.DefaultMaxFilters); err != nil {
+		return err
+	}
+	if len(diff.LabelsDiff.Removed) > 0 {
+		fmt.Print(renameLabelWarning)
+		if !removeLabels {
+			return errors.WithDetails(errors.New("no changes have been made"),
+				"To protect you, deletion is disabled unless you\n"+
+					"explicitly provide the --remove-labels flag.\n")
+		}
+	}
+	if interactive && !askYN("Do you want to restore it?") {
+		return nil
+	}
+
+	fmt.Println("Restoring the snapshot...")
+	opts := gmailctl.Options{AllowRemoveLabels: removeLabels}
+	_, err = gmailctl.ApplyDiff(context.Background(), diff, gmailapi, opts)
+	return err
+}
@@ -5,11 +5,26 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
 )
 
-var cfgDir string
+var (
+	cfgDir      string
+	accountName string
+	extStrs     []string
+	extCodes    []string
+	extVars     config.ExtVars
+	apiRetries  int
+	apiBackoff  time.Duration
+	noSimplify  bool
+	normDomains bool
+	logFormat   string
+)
 
 // rootCmd is the command run when executing without subcommands.
 var rootCmd = &cobra.Command{
@@ -25,7 +40,7 @@ to maintain and understand.`,
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 }
 
@@ -52,6 +67,32 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgDir, "config", "", "config directory (default is $HOME/.gmailctl)")
+	rootCmd.PersistentFlags().StringVar(&accountName, "account", "",
+		"name of the Gmail account to use, with its own config directory under <config>/accounts")
+	rootCmd.PersistentFlags().StringArrayVar(&extStrs, "ext-str", nil,
+		"string external variable of the Jsonnet config, as <name>=<value>, or <name> to read it from the environment")
+	rootCmd.PersistentFlags().StringArrayVar(&extCodes, "ext-code", nil,
+		"Jsonnet code external variable of the config, as <name>=<expr>, or <name> to read it from the environment")
+	rootCmd.PersistentFlags().IntVar(&apiRetries, "api-retries", 5,
+		"maximum number of retries of Gmail API calls failed because of quota limits or transient errors")
+	rootCmd.PersistentFlags().DurationVar(&apiBackoff, "api-backoff", time.Second,
+		"wait before the first retry of a failed Gmail API call, doubled at every following one")
+	rootCmd.PersistentFlags().BoolVar(&noSimplify, "no-simplify", false,
+		"generate the filters exactly as written in the config, without simplifying the criteria")
+	rootCmd.PersistentFlags().BoolVar(&normDomains, "normalize-from-domains", false,
+		"rewrite the 'from' criteria that are only a domain, like 'example.com', to '@example.com'")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText,
+		"format of the logs on stderr: 'text', or 'json' for one object per event")
+}
+
+// readOptions returns the options used to read the config.
+func readOptions() config.ReadOptions {
+	return config.ReadOptions{ExtVars: extVars}
+}
+
+// parseOptions returns the options used to parse the rules of the config.
+func parseOptions() parser.Options {
+	return parser.Options{NoSimplify: noSimplify, NormalizeDomains: normDomains}
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -63,8 +104,22 @@ func initConfig() {
 		usr, err := user.Current()
 		if err != nil {
 			fmt.Println(err)
-			os.Exit(1)
+			os.Exit(exitError)
 		}
 		cfgDir = path.Join(usr.HomeDir, ".gmailctl")
 	}
+
+	var err error
+	if cfgDir, err = accountConfigDir(cfgDir, accountName); err != nil {
+		fmt.Println(err)
+		os.Exit(exitError)
+	}
+	if err := checkLogFormat(logFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(exitError)
+	}
+	if extVars, err = parseExtVars(extStrs, extCodes, os.LookupEnv); err != nil {
+		fmt.Println(err)
+		os.Exit(exitError)
+	}
 }
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/api"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+)
+
+// snapshotTimeFormat is used for the default snapshot file names.
+const snapshotTimeFormat = "20060102-150405"
+
+var snapshotOutput string
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save all filters and labels to a file, to restore them later",
+	Long: `The snapshot command saves all the filters and labels currently
+configured in Gmail to a file. This is useful as a restore point before
+a risky apply: 'gmailctl restore' brings the settings back to the state
+saved in the snapshot.
+
+By default the snapshot is saved in the current directory, in a file
+named after the current time [gmailctl-snapshot-<time>.json].`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := snapshot(snapshotOutput, time.Now()); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+
+	// Flags and configuration settings
+	snapshotCmd.Flags().StringVarP(&snapshotOutput, "output", "o", "", "output file (default to a timestamped file)")
+}
+
+func snapshot(outputPath string, now time.Time) (err error) {
+	if outputPath == "" {
+		outputPath = fmt.Sprintf("gmailctl-snapshot-%s.json", now.Format(snapshotTimeFormat))
+	}
+	gmailapi, err := openAPI()
+	if err != nil {
+		return configurationError(fmt.Errorf("connecting to Gmail: %w", err))
+	}
+
+	f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("opening output: %w", err)
+	}
+	defer func() {
+		e := f.Close()
+		// do not hide more important error
+		if err == nil {
+			err = e
+		}
+	}()
+
+	if err := takeSnapshot(gmailapi, f, now); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot saved to %s.\n", outputPath)
+	return nil
+}
+
+func takeSnapshot(gmailapi *api.GmailAPI, out io.Writer, now time.Time) error {
+	upstream, err := upstreamConfig(gmailapi)
+	if err != nil {
+		return err
+	}
+	return papply.NewSnapshot(upstream, now).Write(out)
+}
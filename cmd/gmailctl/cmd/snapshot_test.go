@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/api"
+	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/fakegmail"
+)
+
+// accountState returns the filters and labels of the account, without IDs,
+// which change when they are created again.
+func accountState(t *testing.T, gmailapi *api.GmailAPI) (filter.Filters, []string) {
+	t.Helper()
+	upstream, _, err := papply.FromAPI(gmailapi)
+	require.Nil(t, err)
+	var fs filter.Filters
+	for _, f := range upstream.Filters {
+		f.ID = ""
+		fs = append(fs, f)
+	}
+	var ls []string
+	for _, l := range upstream.Labels {
+		ls = append(ls, l.Name)
+	}
+	return fs, ls
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	gmailapi := api.NewFromService(fakegmail.NewService(context.Background(), t))
+	require.Nil(t, gmailapi.AddLabels(label.Labels{{Name: "work"}, {Name: "family"}}))
+	require.Nil(t, gmailapi.AddFilters(filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "boss@work.com"},
+			Action:   filter.Actions{AddLabel: "work"},
+		},
+		{
+			Criteria: filter.Criteria{From: "spam@example.com"},
+			Action:   filter.Actions{Delete: true},
+		},
+	}))
+	wantFilters, wantLabels := accountState(t, gmailapi)
+
+	var buf bytes.Buffer
+	now := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	require.Nil(t, takeSnapshot(gmailapi, &buf, now))
+	snap, err := papply.ReadSnapshot(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, now, snap.Time)
+
+	// Simulate a bad change.
+	upstream, _, err := papply.FromAPI(gmailapi)
+	require.Nil(t, err)
+	require.Nil(t, gmailapi.DeleteFilters([]string{upstream.Filters[0].ID}))
+	require.Nil(t, gmailapi.AddLabels(label.Labels{{Name: "temp"}}))
+	require.Nil(t, gmailapi.AddFilters(filter.Filters{
+		{
+			Criteria: filter.Criteria{To: "me@example.com"},
+			Action:   filter.Actions{AddLabel: "temp", Archive: true},
+		},
+	}))
+	gotFilters, _ := accountState(t, gmailapi)
+	require.NotEqual(t, wantFilters, gotFilters)
+
+	// Labels are not deleted without permission.
+	err = restoreSnapshot(snap, gmailapi, false, false)
+	assert.NotNil(t, err)
+
+	require.Nil(t, restoreSnapshot(snap, gmailapi, false, true))
+	gotFilters, gotLabels := accountState(t, gmailapi)
+	assert.ElementsMatch(t, wantFilters, gotFilters)
+	assert.ElementsMatch(t, wantLabels, gotLabels)
+
+	// Restoring again is a no-op.
+	require.Nil(t, restoreSnapshot(snap, gmailapi, false, true))
+}
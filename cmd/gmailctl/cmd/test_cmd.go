@@ -1,8 +1,17 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
 
-var testFilename string
+	"github.com/spf13/cobra"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+)
+
+var (
+	testFilename  string
+	testTestsFile string
+)
 
 // testCmd represents the test command
 var testCmd = &cobra.Command{
@@ -14,6 +23,10 @@ this command. Not all filters constructs are supported; if any
 is found, the tests will ignore the entire filter and continue.
 This might result in imprecise testing.
 
+Tests can also be kept in a separate file, given with --tests. The file
+has to evaluate to a list of tests, in the same format as the 'tests'
+field of the configuration. Both sets of tests are executed.
+
 Warning: This command is still experimental.
 
 List of unsupported constructs:
@@ -27,7 +40,7 @@ directory [config.jsonnet].`,
 		if f == "" {
 			f = configFilenameFromDir(cfgDir)
 		}
-		if err := test(f); err != nil {
+		if err := test(f, testTestsFile); err != nil {
 			fatal(err)
 		}
 	},
@@ -38,9 +51,22 @@ func init() {
 
 	// Flags and configuration settings
 	testCmd.PersistentFlags().StringVarP(&testFilename, "filename", "f", "", "configuration file")
+	testCmd.Flags().StringVarP(&testTestsFile, "tests", "", "", "additional file containing a list of tests")
 }
 
-func test(path string) error {
-	_, err := parseConfig(path, "", true)
-	return err
+func test(path, testsPath string) error {
+	if testsPath == "" {
+		_, err := parseConfig(path, "", true)
+		return err
+	}
+
+	tests, err := config.ReadTestsFile(testsPath, readOptions())
+	if err != nil {
+		return fmt.Errorf("reading tests file: %w", err)
+	}
+	parseRes, err := parseConfig(path, "", false)
+	if err != nil {
+		return err
+	}
+	return runTests(parseRes.Res.Rules, append(parseRes.Config.Tests, tests...))
 }
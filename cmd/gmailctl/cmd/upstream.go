@@ -3,16 +3,59 @@ package cmd
 import (
 	"github.com/mbrt/gmailctl/internal/engine/api"
 	papply "github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 func upstreamConfig(gmailapi *api.GmailAPI) (papply.GmailConfig, error) {
-	cfg, err := papply.FromAPI(gmailapi)
+	cfg, warning, err := papply.FromAPI(gmailapi)
 	if err != nil {
-		if len(cfg.Filters) == 0 {
-			return papply.GmailConfig{}, err
-		}
-		// We have some filters, let's work with what we have and issue a warning.
-		stderrPrintf("Warning: Error getting one or more filters from Gmail: %sThey will be ignored in the diff.\n", err)
+		return papply.GmailConfig{}, err
+	}
+	if warning != nil {
+		// Let's work with what we have and issue a warning.
+		stderrPrintf("Warning: Error getting one or more filters from Gmail: %sThey will be ignored.\n", warning)
 	}
 	return cfg, nil
 }
+
+// guardedConfig returns the local settings without the rules whose guard is
+// not satisfied by the upstream ones, warning about them.
+func guardedConfig(parseRes parseResult, upstream papply.GmailConfig) (papply.GmailConfig, error) {
+	if !papply.HasGuards(parseRes.Config) {
+		return parseRes.Res.GmailConfig, nil
+	}
+	res, skipped, err := papply.FromConfigWithState(parseRes.Config, upstream, parseOptions())
+	if err != nil {
+		return res.GmailConfig, err
+	}
+	for _, s := range skipped {
+		stderrPrintf("WARNING: %s.\n", s)
+	}
+	return res.GmailConfig, nil
+}
+
+// matchLabelCase replaces the local labels differing only by case from the
+// upstream ones with the upstream spelling, warning about it. With strict an
+// error is returned instead.
+func matchLabelCase(local, upstream papply.GmailConfig, strict bool) (papply.GmailConfig, error) {
+	res, matches, err := papply.MatchLabelCase(local, upstream, strict)
+	if err != nil {
+		return res, err
+	}
+	for _, m := range matches {
+		stderrPrintf("WARNING: %s, the existing one will be used.\n", m)
+	}
+	return res, nil
+}
+
+// filtersOnly drops the labels from the local config, so that only the
+// filters are compared and applied.
+func filtersOnly(local, upstream papply.GmailConfig) (papply.GmailConfig, error) {
+	res, err := papply.FiltersOnly(local, upstream)
+	if err != nil {
+		return res, errors.WithDetails(err,
+			"With --diff-only-filters labels are not created: create them\n"+
+				"in the Gmail UI first, or remove the flag.\n")
+	}
+	return res, nil
+}
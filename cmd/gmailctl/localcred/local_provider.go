@@ -32,6 +32,8 @@ To do so, head to https://console.developers.google.com
     3c. Select 'Add or remove scopes' and add:
         * https://www.googleapis.com/auth/gmail.labels
         * https://www.googleapis.com/auth/gmail.settings.basic
+        * https://www.googleapis.com/auth/gmail.modify (optional,
+          only needed by 'apply --to-existing')
     3d. Save and continue until you're back to the dashboard.
 3. You now have a choice. You can either:
     * Click on 'Publish App' and avoid 'Submitting for
@@ -75,6 +77,31 @@ func (Provider) Service(ctx context.Context, cfgDir string) (*gmail.Service, err
 	return openToken(ctx, auth, tokenPath(cfgDir))
 }
 
+// MessagesService returns a GMail API service allowed to modify messages.
+//
+// The authorization is separate from the one of Service, so that the broader
+// permission is only granted by the users applying filters to existing
+// messages. It's requested the first time it's needed.
+func (Provider) MessagesService(ctx context.Context, cfgDir string) (*gmail.Service, error) {
+	cred, err := os.Open(credentialsPath(cfgDir))
+	if err != nil {
+		return nil, fmt.Errorf("opening credentials: %w", err)
+	}
+	auth, err := api.NewMessagesAuthenticator(cred)
+	if err != nil {
+		return nil, err
+	}
+	tpath := messagesTokenPath(cfgDir)
+	if srv, err := openToken(ctx, auth, tpath); err == nil {
+		return srv, nil
+	}
+	fmt.Println("Modifying messages requires an additional authorization.")
+	if err := setupToken(auth, tpath); err != nil {
+		return nil, err
+	}
+	return openToken(ctx, auth, tpath)
+}
+
 func (Provider) InitConfig(cfgDir string) error {
 	cpath := credentialsPath(cfgDir)
 	tpath := tokenPath(cfgDir)
@@ -92,6 +119,15 @@ func (Provider) InitConfig(cfgDir string) error {
 	return err
 }
 
+// Authenticated returns whether the token of the config directory exists.
+func (Provider) Authenticated(cfgDir string) (bool, error) {
+	_, err := os.Stat(tokenPath(cfgDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (Provider) ResetConfig(cfgDir string) error {
 	if err := deleteFile(credentialsPath(cfgDir)); err != nil {
 		return err
@@ -99,6 +135,9 @@ func (Provider) ResetConfig(cfgDir string) error {
 	if err := deleteFile(tokenPath(cfgDir)); err != nil {
 		return err
 	}
+	if err := deleteFile(messagesTokenPath(cfgDir)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -177,6 +216,10 @@ func tokenPath(cfgDir string) string {
 	return path.Join(cfgDir, "token.json")
 }
 
+func messagesTokenPath(cfgDir string) string {
+	return path.Join(cfgDir, "token-messages.json")
+}
+
 func deleteFile(path string) error {
 	if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
 		return nil
@@ -189,5 +232,9 @@ func stderrPrintf(format string, a ...interface{}) {
 	_, _ = fmt.Fprintf(os.Stderr, format, a...)
 }
 
-// Verify that the interface is implemented.
-var _ cmd.GmailAPIProvider = Provider{}
+// Verify that the interfaces are implemented.
+var (
+	_ cmd.GmailAPIProvider    = Provider{}
+	_ cmd.MessagesAPIProvider = Provider{}
+	_ cmd.AuthChecker         = Provider{}
+)
@@ -0,0 +1,60 @@
+package localcred
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCredentials = `{"installed": {
+  "client_id": "id.apps.googleusercontent.com",
+  "client_secret": "secret",
+  "auth_uri": "https://accounts.google.com/o/oauth2/auth",
+  "token_uri": "https://oauth2.googleapis.com/token",
+  "redirect_uris": ["http://localhost"]
+}}`
+	testToken = `{"access_token": "token", "token_type": "Bearer", "refresh_token": "refresh"}`
+)
+
+func writeFile(t *testing.T, p, contents string) {
+	t.Helper()
+	require.Nil(t, os.MkdirAll(path.Dir(p), 0700))
+	require.Nil(t, os.WriteFile(p, []byte(contents), 0600))
+}
+
+func TestTokenIsolation(t *testing.T) {
+	root := t.TempDir()
+	work := path.Join(root, "accounts", "work")
+	home := path.Join(root, "accounts", "home")
+
+	// Both accounts share the same credentials, but only one has a token.
+	writeFile(t, credentialsPath(work), testCredentials)
+	writeFile(t, credentialsPath(home), testCredentials)
+	writeFile(t, tokenPath(work), testToken)
+
+	ok, err := Provider{}.Authenticated(work)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = Provider{}.Authenticated(home)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	ctx := context.Background()
+	_, err = Provider{}.Service(ctx, work)
+	assert.Nil(t, err)
+	_, err = Provider{}.Service(ctx, home)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing or invalid cached token")
+
+	// Resetting one account doesn't affect the other.
+	require.Nil(t, Provider{}.ResetConfig(work))
+	_, err = os.Stat(tokenPath(work))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(credentialsPath(home))
+	assert.Nil(t, err)
+}
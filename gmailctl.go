@@ -0,0 +1,298 @@
+// Package gmailctl applies declarative configurations to Gmail filters and
+// labels.
+//
+// It is the programmatic counterpart of the gmailctl command line tool,
+// which is a thin wrapper around it:
+//
+//	cfg, err := gmailctl.ReadConfig("config.jsonnet")
+//	if err != nil {
+//		return err
+//	}
+//	res, err := gmailctl.Apply(ctx, cfg, client, gmailctl.Options{})
+//
+// The Gmail settings are accessed through the Client interface, so they can
+// be easily replaced in tests.
+package gmailctl
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/engine/validate"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+type (
+	// Config is a parsed configuration file.
+	Config = v1alpha3.Config
+	// ConfigDiff is the difference between local and upstream settings.
+	ConfigDiff = apply.ConfigDiff
+	// Operation is a single call to the Gmail APIs.
+	Operation = apply.Operation
+	// Filter is a Gmail filter.
+	Filter = filter.Filter
+	// Filters is a list of Gmail filters.
+	Filters = filter.Filters
+	// Label is a Gmail label.
+	Label = label.Label
+	// Labels is a list of Gmail labels.
+	Labels = label.Labels
+	// LabelCaseMatch is a label of the config that differs only by case
+	// from an existing one.
+	LabelCaseMatch = apply.LabelCaseMatch
+	// Diagnostic is a problem found in a rule of the config.
+	Diagnostic = validate.Diagnostic
+	// GuardSkip is a rule skipped because its guard is not satisfied.
+	GuardSkip = apply.GuardSkip
+	// ReadOptions control how configuration files are read.
+	ReadOptions = config.ReadOptions
+	// ExtVars are the external variables available to Jsonnet
+	// configurations, with std.extVar.
+	ExtVars = config.ExtVars
+)
+
+// Reader provides read access to the Gmail settings.
+type Reader interface {
+	ListFilters() (Filters, error)
+	ListLabels() (Labels, error)
+	// ListForwardingAddresses returns the verified forwarding addresses.
+	ListForwardingAddresses() ([]string, error)
+}
+
+// Writer provides write access to the Gmail settings.
+type Writer interface {
+	AddLabels(lbs Labels) error
+	AddFilters(fs Filters) error
+	UpdateLabels(lbs Labels) error
+	DeleteFilters(ids []string) error
+	DeleteLabels(ids []string) error
+}
+
+// Client provides access to the Gmail settings managed by gmailctl.
+type Client interface {
+	Reader
+	Writer
+}
+
+// Options control how the changes are applied.
+type Options struct {
+	// AllowRemoveLabels allows deleting the labels not present in the
+	// config. This is irreversible, because it also removes those labels
+	// from messages.
+	AllowRemoveLabels bool
+	// DryRun computes the operations without performing them.
+	DryRun bool
+	// StrictLabelCase returns an error for the labels of the config that
+	// differ only by case from existing ones, instead of reusing them.
+	StrictLabelCase bool
+	// MaxFilters is the maximum number of filters allowed in the account.
+	// Zero means apply.DefaultMaxFilters, while a negative value disables
+	// the check.
+	MaxFilters int
+	// NoSimplify keeps the criteria of the filters as they are written in
+	// the config, instead of simplifying them.
+	NoSimplify bool
+	// PruneFiltersMatching, if not nil, restricts the existing filters
+	// managed by the config to the ones whose Gmail query matches it. The
+	// others are never deleted, even if they are not in the config.
+	PruneFiltersMatching *regexp.Regexp
+}
+
+// Result reports the changes made by Apply.
+type Result struct {
+	// Diff is the difference between the config and the upstream settings.
+	Diff ConfigDiff
+	// Operations are the Gmail API calls performed, in order. With DryRun,
+	// they are the calls that would have been performed.
+	//
+	// When applying fails, only the operations that succeeded are present.
+	Operations []Operation
+	// ReusedLabels are the existing labels used in place of the ones of the
+	// config differing only by case.
+	ReusedLabels []LabelCaseMatch
+	// SkippedRules are the rules of the config whose guard is not
+	// satisfied by the upstream settings.
+	SkippedRules []GuardSkip
+}
+
+// ReadConfig reads and parses a Jsonnet configuration file.
+func ReadConfig(path string) (Config, error) {
+	return ReadConfigWithOptions(path, ReadOptions{})
+}
+
+// ReadConfigWithOptions is like ReadConfig, but reads the file with the
+// given options, e.g. to set the external variables.
+func ReadConfigWithOptions(path string, opts ReadOptions) (Config, error) {
+	cfg, _, err := config.ReadFile(path, "", opts)
+	return cfg, err
+}
+
+// Validate returns all the problems found in the rules of the config, e.g.
+// for editor integrations. Apply fails on the first error instead.
+func Validate(cfg Config) []Diagnostic {
+	return validate.Validate(cfg)
+}
+
+// Apply changes the Gmail settings to make them match the given config.
+//
+// Upstream filters that are not valid are ignored, as they can't be
+// compared with the local ones.
+func Apply(ctx context.Context, cfg Config, client Client, opts Options) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	parseOpts := parser.Options{NoSimplify: opts.NoSimplify}
+	local, err := apply.FromConfigWithOptions(cfg, parseOpts)
+	if err != nil {
+		return Result{}, err
+	}
+	upstream, _, err := apply.FromAPI(client)
+	if err != nil {
+		return Result{}, err
+	}
+	var skipped []GuardSkip
+	if apply.HasGuards(cfg) {
+		// The whole config is parsed first, so that errors refer to the
+		// original rules.
+		if local, skipped, err = apply.FromConfigWithState(cfg, upstream, parseOpts); err != nil {
+			return Result{}, err
+		}
+	}
+	localCfg, reused, err := apply.MatchLabelCase(local.GmailConfig, upstream, opts.StrictLabelCase)
+	if err != nil {
+		return Result{}, err
+	}
+	// Unmanaged filters are not in the diff, but they count for the limit.
+	existing := len(upstream.Filters)
+	if re := opts.PruneFiltersMatching; re != nil {
+		upstream = apply.ManagedFilters(localCfg, upstream, func(f Filter) bool {
+			return re.MatchString(f.Criteria.ToGmailSearch())
+		})
+	}
+	diff, err := apply.Diff(localCfg, upstream)
+	if err != nil {
+		return Result{}, fmt.Errorf("cannot compare upstream with local config: %w", err)
+	}
+	partial := Result{Diff: diff, ReusedLabels: reused, SkippedRules: skipped}
+	if err := Check(diff, client); err != nil {
+		return partial, err
+	}
+	if err := apply.CheckFilterLimit(diff, existing, opts.MaxFilters); err != nil {
+		return partial, err
+	}
+	if len(diff.LabelsDiff.Removed) > 0 && !opts.AllowRemoveLabels {
+		return partial, errors.WithDetails(
+			errors.New("the config requires deleting labels"),
+			"Deleting labels is irreversible and it has to be explicitly\n"+
+				"allowed with Options.AllowRemoveLabels.\n")
+	}
+	res, err := ApplyDiff(ctx, diff, client, opts)
+	res.ReusedLabels = reused
+	res.SkippedRules = skipped
+	return res, err
+}
+
+// Check returns an error if the diff can't be applied, because of invalid
+// labels or filters forwarding to unverified addresses.
+func Check(d ConfigDiff, r Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	return apply.CheckForwarding(d, r)
+}
+
+// ApplyDiff performs the changes in the diff, which should have been
+// checked beforehand with Check.
+//
+// Labels are deleted only if allowed by the options. The context is checked
+// before every call to the Gmail APIs.
+func ApplyDiff(ctx context.Context, d ConfigDiff, w Writer, opts Options) (Result, error) {
+	res := Result{Diff: d}
+	plan := apply.Plan{Operations: []Operation{}}
+	var target apply.API = &plan
+	if !opts.DryRun {
+		target = &recordingWriter{ctx: ctx, w: w, plan: &plan}
+	}
+	err := apply.Apply(d, target, opts.AllowRemoveLabels)
+	res.Operations = plan.Operations
+	return res, err
+}
+
+// recordingWriter forwards the calls to a Writer and records the changes that
+// succeeded, unless the context is done.
+//
+// Failed calls still record the items that succeeded on their own, as far as
+// the failures can be attributed to single items (see apply.Succeeded).
+type recordingWriter struct {
+	ctx  context.Context
+	w    Writer
+	plan *apply.Plan
+}
+
+func (r *recordingWriter) AddLabels(lbs Labels) error {
+	err := r.call(func() error { return r.w.AddLabels(lbs) })
+	var done Labels
+	for _, i := range apply.Succeeded(len(lbs), err) {
+		done = append(done, lbs[i])
+	}
+	return r.record(err, len(done), func() error { return r.plan.AddLabels(done) })
+}
+
+func (r *recordingWriter) AddFilters(fs Filters) error {
+	err := r.call(func() error { return r.w.AddFilters(fs) })
+	var done Filters
+	for _, i := range apply.Succeeded(len(fs), err) {
+		done = append(done, fs[i])
+	}
+	return r.record(err, len(done), func() error { return r.plan.AddFilters(done) })
+}
+
+func (r *recordingWriter) UpdateLabels(lbs Labels) error {
+	err := r.call(func() error { return r.w.UpdateLabels(lbs) })
+	var done Labels
+	for _, i := range apply.Succeeded(len(lbs), err) {
+		done = append(done, lbs[i])
+	}
+	return r.record(err, len(done), func() error { return r.plan.UpdateLabels(done) })
+}
+
+func (r *recordingWriter) DeleteFilters(ids []string) error {
+	err := r.call(func() error { return r.w.DeleteFilters(ids) })
+	var done []string
+	for _, i := range apply.Succeeded(len(ids), err) {
+		done = append(done, ids[i])
+	}
+	return r.record(err, len(done), func() error { return r.plan.DeleteFilters(done) })
+}
+
+func (r *recordingWriter) DeleteLabels(ids []string) error {
+	err := r.call(func() error { return r.w.DeleteLabels(ids) })
+	var done []string
+	for _, i := range apply.Succeeded(len(ids), err) {
+		done = append(done, ids[i])
+	}
+	return r.record(err, len(done), func() error { return r.plan.DeleteLabels(done) })
+}
+
+// record records the items that succeeded, if any, and returns the errors
+// of the call.
+func (r *recordingWriter) record(err error, done int, rec func() error) error {
+	if done == 0 {
+		return err
+	}
+	return errors.Combine(err, rec())
+}
+
+func (r *recordingWriter) call(f func() error) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	return f()
+}
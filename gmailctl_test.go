@@ -0,0 +1,421 @@
+package gmailctl_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl"
+	"github.com/mbrt/gmailctl/internal/engine/apply"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	exportapi "github.com/mbrt/gmailctl/internal/engine/export/api"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// fakeClient keeps the Gmail settings in memory.
+type fakeClient struct {
+	filters    gmailctl.Filters
+	labels     gmailctl.Labels
+	forwarding []string
+	nextID     int
+	// failOn makes the given operation fail.
+	failOn apply.OperationKind
+	// failFrom makes adding the filters from the given address fail.
+	failFrom string
+	// filtersErr is returned when listing the filters, e.g. because some
+	// couldn't be imported.
+	filtersErr error
+}
+
+func (c *fakeClient) ListFilters() (gmailctl.Filters, error) {
+	return append(gmailctl.Filters{}, c.filters...), c.filtersErr
+}
+
+func (c *fakeClient) ListLabels() (gmailctl.Labels, error) {
+	return append(gmailctl.Labels{}, c.labels...), nil
+}
+
+func (c *fakeClient) ListForwardingAddresses() ([]string, error) {
+	return c.forwarding, nil
+}
+
+func (c *fakeClient) AddLabels(lbs gmailctl.Labels) error {
+	if err := c.fail(apply.OperationAddLabels); err != nil {
+		return err
+	}
+	for _, l := range lbs {
+		l.ID = c.newID()
+		c.labels = append(c.labels, l)
+	}
+	return nil
+}
+
+func (c *fakeClient) AddFilters(fs gmailctl.Filters) error {
+	if err := c.fail(apply.OperationAddFilters); err != nil {
+		return err
+	}
+	var errs []error
+	for i, f := range fs {
+		if f.Criteria.From != "" && f.Criteria.From == c.failFrom {
+			errs = append(errs, errors.WithIndex(errors.New("invalid filter"), i))
+			continue
+		}
+		f.ID = c.newID()
+		c.filters = append(c.filters, f)
+	}
+	return errors.Combine(errs...)
+}
+
+func (c *fakeClient) UpdateLabels(lbs gmailctl.Labels) error {
+	if err := c.fail(apply.OperationUpdateLabels); err != nil {
+		return err
+	}
+	for _, l := range lbs {
+		for i := range c.labels {
+			if c.labels[i].ID == l.ID {
+				c.labels[i] = l
+			}
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) DeleteFilters(ids []string) error {
+	if err := c.fail(apply.OperationDeleteFilters); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		for i, f := range c.filters {
+			if f.ID == id {
+				c.filters = append(c.filters[:i], c.filters[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) DeleteLabels(ids []string) error {
+	if err := c.fail(apply.OperationDeleteLabels); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		for i, l := range c.labels {
+			if l.ID == id {
+				c.labels = append(c.labels[:i], c.labels[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) fail(kind apply.OperationKind) error {
+	if c.failOn == kind {
+		return errors.New("quota exceeded")
+	}
+	return nil
+}
+
+func (c *fakeClient) newID() string {
+	c.nextID++
+	return fmt.Sprintf("id%d", c.nextID)
+}
+
+func testConfig() gmailctl.Config {
+	return gmailctl.Config{
+		Version: v1alpha3.Version,
+		Labels:  []v1alpha3.Label{{Name: "work"}},
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "boss@work.com"},
+				Actions: v1alpha3.Actions{Labels: []string{"work"}},
+			},
+			{
+				Filter:  v1alpha3.FilterNode{List: "dev@lists.com"},
+				Actions: v1alpha3.Actions{Archive: true},
+			},
+		},
+	}
+}
+
+func operationKinds(ops []gmailctl.Operation) []apply.OperationKind {
+	var res []apply.OperationKind
+	for _, op := range ops {
+		res = append(res, op.Kind)
+	}
+	return res
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeClient{
+		filters: gmailctl.Filters{
+			{
+				ID:       "old",
+				Action:   filter.Actions{Archive: true},
+				Criteria: filter.Criteria{From: "spam@example.com"},
+			},
+		},
+	}
+
+	res, err := gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []apply.OperationKind{
+		apply.OperationAddLabels,
+		apply.OperationAddFilters,
+		apply.OperationDeleteFilters,
+	}, operationKinds(res.Operations))
+	assert.Len(t, res.Diff.FiltersDiff.Added, 2)
+	assert.Len(t, res.Diff.FiltersDiff.Removed, 1)
+	assert.Len(t, client.filters, 2)
+	require.Len(t, client.labels, 1)
+	assert.Equal(t, "work", client.labels[0].Name)
+
+	// Applying again is a no-op.
+	res, err = gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.True(t, res.Diff.Empty())
+	assert.Empty(t, res.Operations)
+}
+
+func TestApplyDryRun(t *testing.T) {
+	client := &fakeClient{}
+	res, err := gmailctl.Apply(context.Background(), testConfig(), client, gmailctl.Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Equal(t, []apply.OperationKind{
+		apply.OperationAddLabels,
+		apply.OperationAddFilters,
+	}, operationKinds(res.Operations))
+	assert.Empty(t, client.filters)
+	assert.Empty(t, client.labels)
+}
+
+func TestApplyRemoveLabels(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig()
+	client := &fakeClient{}
+	_, err := gmailctl.Apply(ctx, cfg, client, gmailctl.Options{})
+	require.Nil(t, err)
+
+	cfg.Labels = []v1alpha3.Label{{Name: "other"}}
+	cfg.Rules = cfg.Rules[1:]
+	_, err = gmailctl.Apply(ctx, cfg, client, gmailctl.Options{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "work", client.labels[0].Name)
+
+	res, err := gmailctl.Apply(ctx, cfg, client, gmailctl.Options{AllowRemoveLabels: true})
+	require.Nil(t, err)
+	assert.Contains(t, operationKinds(res.Operations), apply.OperationDeleteLabels)
+	require.Len(t, client.labels, 1)
+	assert.Equal(t, "other", client.labels[0].Name)
+}
+
+func TestApplyForwarding(t *testing.T) {
+	cfg := testConfig()
+	cfg.Rules = append(cfg.Rules, v1alpha3.Rule{
+		Filter:  v1alpha3.FilterNode{From: "bills@bank.com"},
+		Actions: v1alpha3.Actions{Forward: "me@example.com"},
+	})
+	client := &fakeClient{}
+
+	_, err := gmailctl.Apply(context.Background(), cfg, client, gmailctl.Options{})
+	assert.NotNil(t, err)
+	assert.Empty(t, client.filters)
+
+	client.forwarding = []string{"me@example.com"}
+	_, err = gmailctl.Apply(context.Background(), cfg, client, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.Len(t, client.filters, 3)
+}
+
+func TestApplyLabelCase(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeClient{labels: gmailctl.Labels{{ID: "l1", Name: "Work"}}}
+
+	_, err := gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{StrictLabelCase: true})
+	assert.NotNil(t, err)
+	assert.Empty(t, client.filters)
+
+	res, err := gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []gmailctl.LabelCaseMatch{{Local: "work", Upstream: "Work"}}, res.ReusedLabels)
+	// No new label was created.
+	assert.Equal(t, gmailctl.Labels{{ID: "l1", Name: "Work"}}, client.labels)
+	assert.Len(t, client.filters, 2)
+	assert.True(t, client.filters.HasLabel("Work"))
+}
+
+func TestApplyGuard(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig()
+	cfg.Rules = append(cfg.Rules, v1alpha3.Rule{
+		Filter:  v1alpha3.FilterNode{From: "ci@example.com"},
+		Actions: v1alpha3.Actions{Archive: true},
+		Guard:   &v1alpha3.RuleGuard{LabelMissing: "ci"},
+	})
+	guarded := len(cfg.Rules) - 1
+
+	// Satisfied.
+	client := &fakeClient{}
+	res, err := gmailctl.Apply(ctx, cfg, client, gmailctl.Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Empty(t, res.SkippedRules)
+	assert.Len(t, res.Diff.FiltersDiff.Added, 3)
+	assert.Len(t, res.Diff.LabelsDiff.Added, 1)
+
+	// Not satisfied.
+	client = &fakeClient{labels: gmailctl.Labels{{ID: "l1", Name: "ci"}}}
+	res, err = gmailctl.Apply(ctx, cfg, client, gmailctl.Options{DryRun: true, AllowRemoveLabels: true})
+	require.Nil(t, err)
+	assert.Equal(t, []gmailctl.GuardSkip{
+		{Rule: guarded, Reason: `label "ci" already exists`},
+	}, res.SkippedRules)
+	assert.Len(t, res.Diff.FiltersDiff.Added, 2)
+
+	// Labels managed by the config don't count.
+	cfg.Labels = append(cfg.Labels, v1alpha3.Label{Name: "ci"})
+	res, err = gmailctl.Apply(ctx, cfg, client, gmailctl.Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Empty(t, res.SkippedRules)
+	assert.Len(t, res.Diff.FiltersDiff.Added, 3)
+}
+
+func TestApplyUnsupportedFilters(t *testing.T) {
+	ctx := context.Background()
+	// Every upstream filter was skipped as unsupported.
+	client := &fakeClient{
+		filtersErr: errors.WithCause(errors.New(`importing filter "a"`), exportapi.ErrUnsupportedFilter),
+	}
+	res, err := gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Len(t, res.Diff.FiltersDiff.Added, 2)
+
+	// Other errors are still returned.
+	client.filtersErr = errors.New("invalid criteria")
+	_, err = gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{DryRun: true})
+	assert.EqualError(t, err, "getting filters from Gmail: invalid criteria")
+}
+
+func TestApplyMaxFilters(t *testing.T) {
+	ctx := context.Background()
+	// Filters not managed by the config count for the limit as well.
+	client := &fakeClient{
+		filters: gmailctl.Filters{
+			{
+				ID:       "old",
+				Action:   filter.Actions{Archive: true},
+				Criteria: filter.Criteria{From: "spam@example.com"},
+			},
+		},
+	}
+
+	// The two new filters are created before the old one is deleted.
+	res, err := gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{MaxFilters: 2})
+	assert.EqualError(t, err, "applying the changes requires up to 3 filters at the same time, more than the 2 allowed by Gmail")
+	assert.Empty(t, res.Operations)
+	assert.Len(t, res.Diff.FiltersDiff.Added, 2)
+	// Nothing has been written.
+	assert.Equal(t, 0, client.nextID)
+	assert.Len(t, client.filters, 1)
+	assert.Empty(t, client.labels)
+
+	_, err = gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{MaxFilters: 1})
+	assert.EqualError(t, err, "the config requires 2 filters, more than the 1 allowed by Gmail")
+
+	_, err = gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{MaxFilters: 3})
+	assert.Nil(t, err)
+}
+
+func TestApplyPruneFiltersMatching(t *testing.T) {
+	client := &fakeClient{
+		filters: gmailctl.Filters{
+			{ID: "f1", Action: filter.Actions{Archive: true}, Criteria: filter.Criteria{From: "old@work.com"}},
+			{ID: "f2", Action: filter.Actions{Archive: true}, Criteria: filter.Criteria{From: "spam@example.com"}},
+		},
+	}
+	opts := gmailctl.Options{PruneFiltersMatching: regexp.MustCompile(`@work\.com`)}
+	res, err := gmailctl.Apply(context.Background(), testConfig(), client, opts)
+	require.Nil(t, err)
+	// Only the matching filter is deleted.
+	require.Len(t, res.Diff.FiltersDiff.Removed, 1)
+	assert.Equal(t, "f1", res.Diff.FiltersDiff.Removed[0].ID)
+	assert.Len(t, client.filters, 3)
+}
+
+func TestReadConfigWithOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.jsonnet")
+	cfg := `{
+  version: 'v1alpha3',
+  rules: [{ filter: { from: std.extVar('boss') }, actions: { archive: true } }],
+}`
+	require.Nil(t, os.WriteFile(path, []byte(cfg), 0o600))
+
+	_, err := gmailctl.ReadConfig(path)
+	assert.ErrorContains(t, err, "Undefined external variable")
+
+	opts := gmailctl.ReadOptions{ExtVars: gmailctl.ExtVars{Str: map[string]string{"boss": "boss@work.com"}}}
+	got, err := gmailctl.ReadConfigWithOptions(path, opts)
+	require.Nil(t, err)
+	require.Len(t, got.Rules, 1)
+	assert.Equal(t, "boss@work.com", got.Rules[0].Filter.From)
+}
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, gmailctl.Validate(testConfig()))
+
+	cfg := testConfig()
+	cfg.Rules[0].Actions = v1alpha3.Actions{}
+	cfg.Rules[1].Filter = v1alpha3.FilterNode{}
+	diags := gmailctl.Validate(cfg)
+	require.Len(t, diags, 2)
+	assert.Equal(t, "rule #0: actions: error: empty action", diags[0].String())
+	assert.Equal(t, "rule #1: filter: error: empty filter node", diags[1].String())
+}
+
+func TestApplyPartialFailure(t *testing.T) {
+	client := &fakeClient{failOn: apply.OperationAddFilters}
+	res, err := gmailctl.Apply(context.Background(), testConfig(), client, gmailctl.Options{})
+	assert.NotNil(t, err)
+	// Only the labels were created.
+	assert.Equal(t, []apply.OperationKind{apply.OperationAddLabels}, operationKinds(res.Operations))
+	assert.Len(t, client.labels, 1)
+	assert.Empty(t, client.filters)
+}
+
+func TestApplyRecordsSucceededItems(t *testing.T) {
+	client := &fakeClient{failFrom: "boss@work.com"}
+	res, err := gmailctl.Apply(context.Background(), testConfig(), client, gmailctl.Options{})
+	assert.NotNil(t, err)
+	// The filter that was created is recorded anyway.
+	require.Len(t, res.Operations, 2)
+	assert.Equal(t, apply.OperationAddFilters, res.Operations[1].Kind)
+	require.Len(t, res.Operations[1].Filters, 1)
+	assert.Equal(t, "list:dev@lists.com", res.Operations[1].Filters[0].Criteria.ToGmailSearch())
+	assert.Len(t, client.filters, 1)
+}
+
+func TestApplyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &fakeClient{}
+	_, err := gmailctl.Apply(ctx, testConfig(), client, gmailctl.Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Canceling while applying stops before the next call.
+	diff, err := apply.Diff(apply.GmailConfig{Filters: gmailctl.Filters{
+		{Action: filter.Actions{Archive: true}, Criteria: filter.Criteria{From: "a@b.com"}},
+	}}, apply.GmailConfig{})
+	require.Nil(t, err)
+	res, err := gmailctl.ApplyDiff(ctx, diff, client, gmailctl.Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, res.Operations)
+	assert.Empty(t, client.filters)
+}
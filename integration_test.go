@@ -1,4 +1,4 @@
-package integration_test
+package gmailctl_test
 
 import (
 	"bufio"
@@ -16,11 +16,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/mbrt/gmailctl"
 	"github.com/mbrt/gmailctl/internal/engine/api"
 	"github.com/mbrt/gmailctl/internal/engine/apply"
 	"github.com/mbrt/gmailctl/internal/engine/config"
 	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
 	"github.com/mbrt/gmailctl/internal/engine/export/xml"
+	"github.com/mbrt/gmailctl/internal/engine/label"
 	"github.com/mbrt/gmailctl/internal/engine/rimport"
 	"github.com/mbrt/gmailctl/internal/fakegmail"
 )
@@ -49,7 +51,7 @@ func TestIntegration(t *testing.T) {
 		name := strings.TrimSuffix(cfgPath, ".jsonnet")
 		t.Run(name, func(t *testing.T) {
 			// Parse the config.
-			cfg, err := config.ReadFile(cfgPath, filepath.Join("testdata", cfgPath))
+			cfg, _, err := config.ReadFile(cfgPath, filepath.Join("testdata", cfgPath), config.ReadOptions{})
 			require.Nil(t, err)
 			pres, err := apply.FromConfig(cfg)
 			require.Nil(t, err)
@@ -64,7 +66,7 @@ func TestIntegration(t *testing.T) {
 			require.Nil(t, err)
 
 			// Fetch the upstream filters.
-			upres, err := apply.FromAPI(gapi)
+			upres, _, err := apply.FromAPI(gapi)
 			require.Nil(t, err)
 
 			// Apply the diff.
@@ -74,7 +76,7 @@ func TestIntegration(t *testing.T) {
 			require.Nil(t, err)
 
 			// Import.
-			upres, err = apply.FromAPI(gapi)
+			upres, _, err = apply.FromAPI(gapi)
 			require.Nil(t, err)
 			icfg, err := rimport.Import(upres.Filters, upres.Labels)
 			require.Nil(t, err)
@@ -129,13 +131,13 @@ func TestIntegrationImportExport(t *testing.T) {
 		name := strings.TrimSuffix(cfgPath, ".jsonnet")
 		t.Run(name, func(t *testing.T) {
 			// Parse the config.
-			cfg, err := config.ReadFile(cfgPath, filepath.Join("testdata", cfgPath))
+			cfg, _, err := config.ReadFile(cfgPath, filepath.Join("testdata", cfgPath), config.ReadOptions{})
 			require.Nil(t, err)
 			pres, err := apply.FromConfig(cfg)
 			require.Nil(t, err)
 
 			// Fetch the upstream filters.
-			upres, err := apply.FromAPI(gapi)
+			upres, _, err := apply.FromAPI(gapi)
 			require.Nil(t, err)
 
 			// Apply the diff.
@@ -145,7 +147,7 @@ func TestIntegrationImportExport(t *testing.T) {
 			require.Nil(t, err)
 
 			// Import.
-			upres, err = apply.FromAPI(gapi)
+			upres, _, err = apply.FromAPI(gapi)
 			require.Nil(t, err)
 			icfg, err := rimport.Import(upres.Filters, upres.Labels)
 			require.Nil(t, err)
@@ -164,7 +166,7 @@ func TestIntegrationImportExport(t *testing.T) {
 			require.Nil(t, err)
 
 			// There should be no diff between the original and the converted config.
-			ijcfg, err := config.ReadJsonnet("", buf.Bytes())
+			ijcfg, err := config.ReadJsonnet("", buf.Bytes(), config.ReadOptions{})
 			require.Nil(t, err)
 			ijpres, err := apply.FromConfig(ijcfg)
 			require.Nil(t, err)
@@ -173,6 +175,332 @@ func TestIntegrationImportExport(t *testing.T) {
 	}
 }
 
+// writeCountingClient counts the write calls made to the Gmail APIs.
+type writeCountingClient struct {
+	*api.GmailAPI
+	writes int
+}
+
+func (c *writeCountingClient) ListForwardingAddresses() ([]string, error) {
+	return []string{"me@example.com"}, nil
+}
+
+func (c *writeCountingClient) AddLabels(lbs gmailctl.Labels) error {
+	c.writes++
+	return c.GmailAPI.AddLabels(lbs)
+}
+
+func (c *writeCountingClient) AddFilters(fs gmailctl.Filters) error {
+	c.writes++
+	return c.GmailAPI.AddFilters(fs)
+}
+
+func (c *writeCountingClient) UpdateLabels(lbs gmailctl.Labels) error {
+	c.writes++
+	return c.GmailAPI.UpdateLabels(lbs)
+}
+
+func (c *writeCountingClient) DeleteFilters(ids []string) error {
+	c.writes++
+	return c.GmailAPI.DeleteFilters(ids)
+}
+
+func (c *writeCountingClient) DeleteLabels(ids []string) error {
+	c.writes++
+	return c.GmailAPI.DeleteLabels(ids)
+}
+
+func TestIntegrationApplyTwice(t *testing.T) {
+	ctx := context.Background()
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Labels: []v1alpha3.Label{
+			// Gmail stores the colors in lowercase.
+			{Name: "work/projects", Color: &v1alpha3.LabelColor{Background: "#FB4C2F", Text: "#FFFFFF"}},
+			{Name: "news"},
+		},
+		Rules: []v1alpha3.Rule{
+			{
+				Filter: v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{
+					{From: "boss@work.com"},
+					{From: "alpha@work.com"},
+				}},
+				Actions: v1alpha3.Actions{
+					Labels:        []string{"work/projects", "news"},
+					MarkImportant: boolPtr(false),
+				},
+			},
+			{
+				Filter: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{
+					{Category: "promotions"},
+					{Not: &v1alpha3.FilterNode{Subject: "order confirmation"}},
+				}},
+				Actions: v1alpha3.Actions{Archive: true, MarkRead: true, Category: "updates"},
+			},
+			{
+				Filter: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{
+					{List: "dev@lists.com"},
+					{HasAttachment: true},
+				}},
+				Actions: v1alpha3.Actions{Forward: "me@example.com", Star: true},
+			},
+			// Generates the same filter of the previous rule.
+			{
+				Filter: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{
+					{List: "dev@lists.com"},
+					{HasAttachment: true},
+				}},
+				Actions: v1alpha3.Actions{Forward: "me@example.com", Star: true},
+			},
+		},
+	}
+	client := &writeCountingClient{
+		GmailAPI: api.NewFromService(fakegmail.NewService(ctx, t)),
+	}
+
+	res, err := gmailctl.Apply(ctx, cfg, client, gmailctl.Options{})
+	require.Nil(t, err)
+	require.NotEmpty(t, res.Operations)
+	require.NotZero(t, client.writes)
+
+	// Nothing changed, so the second apply doesn't write anything.
+	client.writes = 0
+	res, err = gmailctl.Apply(ctx, cfg, client, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.True(t, res.Diff.Empty(), res.Diff.String())
+	assert.Empty(t, res.Operations)
+	assert.Zero(t, client.writes)
+}
+
+func TestIntegrationImportanceRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions v1alpha3.Actions
+		// want is the action of the downloaded config.
+		want v1alpha3.Actions
+	}{
+		{
+			name:    "mark important",
+			actions: v1alpha3.Actions{MarkImportant: boolPtr(true)},
+			want:    v1alpha3.Actions{MarkImportant: boolPtr(true)},
+		},
+		{
+			name:    "never mark important",
+			actions: v1alpha3.Actions{MarkImportant: boolPtr(false)},
+			want:    v1alpha3.Actions{MarkImportant: boolPtr(false)},
+		},
+		{
+			name:    "never mark important explicit",
+			actions: v1alpha3.Actions{NeverMarkImportant: true},
+			want:    v1alpha3.Actions{MarkImportant: boolPtr(false)},
+		},
+		{
+			name:    "unspecified",
+			actions: v1alpha3.Actions{Archive: true},
+			want:    v1alpha3.Actions{Archive: true},
+		},
+		{
+			name: "with other system labels",
+			actions: v1alpha3.Actions{
+				MarkImportant: boolPtr(false),
+				MarkSpam:      boolPtr(false),
+				MarkRead:      true,
+				Star:          true,
+				Category:      "updates",
+			},
+			want: v1alpha3.Actions{
+				MarkImportant: boolPtr(false),
+				MarkSpam:      boolPtr(false),
+				MarkRead:      true,
+				Star:          true,
+				Category:      "updates",
+			},
+		},
+		{
+			name:    "with a label",
+			actions: v1alpha3.Actions{MarkImportant: boolPtr(true), Labels: []string{"work"}},
+			want:    v1alpha3.Actions{MarkImportant: boolPtr(true), Labels: []string{"work"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gapi := api.NewFromService(fakegmail.NewService(context.Background(), t))
+			cfg := v1alpha3.Config{
+				Version: v1alpha3.Version,
+				Labels:  []v1alpha3.Label{{Name: "work"}},
+				Rules: []v1alpha3.Rule{
+					{Filter: v1alpha3.FilterNode{From: "boss@work.com"}, Actions: tc.actions},
+				},
+			}
+			pres, err := apply.FromConfig(cfg)
+			require.Nil(t, err)
+			upres, _, err := apply.FromAPI(gapi)
+			require.Nil(t, err)
+			d, err := apply.Diff(pres.GmailConfig, upres)
+			require.Nil(t, err)
+			require.Nil(t, apply.Apply(d, gapi, true))
+
+			// The downloaded config has the same actions.
+			upres, _, err = apply.FromAPI(gapi)
+			require.Nil(t, err)
+			assertEmptyDiff(t, pres.GmailConfig, upres)
+			icfg, err := rimport.Import(upres.Filters, upres.Labels)
+			require.Nil(t, err)
+			require.Len(t, icfg.Rules, 1)
+			assert.Equal(t, tc.want, icfg.Rules[0].Actions)
+
+			// And applying it again changes nothing.
+			ipres, err := apply.FromConfig(icfg)
+			require.Nil(t, err)
+			assertEmptyDiff(t, ipres.GmailConfig, upres)
+		})
+	}
+}
+
+func TestIntegrationReplyToRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   v1alpha3.FilterNode
+		query    string
+		imported v1alpha3.FilterNode
+	}{
+		{
+			name:     "single",
+			filter:   v1alpha3.FilterNode{ReplyTo: "a@x.com"},
+			query:    "replyto:a@x.com",
+			imported: v1alpha3.FilterNode{ReplyTo: "a@x.com"},
+		},
+		{
+			name: "or",
+			filter: v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{
+				{ReplyTo: "a@x.com"}, {ReplyTo: "b@x.com"},
+			}},
+			query: "replyto:{a@x.com b@x.com}",
+			imported: v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{
+				{ReplyTo: "a@x.com"}, {ReplyTo: "b@x.com"},
+			}},
+		},
+		{
+			name: "negated",
+			filter: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{
+				{From: "boss@work.com"},
+				{Not: &v1alpha3.FilterNode{ReplyTo: "a@x.com"}},
+			}},
+			query: "-replyto:a@x.com",
+			imported: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{
+				{From: "boss@work.com"},
+				{Not: &v1alpha3.FilterNode{ReplyTo: "a@x.com"}},
+			}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gapi := api.NewFromService(fakegmail.NewService(context.Background(), t))
+			cfg := v1alpha3.Config{
+				Version: v1alpha3.Version,
+				Rules: []v1alpha3.Rule{
+					{Filter: tc.filter, Actions: v1alpha3.Actions{Archive: true}},
+				},
+			}
+			pres, err := apply.FromConfig(cfg)
+			require.Nil(t, err)
+			require.Len(t, pres.Filters, 1)
+			assert.Equal(t, tc.query, pres.Filters[0].Criteria.Query)
+			upres, _, err := apply.FromAPI(gapi)
+			require.Nil(t, err)
+			d, err := apply.Diff(pres.GmailConfig, upres)
+			require.Nil(t, err)
+			require.Nil(t, apply.Apply(d, gapi, true))
+
+			// The downloaded config has the 'replyto' nodes, not a raw query.
+			upres, _, err = apply.FromAPI(gapi)
+			require.Nil(t, err)
+			icfg, err := rimport.Import(upres.Filters, upres.Labels)
+			require.Nil(t, err)
+			require.Len(t, icfg.Rules, 1)
+			assert.Equal(t, tc.imported, icfg.Rules[0].Filter)
+
+			// And applying it again changes nothing.
+			ipres, err := apply.FromConfig(icfg)
+			require.Nil(t, err)
+			assertEmptyDiff(t, ipres.GmailConfig, upres)
+		})
+	}
+}
+
+func TestIntegrationStandaloneLabels(t *testing.T) {
+	ctx := context.Background()
+	gapi := api.NewFromService(fakegmail.NewService(ctx, t))
+	work := v1alpha3.Label{Name: "work"}
+	// Not referenced by any filter.
+	someday := v1alpha3.Label{
+		Name:       "someday",
+		Color:      &v1alpha3.LabelColor{Background: "#fb4c2f", Text: "#ffffff"},
+		Visibility: &v1alpha3.LabelVisibility{LabelList: "showIfUnread", MessageList: "hide"},
+	}
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Labels:  []v1alpha3.Label{work, someday},
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "boss@work.com"},
+				Actions: v1alpha3.Actions{Labels: []string{"work"}},
+			},
+		},
+	}
+
+	// The label is created with its settings.
+	res, err := gmailctl.Apply(ctx, cfg, gapi, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.Len(t, res.Diff.LabelsDiff.Added, 2)
+	upstream, err := gapi.ListLabels()
+	require.Nil(t, err)
+	require.Len(t, upstream, 2)
+	sort.Slice(upstream, func(i, j int) bool { return upstream[i].Name < upstream[j].Name })
+	assert.Equal(t, "someday", upstream[0].Name)
+	assert.Equal(t, &label.Visibility{LabelList: "showIfUnread", MessageList: "hide"}, upstream[0].Visibility)
+
+	// It's downloaded as it is, and applying again changes nothing.
+	icfg, err := rimport.Import(nil, upstream)
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Label{someday, work}, icfg.Labels)
+	res, err = gmailctl.Apply(ctx, cfg, gapi, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.True(t, res.Diff.Empty(), res.Diff.String())
+
+	// The settings can be changed.
+	cfg.Labels[1].Visibility = &v1alpha3.LabelVisibility{LabelList: "show"}
+	res, err = gmailctl.Apply(ctx, cfg, gapi, gmailctl.Options{})
+	require.Nil(t, err)
+	assert.Len(t, res.Diff.LabelsDiff.Modified, 1)
+	upstream, err = gapi.ListLabels()
+	require.Nil(t, err)
+	sort.Slice(upstream, func(i, j int) bool { return upstream[i].Name < upstream[j].Name })
+	assert.Equal(t, &label.Visibility{LabelList: "show", MessageList: "hide"}, upstream[0].Visibility)
+
+	// Removed from the config, it's not deleted unless allowed.
+	cfg.Labels = []v1alpha3.Label{work}
+	_, err = gmailctl.Apply(ctx, cfg, gapi, gmailctl.Options{})
+	assert.EqualError(t, err, "the config requires deleting labels")
+
+	// But it's pruned, being empty.
+	local, err := apply.FromConfig(cfg)
+	require.Nil(t, err)
+	pres, err := apply.PruneLabels(local.GmailConfig, upstream, gapi)
+	require.Nil(t, err)
+	require.Len(t, pres.Deleted, 1)
+	assert.Equal(t, "someday", pres.Deleted[0].Name)
+	upstream, err = gapi.ListLabels()
+	require.Nil(t, err)
+	assert.Equal(t, label.Labels{{ID: upstream[0].ID, Name: "work"}}, upstream)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 func assertEmptyDiff(t *testing.T, local, remote apply.GmailConfig) {
 	d, err := apply.Diff(local, remote)
 	require.Nil(t, err)
@@ -7,6 +7,8 @@ var (
 	gmailctlLib string
 	//go:embed default-config.jsonnet
 	defaultConfig string
+	//go:embed example-config.jsonnet
+	exampleConfig string
 )
 
 // GmailctlLib returns the embedded gmailctl.libsonnet file
@@ -18,3 +20,9 @@ func GmailctlLib() string {
 func DefaultConfig() string {
 	return defaultConfig
 }
+
+// ExampleConfig returns the embedded starter configuration file, with
+// example rules
+func ExampleConfig() string {
+	return exampleConfig
+}
@@ -42,6 +42,19 @@
     ],
   },
 
+  // anyList matches emails sent to any of the given mailing lists.
+  // The lists are grouped together in a single 'list:' operator.
+  anyList(lists)::
+    if std.length(lists) == 1 then { list: lists[0] }
+    else { or: [{ list: l } for l in lists] },
+
+  // listAny is an alias of anyList.
+  listAny(lists):: $.anyList(lists),
+
+  // fromDomain matches emails sent from any address of the given domain.
+  // Subdomains are not included.
+  fromDomain(domain):: { from: '@' + domain },
+
   local extendWithParents(labels) =
     local extend(p) =
       local comps = std.split(p, '/');
@@ -1,4 +1,4 @@
-package data
+package data_test
 
 import (
 	"encoding/json"
@@ -58,7 +58,7 @@ func TestJsonnetLib(t *testing.T) {
 		jfile := tps.jsonnets[i]
 
 		t.Run(jfile, func(t *testing.T) {
-			jnparsed, err := config.ReadFile(jfile, "")
+			jnparsed, _, err := config.ReadFile(jfile, "", config.ReadOptions{})
 			assert.Nil(t, err)
 
 			jsfile := tps.jsons[i]
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/oauth2"
 	"google.golang.org/api/gmail/v1"
@@ -20,22 +22,64 @@ const (
 	labelTypeSystem = "system"
 	labelDocsURL    = "https://developers.google.com/gmail/api/v1/reference/users/labels#resource"
 	authExpiredURL  = "https://github.com/mbrt/gmailctl#oauth2-authentication-errors"
+	// maxSearchResults is the maximum page size of a messages search.
+	maxSearchResults = 500
 )
 
 // NewFromService creates a new GmailAPI instance from the given Gmail service.
 func NewFromService(s *gmail.Service) *GmailAPI {
-	return &GmailAPI{s, nil}
+	return &GmailAPI{service: s, throttle: newThrottler()}
 }
 
 // NewWithAPIKey creates a new GmailAPI instance from the given Gmail service and API key.
 func NewWithAPIKey(s *gmail.Service, key string) *GmailAPI {
-	return &GmailAPI{s, []googleapi.CallOption{keyOption(key)}}
+	return &GmailAPI{
+		service:  s,
+		opts:     []googleapi.CallOption{keyOption(key)},
+		throttle: newThrottler(),
+	}
 }
 
 // GmailAPI is a wrapper around the Gmail APIs.
+//
+// Calls failed because of quota limits or transient server errors are
+// retried with exponential backoff. Creating filters and labels is retried
+// only after quota errors, as it can't be safely repeated.
+//
+// The mapping between label names and IDs, needed to read and write
+// filters, is cached after the labels are listed. Creating, updating or
+// deleting labels invalidates it.
+//
+// Calls changing multiple filters or labels go on after a failure. The
+// failures are annotated with the index of their item (see errors.WithIndex).
 type GmailAPI struct {
-	service *gmail.Service
-	opts    []googleapi.CallOption
+	service  *gmail.Service
+	opts     []googleapi.CallOption
+	throttle *throttler
+	progress func()
+
+	mu   sync.Mutex
+	lmap *api.LabelMap
+}
+
+// SetRate limits the API calls to the given number per second. A non
+// positive rate disables the limit.
+func (g *GmailAPI) SetRate(perSecond float64) {
+	g.throttle.setRate(perSecond)
+}
+
+// SetRetries sets the maximum number of retries of a failed API call and
+// the wait before the first one, doubled at every following retry. Zero
+// retries disable them.
+func (g *GmailAPI) SetRetries(maxRetries int, backoff time.Duration) {
+	g.throttle.setRetries(maxRetries, backoff)
+}
+
+// SetProgress sets a function called after every filter or label created,
+// updated or deleted successfully, e.g. to report the progress of large
+// changes. Nil disables it.
+func (g *GmailAPI) SetProgress(f func()) {
+	g.progress = f
 }
 
 // ListFilters returns the list of Gmail filters in the settings.
@@ -45,7 +89,11 @@ func (g *GmailAPI) ListFilters() (filter.Filters, error) {
 		return nil, err
 	}
 
-	apires, err := g.service.Users.Settings.Filters.List(gmailUser).Do(g.opts...)
+	var apires *gmail.ListFiltersResponse
+	err = g.throttle.Do(func() (err error) {
+		apires, err = g.service.Users.Settings.Filters.List(gmailUser).Do(g.opts...)
+		return err
+	})
 	if err != nil {
 		return nil, annotateError(err)
 	}
@@ -53,17 +101,29 @@ func (g *GmailAPI) ListFilters() (filter.Filters, error) {
 }
 
 // DeleteFilters deletes all the given filter IDs.
+//
+// A failure doesn't stop the deletion of the other filters. All the
+// failures are returned together.
 func (g *GmailAPI) DeleteFilters(ids []string) error {
-	for _, id := range ids {
-		err := g.service.Users.Settings.Filters.Delete(gmailUser, id).Do(g.opts...)
+	var errs []error
+	for i, id := range ids {
+		err := g.throttle.Do(func() error {
+			return g.service.Users.Settings.Filters.Delete(gmailUser, id).Do(g.opts...)
+		})
 		if err != nil {
-			return fmt.Errorf("deleting filter %q: %w", id, annotateError(err))
+			errs = append(errs, errors.WithIndex(
+				fmt.Errorf("deleting filter %q: %w", id, annotateError(err)), i))
+			continue
 		}
+		g.itemDone()
 	}
-	return nil
+	return errors.Combine(errs...)
 }
 
 // AddFilters creates the given filters.
+//
+// A failure doesn't stop the creation of the other filters. All the
+// failures are returned together.
 func (g *GmailAPI) AddFilters(fs filter.Filters) error {
 	lmap, err := g.getLabelMap()
 	if err != nil {
@@ -75,19 +135,30 @@ func (g *GmailAPI) AddFilters(fs filter.Filters) error {
 		return err
 	}
 
+	var errs []error
 	for i, gfilter := range gfilters {
-		_, err = g.service.Users.Settings.Filters.Create(gmailUser, gfilter).Do(g.opts...)
+		err = g.throttle.DoNonIdempotent(func() error {
+			_, err := g.service.Users.Settings.Filters.Create(gmailUser, gfilter).Do(g.opts...)
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("creating filter %d: %w", i, annotateError(err))
+			errs = append(errs, errors.WithIndex(fmt.Errorf("creating filter %q: %w",
+				fs[i].Criteria.ToGmailSearch(), annotateError(err)), i))
+			continue
 		}
+		g.itemDone()
 	}
 
-	return nil
+	return errors.Combine(errs...)
 }
 
-// ListLabels lists the user labels.
+// ListLabels lists the user labels, caching the mapping of their names.
 func (g *GmailAPI) ListLabels() (label.Labels, error) {
-	apires, err := g.service.Users.Labels.List(gmailUser).Do(g.opts...)
+	var apires *gmail.ListLabelsResponse
+	err := g.throttle.Do(func() (err error) {
+		apires, err = g.service.Users.Labels.List(gmailUser).Do(g.opts...)
+		return err
+	})
 	if err != nil {
 		return nil, annotateError(err)
 	}
@@ -109,55 +180,188 @@ func (g *GmailAPI) ListLabels() (label.Labels, error) {
 		}
 
 		res = append(res, label.Label{
-			ID:    lb.Id,
-			Name:  lb.Name,
-			Color: color,
+			ID:         lb.Id,
+			Name:       lb.Name,
+			Color:      color,
+			Visibility: visibilityFromGmailAPI(lb),
 		})
 	}
 
+	g.setLabelMap(res)
 	return res, nil
 }
 
 // DeleteLabels deletes all the given label IDs.
+//
+// A failure doesn't stop the deletion of the other labels. All the
+// failures are returned together.
 func (g *GmailAPI) DeleteLabels(ids []string) error {
-	for _, id := range ids {
-		err := g.service.Users.Labels.Delete(gmailUser, id).Do(g.opts...)
+	defer g.invalidateLabelMap()
+	var errs []error
+	for i, id := range ids {
+		err := g.throttle.Do(func() error {
+			return g.service.Users.Labels.Delete(gmailUser, id).Do(g.opts...)
+		})
 		if err != nil {
-			return fmt.Errorf("deleting label %q: %w", id, annotateError(err))
+			errs = append(errs, errors.WithIndex(
+				fmt.Errorf("deleting label %q: %w", id, annotateError(err)), i))
+			continue
 		}
+		g.itemDone()
 	}
-	return nil
+	return errors.Combine(errs...)
+}
+
+// CountLabelMessages returns the number of messages with the given label ID.
+func (g *GmailAPI) CountLabelMessages(id string) (int64, error) {
+	var lb *gmail.Label
+	err := g.throttle.Do(func() (err error) {
+		lb, err = g.service.Users.Labels.Get(gmailUser, id).Do(g.opts...)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("getting label %q: %w", id, annotateError(err))
+	}
+	return lb.MessagesTotal, nil
+}
 
+// ListForwardingAddresses returns the addresses the account can forward
+// emails to, i.e. the forwarding addresses that have been verified.
+func (g *GmailAPI) ListForwardingAddresses() ([]string, error) {
+	var apires *gmail.ListForwardingAddressesResponse
+	err := g.throttle.Do(func() (err error) {
+		apires, err = g.service.Users.Settings.ForwardingAddresses.List(gmailUser).Do(g.opts...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing forwarding addresses: %w", annotateError(err))
+	}
+
+	var res []string
+	for _, fa := range apires.ForwardingAddresses {
+		if fa.VerificationStatus == "accepted" {
+			res = append(res, fa.ForwardingEmail)
+		}
+	}
+	return res, nil
 }
 
 // AddLabels creates the given labels.
+//
+// A failure doesn't stop the creation of the other labels. All the failures
+// are returned together.
 func (g *GmailAPI) AddLabels(lbs label.Labels) error {
-	for _, lb := range lbs {
-		_, err := g.service.Users.Labels.Create(gmailUser, labelToGmailAPI(lb)).Do(g.opts...)
+	defer g.invalidateLabelMap()
+	var errs []error
+	for i, lb := range lbs {
+		err := g.throttle.DoNonIdempotent(func() error {
+			_, err := g.service.Users.Labels.Create(gmailUser, labelToGmailAPI(lb)).Do(g.opts...)
+			return err
+		})
 		if err != nil {
-			return annotateError(fmt.Errorf("creating label %q: %w", lb.Name, err))
+			errs = append(errs, errors.WithIndex(
+				annotateError(fmt.Errorf("creating label %q: %w", lb.Name, err)), i))
+			continue
 		}
+		g.itemDone()
 	}
-	return nil
+	return errors.Combine(errs...)
 }
 
 // UpdateLabels modifies the given labels.
 //
-// The label ID is required for the edit to be successful.
+// The label ID is required for the edit to be successful. A failure doesn't
+// stop the update of the other labels. All the failures are returned
+// together.
 func (g *GmailAPI) UpdateLabels(lbs label.Labels) error {
-	for _, lb := range lbs {
+	defer g.invalidateLabelMap()
+	var errs []error
+	for i, lb := range lbs {
 		if lb.ID == "" {
-			return fmt.Errorf("label %q has empty ID", lb.Name)
+			errs = append(errs, errors.WithIndex(fmt.Errorf("label %q has empty ID", lb.Name), i))
+			continue
 		}
-		_, err := g.service.Users.Labels.Patch(gmailUser, lb.ID, labelToGmailAPI(lb)).Do(g.opts...)
+		err := g.throttle.Do(func() error {
+			_, err := g.service.Users.Labels.Patch(gmailUser, lb.ID, labelToGmailAPI(lb)).Do(g.opts...)
+			return err
+		})
 		if err != nil {
-			return annotateError(fmt.Errorf("patching label %q: %w", lb.Name, err))
+			errs = append(errs, errors.WithIndex(
+				annotateError(fmt.Errorf("patching label %q: %w", lb.Name, err)), i))
+			continue
+		}
+		g.itemDone()
+	}
+	return errors.Combine(errs...)
+}
+
+// SearchMessages returns the IDs of the messages matching the given Gmail
+// search query.
+func (g *GmailAPI) SearchMessages(query string) ([]string, error) {
+	var (
+		res       []string
+		pageToken string
+	)
+	for {
+		var apires *gmail.ListMessagesResponse
+		err := g.throttle.Do(func() (err error) {
+			apires, err = g.service.Users.Messages.List(gmailUser).
+				Q(query).MaxResults(maxSearchResults).PageToken(pageToken).Do(g.opts...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("searching messages %q: %w", query, annotateError(err))
+		}
+		for _, m := range apires.Messages {
+			res = append(res, m.Id)
 		}
+		if apires.NextPageToken == "" {
+			return res, nil
+		}
+		pageToken = apires.NextPageToken
+	}
+}
+
+// ModifyMessages performs the given actions on the messages with the given
+// IDs, by changing their labels. Forwarding is not supported.
+func (g *GmailAPI) ModifyMessages(ids []string, a filter.Actions) error {
+	lmap, err := g.getLabelMap()
+	if err != nil {
+		return err
+	}
+	add, remove, err := api.LabelChanges(a, lmap)
+	if err != nil {
+		return err
+	}
+	err = g.throttle.Do(func() error {
+		return g.service.Users.Messages.BatchModify(gmailUser, &gmail.BatchModifyMessagesRequest{
+			Ids:            ids,
+			AddLabelIds:    add,
+			RemoveLabelIds: remove,
+		}).Do(g.opts...)
+	})
+	if err != nil {
+		return fmt.Errorf("modifying %d messages: %w", len(ids), annotateError(err))
 	}
 	return nil
 }
 
+// itemDone reports the progress of a filter or label changed.
+func (g *GmailAPI) itemDone() {
+	if g.progress != nil {
+		g.progress()
+	}
+}
+
+// getLabelMap returns the cached label map, listing the labels only if it's
+// not present.
 func (g *GmailAPI) getLabelMap() (api.LabelMap, error) {
+	g.mu.Lock()
+	lmap := g.lmap
+	g.mu.Unlock()
+	if lmap != nil {
+		return *lmap, nil
+	}
 	labels, err := g.ListLabels()
 	if err != nil {
 		return api.LabelMap{}, err
@@ -165,6 +369,21 @@ func (g *GmailAPI) getLabelMap() (api.LabelMap, error) {
 	return api.NewLabelMap(labels), nil
 }
 
+func (g *GmailAPI) setLabelMap(labels label.Labels) {
+	lmap := api.NewLabelMap(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lmap = &lmap
+}
+
+// invalidateLabelMap drops the cached label map, after the labels are
+// changed. Even failed calls may have changed some of them.
+func (g *GmailAPI) invalidateLabelMap() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lmap = nil
+}
+
 func labelToGmailAPI(lb label.Label) *gmail.Label {
 	var color *gmail.LabelColor
 	if lb.Color != nil {
@@ -173,10 +392,45 @@ func labelToGmailAPI(lb label.Label) *gmail.Label {
 			TextColor:       lb.Color.Text,
 		}
 	}
-	return &gmail.Label{
+	res := &gmail.Label{
 		Name:  lb.Name,
 		Color: color,
 	}
+	if v := lb.Visibility; v != nil {
+		res.LabelListVisibility = labelListVisibility[v.LabelList]
+		res.MessageListVisibility = v.MessageList
+	}
+	return res
+}
+
+// labelListVisibility maps the visibility of labels in the list of labels to
+// their Gmail API names. The visibility in the list of messages has the same
+// names in both.
+var labelListVisibility = map[string]string{
+	label.VisibilityShow:         "labelShow",
+	label.VisibilityShowIfUnread: "labelShowIfUnread",
+	label.VisibilityHide:         "labelHide",
+}
+
+// visibilityFromGmailAPI returns the visibility settings of the label, or
+// nil if it's shown everywhere, which is the default.
+func visibilityFromGmailAPI(lb *gmail.Label) *label.Visibility {
+	res := label.Visibility{
+		LabelList:   label.VisibilityShow,
+		MessageList: label.VisibilityShow,
+	}
+	for k, v := range labelListVisibility {
+		if v == lb.LabelListVisibility {
+			res.LabelList = k
+		}
+	}
+	if lb.MessageListVisibility == label.VisibilityHide {
+		res.MessageList = label.VisibilityHide
+	}
+	if res.LabelList == label.VisibilityShow && res.MessageList == label.VisibilityShow {
+		return nil
+	}
+	return &res
 }
 
 func annotateError(err error) error {
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestLabelVisibilityToGmailAPI(t *testing.T) {
+	tests := []struct {
+		name        string
+		visibility  *label.Visibility
+		labelList   string
+		messageList string
+	}{
+		// Gmail applies its defaults.
+		{name: "unspecified"},
+		{
+			name:        "shown",
+			visibility:  &label.Visibility{LabelList: label.VisibilityShow, MessageList: label.VisibilityShow},
+			labelList:   "labelShow",
+			messageList: "show",
+		},
+		{
+			name:        "hidden",
+			visibility:  &label.Visibility{LabelList: label.VisibilityHide, MessageList: label.VisibilityHide},
+			labelList:   "labelHide",
+			messageList: "hide",
+		},
+		{
+			name:       "only label list",
+			visibility: &label.Visibility{LabelList: label.VisibilityShowIfUnread},
+			labelList:  "labelShowIfUnread",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := labelToGmailAPI(label.Label{Name: "a", Visibility: tc.visibility})
+			assert.Equal(t, tc.labelList, got.LabelListVisibility)
+			assert.Equal(t, tc.messageList, got.MessageListVisibility)
+		})
+	}
+}
+
+func TestLabelVisibilityFromGmailAPI(t *testing.T) {
+	tests := []struct {
+		name        string
+		labelList   string
+		messageList string
+		want        *label.Visibility
+	}{
+		{name: "missing"},
+		{name: "default", labelList: "labelShow", messageList: "show"},
+		{
+			name:        "hidden from messages",
+			labelList:   "labelShow",
+			messageList: "hide",
+			want:        &label.Visibility{LabelList: label.VisibilityShow, MessageList: label.VisibilityHide},
+		},
+		{
+			name:      "shown if unread",
+			labelList: "labelShowIfUnread",
+			want:      &label.Visibility{LabelList: label.VisibilityShowIfUnread, MessageList: label.VisibilityShow},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := visibilityFromGmailAPI(&gmail.Label{
+				LabelListVisibility:   tc.labelList,
+				MessageListVisibility: tc.messageList,
+			})
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLabelMapCache(t *testing.T) {
+	var (
+		labels    = []*gmail.Label{{Id: "l1", Name: "work", Type: "user"}}
+		listCalls int
+		filterIDs [][]string
+	)
+	api, _ := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/labels"):
+			listCalls++
+			_ = json.NewEncoder(w).Encode(gmail.ListLabelsResponse{Labels: labels})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/labels"):
+			var lb gmail.Label
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&lb))
+			lb.Id = fmt.Sprintf("l%d", len(labels)+1)
+			labels = append(labels, &lb)
+			_ = json.NewEncoder(w).Encode(lb)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/filters"):
+			var f gmail.Filter
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&f))
+			filterIDs = append(filterIDs, f.Action.AddLabelIds)
+			_ = json.NewEncoder(w).Encode(f)
+		case r.Method == http.MethodPatch && strings.HasSuffix(r.URL.Path, "/labels/l2"):
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(labels[1]))
+			_ = json.NewEncoder(w).Encode(labels[1])
+		case r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/labels/l2"):
+			labels = labels[:1]
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	mkFilter := func(lb string) filter.Filters {
+		return filter.Filters{{
+			Criteria: filter.Criteria{From: "a"},
+			Action:   filter.Actions{AddLabel: lb},
+		}}
+	}
+
+	// Listing the labels populates the cache.
+	_, err := api.ListLabels()
+	require.Nil(t, err)
+	require.Nil(t, api.AddFilters(mkFilter("work")))
+	require.Nil(t, api.AddFilters(mkFilter("work")))
+	assert.Equal(t, 1, listCalls)
+
+	// A label created in the meantime can be referenced right away.
+	require.Nil(t, api.AddLabels(label.Labels{{Name: "new"}}))
+	require.Nil(t, api.AddFilters(mkFilter("new")))
+	require.Nil(t, api.AddFilters(mkFilter("work")))
+	assert.Equal(t, 2, listCalls)
+	assert.Equal(t, [][]string{{"l1"}, {"l1"}, {"l2"}, {"l1"}}, filterIDs)
+
+	// Renamed and deleted labels invalidate the cache as well.
+	require.Nil(t, api.UpdateLabels(label.Labels{{ID: "l2", Name: "renamed"}}))
+	require.Nil(t, api.AddFilters(mkFilter("renamed")))
+	assert.Equal(t, 3, listCalls)
+	require.Nil(t, api.DeleteLabels([]string{"l2"}))
+	assert.NotNil(t, api.AddFilters(mkFilter("renamed")))
+	assert.Equal(t, 4, listCalls)
+}
+
+func TestProgress(t *testing.T) {
+	var (
+		done int
+		// seen is the progress reported before every request.
+		seen []int
+	)
+	api, _ := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/labels"):
+			_ = json.NewEncoder(w).Encode(gmail.ListLabelsResponse{})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/filters"):
+			seen = append(seen, done)
+			var f gmail.Filter
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&f))
+			if f.Criteria.From == "b" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(f)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	api.SetProgress(func() { done++ })
+
+	var fs filter.Filters
+	for _, from := range []string{"a", "b", "c"} {
+		fs = append(fs, filter.Filter{
+			Criteria: filter.Criteria{From: from},
+			Action:   filter.Actions{Archive: true},
+		})
+	}
+	// The progress is reported after every filter, except the failed one.
+	assert.NotNil(t, api.AddFilters(fs))
+	assert.Equal(t, []int{0, 1, 1}, seen)
+	assert.Equal(t, 2, done)
+}
@@ -19,7 +19,22 @@ import (
 // Credentials can be obtained by creating a new OAuth client ID at the Google API console
 // https://console.developers.google.com/apis/credentials.
 func NewAuthenticator(credentials io.Reader) (*Authenticator, error) {
-	cfg, err := clientFromCredentials(credentials)
+	return newAuthenticator(credentials, settingsScopes...)
+}
+
+// NewMessagesAuthenticator is like NewAuthenticator, but the authorization
+// also allows to modify the messages, as required to apply the filters to
+// the existing ones.
+//
+// This is a much broader permission than the one needed to manage the
+// settings, so it should only be requested when needed.
+func NewMessagesAuthenticator(credentials io.Reader) (*Authenticator, error) {
+	scopes := append([]string{gmail.GmailModifyScope}, settingsScopes...)
+	return newAuthenticator(credentials, scopes...)
+}
+
+func newAuthenticator(credentials io.Reader, scopes ...string) (*Authenticator, error) {
+	cfg, err := clientFromCredentials(credentials, scopes...)
 	if err != nil {
 		return nil, fmt.Errorf("creating config from credentials: %w", err)
 	}
@@ -77,15 +92,18 @@ func (a Authenticator) CacheToken(ctx context.Context, authCode string, token io
 	return json.NewEncoder(token).Encode(tok)
 }
 
-func clientFromCredentials(credentials io.Reader) (*oauth2.Config, error) {
+// settingsScopes are the scopes required to manage filters and labels.
+var settingsScopes = []string{
+	gmail.GmailSettingsBasicScope,
+	gmail.GmailLabelsScope,
+}
+
+func clientFromCredentials(credentials io.Reader, scopes ...string) (*oauth2.Config, error) {
 	credBytes, err := io.ReadAll(credentials)
 	if err != nil {
 		return nil, fmt.Errorf("reading credentials: %w", err)
 	}
-	return google.ConfigFromJSON(credBytes,
-		gmail.GmailSettingsBasicScope,
-		gmail.GmailLabelsScope,
-	)
+	return google.ConfigFromJSON(credBytes, scopes...)
 }
 
 func parseToken(token io.Reader) (*oauth2.Token, error) {
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultBackoff    = time.Second
+)
+
+// throttler limits the rate of the API calls and retries the ones failed
+// because of quota limits or transient server errors, with exponential
+// backoff.
+//
+// It's safe for concurrent use.
+type throttler struct {
+	mu sync.Mutex
+	// interval is the minimum time between two calls.
+	interval   time.Duration
+	maxRetries int
+	backoff    time.Duration
+	// next is the earliest time for the next call.
+	next time.Time
+
+	// Allow to fake time in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newThrottler() *throttler {
+	return &throttler{
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+		now:        time.Now,
+		sleep:      time.Sleep,
+	}
+}
+
+// setRate limits the calls to the given number per second. A non positive
+// rate disables the limit.
+func (t *throttler) setRate(perSecond float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if perSecond <= 0 {
+		t.interval = 0
+		return
+	}
+	t.interval = time.Duration(float64(time.Second) / perSecond)
+}
+
+// setRetries sets the maximum number of retries of a failed call and the
+// wait before the first one, doubled at every following retry. Zero
+// retries disable them.
+func (t *throttler) setRetries(maxRetries int, backoff time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxRetries = maxRetries
+	t.backoff = backoff
+}
+
+// Do executes the call, waiting for the rate limit and retrying it if
+// the quota is exceeded or the error is transient.
+func (t *throttler) Do(call func() error) error {
+	return t.do(call, isRetryable)
+}
+
+// DoNonIdempotent is like Do, but for the calls that can't be safely
+// repeated, like creating a filter. They are retried only if the quota is
+// exceeded, because the request was rejected, while after a transient
+// server error it could have been performed anyway.
+func (t *throttler) DoNonIdempotent(call func() error) error {
+	return t.do(call, isRateLimited)
+}
+
+func (t *throttler) do(call func() error, retryable func(error) bool) error {
+	t.mu.Lock()
+	maxRetries, backoff := t.maxRetries, t.backoff
+	t.mu.Unlock()
+
+	for i := 0; ; i++ {
+		t.wait()
+		err := call()
+		if err == nil || i >= maxRetries || !retryable(err) {
+			return err
+		}
+		t.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (t *throttler) wait() {
+	// The time slot is reserved before sleeping, so that concurrent calls
+	// wait for the following ones.
+	t.mu.Lock()
+	if t.interval == 0 {
+		t.mu.Unlock()
+		return
+	}
+	now := t.now()
+	wait := t.next.Sub(now)
+	if wait > 0 {
+		now = t.next
+	}
+	t.next = now.Add(t.interval)
+	t.mu.Unlock()
+
+	if wait > 0 {
+		t.sleep(wait)
+	}
+}
+
+// isRetryable returns true if the call may succeed when retried, because
+// the error was caused by the quota or by a transient server error.
+//
+// Other errors, like invalid requests or missing permissions, fail
+// immediately.
+func isRetryable(err error) bool {
+	if isRateLimited(err) {
+		return true
+	}
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	switch gerr.Code {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRateLimited returns true if the error was caused by exceeding the
+// Gmail API quota.
+func isRateLimited(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	if gerr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if gerr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, e := range gerr.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// newTestAPI returns an API backed by the given handler, sleeping on a fake
+// clock.
+func newTestAPI(t *testing.T, h http.HandlerFunc) (*GmailAPI, *[]time.Duration) {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	svc, err := gmail.NewService(context.Background(),
+		option.WithoutAuthentication(), option.WithEndpoint(ts.URL))
+	require.Nil(t, err)
+
+	api := NewFromService(svc)
+	var sleeps []time.Duration
+	now := time.Unix(0, 0)
+	api.throttle.now = func() time.Time { return now }
+	api.throttle.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+	return api, &sleeps
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	calls := 0
+	api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "l1", "name": "work"}`))
+	})
+
+	err := api.AddLabels(label.Labels{{Name: "work"}})
+	require.Nil(t, err)
+	assert.Equal(t, 3, calls)
+	// Exponential backoff.
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *sleeps)
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	calls := 0
+	api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	err := api.AddLabels(label.Labels{{Name: "work"}})
+	require.NotNil(t, err)
+	assert.Equal(t, defaultMaxRetries+1, calls)
+	assert.Len(t, *sleeps, defaultMaxRetries)
+}
+
+func TestRetryTransient(t *testing.T) {
+	codes := []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusBadGateway}
+	calls := 0
+	api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= len(codes) {
+			w.WriteHeader(codes[calls-1])
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"labels": [{"id": "l1", "name": "work", "type": "user"}]}`))
+	})
+
+	// Reads are retried as well.
+	ls, err := api.ListLabels()
+	require.Nil(t, err)
+	assert.Equal(t, label.Labels{{ID: "l1", Name: "work"}}, ls)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, *sleeps)
+}
+
+func TestNoRetryTransientCreate(t *testing.T) {
+	creates := 0
+	api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"labels": []}`))
+			return
+		}
+		creates++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	// The filter could have been created anyway.
+	err := api.AddFilters(filter.Filters{{
+		Criteria: filter.Criteria{From: "a@b.com"},
+		Action:   filter.Actions{Archive: true},
+	}})
+	require.NotNil(t, err)
+	assert.Equal(t, 1, creates)
+	assert.Empty(t, *sleeps)
+}
+
+func TestNoRetryOtherErrors(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound} {
+		calls := 0
+		api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(code)
+		})
+
+		err := api.DeleteLabels([]string{"l1"})
+		require.NotNil(t, err, code)
+		assert.Equal(t, 1, calls, code)
+		assert.Empty(t, *sleeps, code)
+	}
+}
+
+func TestSetRetries(t *testing.T) {
+	calls := 0
+	api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	api.SetRetries(2, 100*time.Millisecond)
+
+	err := api.DeleteLabels([]string{"l1"})
+	require.NotNil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, *sleeps)
+
+	// Zero disables the retries.
+	calls = 0
+	api.SetRetries(0, time.Second)
+	require.NotNil(t, api.DeleteLabels([]string{"l1"}))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRateLimit(t *testing.T) {
+	api, sleeps := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api.SetRate(4)
+
+	err := api.DeleteLabels([]string{"l1", "l2", "l3"})
+	require.Nil(t, err)
+	// The first call is immediate, the others are spaced.
+	assert.Equal(t, []time.Duration{250 * time.Millisecond, 250 * time.Millisecond}, *sleeps)
+}
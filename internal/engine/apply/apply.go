@@ -2,13 +2,15 @@ package apply
 
 import (
 	"fmt"
-	"sort"
 	"strings"
+	"sync"
 
 	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	exportapi "github.com/mbrt/gmailctl/internal/engine/export/api"
 	"github.com/mbrt/gmailctl/internal/engine/filter"
 	"github.com/mbrt/gmailctl/internal/engine/label"
 	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 // GmailConfig represents a Gmail configuration.
@@ -21,22 +23,79 @@ type GmailConfig struct {
 type ConfigParseRes struct {
 	GmailConfig
 	Rules []parser.Rule
+	// RuleIndexes maps Rules to the indexes of the config rules they come
+	// from, as a config rule can be split in multiple ones and merged.
+	RuleIndexes []int
+	// MergeConflicts reports the config rules with duplicate criteria that
+	// couldn't be merged together.
+	MergeConflicts []parser.MergeConflict
+	// DepthWarnings reports the config rules nested too deeply.
+	DepthWarnings []parser.DepthWarning
+	// CatchAll are the indexes of the config rules matching all messages,
+	// marked as catch-all, whose actions apply to every incoming message.
+	CatchAll []int
 }
 
 // FromConfig creates a GmailConfig from a parsed configuration file.
 func FromConfig(cfg v1alpha3.Config) (ConfigParseRes, error) {
+	return FromConfigWithOptions(cfg, parser.Options{})
+}
+
+// FromConfigWithOptions is like FromConfig, but parses the rules with the
+// given options.
+func FromConfigWithOptions(cfg v1alpha3.Config, opts parser.Options) (ConfigParseRes, error) {
+	return fromConfig(cfg, opts, nil)
+}
+
+// fromConfig parses the config. If not nil, origIndexes maps the config
+// rules to the indexes reported in the result.
+func fromConfig(cfg v1alpha3.Config, opts parser.Options, origIndexes []int) (ConfigParseRes, error) {
 	res := ConfigParseRes{}
 	var err error
 
-	res.Rules, err = parser.Parse(cfg)
+	// indexes maps the parsed rules to the config rules they come from.
+	var indexes []int
+	res.Rules, indexes, err = parser.ParseIndexed(cfg, opts)
 	if err != nil {
 		return res, fmt.Errorf("cannot parse config file: %w", err)
 	}
-	res.Filters, err = filter.FromRules(res.Rules)
+	origIndex := func(i int) int {
+		if origIndexes == nil {
+			return i
+		}
+		return origIndexes[i]
+	}
+	for i := range indexes {
+		indexes[i] = origIndex(indexes[i])
+	}
+	for i, r := range res.Rules {
+		// A config rule can be split in multiple ones.
+		if !parser.MatchesAll(r.Criteria) || (len(res.CatchAll) > 0 && res.CatchAll[len(res.CatchAll)-1] == indexes[i]) {
+			continue
+		}
+		res.CatchAll = append(res.CatchAll, indexes[i])
+	}
+	res.Rules, res.DepthWarnings = parser.FlattenDeep(res.Rules)
+	var merged []int
+	res.Rules, merged, res.MergeConflicts = parser.MergeDuplicatesIndexed(res.Rules)
+	for i, c := range res.MergeConflicts {
+		res.MergeConflicts[i].Rule = indexes[c.Rule]
+		res.MergeConflicts[i].Duplicate = indexes[c.Duplicate]
+	}
+	for i, w := range res.DepthWarnings {
+		res.DepthWarnings[i].Rule = indexes[w.Rule]
+	}
+	for _, i := range merged {
+		res.RuleIndexes = append(res.RuleIndexes, indexes[i])
+	}
+	res.Filters, err = filter.FromRulesIndexed(res.Rules, res.RuleIndexes)
 	if err != nil {
 		return res, fmt.Errorf("exporting to filters: %w", err)
 	}
-	res.Labels = label.FromConfig(cfg.Labels)
+	if len(cfg.Labels) > 0 {
+		// Parent labels are implicitly managed as well.
+		res.Labels = label.WithParents(label.FromConfig(cfg.Labels))
+	}
 
 	return res, nil
 }
@@ -47,24 +106,49 @@ type FetchAPI interface {
 	ListLabels() (label.Labels, error)
 }
 
-// FromConfig creates a GmailConfig from Gmail APIs.
-func FromAPI(api FetchAPI) (GmailConfig, error) {
-	l, err := api.ListLabels()
-	if err != nil {
-		return GmailConfig{}, fmt.Errorf("listing labels from Gmail: %w", err)
+// FromAPI creates a GmailConfig from Gmail APIs.
+//
+// Labels and filters are fetched concurrently, so the API has to be safe
+// for concurrent use.
+//
+// Upstream filters that can't be read, e.g. because they are not supported,
+// are skipped: the returned warning reports them, while err is only returned
+// if the settings can't be fetched at all.
+func FromAPI(api FetchAPI) (res GmailConfig, warning error, err error) {
+	var (
+		wg         sync.WaitGroup
+		l          label.Labels
+		f          filter.Filters
+		lerr, ferr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l, lerr = api.ListLabels()
+	}()
+	go func() {
+		defer wg.Done()
+		f, ferr = api.ListFilters()
+	}()
+	wg.Wait()
+
+	var errs []error
+	if lerr != nil {
+		errs = append(errs, fmt.Errorf("listing labels from Gmail: %w", lerr))
 	}
-	f, err := api.ListFilters()
-	if err != nil {
-		if len(f) == 0 {
-			return GmailConfig{}, fmt.Errorf("getting filters from Gmail: %w", err)
-		}
-		// Some upstream filters may be invalid and in most cases we just want to ignore
-		// those and carry on.
+	// Unsupported filters are only skipped, even when no filter is left.
+	if ferr != nil && !errors.Is(ferr, exportapi.ErrUnsupportedFilter) && (len(f) == 0 || lerr != nil) {
+		errs = append(errs, fmt.Errorf("getting filters from Gmail: %w", ferr))
 	}
+	if len(errs) > 0 {
+		return GmailConfig{}, nil, errors.Combine(errs...)
+	}
+	// Some upstream filters may be invalid and in most cases we just want to ignore
+	// those and carry on.
 	return GmailConfig{
 		Labels:  l,
 		Filters: f,
-	}, err
+	}, ferr, nil
 }
 
 // ConfigDiff contains the difference between local and upstream configuration,
@@ -145,6 +229,12 @@ type API interface {
 }
 
 // Apply applies the changes identified by the diff to the remote configuration.
+//
+// A failed change doesn't stop the following ones, so that as much as
+// possible is applied. The failures are reported together at the end, by
+// an error listing each of them. Labels are only removed if everything else
+// succeeded, because filters that failed to be changed could still
+// reference them.
 func Apply(d ConfigDiff, api API, allowRemoveLabels bool) error {
 	// In order to prevent not found errors, the sequence has to be:
 	//
@@ -153,28 +243,72 @@ func Apply(d ConfigDiff, api API, allowRemoveLabels bool) error {
 	// - modify labels
 	// - remove filters
 	// - remove labels
-
-	if err := addLabels(d.LabelsDiff.Added, api); err != nil {
-		return fmt.Errorf("creating labels: %w", err)
-	}
-	if err := addFilters(d.FiltersDiff.Added, api); err != nil {
-		return fmt.Errorf("creating filters: %w", err)
+	var errs []error
+	collect := func(step string, err error) {
+		for _, e := range errors.Errors(err) {
+			errs = append(errs, fmt.Errorf("%s: %w", step, e))
+		}
 	}
-	if err := updateLabels(d.LabelsDiff.Modified, api); err != nil {
-		return fmt.Errorf("updating labels: %w", err)
+
+	collect("creating labels", addLabels(d.LabelsDiff.Added, api))
+	addErr := addFilters(d.FiltersDiff.Added, api)
+	collect("creating filters", addErr)
+	collect("updating labels", updateLabels(d.LabelsDiff.Modified, api))
+
+	if len(d.FiltersDiff.Removed) > 0 {
+		if addErr == nil {
+			collect("deleting filters", removeFilters(d.FiltersDiff.Removed, api))
+		} else {
+			// The removed filters could be replaced by the ones that failed.
+			errs = append(errs, errors.New("deleting filters: skipped because creating filters failed"))
+		}
 	}
-	if err := removeFilters(d.FiltersDiff.Removed, api); err != nil {
-		return fmt.Errorf("deleting filters: %w", err)
+
+	if allowRemoveLabels && len(d.LabelsDiff.Removed) > 0 {
+		if len(errs) == 0 {
+			collect("removing labels", removeLabels(d.LabelsDiff.Removed, api))
+		} else {
+			errs = append(errs, errors.New("removing labels: skipped because of the previous errors"))
+		}
 	}
 
-	if !allowRemoveLabels {
+	return applyError(errs)
+}
+
+// applyError combines the errors of the failed changes into one, listing
+// each of them in the details.
+func applyError(errs []error) error {
+	switch len(errs) {
+	case 0:
 		return nil
+	case 1:
+		return errs[0]
 	}
-	if err := removeLabels(d.LabelsDiff.Removed, api); err != nil {
-		return fmt.Errorf("removing labels: %w", err)
+	details := make([]string, len(errs))
+	for i, err := range errs {
+		details[i] = err.Error()
 	}
+	return errors.WithDetails(ApplyError{Failed: errs}, details...)
+}
 
-	return nil
+// ApplyError reports the changes that failed to be applied, while the
+// others succeeded.
+type ApplyError struct {
+	Failed []error
+}
+
+func (e ApplyError) Error() string {
+	return fmt.Sprintf("%d changes failed, the others have been applied", len(e.Failed))
+}
+
+// Is returns true if any of the failures matches the target.
+func (e ApplyError) Is(target error) bool {
+	return errors.Is(errors.Combine(e.Failed...), target)
+}
+
+// As finds the first failure that matches the target.
+func (e ApplyError) As(target interface{}) bool {
+	return errors.As(errors.Combine(e.Failed...), target)
 }
 
 func addLabels(lbs label.Labels, api API) error {
@@ -182,9 +316,7 @@ func addLabels(lbs label.Labels, api API) error {
 		return nil
 	}
 	// If we have nested labels we should create them in the right order.
-	// As a quick hack, we could sort them by the length of the name,
-	// because a label is strictly longer than its prefixes.
-	sort.Sort(byLen(lbs))
+	label.SortByDepth(lbs)
 	return api.AddLabels(lbs)
 }
 
@@ -219,14 +351,17 @@ func removeFilters(ls filter.Filters, api API) error {
 	return api.DeleteFilters(ids)
 }
 
-func removeLabels(lbs label.Labels, api API) error {
+// labelsDeleter allows to delete labels.
+type labelsDeleter interface {
+	DeleteLabels(ids []string) error
+}
+
+func removeLabels(lbs label.Labels, api labelsDeleter) error {
 	if len(lbs) == 0 {
 		return nil
 	}
 	// If we have nested labels we should remove them in the right order.
-	// As a quick hack, we could sort them by the length of the name,
-	// because a label is strictly longer than its prefixes.
-	sort.Sort(byLen(lbs))
+	label.SortByDepth(lbs)
 
 	// Delete in reverse order
 	var ids []string
@@ -235,17 +370,3 @@ func removeLabels(lbs label.Labels, api API) error {
 	}
 	return api.DeleteLabels(ids)
 }
-
-type byLen label.Labels
-
-func (b byLen) Len() int {
-	return len(b)
-}
-
-func (b byLen) Less(i, j int) bool {
-	return len(b[i].Name) < len(b[j].Name)
-}
-
-func (b byLen) Swap(i, j int) {
-	b[i], b[j] = b[j], b[i]
-}
@@ -0,0 +1,391 @@
+package apply
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	exportapi "github.com/mbrt/gmailctl/internal/engine/export/api"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/gmail"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+type fakeAPI struct {
+	addedLabels    []string
+	addedFilters   filter.Filters
+	deletedLabels  []string
+	deletedFilters []string
+	// messages is the number of messages, by label ID.
+	messages map[string]int64
+}
+
+func (f *fakeAPI) AddLabels(lbs label.Labels) error {
+	for _, l := range lbs {
+		f.addedLabels = append(f.addedLabels, l.Name)
+	}
+	return nil
+}
+
+func (f *fakeAPI) AddFilters(fs filter.Filters) error {
+	f.addedFilters = append(f.addedFilters, fs...)
+	return nil
+}
+
+func (f *fakeAPI) DeleteLabels(ids []string) error {
+	f.deletedLabels = append(f.deletedLabels, ids...)
+	return nil
+}
+
+func (f *fakeAPI) CountLabelMessages(id string) (int64, error) {
+	return f.messages[id], nil
+}
+
+func (f *fakeAPI) DeleteFilters(ids []string) error {
+	f.deletedFilters = append(f.deletedFilters, ids...)
+	return nil
+}
+
+func (f *fakeAPI) UpdateLabels(lbs label.Labels) error { return nil }
+
+func TestNestedLabelParents(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Labels:  []v1alpha3.Label{{Name: "Work/Projects/Alpha"}},
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "alpha@work.com"},
+				Actions: v1alpha3.Actions{Labels: []string{"Work/Projects/Alpha"}},
+			},
+		},
+	}
+	local, err := FromConfig(cfg)
+	require.Nil(t, err)
+
+	d, err := Diff(local.GmailConfig, GmailConfig{})
+	require.Nil(t, err)
+	require.Nil(t, d.Validate())
+	assert.Equal(t, label.Labels{
+		{Name: "Work"},
+		{Name: "Work/Projects"},
+		{Name: "Work/Projects/Alpha"},
+	}, d.LabelsDiff.Added)
+
+	api := &fakeAPI{}
+	require.Nil(t, Apply(d, api, false))
+	// Parents have to be created first.
+	assert.Equal(t, []string{"Work", "Work/Projects", "Work/Projects/Alpha"}, api.addedLabels)
+	assert.Len(t, api.addedFilters, 1)
+}
+
+func TestFromConfigCatchAll(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			{Filter: v1alpha3.FilterNode{From: "a"}, Actions: v1alpha3.Actions{Star: true}},
+			{Filter: v1alpha3.FilterNode{In: "anywhere"}, Actions: v1alpha3.Actions{Archive: true}, CatchAll: true},
+		},
+	}
+	res, err := FromConfig(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, res.CatchAll)
+	assert.Len(t, res.Filters, 2)
+
+	// Without the marker the rule is rejected.
+	cfg.Rules[1].CatchAll = false
+	_, err = FromConfig(cfg)
+	assert.ErrorContains(t, err, "matches all messages")
+
+	// The marker is rejected on rules not matching all messages.
+	cfg.Rules[1].CatchAll = true
+	cfg.Rules[0].CatchAll = true
+	_, err = FromConfig(cfg)
+	assert.ErrorContains(t, err, `rule #0: rule marked as catch-all, but criteria "from:a" don't match all messages`)
+
+	// Rules split in multiple filters are reported once.
+	cfg.Rules[0].CatchAll = false
+	cfg.Rules[1].Actions = v1alpha3.Actions{}
+	cfg.Rules[1].ActionGroups = []v1alpha3.Actions{{Labels: []string{"l1"}}, {Labels: []string{"l2"}}}
+	res, err = FromConfig(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, res.CatchAll)
+	assert.Len(t, res.Filters, 3)
+}
+
+func TestFromConfigMergeConflicts(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			// Split into one rule per label.
+			{
+				Filter:       v1alpha3.FilterNode{From: "a"},
+				ActionGroups: []v1alpha3.Actions{{Labels: []string{"l1"}}, {Labels: []string{"l2"}}},
+			},
+			{Filter: v1alpha3.FilterNode{From: "b"}, Actions: v1alpha3.Actions{Category: gmail.CategorySocial}},
+			{Filter: v1alpha3.FilterNode{From: "b"}, Actions: v1alpha3.Actions{Category: gmail.CategoryForums}},
+		},
+	}
+	res, err := FromConfig(cfg)
+	require.Nil(t, err)
+	// The indexes refer to the config rules.
+	assert.Equal(t, []parser.MergeConflict{
+		{Rule: 1, Duplicate: 2, Reason: `conflicting values for 'category': "social" and "forums"`},
+	}, res.MergeConflicts)
+	assert.Equal(t, []int{0, 0, 1, 2}, res.RuleIndexes)
+}
+
+func TestFromConfigQueryTooLong(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			// Split into one rule per label.
+			{
+				Filter:       v1alpha3.FilterNode{From: "a"},
+				ActionGroups: []v1alpha3.Actions{{Labels: []string{"l1"}}, {Labels: []string{"l2"}}},
+			},
+			{Filter: v1alpha3.FilterNode{Subject: strings.Repeat("a", 1600)}, Actions: v1alpha3.Actions{Archive: true}},
+		},
+	}
+	_, err := FromConfig(cfg)
+	// The index refers to the config rule.
+	assert.ErrorContains(t, err, "generating rule #1: ")
+}
+
+func TestFromConfigFlattenDeep(t *testing.T) {
+	deep := v1alpha3.FilterNode{From: "f"}
+	for _, from := range []string{"e", "d", "c", "b", "a"} {
+		deep = v1alpha3.FilterNode{And: []v1alpha3.FilterNode{{From: from}, deep}}
+	}
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			// Split into one rule per label.
+			{
+				Filter:       v1alpha3.FilterNode{From: "a"},
+				ActionGroups: []v1alpha3.Actions{{Labels: []string{"l1"}}, {Labels: []string{"l2"}}},
+			},
+			{Filter: deep, Actions: v1alpha3.Actions{Archive: true}},
+		},
+	}
+	// Simplification already flattens the criteria.
+	res, err := FromConfig(cfg)
+	require.Nil(t, err)
+	assert.Empty(t, res.DepthWarnings)
+
+	res, err = FromConfigWithOptions(cfg, parser.Options{NoSimplify: true})
+	require.Nil(t, err)
+	require.Len(t, res.DepthWarnings, 1)
+	w := res.DepthWarnings[0]
+	// The index refers to the config rule.
+	assert.Equal(t, 1, w.Rule)
+	assert.Equal(t, 5, w.Depth)
+	assert.Equal(t, 1, w.Flattened)
+	require.Len(t, res.Filters, 3)
+	assert.Equal(t, "a b c d e f", res.Filters[2].Criteria.From)
+}
+
+func TestFromConfigNoSimplify(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			{
+				Filter: v1alpha3.FilterNode{
+					And: []v1alpha3.FilterNode{
+						{Or: []v1alpha3.FilterNode{{From: "a"}, {From: "b"}}},
+						{Not: &v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{{List: "x"}, {List: "y"}}}},
+					},
+				},
+				Actions: v1alpha3.Actions{Archive: true},
+			},
+		},
+	}
+	queries := func(res ConfigParseRes) []string {
+		var qs []string
+		for _, f := range res.Filters {
+			qs = append(qs, f.Criteria.ToGmailSearch())
+		}
+		return qs
+	}
+
+	res, err := FromConfig(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"from:a -{list:x list:y}", "from:b -{list:x list:y}"}, queries(res))
+
+	res, err = FromConfigWithOptions(cfg, parser.Options{NoSimplify: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"{from:a from:b} -{list:x list:y}"}, queries(res))
+}
+
+// failingFiltersAPI fails the calls about filters.
+type failingFiltersAPI struct {
+	callsAPI
+	failAdd, failDelete bool
+}
+
+func (f *failingFiltersAPI) AddFilters(fs filter.Filters) error {
+	if f.failAdd {
+		return errors.New("quota exceeded")
+	}
+	return f.callsAPI.AddFilters(fs)
+}
+
+func (f *failingFiltersAPI) DeleteFilters(ids []string) error {
+	if f.failDelete {
+		return errors.New("filter not found")
+	}
+	return f.callsAPI.DeleteFilters(ids)
+}
+
+func partialDiff() ConfigDiff {
+	return ConfigDiff{
+		FiltersDiff: filter.FiltersDiff{
+			Added: filter.Filters{
+				{Criteria: filter.Criteria{From: "a@b.com"}, Action: filter.Actions{AddLabel: "new"}},
+			},
+			Removed: filter.Filters{
+				{ID: "f1", Criteria: filter.Criteria{From: "c@d.com"}, Action: filter.Actions{Archive: true}},
+			},
+		},
+		LabelsDiff: label.LabelsDiff{
+			Added:   label.Labels{{Name: "new"}},
+			Removed: label.Labels{{ID: "l1", Name: "old"}},
+		},
+	}
+}
+
+func TestApplyPartialFailure(t *testing.T) {
+	// The last of the filters operations fails.
+	api := &failingFiltersAPI{failDelete: true}
+	err := Apply(partialDiff(), api, false)
+
+	assert.EqualError(t, err, "deleting filters: filter not found")
+	// The others succeeded anyway.
+	assert.Equal(t, []string{"add label new", "add filter " + partialDiff().FiltersDiff.Added[0].String()}, api.calls)
+}
+
+func TestApplyAddFiltersFailure(t *testing.T) {
+	api := &failingFiltersAPI{failAdd: true}
+	err := Apply(partialDiff(), api, false)
+
+	assert.EqualError(t, err, "2 changes failed, the others have been applied")
+	assert.Equal(t, `
+  - creating filters: quota exceeded
+  - deleting filters: skipped because creating filters failed`, errors.Details(err))
+	// Filters are not deleted, as they could be replaced by the failed ones.
+	assert.Equal(t, []string{"add label new"}, api.calls)
+}
+
+func TestApplyMultipleFailures(t *testing.T) {
+	api := &failingFiltersAPI{failDelete: true}
+	err := Apply(partialDiff(), api, true)
+
+	assert.EqualError(t, err, "2 changes failed, the others have been applied")
+	var aerr ApplyError
+	require.True(t, errors.As(err, &aerr))
+	assert.Len(t, aerr.Failed, 2)
+	assert.Equal(t, `
+  - deleting filters: filter not found
+  - removing labels: skipped because of the previous errors`, errors.Details(err))
+	// Labels are not removed, as the failed filters could use them.
+	assert.Equal(t, []string{"add label new", "add filter " + partialDiff().FiltersDiff.Added[0].String()}, api.calls)
+}
+
+// slowFetchAPI answers after all the expected calls have started, or after
+// a timeout, to detect whether they run concurrently.
+type slowFetchAPI struct {
+	started chan struct{}
+	calls   int
+	// overlapped counts the calls that saw all the others start.
+	overlapped int32
+
+	labels     label.Labels
+	filters    filter.Filters
+	filtersErr error
+}
+
+func (f *slowFetchAPI) wait() {
+	f.started <- struct{}{}
+	deadline := time.After(time.Second)
+	for {
+		if len(f.started) == f.calls {
+			atomic.AddInt32(&f.overlapped, 1)
+			// Give the other calls the time to notice.
+			time.Sleep(10 * time.Millisecond)
+			return
+		}
+		select {
+		case <-deadline:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func (f *slowFetchAPI) ListLabels() (label.Labels, error) {
+	f.wait()
+	return f.labels, nil
+}
+
+func (f *slowFetchAPI) ListFilters() (filter.Filters, error) {
+	f.wait()
+	return f.filters, f.filtersErr
+}
+
+func TestFromAPIConcurrent(t *testing.T) {
+	api := &slowFetchAPI{
+		started: make(chan struct{}, 2),
+		calls:   2,
+		labels:  label.Labels{{ID: "1", Name: "work"}},
+		filters: filter.Filters{
+			{ID: "a", Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{AddLabel: "work"}},
+			{ID: "b", Criteria: filter.Criteria{From: "b"}, Action: filter.Actions{Archive: true}},
+		},
+	}
+	got, warning, err := FromAPI(api)
+	require.Nil(t, err)
+	assert.Nil(t, warning)
+	assert.Equal(t, GmailConfig{Labels: api.labels, Filters: api.filters}, got)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&api.overlapped))
+}
+
+func TestFromAPIErrors(t *testing.T) {
+	fetchErr := errors.New("invalid filter")
+	api := &slowFetchAPI{
+		started:    make(chan struct{}, 2),
+		calls:      2,
+		filters:    filter.Filters{{ID: "a", Criteria: filter.Criteria{From: "a"}}},
+		filtersErr: fetchErr,
+	}
+	// Partial results are returned together with the warning.
+	got, warning, err := FromAPI(api)
+	require.Nil(t, err)
+	assert.True(t, errors.Is(warning, fetchErr))
+	assert.Equal(t, api.filters, got.Filters)
+
+	api = &slowFetchAPI{started: make(chan struct{}, 2), calls: 2, filtersErr: fetchErr}
+	_, _, err = FromAPI(api)
+	require.NotNil(t, err)
+	assert.Equal(t, "getting filters from Gmail: invalid filter", err.Error())
+}
+
+func TestFromAPIUnsupportedFilters(t *testing.T) {
+	// Unsupported filters are skipped, even when none is left.
+	skipErr := errors.WithCause(errors.New(`importing filter "a"`), exportapi.ErrUnsupportedFilter)
+	api := &slowFetchAPI{
+		started:    make(chan struct{}, 2),
+		calls:      2,
+		labels:     label.Labels{{ID: "1", Name: "work"}},
+		filtersErr: skipErr,
+	}
+	got, warning, err := FromAPI(api)
+	require.Nil(t, err)
+	assert.True(t, errors.Is(warning, exportapi.ErrUnsupportedFilter))
+	assert.Equal(t, api.labels, got.Labels)
+}
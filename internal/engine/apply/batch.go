@@ -0,0 +1,108 @@
+package apply
+
+import (
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// ProgressFunc reports that done out of total items of an operation have been
+// completed.
+type ProgressFunc func(kind OperationKind, done, total int)
+
+// NewBatchedAPI returns an API that splits every call into batches of at most
+// size items, reporting the progress after each one.
+//
+// A failed batch doesn't stop the following ones, and the progress is only
+// reported for successful batches. Apply only performs the changes still
+// missing upstream, so running it again retries the failed batches.
+func NewBatchedAPI(api API, size int, progress ProgressFunc) API {
+	return batchedAPI{api, size, progress}
+}
+
+type batchedAPI struct {
+	api      API
+	size     int
+	progress ProgressFunc
+}
+
+func (b batchedAPI) AddLabels(lbs label.Labels) error {
+	return b.batches(OperationAddLabels, len(lbs), func(i, j int) error {
+		return b.api.AddLabels(lbs[i:j])
+	})
+}
+
+func (b batchedAPI) AddFilters(fs filter.Filters) error {
+	return b.batches(OperationAddFilters, len(fs), func(i, j int) error {
+		return b.api.AddFilters(fs[i:j])
+	})
+}
+
+func (b batchedAPI) UpdateLabels(lbs label.Labels) error {
+	return b.batches(OperationUpdateLabels, len(lbs), func(i, j int) error {
+		return b.api.UpdateLabels(lbs[i:j])
+	})
+}
+
+func (b batchedAPI) DeleteFilters(ids []string) error {
+	return b.batches(OperationDeleteFilters, len(ids), func(i, j int) error {
+		return b.api.DeleteFilters(ids[i:j])
+	})
+}
+
+func (b batchedAPI) DeleteLabels(ids []string) error {
+	return b.batches(OperationDeleteLabels, len(ids), func(i, j int) error {
+		return b.api.DeleteLabels(ids[i:j])
+	})
+}
+
+// batches calls f with the bounds of every batch, in order.
+func (b batchedAPI) batches(kind OperationKind, total int, f func(i, j int) error) error {
+	size := b.size
+	if size <= 0 {
+		size = total
+	}
+	var errs []error
+	for i := 0; i < total; i += size {
+		j := i + size
+		if j > total {
+			j = total
+		}
+		if err := f(i, j); err != nil {
+			// The failed items are indexed from the start of the batch.
+			for _, e := range errors.Errors(err) {
+				if k, ok := errors.Index(e); ok {
+					e = errors.WithIndex(e, i+k)
+				}
+				errs = append(errs, e)
+			}
+			continue
+		}
+		if b.progress != nil {
+			b.progress(kind, j, total)
+		}
+	}
+	return errors.Combine(errs...)
+}
+
+// Succeeded returns the indexes of the items changed by a call on n items,
+// given the error it returned. Failures are attributed to their item by the
+// index they are annotated with (see errors.WithIndex). If any is not, no
+// item is considered successful.
+func Succeeded(n int, err error) []int {
+	failed := map[int]bool{}
+	for _, e := range errors.Errors(err) {
+		i, ok := errors.Index(e)
+		if !ok {
+			return nil
+		}
+		failed[i] = true
+	}
+	var res []int
+	for i := 0; i < n; i++ {
+		if !failed[i] {
+			res = append(res, i)
+		}
+	}
+	return res
+}
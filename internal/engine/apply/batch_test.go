@@ -0,0 +1,102 @@
+package apply
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// failingAPI fails the given call number.
+type failingAPI struct {
+	callsAPI
+	failAt int
+	n      int
+}
+
+func (f *failingAPI) DeleteFilters(ids []string) error {
+	f.n++
+	if f.n == f.failAt {
+		return fmt.Errorf("quota exceeded")
+	}
+	return f.callsAPI.DeleteFilters(ids)
+}
+
+// itemFailingAPI fails to delete the given filter IDs, reporting the index
+// of each failure.
+type itemFailingAPI struct {
+	callsAPI
+	fail map[string]bool
+}
+
+func (f *itemFailingAPI) DeleteFilters(ids []string) error {
+	var errs []error
+	for i, id := range ids {
+		if f.fail[id] {
+			errs = append(errs, errors.WithIndex(fmt.Errorf("deleting %s: not found", id), i))
+			continue
+		}
+		f.calls = append(f.calls, "delete filter "+id)
+	}
+	return errors.Combine(errs...)
+}
+
+func TestBatchedAPI(t *testing.T) {
+	type progress struct {
+		kind        OperationKind
+		done, total int
+	}
+	var got []progress
+	rec := func(kind OperationKind, done, total int) {
+		got = append(got, progress{kind, done, total})
+	}
+
+	apis := &callsAPI{}
+	api := NewBatchedAPI(apis, 2, rec)
+	require.Nil(t, api.AddLabels(label.Labels{{Name: "a"}, {Name: "b"}, {Name: "c"}}))
+	require.Nil(t, api.AddFilters(filter.Filters{}))
+
+	assert.Equal(t, []progress{
+		{OperationAddLabels, 2, 3},
+		{OperationAddLabels, 3, 3},
+	}, got)
+	assert.Len(t, apis.calls, 3)
+}
+
+func TestBatchedAPIContinuesOnError(t *testing.T) {
+	var done []int
+	fapi := &failingAPI{failAt: 2}
+	api := NewBatchedAPI(fapi, 1, func(kind OperationKind, d, total int) {
+		done = append(done, d)
+	})
+
+	err := api.DeleteFilters([]string{"f1", "f2", "f3"})
+	assert.EqualError(t, err, "quota exceeded")
+	// The failed batch is skipped.
+	assert.Equal(t, []int{1, 3}, done)
+	assert.Equal(t, []string{"delete filter f1", "delete filter f3"}, fapi.calls)
+}
+
+func TestBatchedAPIFailedItems(t *testing.T) {
+	fapi := &itemFailingAPI{fail: map[string]bool{"f2": true, "f3": true}}
+	api := NewBatchedAPI(fapi, 2, nil)
+
+	ids := []string{"f1", "f2", "f3", "f4"}
+	err := api.DeleteFilters(ids)
+	require.NotNil(t, err)
+	// The indexes refer to the whole call.
+	assert.Equal(t, []int{0, 3}, Succeeded(len(ids), err))
+}
+
+func TestSucceeded(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2}, Succeeded(3, nil))
+	err := errors.Combine(errors.WithIndex(errors.New("a"), 1), errors.WithIndex(errors.New("b"), 2))
+	assert.Equal(t, []int{0}, Succeeded(3, err))
+	// Failures of the whole call can't be attributed.
+	assert.Empty(t, Succeeded(3, errors.New("quota exceeded")))
+}
@@ -0,0 +1,86 @@
+package apply
+
+import (
+	"fmt"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// MaxMessagesBatchSize is the maximum number of messages modified by a
+// single call, as allowed by the Gmail APIs.
+const MaxMessagesBatchSize = 1000
+
+// MessagesAPI provides access to the Gmail APIs needed to apply filters to
+// the existing messages.
+type MessagesAPI interface {
+	SearchMessages(query string) ([]string, error)
+	ModifyMessages(ids []string, a filter.Actions) error
+}
+
+// ExistingMatch is a filter with the existing messages matching it.
+type ExistingMatch struct {
+	// Filter is the filter, with only the actions that can be performed
+	// on existing messages.
+	Filter filter.Filter
+	// IDs are the IDs of the matching messages.
+	IDs []string
+}
+
+// FindExisting searches the existing messages matching every filter.
+//
+// Forwarding can't be performed on existing messages, so it's dropped from
+// the actions, and the filters doing nothing else are skipped. The filters
+// matching no messages are skipped as well.
+func FindExisting(fs filter.Filters, api MessagesAPI) ([]ExistingMatch, error) {
+	var res []ExistingMatch
+	for _, f := range fs {
+		f.Action.Forward = ""
+		if f.Action.Empty() {
+			continue
+		}
+		ids, err := api.SearchMessages(f.Criteria.ToGmailSearch())
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 {
+			res = append(res, ExistingMatch{Filter: f, IDs: ids})
+		}
+	}
+	return res, nil
+}
+
+// CountMessages returns the total number of messages matched.
+func CountMessages(ms []ExistingMatch) int {
+	res := 0
+	for _, m := range ms {
+		res += len(m.IDs)
+	}
+	return res
+}
+
+// ApplyToExisting performs the actions of the filters on the matching
+// messages, in batches of at most size messages. A non positive size, or one
+// larger than MaxMessagesBatchSize, means MaxMessagesBatchSize.
+//
+// A failed batch doesn't stop the following ones. All the failures are
+// returned together.
+func ApplyToExisting(ms []ExistingMatch, api MessagesAPI, size int) error {
+	if size <= 0 || size > MaxMessagesBatchSize {
+		size = MaxMessagesBatchSize
+	}
+	var errs []error
+	for _, m := range ms {
+		for i := 0; i < len(m.IDs); i += size {
+			j := i + size
+			if j > len(m.IDs) {
+				j = len(m.IDs)
+			}
+			if err := api.ModifyMessages(m.IDs[i:j], m.Filter.Action); err != nil {
+				errs = append(errs, fmt.Errorf("applying filter %q: %w",
+					m.Filter.Criteria.ToGmailSearch(), err))
+			}
+		}
+	}
+	return errors.Combine(errs...)
+}
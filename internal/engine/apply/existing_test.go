@@ -0,0 +1,103 @@
+package apply
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+type modifyCall struct {
+	ids    []string
+	action filter.Actions
+}
+
+// fakeMessagesAPI returns the messages matching the queries and records the
+// modifications.
+type fakeMessagesAPI struct {
+	messages map[string][]string
+	modified []modifyCall
+	failIDs  map[string]bool
+}
+
+func (f *fakeMessagesAPI) SearchMessages(query string) ([]string, error) {
+	return f.messages[query], nil
+}
+
+func (f *fakeMessagesAPI) ModifyMessages(ids []string, a filter.Actions) error {
+	if f.failIDs[ids[0]] {
+		return errors.New("quota exceeded")
+	}
+	f.modified = append(f.modified, modifyCall{append([]string{}, ids...), a})
+	return nil
+}
+
+func TestApplyToExisting(t *testing.T) {
+	fs := filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "news@a.com"},
+			Action:   filter.Actions{Archive: true, AddLabel: "news"},
+		},
+		{
+			// Forwarding only, not applicable to existing messages.
+			Criteria: filter.Criteria{From: "boss@work.com"},
+			Action:   filter.Actions{Forward: "me@home.com"},
+		},
+		{
+			// Forwarding is dropped from the actions.
+			Criteria: filter.Criteria{From: "mom@home.com"},
+			Action:   filter.Actions{Star: true, Forward: "me@home.com"},
+		},
+		{
+			// No matching messages.
+			Criteria: filter.Criteria{To: "nobody"},
+			Action:   filter.Actions{Delete: true},
+		},
+	}
+	api := &fakeMessagesAPI{messages: map[string][]string{
+		"from:news@a.com":    {"m1", "m2", "m3", "m4", "m5"},
+		"from:boss@work.com": {"m6"},
+		"from:mom@home.com":  {"m7"},
+	}}
+
+	ms, err := FindExisting(fs, api)
+	require.Nil(t, err)
+	require.Len(t, ms, 2)
+	assert.Equal(t, filter.Actions{Star: true}, ms[1].Filter.Action)
+	assert.Equal(t, 6, CountMessages(ms))
+
+	require.Nil(t, ApplyToExisting(ms, api, 2))
+	news := filter.Actions{Archive: true, AddLabel: "news"}
+	assert.Equal(t, []modifyCall{
+		{[]string{"m1", "m2"}, news},
+		{[]string{"m3", "m4"}, news},
+		{[]string{"m5"}, news},
+		{[]string{"m7"}, filter.Actions{Star: true}},
+	}, api.modified)
+}
+
+func TestApplyToExistingFailures(t *testing.T) {
+	ms := []ExistingMatch{
+		{
+			Filter: filter.Filter{Criteria: filter.Criteria{From: "a"}, Action: filter.Actions{Archive: true}},
+			IDs:    []string{"m1", "m2", "m3"},
+		},
+		{
+			Filter: filter.Filter{Criteria: filter.Criteria{From: "b"}, Action: filter.Actions{Star: true}},
+			IDs:    []string{"m4"},
+		},
+	}
+	api := &fakeMessagesAPI{failIDs: map[string]bool{"m1": true}}
+
+	// A failed batch doesn't stop the others.
+	err := ApplyToExisting(ms, api, 2)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `applying filter "from:a": quota exceeded`)
+	assert.Equal(t, []modifyCall{
+		{[]string{"m3"}, filter.Actions{Archive: true}},
+		{[]string{"m4"}, filter.Actions{Star: true}},
+	}, api.modified)
+}
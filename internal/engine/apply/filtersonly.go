@@ -0,0 +1,36 @@
+package apply
+
+import (
+	"fmt"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+	"github.com/mbrt/gmailctl/internal/stringset"
+)
+
+// FiltersOnly returns the local config without the labels, so that only
+// the filters are compared and applied, while labels are left as they are.
+//
+// The labels used by the filters are expected to exist upstream already: an
+// error is returned for each one that doesn't.
+func FiltersOnly(local, upstream GmailConfig) (GmailConfig, error) {
+	existing := stringset.New()
+	for _, l := range upstream.Labels {
+		existing.Add(l.Name)
+	}
+
+	var errs []error
+	missing := stringset.New()
+	for _, f := range local.Filters {
+		name := f.Action.AddLabel
+		if name == "" || existing.Has(name) || systemLabelIDs.Has(name) || missing.Has(name) {
+			continue
+		}
+		missing.Add(name)
+		errs = append(errs, fmt.Errorf("label %q doesn't exist", name))
+	}
+	if len(errs) > 0 {
+		return local, errors.Combine(errs...)
+	}
+
+	return GmailConfig{Filters: local.Filters}, nil
+}
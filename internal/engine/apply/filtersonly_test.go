@@ -0,0 +1,75 @@
+package apply
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestFiltersOnly(t *testing.T) {
+	local := GmailConfig{
+		// The managed labels differ from upstream in every way.
+		Labels: label.Labels{
+			{Name: "work"},
+			{Name: "new", Color: &label.Color{Background: "#000000", Text: "#ffffff"}},
+		},
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "boss@work.com"},
+				Action:   filter.Actions{AddLabel: "work"},
+			},
+		},
+	}
+	upstream := GmailConfig{
+		Labels: label.Labels{
+			{ID: "1", Name: "work"},
+			{ID: "2", Name: "manual"},
+		},
+		Filters: filter.Filters{
+			{
+				ID:       "f1",
+				Criteria: filter.Criteria{From: "spam@example.com"},
+				Action:   filter.Actions{Delete: true},
+			},
+		},
+	}
+
+	filtersOnly, err := FiltersOnly(local, upstream)
+	require.Nil(t, err)
+	assert.Nil(t, filtersOnly.Labels)
+	assert.Equal(t, local.Filters, filtersOnly.Filters)
+
+	d, err := Diff(filtersOnly, upstream)
+	require.Nil(t, err)
+	assert.True(t, d.LabelsDiff.Empty())
+
+	api := &fakeAPI{}
+	require.Nil(t, Apply(d, api, true))
+	assert.Empty(t, api.addedLabels)
+	assert.Empty(t, api.deletedLabels)
+	assert.Equal(t, local.Filters, api.addedFilters)
+}
+
+func TestFiltersOnlyMissingLabel(t *testing.T) {
+	local := GmailConfig{
+		Labels: label.Labels{{Name: "work"}},
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "boss@work.com"},
+				Action:   filter.Actions{AddLabel: "work"},
+			},
+			{
+				Criteria: filter.Criteria{From: "hr@work.com"},
+				Action:   filter.Actions{AddLabel: "work"},
+			},
+		},
+	}
+	_, err := FiltersOnly(local, GmailConfig{})
+	require.NotNil(t, err)
+	// Reported once.
+	assert.Equal(t, `label "work" doesn't exist`, err.Error())
+}
@@ -0,0 +1,56 @@
+package apply
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// ForwardingAPI provides access to the forwarding settings of Gmail.
+type ForwardingAPI interface {
+	// ListForwardingAddresses returns the verified forwarding addresses.
+	ListForwardingAddresses() ([]string, error)
+}
+
+// CheckForwarding returns an error if a filter to be added forwards emails to
+// an address that is not verified in the account.
+//
+// Gmail rejects such filters, so checking them before applying the diff
+// avoids failing halfway through. The addresses are only fetched when some
+// filter actually forwards.
+func CheckForwarding(d ConfigDiff, api ForwardingAPI) error {
+	var targets []string
+	for _, f := range d.FiltersDiff.Added {
+		if f.Action.Forward != "" {
+			targets = append(targets, f.Action.Forward)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	verified, err := api.ListForwardingAddresses()
+	if err != nil {
+		return err
+	}
+	isVerified := map[string]bool{}
+	for _, a := range verified {
+		isVerified[strings.ToLower(a)] = true
+	}
+
+	for _, t := range targets {
+		if isVerified[strings.ToLower(t)] {
+			continue
+		}
+		available := "The account has no verified forwarding addresses."
+		if len(verified) > 0 {
+			available = fmt.Sprintf("Verified forwarding addresses: %s.", strings.Join(verified, ", "))
+		}
+		return errors.WithDetails(
+			fmt.Errorf("forwarding to %q: not a verified forwarding address", t),
+			available+"\nAddresses can be added and verified in the Gmail settings, under Forwarding and POP/IMAP.",
+		)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package apply
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+type forwardingAPI struct {
+	addresses []string
+	calls     int
+}
+
+func (f *forwardingAPI) ListForwardingAddresses() ([]string, error) {
+	f.calls++
+	return f.addresses, nil
+}
+
+func forwardDiff(addresses ...string) ConfigDiff {
+	var fs filter.Filters
+	for _, a := range addresses {
+		fs = append(fs, filter.Filter{
+			Criteria: filter.Criteria{From: "someone@example.com"},
+			Action:   filter.Actions{Forward: a},
+		})
+	}
+	return ConfigDiff{FiltersDiff: filter.FiltersDiff{Added: fs}}
+}
+
+func TestCheckForwarding(t *testing.T) {
+	api := &forwardingAPI{addresses: []string{"me@work.com", "other@example.com"}}
+
+	assert.Nil(t, CheckForwarding(forwardDiff("Me@Work.com", "other@example.com"), api))
+
+	err := CheckForwarding(forwardDiff("me@work.com", "evil@example.com"), api)
+	assert.EqualError(t, err, `forwarding to "evil@example.com": not a verified forwarding address`)
+	assert.Contains(t, errors.Details(err), "Verified forwarding addresses: me@work.com, other@example.com.")
+}
+
+func TestCheckForwardingNoAddresses(t *testing.T) {
+	err := CheckForwarding(forwardDiff("me@work.com"), &forwardingAPI{})
+	assert.NotNil(t, err)
+	assert.Contains(t, errors.Details(err), "The account has no verified forwarding addresses.")
+}
+
+func TestCheckForwardingNotNeeded(t *testing.T) {
+	api := &forwardingAPI{}
+	diff := ConfigDiff{FiltersDiff: filter.FiltersDiff{
+		Added: filter.Filters{{
+			Criteria: filter.Criteria{From: "someone@example.com"},
+			Action:   filter.Actions{Archive: true},
+		}},
+		// Upstream filters have been accepted already.
+		Removed: forwardDiff("old@example.com").FiltersDiff.Added,
+	}}
+	assert.Nil(t, CheckForwarding(diff, api))
+	assert.Equal(t, 0, api.calls)
+}
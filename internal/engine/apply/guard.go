@@ -0,0 +1,107 @@
+package apply
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+// GuardSkip reports a rule skipped because its guard is not satisfied.
+type GuardSkip struct {
+	// Rule is the index of the rule in the config.
+	Rule int
+	// Reason describes the unsatisfied guard.
+	Reason string
+}
+
+func (s GuardSkip) String() string {
+	return fmt.Sprintf("rule #%d skipped, because %s", s.Rule, s.Reason)
+}
+
+// HasGuards returns true if any rule of the config has a guard.
+func HasGuards(cfg v1alpha3.Config) bool {
+	for _, r := range cfg.Rules {
+		if r.Guard != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// FromConfigWithState is like FromConfig, but the rules whose guard is not
+// satisfied by the upstream settings are skipped, and reported. The rules
+// are parsed with the given options.
+//
+// Guards are checked only against the upstream labels not managed by the
+// config, as the managed ones are created or deleted by the config itself.
+// All the reported rule indexes refer to the given config.
+func FromConfigWithState(cfg v1alpha3.Config, upstream GmailConfig, opts parser.Options) (ConfigParseRes, []GuardSkip, error) {
+	var (
+		rules   []v1alpha3.Rule
+		kept    []int
+		skipped []GuardSkip
+	)
+	labels := unmanagedLabels(cfg, upstream.Labels)
+	for i, r := range cfg.Rules {
+		if reason, ok := checkGuard(r.Guard, labels); !ok {
+			skipped = append(skipped, GuardSkip{Rule: i, Reason: reason})
+			continue
+		}
+		rules = append(rules, r)
+		kept = append(kept, i)
+	}
+	if len(skipped) > 0 {
+		// The errors of the skipped rules are reported as well, and with
+		// their index in the config.
+		if _, _, err := parser.ParseIndexed(cfg, opts); err != nil {
+			return ConfigParseRes{}, nil, fmt.Errorf("cannot parse config file: %w", err)
+		}
+	}
+	if rules == nil {
+		rules = []v1alpha3.Rule{}
+	}
+	cfg.Rules = rules
+	res, err := fromConfig(cfg, opts, kept)
+	return res, skipped, err
+}
+
+// unmanagedLabels returns the upstream labels not managed by the config.
+func unmanagedLabels(cfg v1alpha3.Config, upstream label.Labels) label.Labels {
+	if len(cfg.Labels) == 0 {
+		return upstream
+	}
+	managed := label.WithParents(label.FromConfig(cfg.Labels))
+	var res label.Labels
+	for _, l := range upstream {
+		if !hasLabel(managed, l.Name) {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// checkGuard returns whether the guard is satisfied, or the reason why not.
+func checkGuard(g *v1alpha3.RuleGuard, labels label.Labels) (string, bool) {
+	if g == nil {
+		return "", true
+	}
+	if g.LabelExists != "" && !hasLabel(labels, g.LabelExists) {
+		return fmt.Sprintf("label %q doesn't exist", g.LabelExists), false
+	}
+	if g.LabelMissing != "" && hasLabel(labels, g.LabelMissing) {
+		return fmt.Sprintf("label %q already exists", g.LabelMissing), false
+	}
+	return "", true
+}
+
+func hasLabel(labels label.Labels, name string) bool {
+	for _, l := range labels {
+		if strings.EqualFold(l.Name, name) {
+			return true
+		}
+	}
+	return false
+}
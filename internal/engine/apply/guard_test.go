@@ -0,0 +1,142 @@
+package apply
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/gmail"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+func guardedConfig() v1alpha3.Config {
+	return v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "boss@work.com"},
+				Actions: v1alpha3.Actions{Star: true},
+			},
+			{
+				Filter:  v1alpha3.FilterNode{From: "ci@work.com"},
+				Actions: v1alpha3.Actions{Archive: true},
+				Guard:   &v1alpha3.RuleGuard{LabelMissing: "CI"},
+			},
+			{
+				Filter:  v1alpha3.FilterNode{From: "alerts@work.com"},
+				Actions: v1alpha3.Actions{MarkRead: true},
+				Guard:   &v1alpha3.RuleGuard{LabelExists: "alerts"},
+			},
+		},
+	}
+}
+
+func TestFromConfigWithState(t *testing.T) {
+	froms := func(fs filter.Filters) []string {
+		var res []string
+		for _, f := range fs {
+			res = append(res, f.Criteria.From)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		labels  label.Labels
+		want    []string
+		skipped []GuardSkip
+	}{
+		{
+			name: "no labels",
+			want: []string{"boss@work.com", "ci@work.com"},
+			skipped: []GuardSkip{
+				{Rule: 2, Reason: `label "alerts" doesn't exist`},
+			},
+		},
+		{
+			name:   "all labels",
+			labels: label.Labels{{ID: "1", Name: "ci"}, {ID: "2", Name: "Alerts"}},
+			want:   []string{"boss@work.com", "alerts@work.com"},
+			skipped: []GuardSkip{
+				{Rule: 1, Reason: `label "CI" already exists`},
+			},
+		},
+		{
+			name:   "all satisfied",
+			labels: label.Labels{{ID: "2", Name: "alerts"}},
+			want:   []string{"boss@work.com", "ci@work.com", "alerts@work.com"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, skipped, err := FromConfigWithState(guardedConfig(), GmailConfig{Labels: tc.labels}, parser.Options{})
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, froms(res.Filters))
+			assert.Equal(t, tc.skipped, skipped)
+		})
+	}
+}
+
+func TestFromConfigWithStateManagedLabels(t *testing.T) {
+	cfg := guardedConfig()
+	cfg.Labels = []v1alpha3.Label{{Name: "ci"}, {Name: "alerts/prod"}}
+	// The labels are created by the config itself: the guards don't
+	// depend on them, otherwise the rules would change at every apply.
+	upstream := GmailConfig{Labels: label.Labels{
+		{ID: "1", Name: "CI"},
+		{ID: "2", Name: "alerts"},
+		{ID: "3", Name: "alerts/prod"},
+	}}
+	res, skipped, err := FromConfigWithState(cfg, upstream, parser.Options{})
+	require.Nil(t, err)
+	assert.Len(t, res.Filters, 2)
+	assert.Equal(t, []GuardSkip{
+		{Rule: 2, Reason: `label "alerts" doesn't exist`},
+	}, skipped)
+}
+
+func TestFromConfigWithStateIndexes(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "a"},
+				Actions: v1alpha3.Actions{Star: true},
+				Guard:   &v1alpha3.RuleGuard{LabelExists: "missing"},
+			},
+			{Filter: v1alpha3.FilterNode{From: "b"}, Actions: v1alpha3.Actions{Category: gmail.CategorySocial}},
+			{Filter: v1alpha3.FilterNode{From: "b"}, Actions: v1alpha3.Actions{Category: gmail.CategoryForums}},
+			{Filter: v1alpha3.FilterNode{In: "anywhere"}, Actions: v1alpha3.Actions{Archive: true}, CatchAll: true},
+		},
+	}
+	res, skipped, err := FromConfigWithState(cfg, GmailConfig{}, parser.Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []GuardSkip{{Rule: 0, Reason: `label "missing" doesn't exist`}}, skipped)
+	// The indexes refer to the config rules, including the skipped ones.
+	assert.Equal(t, []parser.MergeConflict{
+		{Rule: 1, Duplicate: 2, Reason: `conflicting values for 'category': "social" and "forums"`},
+	}, res.MergeConflicts)
+	assert.Equal(t, []int{3}, res.CatchAll)
+
+	cfg.Rules[2].Filter = v1alpha3.FilterNode{}
+	_, _, err = FromConfigWithState(cfg, GmailConfig{}, parser.Options{})
+	assert.ErrorContains(t, err, "rule #2")
+}
+
+func TestFromConfigGuardsIgnored(t *testing.T) {
+	// Without the state of the account, all the rules are kept.
+	res, err := FromConfig(guardedConfig())
+	require.Nil(t, err)
+	assert.Len(t, res.Filters, 3)
+	assert.True(t, HasGuards(guardedConfig()))
+	assert.False(t, HasGuards(v1alpha3.Config{}))
+}
+
+func TestGuardSkipString(t *testing.T) {
+	s := GuardSkip{Rule: 1, Reason: `label "CI" already exists`}
+	assert.Equal(t, `rule #1 skipped, because label "CI" already exists`, s.String())
+}
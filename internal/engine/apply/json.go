@@ -0,0 +1,146 @@
+package apply
+
+import (
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// JSONDiff is the machine-readable representation of a ConfigDiff.
+//
+// The schema is meant to be consumed by external tools, so fields should
+// only be added, never renamed or removed.
+type JSONDiff struct {
+	// Added contains the filters that are going to be created.
+	Added []JSONFilter `json:"added"`
+	// Removed contains the filters that are going to be deleted.
+	Removed []JSONFilter `json:"removed"`
+	// Modified contains pairs of removed and added filters with the same
+	// criteria, where only the actions are going to be changed.
+	Modified []JSONModifiedFilter `json:"modified"`
+	// Labels is only present when label changes are managed and detected.
+	Labels *JSONLabelsDiff `json:"labels,omitempty"`
+	// Summary counts all the changes in the diff, even the ones not listed
+	// because of the selected side.
+	Summary DiffSummary `json:"summary"`
+}
+
+// JSONFilter is a Gmail filter, identified by its generated query.
+type JSONFilter struct {
+	// ID is the Gmail ID, only present for upstream filters.
+	ID string `json:"id,omitempty"`
+	// RuleName is the name of the config rule, only present for local
+	// filters generated by named rules.
+	RuleName string      `json:"ruleName,omitempty"`
+	Query    string      `json:"query"`
+	Actions  JSONActions `json:"actions"`
+}
+
+// JSONModifiedFilter is a filter whose actions are going to change.
+type JSONModifiedFilter struct {
+	Query string     `json:"query"`
+	Old   JSONFilter `json:"old"`
+	New   JSONFilter `json:"new"`
+}
+
+// JSONActions are the actions applied by a filter.
+type JSONActions struct {
+	AddLabel         string `json:"addLabel,omitempty"`
+	Category         string `json:"category,omitempty"`
+	Archive          bool   `json:"archive,omitempty"`
+	Delete           bool   `json:"delete,omitempty"`
+	MarkImportant    bool   `json:"markImportant,omitempty"`
+	MarkNotImportant bool   `json:"markNotImportant,omitempty"`
+	MarkRead         bool   `json:"markRead,omitempty"`
+	MarkNotSpam      bool   `json:"markNotSpam,omitempty"`
+	Star             bool   `json:"star,omitempty"`
+	Forward          string `json:"forward,omitempty"`
+}
+
+// JSONLabelsDiff contains the names of the labels changed by a diff.
+type JSONLabelsDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// NewJSONDiff converts the diff into its machine-readable representation,
+// listing only the changes of the given side.
+func NewJSONDiff(full ConfigDiff, side DiffSide) JSONDiff {
+	res := JSONDiff{
+		Added:    []JSONFilter{},
+		Removed:  []JSONFilter{},
+		Modified: []JSONModifiedFilter{},
+		Summary:  full.Summary(),
+	}
+	d := full.Only(side)
+
+	// Filters cannot be updated in Gmail, so a modified filter is just a
+	// removed and an added one with the same criteria.
+	added := map[string]int{}
+	for i, f := range d.FiltersDiff.Added {
+		added[f.Criteria.ToGmailSearch()] = i
+	}
+	paired := map[int]bool{}
+	for _, f := range d.FiltersDiff.Removed {
+		q := f.Criteria.ToGmailSearch()
+		if i, ok := added[q]; ok && !paired[i] {
+			paired[i] = true
+			res.Modified = append(res.Modified, JSONModifiedFilter{
+				Query: q,
+				Old:   newJSONFilter(f),
+				New:   newJSONFilter(d.FiltersDiff.Added[i]),
+			})
+			continue
+		}
+		res.Removed = append(res.Removed, newJSONFilter(f))
+	}
+	for i, f := range d.FiltersDiff.Added {
+		if !paired[i] {
+			res.Added = append(res.Added, newJSONFilter(f))
+		}
+	}
+
+	if !d.LabelsDiff.Empty() {
+		res.Labels = newJSONLabelsDiff(d.LabelsDiff)
+	}
+
+	return res
+}
+
+func newJSONFilter(f filter.Filter) JSONFilter {
+	return JSONFilter{
+		ID:       f.ID,
+		RuleName: f.RuleName,
+		Query:    f.Criteria.ToGmailSearch(),
+		Actions: JSONActions{
+			AddLabel:         f.Action.AddLabel,
+			Category:         string(f.Action.Category),
+			Archive:          f.Action.Archive,
+			Delete:           f.Action.Delete,
+			MarkImportant:    f.Action.MarkImportant,
+			MarkNotImportant: f.Action.MarkNotImportant,
+			MarkRead:         f.Action.MarkRead,
+			MarkNotSpam:      f.Action.MarkNotSpam,
+			Star:             f.Action.Star,
+			Forward:          f.Action.Forward,
+		},
+	}
+}
+
+func newJSONLabelsDiff(d label.LabelsDiff) *JSONLabelsDiff {
+	res := &JSONLabelsDiff{
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []string{},
+	}
+	for _, l := range d.Added {
+		res.Added = append(res.Added, l.Name)
+	}
+	for _, l := range d.Removed {
+		res.Removed = append(res.Removed, l.Name)
+	}
+	for _, m := range d.Modified {
+		res.Modified = append(res.Modified, m.New.Name)
+	}
+	return res
+}
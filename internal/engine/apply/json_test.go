@@ -0,0 +1,108 @@
+package apply
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestJSONDiffEmpty(t *testing.T) {
+	b, err := json.Marshal(NewJSONDiff(ConfigDiff{}, BothSides))
+	require.Nil(t, err)
+	assert.Equal(t, `{"added":[],"removed":[],"modified":[],`+
+		`"summary":{"filtersAdded":0,"filtersRemoved":0,"labelsAdded":0,"labelsRemoved":0,"labelsModified":0}}`,
+		string(b))
+}
+
+func testConfigDiff() ConfigDiff {
+	return ConfigDiff{
+		FiltersDiff: filter.FiltersDiff{
+			Added: filter.Filters{
+				{
+					Criteria: filter.Criteria{From: "a@b.com"},
+					Action:   filter.Actions{Archive: true, MarkRead: true},
+				},
+				{
+					Criteria: filter.Criteria{To: "me@b.com", Query: "list:foo"},
+					Action:   filter.Actions{AddLabel: "foo"},
+				},
+			},
+			Removed: filter.Filters{
+				{
+					ID:       "abc",
+					Criteria: filter.Criteria{From: "a@b.com"},
+					Action:   filter.Actions{Archive: true},
+				},
+				{
+					ID:       "def",
+					Criteria: filter.Criteria{Subject: "spam"},
+					Action:   filter.Actions{Delete: true},
+				},
+			},
+		},
+		LabelsDiff: label.LabelsDiff{
+			Added: label.Labels{{Name: "foo"}},
+		},
+	}
+}
+
+func TestJSONDiff(t *testing.T) {
+	expected := JSONDiff{
+		Added: []JSONFilter{
+			{
+				Query:   "to:me@b.com list:foo",
+				Actions: JSONActions{AddLabel: "foo"},
+			},
+		},
+		Removed: []JSONFilter{
+			{
+				ID:      "def",
+				Query:   "subject:spam",
+				Actions: JSONActions{Delete: true},
+			},
+		},
+		Modified: []JSONModifiedFilter{
+			{
+				Query: "from:a@b.com",
+				Old: JSONFilter{
+					ID:      "abc",
+					Query:   "from:a@b.com",
+					Actions: JSONActions{Archive: true},
+				},
+				New: JSONFilter{
+					Query:   "from:a@b.com",
+					Actions: JSONActions{Archive: true, MarkRead: true},
+				},
+			},
+		},
+		Labels: &JSONLabelsDiff{
+			Added:    []string{"foo"},
+			Removed:  []string{},
+			Modified: []string{},
+		},
+		Summary: DiffSummary{
+			FiltersAdded:   2,
+			FiltersRemoved: 2,
+			LabelsAdded:    1,
+		},
+	}
+	assert.Equal(t, expected, NewJSONDiff(testConfigDiff(), BothSides))
+}
+
+func TestJSONDiffOnlyAdded(t *testing.T) {
+	got := NewJSONDiff(testConfigDiff(), AddedOnly)
+
+	// Without removed filters, modified filters are just added.
+	assert.Empty(t, got.Removed)
+	assert.Empty(t, got.Modified)
+	require.Len(t, got.Added, 2)
+	assert.Equal(t, "from:a@b.com", got.Added[0].Query)
+	assert.Equal(t, "to:me@b.com list:foo", got.Added[1].Query)
+	// The summary still covers the full diff.
+	assert.Equal(t, 2, got.Summary.FiltersRemoved)
+}
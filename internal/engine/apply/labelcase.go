@@ -0,0 +1,133 @@
+package apply
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+const labelCaseHelp = "Gmail would create a new label instead of using the existing one.\n" +
+	"Rename the label in the config to match the existing one, or allow\n" +
+	"reusing it by disabling the strict label case check.\n"
+
+// LabelCaseMatch is a local label that differs only by case from an
+// upstream one.
+type LabelCaseMatch struct {
+	Local    string
+	Upstream string
+}
+
+func (m LabelCaseMatch) String() string {
+	return fmt.Sprintf("label %q differs only by case from the existing label %q", m.Local, m.Upstream)
+}
+
+// MatchLabelCase looks for the labels of the local config, including the
+// ones used by filters, that differ only by case from an upstream label.
+//
+// Gmail label names are case sensitive, so applying the config as is would
+// create e.g. 'work' next to an existing 'Work'. If strict is false, the
+// returned config reuses the upstream labels instead, by renaming them in the
+// local labels and filters. Otherwise an error is returned.
+func MatchLabelCase(local, upstream GmailConfig, strict bool) (GmailConfig, []LabelCaseMatch, error) {
+	upNames := map[string]bool{}
+	upByFold := map[string][]string{}
+	for _, l := range upstream.Labels {
+		upNames[l.Name] = true
+		folded := strings.ToLower(l.Name)
+		upByFold[folded] = append(upByFold[folded], l.Name)
+	}
+
+	localNames := map[string]bool{}
+	for _, l := range local.Labels {
+		localNames[l.Name] = true
+	}
+	for _, f := range local.Filters {
+		if f.Action.AddLabel != "" {
+			localNames[f.Action.AddLabel] = true
+		}
+	}
+	// Parents come before their children, so that a child can follow the
+	// renaming of its parent.
+	names := make([]string, 0, len(localNames))
+	for n := range localNames {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var matches []LabelCaseMatch
+	var errs []error
+	renames := map[string]string{}
+
+	for _, name := range names {
+		renamed := name
+		if i := strings.LastIndex(name, "/"); i > 0 {
+			if parent, ok := renames[name[:i]]; ok {
+				renamed = parent + name[i:]
+			}
+		}
+		if !upNames[renamed] {
+			candidates := upByFold[strings.ToLower(renamed)]
+			switch {
+			case len(candidates) > 1:
+				errs = append(errs, fmt.Errorf("label %q matches more than one existing label: %s",
+					name, strings.Join(candidates, ", ")))
+				continue
+			case len(candidates) == 1:
+				m := LabelCaseMatch{Local: name, Upstream: candidates[0]}
+				if strict {
+					errs = append(errs, errors.New(m.String()))
+					continue
+				}
+				if localNames[m.Upstream] {
+					errs = append(errs, fmt.Errorf("labels %q and %q differ only by case", m.Upstream, name))
+					continue
+				}
+				matches = append(matches, m)
+				renamed = m.Upstream
+			}
+		}
+		if renamed != name {
+			renames[name] = renamed
+		}
+	}
+
+	if len(errs) > 0 {
+		err := errors.Combine(errs...)
+		if strict {
+			err = errors.WithDetails(err, labelCaseHelp)
+		}
+		return local, nil, err
+	}
+	if len(renames) == 0 {
+		return local, nil, nil
+	}
+	return renameLabels(local, renames), matches, nil
+}
+
+// renameLabels returns a copy of the config, with the given labels renamed.
+func renameLabels(cfg GmailConfig, renames map[string]string) GmailConfig {
+	res := GmailConfig{
+		Labels:  make(label.Labels, len(cfg.Labels)),
+		Filters: make(filter.Filters, len(cfg.Filters)),
+	}
+	for i, l := range cfg.Labels {
+		if n, ok := renames[l.Name]; ok {
+			l.Name = n
+		}
+		res.Labels[i] = l
+	}
+	for i, f := range cfg.Filters {
+		if n, ok := renames[f.Action.AddLabel]; ok {
+			f.Action.AddLabel = n
+		}
+		res.Filters[i] = f
+	}
+	if cfg.Labels == nil {
+		res.Labels = nil
+	}
+	return res
+}
@@ -0,0 +1,98 @@
+package apply
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func labelCaseConfigs() (local, upstream GmailConfig) {
+	local = GmailConfig{
+		Labels: label.Labels{
+			{Name: "news"},
+			{Name: "work"},
+			{Name: "work/projects"},
+		},
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "boss@work.com"},
+				Action:   filter.Actions{AddLabel: "work"},
+			},
+			{
+				Criteria: filter.Criteria{From: "alpha@work.com"},
+				Action:   filter.Actions{AddLabel: "work/projects"},
+			},
+		},
+	}
+	upstream = GmailConfig{
+		Labels: label.Labels{
+			{ID: "1", Name: "news"},
+			{ID: "2", Name: "Work"},
+		},
+	}
+	return local, upstream
+}
+
+func TestMatchLabelCaseReuse(t *testing.T) {
+	local, upstream := labelCaseConfigs()
+	got, matches, err := MatchLabelCase(local, upstream, false)
+	require.Nil(t, err)
+
+	assert.Equal(t, []LabelCaseMatch{{Local: "work", Upstream: "Work"}}, matches)
+	assert.Equal(t, label.Labels{
+		{Name: "news"},
+		{Name: "Work"},
+		// Children follow their parent.
+		{Name: "Work/projects"},
+	}, got.Labels)
+	assert.Equal(t, "Work", got.Filters[0].Action.AddLabel)
+	assert.Equal(t, "Work/projects", got.Filters[1].Action.AddLabel)
+	// The original config is untouched.
+	assert.Equal(t, "work", local.Labels[1].Name)
+	assert.Equal(t, "work", local.Filters[0].Action.AddLabel)
+
+	// The existing label is reused, instead of creating a new one.
+	diff, err := Diff(got, upstream)
+	require.Nil(t, err)
+	assert.Equal(t, label.Labels{{Name: "Work/projects"}}, diff.LabelsDiff.Added)
+	assert.Empty(t, diff.LabelsDiff.Removed)
+}
+
+func TestMatchLabelCaseStrict(t *testing.T) {
+	local, upstream := labelCaseConfigs()
+	_, matches, err := MatchLabelCase(local, upstream, true)
+	require.NotNil(t, err)
+	assert.Nil(t, matches)
+	assert.Equal(t, `label "work" differs only by case from the existing label "Work"`, err.Error())
+	assert.Contains(t, errors.Details(err), "Rename the label")
+}
+
+func TestMatchLabelCaseNoMatch(t *testing.T) {
+	local, _ := labelCaseConfigs()
+	upstream := GmailConfig{Labels: label.Labels{{ID: "1", Name: "work"}}}
+	got, matches, err := MatchLabelCase(local, upstream, true)
+	require.Nil(t, err)
+	assert.Nil(t, matches)
+	assert.Equal(t, local, got)
+}
+
+func TestMatchLabelCaseConflicts(t *testing.T) {
+	// Both spellings are in the config.
+	local := GmailConfig{Labels: label.Labels{{Name: "Work"}, {Name: "work"}}}
+	upstream := GmailConfig{Labels: label.Labels{{ID: "1", Name: "Work"}}}
+	_, _, err := MatchLabelCase(local, upstream, false)
+	require.NotNil(t, err)
+	assert.Equal(t, `labels "Work" and "work" differ only by case`, err.Error())
+
+	// More than one upstream spelling.
+	local = GmailConfig{Labels: label.Labels{{Name: "WORK"}}}
+	upstream = GmailConfig{Labels: label.Labels{{ID: "1", Name: "Work"}, {ID: "2", Name: "work"}}}
+	_, _, err = MatchLabelCase(local, upstream, false)
+	require.NotNil(t, err)
+	assert.Equal(t, `label "WORK" matches more than one existing label: Work, work`, err.Error())
+}
@@ -0,0 +1,42 @@
+package apply
+
+import (
+	"fmt"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// DefaultMaxFilters is the maximum number of filters Gmail allows in an
+// account, at the time of writing.
+const DefaultMaxFilters = 1000
+
+// CheckFilterLimit returns an error if applying the diff would exceed the
+// given maximum number of filters, considering the existing ones in the
+// account, including those not managed by the config.
+//
+// Filters are created before the old ones are deleted, so the limit has to
+// hold while the changes are applied, not just at the end. Gmail rejects the
+// filters exceeding it, which would fail halfway through. A zero max uses
+// DefaultMaxFilters, while a negative one disables the check.
+func CheckFilterLimit(d ConfigDiff, existing, max int) error {
+	if max < 0 {
+		return nil
+	}
+	if max == 0 {
+		max = DefaultMaxFilters
+	}
+	added, removed := len(d.FiltersDiff.Added), len(d.FiltersDiff.Removed)
+	if final := existing + added - removed; final > max {
+		return errors.WithDetails(
+			fmt.Errorf("the config requires %d filters, more than the %d allowed by Gmail", final, max),
+			"Try to reduce the number of filters, e.g. by merging the rules with the same\n"+
+				"actions. If the limit of Gmail has changed, it can be configured.\n")
+	}
+	if peak := existing + added; added > 0 && peak > max {
+		return errors.WithDetails(
+			fmt.Errorf("applying the changes requires up to %d filters at the same time, more than the %d allowed by Gmail", peak, max),
+			"New filters are created before the old ones are deleted. Apply the\n"+
+				"changes in smaller steps, e.g. by removing some of the rules first.\n")
+	}
+	return nil
+}
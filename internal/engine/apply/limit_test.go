@@ -0,0 +1,43 @@
+package apply
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+)
+
+func limitDiff(added, removed int) ConfigDiff {
+	mk := func(n int) filter.Filters {
+		var res filter.Filters
+		for i := 0; i < n; i++ {
+			res = append(res, filter.Filter{
+				Criteria: filter.Criteria{From: fmt.Sprintf("a%d@example.com", i)},
+				Action:   filter.Actions{Archive: true},
+			})
+		}
+		return res
+	}
+	return ConfigDiff{FiltersDiff: filter.FiltersDiff{Added: mk(added), Removed: mk(removed)}}
+}
+
+func TestCheckFilterLimit(t *testing.T) {
+	assert.Nil(t, CheckFilterLimit(limitDiff(2, 0), 8, 10))
+	assert.Nil(t, CheckFilterLimit(limitDiff(0, 5), 12, 10))
+	assert.Nil(t, CheckFilterLimit(limitDiff(10, 0), 990, 0))
+
+	err := CheckFilterLimit(limitDiff(3, 1), 9, 10)
+	assert.EqualError(t, err, "the config requires 11 filters, more than the 10 allowed by Gmail")
+
+	err = CheckFilterLimit(limitDiff(11, 0), 990, 0)
+	assert.EqualError(t, err, "the config requires 1001 filters, more than the 1000 allowed by Gmail")
+
+	// The new filters don't fit before the old ones are deleted.
+	err = CheckFilterLimit(limitDiff(3, 3), 9, 10)
+	assert.EqualError(t, err, "applying the changes requires up to 12 filters at the same time, more than the 10 allowed by Gmail")
+
+	// Disabled.
+	assert.Nil(t, CheckFilterLimit(limitDiff(3000, 0), 0, -1))
+}
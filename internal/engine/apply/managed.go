@@ -0,0 +1,30 @@
+package apply
+
+import (
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+)
+
+// ManagedFilters returns the upstream config with only the filters for which
+// managed returns true, so that the other ones are ignored by the diff and
+// never deleted.
+//
+// Unmanaged filters that are also in the local config are kept, as they are
+// already in place and don't need to be added again.
+func ManagedFilters(local, upstream GmailConfig, managed func(filter.Filter) bool) GmailConfig {
+	type contents struct {
+		criteria filter.Criteria
+		action   filter.Actions
+	}
+	inLocal := map[contents]bool{}
+	for _, f := range local.Filters {
+		inLocal[contents{f.Criteria, f.Action}] = true
+	}
+
+	var res filter.Filters
+	for _, f := range upstream.Filters {
+		if managed(f) || inLocal[contents{f.Criteria, f.Action}] {
+			res = append(res, f)
+		}
+	}
+	return GmailConfig{Labels: upstream.Labels, Filters: res}
+}
@@ -0,0 +1,69 @@
+package apply
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+)
+
+func TestManagedFilters(t *testing.T) {
+	local := GmailConfig{
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "boss@work.com"},
+				Action:   filter.Actions{AddLabel: "work"},
+			},
+			{
+				Criteria: filter.Criteria{From: "me@home.com"},
+				Action:   filter.Actions{Star: true},
+			},
+		},
+	}
+	upstream := GmailConfig{
+		Filters: filter.Filters{
+			// Managed and no longer in the config.
+			{
+				ID:       "managed",
+				Criteria: filter.Criteria{From: "old@work.com"},
+				Action:   filter.Actions{Archive: true},
+			},
+			// Made by hand.
+			{
+				ID:       "manual",
+				Criteria: filter.Criteria{From: "spam@example.com"},
+				Action:   filter.Actions{Delete: true},
+			},
+			// Not managed, but already matching the config.
+			{
+				ID:       "same",
+				Criteria: filter.Criteria{From: "me@home.com"},
+				Action:   filter.Actions{Star: true},
+			},
+		},
+	}
+	managed := func(f filter.Filter) bool {
+		return strings.Contains(f.Criteria.ToGmailSearch(), "@work.com")
+	}
+
+	scoped := ManagedFilters(local, upstream, managed)
+	assert.Equal(t, filter.Filters{upstream.Filters[0], upstream.Filters[2]}, scoped.Filters)
+
+	d, err := Diff(local, scoped)
+	require.Nil(t, err)
+	api := &fakeAPI{}
+	require.Nil(t, Apply(d, api, true))
+	// The filter made by hand survives.
+	assert.Equal(t, []string{"managed"}, api.deletedFilters)
+	assert.Equal(t, filter.Filters{local.Filters[0]}, api.addedFilters)
+
+	// Without scoping it would be deleted.
+	d, err = Diff(local, upstream)
+	require.Nil(t, err)
+	api = &fakeAPI{}
+	require.Nil(t, Apply(d, api, true))
+	assert.ElementsMatch(t, []string{"managed", "manual"}, api.deletedFilters)
+}
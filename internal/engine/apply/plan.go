@@ -0,0 +1,189 @@
+package apply
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// OperationKind is the type of a Gmail API operation.
+type OperationKind string
+
+// Operations performed by Apply.
+const (
+	OperationAddLabels     OperationKind = "addLabels"
+	OperationAddFilters    OperationKind = "addFilters"
+	OperationUpdateLabels  OperationKind = "updateLabels"
+	OperationDeleteFilters OperationKind = "deleteFilters"
+	OperationDeleteLabels  OperationKind = "deleteLabels"
+)
+
+// Operation is a single call to the Gmail APIs.
+//
+// Depending on the kind, only one of the fields is present: labels to add or
+// update, filters to add, or IDs of filters or labels to delete.
+type Operation struct {
+	Kind    OperationKind  `json:"kind"`
+	Labels  label.Labels   `json:"labels,omitempty"`
+	Filters filter.Filters `json:"filters,omitempty"`
+	IDs     []string       `json:"ids,omitempty"`
+}
+
+// Plan is an ordered list of Gmail API operations.
+//
+// A Plan implements API, so passing it to Apply records the operations
+// instead of executing them. The recorded operations can be executed
+// later on with Replay.
+//
+// Upstream is the fingerprint of the upstream settings the plan was made
+// for, to check that they didn't change before executing the plan. See
+// Fingerprint.
+type Plan struct {
+	Upstream   string      `json:"upstream,omitempty"`
+	Operations []Operation `json:"operations"`
+}
+
+// ReadPlan parses a plan encoded as JSON.
+func ReadPlan(r io.Reader) (Plan, error) {
+	var res Plan
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return res, fmt.Errorf("decoding the plan: %w", err)
+	}
+	return res, nil
+}
+
+// Fingerprint returns a digest of the upstream settings, which changes when
+// any filter or label is added, removed or changed. The order of filters and
+// labels doesn't matter.
+func Fingerprint(upstream GmailConfig) string {
+	fs := append(filter.Filters{}, upstream.Filters...)
+	sort.SliceStable(fs, func(i, j int) bool { return fs[i].ID < fs[j].ID })
+	lbs := append(label.Labels{}, upstream.Labels...)
+	sort.SliceStable(lbs, func(i, j int) bool { return lbs[i].ID < lbs[j].ID })
+
+	// Encoding plain data structures can't fail.
+	b, _ := json.Marshal(GmailConfig{Labels: lbs, Filters: fs})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckUpstream returns an error if the upstream settings are not the ones
+// the plan was made for.
+func (p Plan) CheckUpstream(upstream GmailConfig) error {
+	if p.Upstream == "" {
+		return errors.WithDetails(errors.New("the plan doesn't record the settings it was made for"),
+			"Make a new plan with 'gmailctl apply --dry-run'.")
+	}
+	if p.Upstream != Fingerprint(upstream) {
+		return errors.WithDetails(errors.New("the Gmail settings changed since the plan was made, no changes have been made"),
+			"Executing the plan could undo the latest changes or fail.\n"+
+				"Make a new plan with 'gmailctl apply --dry-run'.")
+	}
+	return nil
+}
+
+// AddLabels records the creation of the given labels.
+func (p *Plan) AddLabels(lbs label.Labels) error {
+	p.record(Operation{Kind: OperationAddLabels, Labels: append(label.Labels{}, lbs...)})
+	return nil
+}
+
+// AddFilters records the creation of the given filters.
+func (p *Plan) AddFilters(fs filter.Filters) error {
+	p.record(Operation{Kind: OperationAddFilters, Filters: append(filter.Filters{}, fs...)})
+	return nil
+}
+
+// UpdateLabels records the update of the given labels.
+func (p *Plan) UpdateLabels(lbs label.Labels) error {
+	p.record(Operation{Kind: OperationUpdateLabels, Labels: append(label.Labels{}, lbs...)})
+	return nil
+}
+
+// DeleteFilters records the deletion of the given filters.
+func (p *Plan) DeleteFilters(ids []string) error {
+	p.record(Operation{Kind: OperationDeleteFilters, IDs: append([]string{}, ids...)})
+	return nil
+}
+
+// DeleteLabels records the deletion of the given labels.
+func (p *Plan) DeleteLabels(ids []string) error {
+	p.record(Operation{Kind: OperationDeleteLabels, IDs: append([]string{}, ids...)})
+	return nil
+}
+
+func (p *Plan) record(op Operation) {
+	p.Operations = append(p.Operations, op)
+}
+
+// Diff returns the changes that the plan makes to the upstream settings,
+// for display purposes. The deleted and updated filters and labels are
+// looked up by ID in the upstream settings.
+func (p Plan) Diff(upstream GmailConfig) ConfigDiff {
+	filters := map[string]filter.Filter{}
+	for _, f := range upstream.Filters {
+		filters[f.ID] = f
+	}
+	labels := map[string]label.Label{}
+	for _, l := range upstream.Labels {
+		labels[l.ID] = l
+	}
+
+	var res ConfigDiff
+	for _, op := range p.Operations {
+		switch op.Kind {
+		case OperationAddLabels:
+			res.LabelsDiff.Added = append(res.LabelsDiff.Added, op.Labels...)
+		case OperationAddFilters:
+			res.FiltersDiff.Added = append(res.FiltersDiff.Added, op.Filters...)
+		case OperationUpdateLabels:
+			for _, l := range op.Labels {
+				res.LabelsDiff.Modified = append(res.LabelsDiff.Modified,
+					label.ModifiedLabel{Old: labels[l.ID], New: l})
+			}
+		case OperationDeleteFilters:
+			for _, id := range op.IDs {
+				res.FiltersDiff.Removed = append(res.FiltersDiff.Removed, filters[id])
+			}
+		case OperationDeleteLabels:
+			for _, id := range op.IDs {
+				res.LabelsDiff.Removed = append(res.LabelsDiff.Removed, labels[id])
+			}
+		}
+	}
+	return res
+}
+
+// Replay executes the operations of the plan, in order.
+func (p Plan) Replay(api API) error {
+	for i, op := range p.Operations {
+		var err error
+		switch op.Kind {
+		case OperationAddLabels:
+			err = api.AddLabels(op.Labels)
+		case OperationAddFilters:
+			err = api.AddFilters(op.Filters)
+		case OperationUpdateLabels:
+			err = api.UpdateLabels(op.Labels)
+		case OperationDeleteFilters:
+			err = api.DeleteFilters(op.IDs)
+		case OperationDeleteLabels:
+			err = api.DeleteLabels(op.IDs)
+		default:
+			err = fmt.Errorf("unknown operation kind %q", op.Kind)
+		}
+		if err != nil {
+			return fmt.Errorf("operation #%d (%s): %w", i, op.Kind, err)
+		}
+	}
+	return nil
+}
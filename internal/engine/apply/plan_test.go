@@ -0,0 +1,201 @@
+package apply
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// callsAPI records every call, in order.
+type callsAPI struct {
+	calls []string
+}
+
+func (c *callsAPI) AddLabels(lbs label.Labels) error {
+	for _, l := range lbs {
+		c.calls = append(c.calls, "add label "+l.String())
+	}
+	return nil
+}
+
+func (c *callsAPI) AddFilters(fs filter.Filters) error {
+	for _, f := range fs {
+		c.calls = append(c.calls, "add filter "+f.String())
+	}
+	return nil
+}
+
+func (c *callsAPI) UpdateLabels(lbs label.Labels) error {
+	for _, l := range lbs {
+		c.calls = append(c.calls, "update label "+l.String())
+	}
+	return nil
+}
+
+func (c *callsAPI) DeleteFilters(ids []string) error {
+	for _, id := range ids {
+		c.calls = append(c.calls, "delete filter "+id)
+	}
+	return nil
+}
+
+func (c *callsAPI) DeleteLabels(ids []string) error {
+	for _, id := range ids {
+		c.calls = append(c.calls, "delete label "+id)
+	}
+	return nil
+}
+
+func TestPlanReplay(t *testing.T) {
+	local := GmailConfig{
+		Labels: label.Labels{
+			{Name: "new"},
+			{Name: "changed", Color: &label.Color{Background: "#000000", Text: "#ffffff"}},
+		},
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "a@b.com"},
+				Action:   filter.Actions{AddLabel: "new", Archive: true},
+			},
+		},
+	}
+	upstream := GmailConfig{
+		Labels: label.Labels{
+			{ID: "l1", Name: "changed"},
+			{ID: "l2", Name: "old"},
+		},
+		Filters: filter.Filters{
+			{
+				ID:       "f1",
+				Criteria: filter.Criteria{From: "old@b.com"},
+				Action:   filter.Actions{AddLabel: "old"},
+			},
+		},
+	}
+	d, err := Diff(local, upstream)
+	require.Nil(t, err)
+
+	// A real apply.
+	applied := &callsAPI{}
+	require.Nil(t, Apply(d, applied, true))
+
+	// A dry run, serialized and replayed.
+	var plan Plan
+	require.Nil(t, Apply(d, &plan, true))
+	b, err := json.Marshal(plan)
+	require.Nil(t, err)
+	var decoded Plan
+	require.Nil(t, json.Unmarshal(b, &decoded))
+	replayed := &callsAPI{}
+	require.Nil(t, decoded.Replay(replayed))
+
+	assert.Equal(t, applied.calls, replayed.calls)
+	assert.Equal(t, []OperationKind{
+		OperationAddLabels,
+		OperationAddFilters,
+		OperationUpdateLabels,
+		OperationDeleteFilters,
+		OperationDeleteLabels,
+	}, kinds(decoded))
+}
+
+func TestPlanReplayUnknownOperation(t *testing.T) {
+	plan := Plan{Operations: []Operation{{Kind: "rename"}}}
+	err := plan.Replay(&callsAPI{})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `unknown operation kind "rename"`)
+}
+
+func TestPlanCheckUpstream(t *testing.T) {
+	upstream := GmailConfig{
+		Labels: label.Labels{{ID: "l1", Name: "old"}, {ID: "l2", Name: "other"}},
+		Filters: filter.Filters{{
+			ID:       "f1",
+			Criteria: filter.Criteria{From: "a"},
+			Action:   filter.Actions{AddLabel: "old"},
+		}},
+	}
+	plan := Plan{Upstream: Fingerprint(upstream)}
+
+	// The order doesn't matter.
+	assert.Nil(t, plan.CheckUpstream(GmailConfig{
+		Labels:  label.Labels{upstream.Labels[1], upstream.Labels[0]},
+		Filters: upstream.Filters,
+	}))
+
+	drifted := []GmailConfig{
+		{Labels: upstream.Labels},
+		{Labels: upstream.Labels[:1], Filters: upstream.Filters},
+		{
+			Labels: upstream.Labels,
+			Filters: filter.Filters{{
+				ID:       "f1",
+				Criteria: filter.Criteria{From: "b"},
+				Action:   filter.Actions{AddLabel: "old"},
+			}},
+		},
+	}
+	for _, d := range drifted {
+		err := plan.CheckUpstream(d)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "settings changed since the plan was made")
+	}
+
+	err := Plan{}.CheckUpstream(upstream)
+	assert.ErrorContains(t, err, "doesn't record the settings")
+}
+
+func TestPlanDiff(t *testing.T) {
+	upstream := GmailConfig{
+		Labels: label.Labels{{ID: "l1", Name: "old"}, {ID: "l2", Name: "other"}},
+		Filters: filter.Filters{{
+			ID:       "f1",
+			Criteria: filter.Criteria{From: "a"},
+			Action:   filter.Actions{AddLabel: "old"},
+		}},
+	}
+	added := filter.Filter{Criteria: filter.Criteria{From: "b"}, Action: filter.Actions{Archive: true}}
+	plan := Plan{Operations: []Operation{
+		{Kind: OperationAddLabels, Labels: label.Labels{{Name: "new"}}},
+		{Kind: OperationAddFilters, Filters: filter.Filters{added}},
+		{Kind: OperationUpdateLabels, Labels: label.Labels{{ID: "l2", Name: "renamed"}}},
+		{Kind: OperationDeleteFilters, IDs: []string{"f1"}},
+		{Kind: OperationDeleteLabels, IDs: []string{"l1"}},
+	}}
+
+	got := plan.Diff(upstream)
+	assert.Equal(t, filter.Filters{added}, got.FiltersDiff.Added)
+	assert.Equal(t, upstream.Filters, got.FiltersDiff.Removed)
+	assert.Equal(t, label.Labels{{Name: "new"}}, got.LabelsDiff.Added)
+	assert.Equal(t, upstream.Labels[:1], got.LabelsDiff.Removed)
+	assert.Equal(t, []label.ModifiedLabel{{
+		Old: upstream.Labels[1],
+		New: label.Label{ID: "l2", Name: "renamed"},
+	}}, got.LabelsDiff.Modified)
+}
+
+func TestReadPlan(t *testing.T) {
+	p, err := ReadPlan(strings.NewReader(`{"upstream":"abc","operations":[{"kind":"deleteFilters","ids":["f1"]}]}`))
+	require.Nil(t, err)
+	assert.Equal(t, Plan{
+		Upstream:   "abc",
+		Operations: []Operation{{Kind: OperationDeleteFilters, IDs: []string{"f1"}}},
+	}, p)
+
+	_, err = ReadPlan(strings.NewReader(`{"unknown":1}`))
+	assert.NotNil(t, err)
+}
+
+func kinds(p Plan) []OperationKind {
+	var res []OperationKind
+	for _, op := range p.Operations {
+		res = append(res, op.Kind)
+	}
+	return res
+}
@@ -0,0 +1,106 @@
+package apply
+
+import (
+	"fmt"
+
+	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/stringset"
+)
+
+// systemLabelIDs are the IDs of the labels managed by Gmail itself.
+var systemLabelIDs = stringset.New(
+	"INBOX",
+	"TRASH",
+	"IMPORTANT",
+	"UNREAD",
+	"SPAM",
+	"STARRED",
+	"SENT",
+	"DRAFT",
+	"CHAT",
+	"CATEGORY_PERSONAL",
+	"CATEGORY_SOCIAL",
+	"CATEGORY_UPDATES",
+	"CATEGORY_FORUMS",
+	"CATEGORY_PROMOTIONS",
+)
+
+// PruneAPI provides access to the Gmail APIs needed to prune labels.
+type PruneAPI interface {
+	CountLabelMessages(id string) (int64, error)
+	DeleteLabels(ids []string) error
+}
+
+// PruneResult reports the outcome of a labels prune.
+type PruneResult struct {
+	// Deleted contains the labels that have been deleted.
+	Deleted label.Labels
+	// NotEmpty contains the unreferenced labels that have been kept,
+	// because they are still applied to some messages.
+	NotEmpty label.Labels
+}
+
+// PruneLabels deletes the upstream labels that are not referenced by the
+// local configuration and contain no messages.
+//
+// A label is referenced if a filter applies it, if it's declared in the
+// configuration, or if it's the parent of a referenced label. System labels
+// are never deleted.
+func PruneLabels(local GmailConfig, upstream label.Labels, api PruneAPI) (PruneResult, error) {
+	var res PruneResult
+
+	referenced := stringset.New()
+	for _, f := range local.Filters {
+		if f.Action.AddLabel != "" {
+			referenced.Add(f.Action.AddLabel)
+		}
+	}
+	for _, l := range local.Labels {
+		referenced.Add(l.Name)
+	}
+	for _, l := range label.WithParents(labelsFromNames(referenced.ToSlice())) {
+		referenced.Add(l.Name)
+	}
+
+	var empty label.Labels
+	for _, l := range upstream {
+		if systemLabelIDs.Has(l.ID) || referenced.Has(l.Name) {
+			continue
+		}
+		n, err := api.CountLabelMessages(l.ID)
+		if err != nil {
+			return res, fmt.Errorf("counting messages of label %q: %w", l.Name, err)
+		}
+		if n > 0 {
+			res.NotEmpty = append(res.NotEmpty, l)
+			continue
+		}
+		empty = append(empty, l)
+	}
+
+	// Parents of the labels we keep have to stay as well.
+	kept := stringset.New()
+	for _, l := range label.WithParents(res.NotEmpty) {
+		kept.Add(l.Name)
+	}
+	var unused label.Labels
+	for _, l := range empty {
+		if !kept.Has(l.Name) {
+			unused = append(unused, l)
+		}
+	}
+
+	if err := removeLabels(unused, api); err != nil {
+		return res, fmt.Errorf("removing labels: %w", err)
+	}
+	res.Deleted = unused
+	return res, nil
+}
+
+func labelsFromNames(names []string) label.Labels {
+	res := make(label.Labels, len(names))
+	for i, n := range names {
+		res[i] = label.Label{Name: n}
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package apply
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestPruneLabels(t *testing.T) {
+	local := GmailConfig{
+		Filters: filter.Filters{
+			{
+				Criteria: filter.Criteria{From: "a@b.com"},
+				Action:   filter.Actions{AddLabel: "Work/Projects"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		upstream label.Labels
+		messages map[string]int64
+		deleted  []string
+		notEmpty label.Labels
+	}{
+		{
+			name: "label still referenced",
+			upstream: label.Labels{
+				{ID: "Label_1", Name: "Work"},
+				{ID: "Label_2", Name: "Work/Projects"},
+			},
+		},
+		{
+			name: "label empty and unreferenced",
+			upstream: label.Labels{
+				{ID: "Label_1", Name: "Work/Projects"},
+				{ID: "Label_2", Name: "Old"},
+				{ID: "Label_3", Name: "Old/Stuff"},
+			},
+			// Children are deleted first.
+			deleted: []string{"Label_3", "Label_2"},
+		},
+		{
+			name: "label has messages",
+			upstream: label.Labels{
+				{ID: "Label_1", Name: "Work/Projects"},
+				{ID: "Label_2", Name: "Old"},
+				{ID: "Label_3", Name: "Old/Stuff"},
+			},
+			messages: map[string]int64{"Label_3": 42},
+			// The parent of a kept label is kept as well.
+			notEmpty: label.Labels{{ID: "Label_3", Name: "Old/Stuff"}},
+		},
+		{
+			name: "system label",
+			upstream: label.Labels{
+				{ID: "CATEGORY_SOCIAL", Name: "CATEGORY_SOCIAL"},
+				{ID: "INBOX", Name: "INBOX"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeAPI{messages: tc.messages}
+			res, err := PruneLabels(local, tc.upstream, api)
+			require.Nil(t, err)
+			assert.Equal(t, tc.deleted, api.deletedLabels)
+			assert.Equal(t, tc.notEmpty, res.NotEmpty)
+			assert.Len(t, res.Deleted, len(tc.deleted))
+		})
+	}
+}
+
+func TestPruneLabelsDeclared(t *testing.T) {
+	local := GmailConfig{
+		Labels: label.Labels{{Name: "Declared"}},
+	}
+	api := &fakeAPI{}
+	res, err := PruneLabels(local, label.Labels{{ID: "Label_1", Name: "Declared"}}, api)
+	require.Nil(t, err)
+	assert.Empty(t, res.Deleted)
+	assert.Empty(t, api.deletedLabels)
+}
@@ -0,0 +1,70 @@
+package apply
+
+import (
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+)
+
+// Decision is the answer to the approval of a change.
+type Decision int
+
+// Possible decisions.
+const (
+	// Approve applies the change.
+	Approve Decision = iota
+	// Decline skips the change.
+	Decline
+	// Quit skips the change and all the following ones.
+	Quit
+)
+
+// ApproveFunc decides whether a filter should be added (or removed, when
+// added is false).
+type ApproveFunc func(f filter.Filter, added bool) Decision
+
+// SelectFilters asks for the approval of every filter to be added or removed
+// and returns a copy of the diff with only the approved ones, together with
+// the declined ones.
+//
+// Label changes are kept as is.
+func SelectFilters(d ConfigDiff, approve ApproveFunc) (ConfigDiff, filter.FiltersDiff) {
+	var (
+		approved, declined filter.FiltersDiff
+		quit               bool
+	)
+
+	sel := func(f filter.Filter, added bool) bool {
+		if quit {
+			return false
+		}
+		switch approve(f, added) {
+		case Approve:
+			return true
+		case Quit:
+			quit = true
+		}
+		return false
+	}
+
+	// Matching removed and added filters are next to each other in the
+	// diff, so they are asked in pairs.
+	fd := d.FiltersDiff
+	for i := 0; i < len(fd.Added) || i < len(fd.Removed); i++ {
+		if i < len(fd.Removed) {
+			if f := fd.Removed[i]; sel(f, false) {
+				approved.Removed = append(approved.Removed, f)
+			} else {
+				declined.Removed = append(declined.Removed, f)
+			}
+		}
+		if i < len(fd.Added) {
+			if f := fd.Added[i]; sel(f, true) {
+				approved.Added = append(approved.Added, f)
+			} else {
+				declined.Added = append(declined.Added, f)
+			}
+		}
+	}
+
+	d.FiltersDiff = approved
+	return d, declined
+}
@@ -0,0 +1,70 @@
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// Snapshot is the state of the Gmail filters and labels at a point in time.
+//
+// Unlike a downloaded config, a snapshot is not meant to be edited, but to
+// restore the exact settings, e.g. after a bad change.
+type Snapshot struct {
+	Time    time.Time      `json:"time"`
+	Labels  label.Labels   `json:"labels"`
+	Filters filter.Filters `json:"filters"`
+}
+
+// NewSnapshot returns a snapshot of the given upstream configuration.
+func NewSnapshot(upstream GmailConfig, t time.Time) Snapshot {
+	res := Snapshot{
+		Time:    t,
+		Labels:  upstream.Labels,
+		Filters: upstream.Filters,
+	}
+	// Keep the lists in the file even when empty.
+	if res.Labels == nil {
+		res.Labels = label.Labels{}
+	}
+	if res.Filters == nil {
+		res.Filters = filter.Filters{}
+	}
+	return res
+}
+
+// ReadSnapshot parses a snapshot written by Write.
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+	var res Snapshot
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return res, fmt.Errorf("decoding the snapshot: %w", err)
+	}
+	return res, nil
+}
+
+// Write encodes the snapshot as JSON.
+func (s Snapshot) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding the snapshot: %w", err)
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Config returns the snapshot as the desired configuration, which Diff
+// compares with the current upstream one to restore the snapshot.
+//
+// Labels are managed only if the snapshot has some, as for a local config.
+func (s Snapshot) Config() GmailConfig {
+	return GmailConfig{
+		Labels:  s.Labels,
+		Filters: s.Filters,
+	}
+}
@@ -0,0 +1,70 @@
+package apply
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	upstream := GmailConfig{
+		Labels: label.Labels{
+			{ID: "l1", Name: "work", Color: &label.Color{Background: "#000000", Text: "#ffffff"}},
+		},
+		Filters: filter.Filters{
+			{
+				ID:       "f1",
+				Criteria: filter.Criteria{From: "boss@work.com"},
+				Action:   filter.Actions{AddLabel: "work", MarkImportant: true},
+			},
+		},
+	}
+	snap := NewSnapshot(upstream, time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC))
+
+	var buf bytes.Buffer
+	require.Nil(t, snap.Write(&buf))
+	got, err := ReadSnapshot(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, snap, got)
+	assert.Equal(t, upstream, got.Config())
+}
+
+func TestSnapshotEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, NewSnapshot(GmailConfig{}, time.Time{}).Write(&buf))
+	assert.Contains(t, buf.String(), `"labels": []`)
+	assert.Contains(t, buf.String(), `"filters": []`)
+}
+
+func TestReadSnapshotInvalid(t *testing.T) {
+	_, err := ReadSnapshot(strings.NewReader(`{"version": "v1alpha3", "rules": []}`))
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotRestoreDiff(t *testing.T) {
+	snap := NewSnapshot(GmailConfig{
+		Labels: label.Labels{{ID: "l1", Name: "work"}},
+		Filters: filter.Filters{
+			{ID: "f1", Criteria: filter.Criteria{From: "a@b.com"}, Action: filter.Actions{Archive: true}},
+		},
+	}, time.Time{})
+	changed := GmailConfig{
+		Labels: label.Labels{{ID: "l1", Name: "work"}, {ID: "l2", Name: "temp"}},
+		Filters: filter.Filters{
+			{ID: "f2", Criteria: filter.Criteria{From: "c@d.com"}, Action: filter.Actions{Archive: true}},
+		},
+	}
+
+	d, err := Diff(snap.Config(), changed)
+	require.Nil(t, err)
+	assert.Equal(t, filter.Filters{snap.Filters[0]}, d.FiltersDiff.Added)
+	assert.Equal(t, filter.Filters{changed.Filters[0]}, d.FiltersDiff.Removed)
+	assert.Equal(t, label.Labels{{ID: "l2", Name: "temp"}}, d.LabelsDiff.Removed)
+}
@@ -0,0 +1,91 @@
+package apply
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// DiffSide selects which changes of a diff are shown.
+type DiffSide int
+
+// Sides of a diff.
+const (
+	// BothSides shows all the changes.
+	BothSides DiffSide = iota
+	// AddedOnly shows only the filters and labels to be created.
+	AddedOnly
+	// RemovedOnly shows only the filters and labels to be deleted.
+	RemovedOnly
+)
+
+// Only returns a copy of the diff restricted to the given side.
+//
+// Modified labels are neither added nor removed, so they are only kept
+// when both sides are selected.
+func (d ConfigDiff) Only(side DiffSide) ConfigDiff {
+	switch side {
+	case AddedOnly:
+		d.FiltersDiff.Removed = nil
+		d.LabelsDiff = label.LabelsDiff{Added: d.LabelsDiff.Added}
+	case RemovedOnly:
+		// Removed filters have no local position, so there's no context
+		// to show around them.
+		d.FiltersDiff = d.FiltersDiff.WithoutContext()
+		d.FiltersDiff.Added = nil
+		d.LabelsDiff = label.LabelsDiff{Removed: d.LabelsDiff.Removed}
+	}
+	return d
+}
+
+// Render returns the changes of the given side of the diff, followed by a
+// summary of all the changes in the diff.
+func (d ConfigDiff) Render(side DiffSide) string {
+	if d.Empty() {
+		return ""
+	}
+	return fmt.Sprintf("%s\nSummary: %s\n", d.Only(side), d.Summary())
+}
+
+// DiffSummary contains the number of changes in a diff.
+type DiffSummary struct {
+	FiltersAdded   int `json:"filtersAdded"`
+	FiltersRemoved int `json:"filtersRemoved"`
+	LabelsAdded    int `json:"labelsAdded"`
+	LabelsRemoved  int `json:"labelsRemoved"`
+	LabelsModified int `json:"labelsModified"`
+}
+
+// Summary counts the changes in the diff.
+func (d ConfigDiff) Summary() DiffSummary {
+	return DiffSummary{
+		FiltersAdded:   len(d.FiltersDiff.Added),
+		FiltersRemoved: len(d.FiltersDiff.Removed),
+		LabelsAdded:    len(d.LabelsDiff.Added),
+		LabelsRemoved:  len(d.LabelsDiff.Removed),
+		LabelsModified: len(d.LabelsDiff.Modified),
+	}
+}
+
+// String returns the non-zero counts in a single line.
+//
+// Example: +12 filters, -3 filters, +2 labels
+func (s DiffSummary) String() string {
+	var res []string
+	add := func(format string, n int) {
+		if n > 0 {
+			res = append(res, fmt.Sprintf(format, n))
+		}
+	}
+	add("+%d filters", s.FiltersAdded)
+	add("-%d filters", s.FiltersRemoved)
+	add("+%d labels", s.LabelsAdded)
+	add("-%d labels", s.LabelsRemoved)
+	add("~%d labels", s.LabelsModified)
+
+	if len(res) == 0 {
+		return "no changes"
+	}
+	return strings.Join(res, ", ")
+}
@@ -0,0 +1,68 @@
+package apply
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderOnlyRemoved(t *testing.T) {
+	d := testConfigDiff()
+	got := d.Render(RemovedOnly)
+
+	assert.Contains(t, got, "-    subject: spam")
+	assert.NotContains(t, got, "list:foo")
+	assert.NotContains(t, got, "Labels:")
+	assert.NotContains(t, got, "\n+  ")
+	// The summary still reports what's going to be added.
+	assert.True(t, strings.HasSuffix(got, "\nSummary: +2 filters, -2 filters, +1 labels\n"), got)
+}
+
+func TestRenderOnlyAdded(t *testing.T) {
+	d := testConfigDiff()
+	got := d.Render(AddedOnly)
+
+	assert.Contains(t, got, "+    query: list:foo")
+	assert.Contains(t, got, "Labels:")
+	assert.NotContains(t, got, "spam")
+	assert.True(t, strings.HasSuffix(got, "\nSummary: +2 filters, -2 filters, +1 labels\n"), got)
+}
+
+func TestRenderBothSides(t *testing.T) {
+	d := testConfigDiff()
+	got := d.Render(BothSides)
+	assert.Equal(t, d.String()+"\nSummary: +2 filters, -2 filters, +1 labels\n", got)
+}
+
+func TestRenderEmpty(t *testing.T) {
+	assert.Equal(t, "", ConfigDiff{}.Render(BothSides))
+}
+
+func TestDiffSummaryString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    DiffSummary
+		want string
+	}{
+		{
+			name: "empty",
+			want: "no changes",
+		},
+		{
+			name: "mixed",
+			s:    DiffSummary{FiltersAdded: 12, FiltersRemoved: 3, LabelsAdded: 2},
+			want: "+12 filters, -3 filters, +2 labels",
+		},
+		{
+			name: "labels only",
+			s:    DiffSummary{LabelsRemoved: 1, LabelsModified: 4},
+			want: "-1 labels, ~4 labels",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.s.String())
+		})
+	}
+}
@@ -63,13 +63,16 @@ func (r *evalBuilder) VisitLeaf(n *parser.Leaf) {
 		rules = expandAll(n.Args, expandTo)
 	case parser.FunctionSubject:
 		rules = expandAll(n.Args, func(a string) RuleEvaluator {
-			return freeTextField(matchFieldSubject, a)
+			// Exact phrases are matched as the plain subjects.
+			return freeTextField(matchFieldSubject, strings.Trim(a, `"`))
 		})
 	case parser.FunctionHas:
 		rules = expandAll(n.Args, expandHas)
 	case parser.FunctionQuery:
-		r.Err = fmt.Errorf("unsupported unconstrained query: '%v'", n)
-		return
+		rules, r.Err = expandQuery(n)
+		if r.Err != nil {
+			return
+		}
 	default:
 		r.Err = fmt.Errorf("unsupported function: %s", n.Function)
 		return
@@ -106,17 +109,38 @@ func expandTo(arg string) RuleEvaluator {
 // The 'has' operator basically matches every field.
 // In input you have a list of items, like "this", "two words", in output evaluators
 // that match them in any possible field (to, from, subject, body, ...).
+//
+// The subject and the body are matched word by word (see containsWords).
 func expandHas(arg string) RuleEvaluator {
 	return orNode{
 		[]RuleEvaluator{
 			expandTo(arg),
 			emailField(matchFieldFrom, arg),
-			freeTextField(matchFieldSubject, arg),
-			freeTextField(matchFieldBody, arg),
+			wordsField(matchFieldSubject, arg),
+			wordsField(matchFieldBody, arg),
 		},
 	}
 }
 
+// expandQuery expands the arguments of a 'query' leaf, by parsing them as
+// Gmail search queries. Only the queries that can be parsed back into
+// criteria are supported, e.g. 'from:{a b} -"some words"'.
+func expandQuery(n *parser.Leaf) ([]RuleEvaluator, error) {
+	var res []RuleEvaluator
+	for _, arg := range n.Args {
+		crit, err := parser.ParseQuery(arg)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported unconstrained query '%v': %w", n, err)
+		}
+		e, err := NewEvaluator(crit)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported unconstrained query '%v': %w", n, err)
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
 func emailField(f matchField, arg string) RuleEvaluator {
 	// Gmail doesn't distinguish between @ and .
 	r := funcNode{
@@ -143,6 +167,14 @@ func freeTextField(f matchField, arg string) RuleEvaluator {
 	}
 }
 
+func wordsField(f matchField, arg string) RuleEvaluator {
+	return funcNode{
+		field:     f,
+		expected:  arg,
+		matchType: matchTypeWords,
+	}
+}
+
 // group returns an evaluator built by grouping together the given ones with
 // an operator.
 func group(op parser.OperationType, rs []RuleEvaluator) (RuleEvaluator, error) {
@@ -78,6 +78,10 @@ func (rs Rules) ExecTests(ts []v1alpha3.Test) Result {
 func (rs Rules) ExecTest(t v1alpha3.Test) []error {
 	var res error
 
+	want, err := parser.ParseActions(t.Actions)
+	if err != nil {
+		return []error{fmt.Errorf("invalid expected actions: %w", err)}
+	}
 	for i, msg := range t.Messages {
 		expected, err := rs.MatchingActions(msg)
 		if err != nil {
@@ -89,7 +93,7 @@ func (rs Rules) ExecTest(t v1alpha3.Test) []error {
 			)
 			continue
 		}
-		if expected.Equal(Actions(t.Actions)) {
+		if expected.Equal(Actions(want)) {
 			// All good with this message.
 			continue
 		}
@@ -2,6 +2,7 @@ package cfgtest
 
 import (
 	"strings"
+	"unicode"
 
 	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
 )
@@ -12,6 +13,7 @@ const (
 	matchTypeExact = iota
 	matchTypeSuffix
 	matchTypeContains
+	matchTypeWords
 )
 
 type matchField int
@@ -108,6 +110,8 @@ func (n funcNode) Match(msg cfg.Message) bool {
 			isMatch = strings.HasSuffix(normF, n.expected)
 		case matchTypeContains:
 			isMatch = strings.Contains(normF, n.expected)
+		case matchTypeWords:
+			isMatch = containsWords(f, n.expected)
 		}
 
 		// If there's no match, continue searching.
@@ -124,3 +128,39 @@ func (n funcNode) Match(msg cfg.Message) bool {
 func normalizeField(a string) string {
 	return strings.ToLower(strings.ReplaceAll(a, "@", "."))
 }
+
+// containsWords approximates the Gmail full-text search: the text matches if
+// it contains all the words of the expected phrase, in the same order and
+// next to each other. Words are compared case insensitively, ignoring
+// punctuation, so 'cat' matches 'Cat!' but not 'category'.
+//
+// Gmail also does stemming and handles some special tokens, like prices and
+// dates, differently. None of that is emulated.
+func containsWords(text, phrase string) bool {
+	want := words(phrase)
+	if len(want) == 0 {
+		return strings.Contains(normalizeField(text), normalizeField(phrase))
+	}
+	got := words(text)
+	for i := 0; i+len(want) <= len(got); i++ {
+		match := true
+		for j, w := range want {
+			if got[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// words splits the text into lowercase words, separated by anything that is
+// not a letter or a digit.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
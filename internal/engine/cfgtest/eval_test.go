@@ -84,3 +84,135 @@ func TestParseEval(t *testing.T) {
 		})
 	}
 }
+
+func TestCcBccEval(t *testing.T) {
+	eval, err := NewEvaluator(or(
+		fn(parser.FunctionCc, parser.OperationOr, "boss@x.com", "*@team.com"),
+		fn1(parser.FunctionBcc, "me@y.com"),
+	))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name        string
+		message     cfg.Message
+		expectMatch bool
+	}{
+		{
+			name:        "cc",
+			message:     cfg.Message{Cc: []string{"other@x.com", "Boss@x.com"}},
+			expectMatch: true,
+		},
+		{
+			name:        "cc domain",
+			message:     cfg.Message{Cc: []string{"dev@team.com"}},
+			expectMatch: true,
+		},
+		{
+			name:        "bcc",
+			message:     cfg.Message{Bcc: []string{"me@y.com"}},
+			expectMatch: true,
+		},
+		{
+			name:        "cc address in to",
+			message:     cfg.Message{To: []string{"boss@x.com"}},
+			expectMatch: false,
+		},
+		{
+			name:        "bcc address in cc",
+			message:     cfg.Message{Cc: []string{"me@y.com"}},
+			expectMatch: false,
+		},
+		{
+			name:        "no headers",
+			message:     cfg.Message{From: "boss@x.com"},
+			expectMatch: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			match := eval.Match(tc.message)
+			assert.Equal(t, tc.expectMatch, match)
+		})
+	}
+
+	// 'to' matches cc and bcc as well.
+	eval, err = NewEvaluator(fn1(parser.FunctionTo, "me@y.com"))
+	assert.Nil(t, err)
+	assert.True(t, eval.Match(cfg.Message{Cc: []string{"me@y.com"}}))
+	assert.True(t, eval.Match(cfg.Message{Bcc: []string{"me@y.com"}}))
+}
+
+func TestHasWords(t *testing.T) {
+	eval, err := NewEvaluator(fn1(parser.FunctionHas, "quarterly report"))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		body        string
+		expectMatch bool
+	}{
+		{"Here is the quarterly report.", true},
+		{"QUARTERLY REPORT: attached", true},
+		{"the quarterly\nreport", true},
+		{"quarterly-report", true},
+		{"the report is quarterly", false},
+		{"quarterly reports", false},
+		{"biquarterly report", false},
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.body, func(t *testing.T) {
+			match := eval.Match(cfg.Message{Body: tc.body})
+			assert.Equal(t, tc.expectMatch, match)
+		})
+	}
+}
+
+func TestQueryEval(t *testing.T) {
+	eval, err := NewEvaluator(fn1(parser.FunctionQuery, `from:{a@x.com b@x.com} invoice -"do not reply"`))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name        string
+		message     cfg.Message
+		expectMatch bool
+	}{
+		{
+			name:        "match",
+			message:     cfg.Message{From: "a@x.com", Body: "Your invoice is ready"},
+			expectMatch: true,
+		},
+		{
+			name:        "match in subject",
+			message:     cfg.Message{From: "b@x.com", Subject: "Invoice #123"},
+			expectMatch: true,
+		},
+		{
+			name:        "other sender",
+			message:     cfg.Message{From: "c@x.com", Body: "Your invoice is ready"},
+			expectMatch: false,
+		},
+		{
+			name:        "no word",
+			message:     cfg.Message{From: "a@x.com", Body: "Your invoices are ready"},
+			expectMatch: false,
+		},
+		{
+			name:        "negated phrase",
+			message:     cfg.Message{From: "a@x.com", Body: "Invoice attached. Do not reply."},
+			expectMatch: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			match := eval.Match(tc.message)
+			assert.Equal(t, tc.expectMatch, match)
+		})
+	}
+
+	// Queries that can't be parsed are not supported.
+	_, err = NewEvaluator(fn1(parser.FunctionQuery, "a OR b"))
+	assert.NotNil(t, err)
+}
@@ -14,7 +14,7 @@ import (
 func readConfig(t *testing.T, path string) v1alpha3.Config {
 	t.Helper()
 	path = filepath.Join("testdata", path)
-	res, err := config.ReadFile(path, path)
+	res, _, err := config.ReadFile(path, path, config.ReadOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -114,3 +114,45 @@ multiple errors (2):
 		})
 	}
 }
+
+func TestExecNeverActions(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "friend@example.com"},
+				Actions: v1alpha3.Actions{NeverMarkSpam: true, NeverMarkImportant: true},
+			},
+		},
+	}
+	pres, err := apply.FromConfig(cfg)
+	assert.Nil(t, err)
+	rules, errs := NewFromParserRules(pres.Rules)
+	assert.Empty(t, errs)
+
+	no := false
+	msgs := []v1alpha3.Message{{From: "friend@example.com"}}
+	res := rules.ExecTests([]v1alpha3.Test{
+		{
+			Name:     "shorthands",
+			Messages: msgs,
+			Actions:  v1alpha3.Actions{NeverMarkSpam: true, NeverMarkImportant: true},
+		},
+		{
+			Name:     "tribools",
+			Messages: msgs,
+			Actions:  v1alpha3.Actions{MarkSpam: &no, MarkImportant: &no},
+		},
+	})
+	assert.True(t, res.OK, res.String())
+
+	yes := true
+	res = rules.ExecTests([]v1alpha3.Test{
+		{
+			Name:     "contradictory",
+			Messages: msgs,
+			Actions:  v1alpha3.Actions{MarkSpam: &yes, NeverMarkSpam: true},
+		},
+	})
+	assert.False(t, res.OK)
+	assert.Contains(t, res.String(), "'markSpam' and 'neverMarkSpam' cannot be both enabled")
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+// Canonicalize returns a copy of the config with labels and rules sorted in
+// a canonical order.
+//
+// Labels are sorted by name and rules by their criteria first and actions
+// then. The order of rules doesn't change the resulting filters, because
+// Gmail applies all the matching ones. Tests are left in their order.
+func Canonicalize(c v1alpha3.Config) v1alpha3.Config {
+	res := c
+	res.Labels = append([]v1alpha3.Label(nil), c.Labels...)
+	sort.SliceStable(res.Labels, func(i, j int) bool {
+		return res.Labels[i].Name < res.Labels[j].Name
+	})
+
+	keys := map[int]string{}
+	rules := make([]int, len(c.Rules))
+	for i, r := range c.Rules {
+		rules[i] = i
+		keys[i] = sortKey(r)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return keys[rules[i]] < keys[rules[j]]
+	})
+	res.Rules = make([]v1alpha3.Rule, len(c.Rules))
+	for i, ri := range rules {
+		res.Rules[i] = c.Rules[ri]
+	}
+
+	return res
+}
+
+// Format writes the canonical form of the config as indented JSON.
+//
+// Formatting is idempotent: reading and formatting again the result
+// produces the same output.
+func Format(c v1alpha3.Config, w io.Writer) error {
+	b, err := json.MarshalIndent(Canonicalize(c), "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+func sortKey(r v1alpha3.Rule) string {
+	// The serialization is deterministic, because fields are always
+	// written in the same order. Errors are impossible here, because the
+	// config only contains serializable types.
+	f, _ := json.Marshal(r.Filter)
+	a, _ := json.Marshal(r.Actions)
+	g, _ := json.Marshal(r.ActionGroups)
+	return string(f) + "\x00" + string(a) + "\x00" + string(g)
+}
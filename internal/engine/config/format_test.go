@@ -0,0 +1,43 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update is useful to regenerate the golden files
+// Make sure the new version makes sense!!
+var update = flag.Bool("update", false, "update golden files")
+
+func TestFormat(t *testing.T) {
+	path := filepath.Join("testdata", "unformatted.json")
+	golden := filepath.Join("testdata", "formatted.json")
+
+	cfg, _, err := ReadFile(path, "", ReadOptions{})
+	require.Nil(t, err)
+	var buf bytes.Buffer
+	err = Format(cfg, &buf)
+	require.Nil(t, err)
+
+	if *update {
+		err = os.WriteFile(golden, buf.Bytes(), 0o600)
+		require.Nil(t, err)
+	}
+	b, err := os.ReadFile(golden)
+	require.Nil(t, err)
+	assert.Equal(t, string(b), buf.String())
+
+	// Formatting again the result must produce the same output.
+	cfg, err = ReadJsonnet(golden, buf.Bytes(), ReadOptions{})
+	require.Nil(t, err)
+	var buf2 bytes.Buffer
+	err = Format(cfg, &buf2)
+	require.Nil(t, err)
+	assert.Equal(t, buf.String(), buf2.String())
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"github.com/google/go-jsonnet"
+
+	"github.com/mbrt/gmailctl/internal/data"
+)
+
+// libName is the name used to import the gmailctl library.
+const libName = "gmailctl.libsonnet"
+
+// libImporter imports files relative to the given paths, like the jsonnet
+// FileImporter.
+//
+// If the gmailctl library is not found, the one bundled with gmailctl is
+// used instead. A local copy of the library, e.g. created by 'gmailctl init',
+// always takes precedence.
+type libImporter struct {
+	files jsonnet.FileImporter
+}
+
+func newImporter(dir string) *libImporter {
+	return &libImporter{
+		files: jsonnet.FileImporter{JPaths: []string{dir}},
+	}
+}
+
+func (i *libImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.files.Import(importedFrom, importedPath)
+	if err != nil && importedPath == libName {
+		return jsonnet.MakeContents(data.GmailctlLib()), "<bundled>/" + libName, nil
+	}
+	return contents, foundAt, err
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha1"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// Migration describes the upgrade of a YAML config to the latest version of
+// the format.
+type Migration struct {
+	// From is the version of the original config.
+	From string
+	// Notes describe the parts of the config that changed form or
+	// meaning in the upgrade.
+	Notes []string
+}
+
+// Migrate reads a YAML config, in any version of the format, and upgrades it
+// to the latest version.
+//
+// The upgraded config behaves like the original one: for example the
+// queries that the latest version would reject are kept as raw queries.
+// Every change is reported in the notes.
+func Migrate(buf []byte) (v1alpha3.Config, Migration, error) {
+	var (
+		res v1alpha3.Config
+		m   Migration
+	)
+	version, err := readYAMLVersion(buf)
+	if err != nil {
+		return res, m, fmt.Errorf("parsing the config version: %w", err)
+	}
+	m.From = version
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+
+	switch version {
+	case v1alpha3.Version:
+		// The fields are named after the JSON ones.
+		var v map[string]interface{}
+		if err := dec.Decode(&v); err != nil {
+			return res, m, fmt.Errorf("parsing the v1alpha3 config: %w", err)
+		}
+		js, err := json.Marshal(v)
+		if err != nil {
+			return res, m, fmt.Errorf("converting the v1alpha3 config: %w", err)
+		}
+		err = jsonUnmarshalStrict(js, &res)
+		return res, m, err
+
+	case v1alpha2.Version:
+		var v2 v1alpha2.Config
+		if err := dec.Decode(&v2); err != nil {
+			return res, m, fmt.Errorf("parsing the v1alpha2 config: %w", err)
+		}
+		return migrateFromV2(v2, m)
+
+	case v1alpha1.Version:
+		var v1 v1alpha1.Config
+		if err := dec.Decode(&v1); err != nil {
+			return res, m, fmt.Errorf("parsing the v1alpha1 config: %w", err)
+		}
+		if len(v1.Consts) > 0 {
+			m.Notes = append(m.Notes, "the 'consts' are replaced by their values in the rules using them")
+		}
+		v2, err := v1alpha2.Import(v1)
+		if err != nil {
+			return res, m, err
+		}
+		return migrateFromV2(v2, m)
+
+	default:
+		return res, m, errors.WithDetails(fmt.Errorf("unsupported config version: %s", version),
+			fmt.Sprintf("The versions that can be migrated are: %s, %s and %s.",
+				v1alpha1.Version, v1alpha2.Version, v1alpha3.Version))
+	}
+}
+
+func migrateFromV2(v2 v1alpha2.Config, m Migration) (v1alpha3.Config, Migration, error) {
+	if len(v2.Filters) > 0 {
+		m.Notes = append(m.Notes, "the named 'filters' are replaced by their definition in the rules using them")
+	}
+	res, err := v1alpha3.Import(v2)
+	if err != nil {
+		return res, m, err
+	}
+	for i := range res.Rules {
+		rawQueries(&res.Rules[i].Filter, func(q string) {
+			m.Notes = append(m.Notes, fmt.Sprintf(
+				"rule #%d: 'query' %q is kept as 'rawQuery', because it can't be safely composed with other criteria",
+				i, q))
+		})
+	}
+	return res, m, nil
+}
+
+// rawQueries turns the queries of the node and its children that don't pass
+// the checks of the latest version into raw queries. Older versions passed
+// all of them as they were.
+func rawQueries(f *v1alpha3.FilterNode, report func(string)) {
+	if f.Query != "" && ValidateQuery(f.Query) != nil {
+		report(f.Query)
+		f.RawQuery, f.Query = f.Query, ""
+	}
+	for i := range f.And {
+		rawQueries(&f.And[i], report)
+	}
+	for i := range f.Or {
+		rawQueries(&f.Or[i], report)
+	}
+	if f.Not != nil {
+		rawQueries(f.Not, report)
+	}
+}
+
+func readYAMLVersion(buf []byte) (string, error) {
+	// Try to unmarshal only the version
+	v := struct {
+		Version string `yaml:"version"`
+	}{}
+	err := yaml.Unmarshal(buf, &v)
+	return v.Version, err
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func TestMigrateV1(t *testing.T) {
+	cfg := `version: v1alpha1
+author:
+  name: Pippo Pluto
+  email: pippo@gmail.com
+consts:
+  me:
+    values:
+      - pippo@gmail.com
+      - pippo@hotmail.com
+rules:
+  - filters:
+      from:
+        - list@maillist.com
+      consts:
+        not:
+          to:
+            - me
+    actions:
+      archive: true
+  - filters:
+      query: "from:a OR from:b"
+    actions:
+      markImportant: true
+      labels:
+        - ab
+`
+	got, m, err := Migrate([]byte(cfg))
+	require.Nil(t, err)
+	assert.Equal(t, v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Author:  v1alpha3.Author{Name: "Pippo Pluto", Email: "pippo@gmail.com"},
+		Rules: []v1alpha3.Rule{
+			{
+				Filter: v1alpha3.FilterNode{
+					And: []v1alpha3.FilterNode{
+						{From: "list@maillist.com"},
+						{Not: &v1alpha3.FilterNode{
+							Or: []v1alpha3.FilterNode{
+								{To: "pippo@gmail.com"},
+								{To: "pippo@hotmail.com"},
+							},
+						}},
+					},
+				},
+				Actions: v1alpha3.Actions{Archive: true},
+			},
+			{
+				Filter:  v1alpha3.FilterNode{RawQuery: "from:a OR from:b"},
+				Actions: v1alpha3.Actions{MarkImportant: boolPtr(true), Labels: []string{"ab"}},
+			},
+		},
+	}, got)
+	assert.Equal(t, Migration{
+		From: "v1alpha1",
+		Notes: []string{
+			"the 'consts' are replaced by their values in the rules using them",
+			`rule #1: 'query' "from:a OR from:b" is kept as 'rawQuery', because it can't be safely composed with other criteria`,
+		},
+	}, m)
+}
+
+func TestMigrateV2(t *testing.T) {
+	cfg := `version: v1alpha2
+filters:
+  - name: me
+    query:
+      or:
+        - to: pippo@gmail.com
+        - to: pippo@hotmail.com
+rules:
+  - filter:
+      and:
+        - name: me
+        - query: "list:dev"
+    actions:
+      labels:
+        - dev
+`
+	got, m, err := Migrate([]byte(cfg))
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Rule{{
+		Filter: v1alpha3.FilterNode{
+			And: []v1alpha3.FilterNode{
+				{Or: []v1alpha3.FilterNode{
+					{To: "pippo@gmail.com"},
+					{To: "pippo@hotmail.com"},
+				}},
+				{Query: "list:dev"},
+			},
+		},
+		Actions: v1alpha3.Actions{Labels: []string{"dev"}},
+	}}, got.Rules)
+	assert.Equal(t, Migration{
+		From:  "v1alpha2",
+		Notes: []string{"the named 'filters' are replaced by their definition in the rules using them"},
+	}, m)
+
+	// References to unknown names can't be migrated.
+	_, _, err = Migrate([]byte("version: v1alpha2\nrules:\n  - filter:\n      name: unknown\n    actions:\n      archive: true\n"))
+	assert.ErrorContains(t, err, "filter name 'unknown' not found")
+}
+
+func TestMigrateErrors(t *testing.T) {
+	_, _, err := Migrate([]byte("version: v0\n"))
+	require.NotNil(t, err)
+	assert.Equal(t, "unsupported config version: v0", err.Error())
+	assert.Contains(t, errors.Details(err), "v1alpha1, v1alpha2 and v1alpha3")
+
+	_, _, err = Migrate([]byte("version: v1alpha2\nunknown: 1\n"))
+	assert.ErrorContains(t, err, "parsing the v1alpha2 config")
+}
+
+func TestReadFileMigrated(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "config.yaml")
+	require.Nil(t, os.WriteFile(p, []byte("version: v1alpha2\nrules:\n  - filter:\n      from: a@b.com\n    actions:\n      archive: true\n"), 0o600))
+
+	got, m, err := ReadFile(p, "", ReadOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, v1alpha3.Version, got.Version)
+	assert.Equal(t, []v1alpha3.Rule{{
+		Filter:  v1alpha3.FilterNode{From: "a@b.com"},
+		Actions: v1alpha3.Actions{Archive: true},
+	}}, got.Rules)
+	require.NotNil(t, m)
+	assert.Equal(t, "v1alpha2", m.From)
+
+	// Left over next to the migrated config, it has to be removed.
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "config.jsonnet"), []byte(`{version: "v1alpha3"}`), 0o600))
+	_, _, err = ReadFile(dir, "", ReadOptions{})
+	assert.ErrorContains(t, err, `YAML config "config.yaml" found in directory`)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
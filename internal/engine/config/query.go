@@ -0,0 +1,62 @@
+package config
+
+import "fmt"
+
+// ValidateQuery makes sure that a query can be safely composed with other
+// criteria: parentheses, braces and quotes have to be balanced and no 'OR' or
+// 'AND' operators can be present outside of them.
+func ValidateQuery(q string) error {
+	var (
+		stack   []rune
+		inQuote bool
+		token   []rune
+	)
+	closing := map[rune]rune{')': '(', '}': '{'}
+
+	checkToken := func() error {
+		t := string(token)
+		token = token[:0]
+		if len(stack) == 0 && (t == "OR" || t == "AND") {
+			return fmt.Errorf("top-level '%s' in query %q would change the meaning "+
+				"of the other criteria: wrap it in parentheses", t, q)
+		}
+		return nil
+	}
+
+	for _, c := range q {
+		if inQuote {
+			if c == '"' {
+				inQuote = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inQuote = true
+		case '(', '{':
+			stack = append(stack, c)
+		case ')', '}':
+			if len(stack) == 0 || stack[len(stack)-1] != closing[c] {
+				return fmt.Errorf("unbalanced '%c' in query %q", c, q)
+			}
+			stack = stack[:len(stack)-1]
+		case ' ', '\t', '\n':
+			if err := checkToken(); err != nil {
+				return err
+			}
+			continue
+		}
+		token = append(token, c)
+	}
+
+	if err := checkToken(); err != nil {
+		return err
+	}
+	if inQuote {
+		return fmt.Errorf("unterminated quote in query %q", q)
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("unbalanced '%c' in query %q", stack[len(stack)-1], q)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{query: "is:muted"},
+		{query: "dinner AROUND 5 friday has:spreadsheet"},
+		{query: "(from:a OR from:b) -{to:c to:d}"},
+		{query: `subject:"a OR (b"`},
+		{query: "from:a OR from:b", err: `top-level 'OR' in query "from:a OR from:b"`},
+		{query: "a AND b", err: `top-level 'AND' in query "a AND b"`},
+		{query: "(from:a", err: `unbalanced '(' in query "(from:a"`},
+		{query: "from:a)", err: `unbalanced ')' in query "from:a)"`},
+		{query: "{from:a)", err: `unbalanced ')' in query "{from:a)"`},
+		{query: `subject:"a`, err: `unterminated quote in query "subject:\"a"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			err := ValidateQuery(tc.query)
+			if tc.err == "" {
+				assert.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
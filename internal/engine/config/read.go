@@ -1,12 +1,17 @@
 package config
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 
 	"github.com/google/go-jsonnet"
 
@@ -18,25 +23,49 @@ const (
 	// LatestVersion points to the latest version of the config format.
 	LatestVersion = v1alpha3.Version
 
-	unsupportedHelp = "Please see https://github.com/mbrt/gmailctl#yaml-config-is-unsupported.\n"
+	unsupportedHelp = "Please see https://github.com/mbrt/gmailctl#migrating-yaml-configs.\n"
 )
 
 // ErrNotFound is returned when a file was not found.
 var ErrNotFound = errors.New("config not found")
 
+// InputFormat is the format of a config file.
+type InputFormat string
+
+// Supported input formats.
+const (
+	// InputAuto detects the format from the content.
+	InputAuto    InputFormat = ""
+	InputJsonnet InputFormat = "jsonnet"
+	InputJSON    InputFormat = "json"
+	InputYAML    InputFormat = "yaml"
+)
+
+// yamlKey matches a top level YAML mapping key, like 'version:'.
+var yamlKey = regexp.MustCompile(`^[A-Za-z_][\w-]*:(\s|$)`)
+
 // ReadFile takes a path and returns the parsed config file.
 //
+// If the path is a directory, all the config files inside it are read and
+// merged together (see ReadDir).
+//
 // If the config file needs to have access to additional libraries,
 // their location can be specified with cfgDirs.
-func ReadFile(path, libPath string) (v1alpha3.Config, error) {
+//
+// YAML configs are upgraded in memory to the latest version, and the
+// returned migration describes the upgrade. It's nil for the other configs.
+func ReadFile(path, libPath string, opts ReadOptions) (v1alpha3.Config, *Migration, error) {
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		res, err := ReadDir(path, libPath, opts)
+		return res, nil, err
+	}
 	/* #nosec */
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return v1alpha3.Config{}, errors.WithCause(err, ErrNotFound)
+		return v1alpha3.Config{}, nil, errors.WithCause(err, ErrNotFound)
 	}
 	if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
-		return v1alpha3.Config{}, errors.WithDetails(errors.New("YAML config is unsupported"),
-			unsupportedHelp)
+		return readYAML(b)
 	}
 	// We pass the libPath to jsonnet, because that is the hint
 	// to the libraries location. If no library is specified,
@@ -44,18 +73,181 @@ func ReadFile(path, libPath string) (v1alpha3.Config, error) {
 	if libPath == "" {
 		libPath = path
 	}
-	return ReadJsonnet(libPath, b)
+	res, err := ReadJsonnet(libPath, b, opts)
+	return res, nil, err
+}
+
+// ReadDir reads all the '.jsonnet' and '.json' config files in the given
+// directory, in lexical order, and merges them into a single config.
+//
+// The rules and the tests are concatenated in the same order. Labels defined
+// more than once are kept only once, as long as they are defined in the same
+// way. Libraries, like '.libsonnet' files, are not read directly. YAML
+// configs are deprecated and can't be merged, so they are an error.
+func ReadDir(dir, libPath string, opts ReadOptions) (v1alpha3.Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return v1alpha3.Config{}, errors.WithCause(err, ErrNotFound)
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch filepath.Ext(e.Name()) {
+		case ".jsonnet", ".json":
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		case ".yaml", ".yml":
+			return v1alpha3.Config{}, errors.WithDetails(
+				fmt.Errorf("YAML config %q found in directory %q", e.Name(), dir),
+				"YAML configs can't be read from a directory. Migrate it to Jsonnet,\n"+
+					"or remove it if it's left over from a migration.",
+				unsupportedHelp)
+		}
+	}
+	if len(paths) == 0 {
+		return v1alpha3.Config{}, errors.WithCause(
+			fmt.Errorf("no config files found in directory %q", dir), ErrNotFound)
+	}
+
+	var res v1alpha3.Config
+	labels := map[string]string{}
+	for _, p := range paths {
+		c, _, err := ReadFile(p, libPath, opts)
+		if err != nil {
+			return v1alpha3.Config{}, fmt.Errorf("reading %q: %w", p, err)
+		}
+		if err := mergeConfig(&res, c, labels, p); err != nil {
+			return v1alpha3.Config{}, err
+		}
+	}
+	return res, nil
+}
+
+// mergeConfig appends the contents of c, read from path, to res. The paths
+// of the labels already in res are tracked in labels.
+func mergeConfig(res *v1alpha3.Config, c v1alpha3.Config, labels map[string]string, path string) error {
+	res.Version = c.Version
+	if c.Author != (v1alpha3.Author{}) {
+		if res.Author != (v1alpha3.Author{}) && res.Author != c.Author {
+			return fmt.Errorf("author in %q differs from the one in another file", path)
+		}
+		res.Author = c.Author
+	}
+	for _, l := range c.Labels {
+		prev, ok := labels[l.Name]
+		if !ok {
+			labels[l.Name] = path
+			res.Labels = append(res.Labels, l)
+			continue
+		}
+		for _, rl := range res.Labels {
+			if rl.Name == l.Name && !reflect.DeepEqual(rl, l) {
+				return fmt.Errorf("label %q is defined differently in %q and %q", l.Name, prev, path)
+			}
+		}
+	}
+	res.Rules = append(res.Rules, c.Rules...)
+	res.Tests = append(res.Tests, c.Tests...)
+	return nil
+}
+
+// Read parses a config read from r, like the standard input.
+//
+// Without a file extension to look at, the format is detected from the
+// content, unless explicitly given. Imports are resolved relative to libPath.
+// Like in ReadFile, the migration of a YAML config is returned as well.
+func Read(r io.Reader, format InputFormat, libPath string, opts ReadOptions) (v1alpha3.Config, *Migration, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return v1alpha3.Config{}, nil, fmt.Errorf("reading config: %w", err)
+	}
+	if format == InputAuto {
+		format = detectFormat(b)
+	}
+	var res v1alpha3.Config
+	switch format {
+	case InputYAML:
+		return readYAML(b)
+	case InputJSON:
+		if !json.Valid(b) {
+			return v1alpha3.Config{}, nil, errors.New("invalid JSON config")
+		}
+		// JSON is valid Jsonnet as well.
+		res, err = ReadJsonnet(libPath, b, opts)
+	case InputJsonnet:
+		res, err = ReadJsonnet(libPath, b, opts)
+	default:
+		err = fmt.Errorf("unknown config format %q", format)
+	}
+	return res, nil, err
+}
+
+// detectFormat guesses the format of a config. JSON is recognized by its
+// validity, while YAML by a top level key in its first meaningful line.
+// Everything else is assumed to be Jsonnet.
+func detectFormat(b []byte) InputFormat {
+	if json.Valid(b) {
+		return InputJSON
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "---" || yamlKey.MatchString(line) {
+			return InputYAML
+		}
+		break
+	}
+	return InputJsonnet
+}
+
+// readYAML reads a YAML config, deprecated in favor of Jsonnet, by
+// upgrading it to the latest version of the format.
+func readYAML(b []byte) (v1alpha3.Config, *Migration, error) {
+	res, m, err := Migrate(b)
+	if err != nil {
+		return res, nil, errors.WithDetails(fmt.Errorf("migrating the YAML config: %w", err), unsupportedHelp)
+	}
+	return res, &m, nil
+}
+
+// ExtVars are the external variables available to the Jsonnet configs, with
+// std.extVar, like the ones given to the jsonnet command.
+type ExtVars struct {
+	// Str are the variables with a string value.
+	Str map[string]string
+	// Code are the variables with a Jsonnet expression as value.
+	Code map[string]string
+}
+
+// ReadOptions control how the configs are read.
+type ReadOptions struct {
+	// ExtVars are the external variables available to the configs.
+	ExtVars ExtVars
+}
+
+// newVM returns a Jsonnet VM resolving imports relative to the given path.
+func newVM(p string, opts ReadOptions) *jsonnet.VM {
+	vm := jsonnet.MakeVM()
+	vm.Importer(newImporter(path.Dir(p)))
+	for k, v := range opts.ExtVars.Str {
+		vm.ExtVar(k, v)
+	}
+	for k, v := range opts.ExtVars.Code {
+		vm.ExtCode(k, v)
+	}
+	return vm
 }
 
 // ReadJsonnet parses a buffer containing a jsonnet config.
 //
 // The path is used to resolve imports.
-func ReadJsonnet(p string, buf []byte) (v1alpha3.Config, error) {
+func ReadJsonnet(p string, buf []byte, opts ReadOptions) (v1alpha3.Config, error) {
 	var res v1alpha3.Config
-	vm := jsonnet.MakeVM()
-	vm.Importer(&jsonnet.FileImporter{
-		JPaths: []string{path.Dir(p)},
-	})
+	vm := newVM(p, opts)
 	jstr, err := vm.EvaluateAnonymousSnippet(p, string(buf))
 	if err != nil {
 		return res, fmt.Errorf("parsing jsonnet: %w", err)
@@ -72,6 +264,41 @@ func ReadJsonnet(p string, buf []byte) (v1alpha3.Config, error) {
 	return res, err
 }
 
+// ReadFilter parses a buffer containing a single Jsonnet filter, like
+// "{ from: 'a@b.com' }".
+//
+// The path is used to resolve imports.
+func ReadFilter(p string, buf []byte, opts ReadOptions) (v1alpha3.FilterNode, error) {
+	var res v1alpha3.FilterNode
+	vm := newVM(p, opts)
+	jstr, err := vm.EvaluateAnonymousSnippet(p, string(buf))
+	if err != nil {
+		return res, fmt.Errorf("parsing jsonnet: %w", err)
+	}
+	err = jsonUnmarshalStrict([]byte(jstr), &res)
+	return res, err
+}
+
+// ReadTestsFile takes a path to a Jsonnet file, evaluating to a list of
+// tests, and returns the parsed tests.
+//
+// This allows to keep the tests separate from the config.
+func ReadTestsFile(p string, opts ReadOptions) ([]v1alpha3.Test, error) {
+	/* #nosec */
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, errors.WithCause(err, ErrNotFound)
+	}
+	vm := newVM(p, opts)
+	jstr, err := vm.EvaluateAnonymousSnippet(p, string(b))
+	if err != nil {
+		return nil, fmt.Errorf("parsing jsonnet: %w", err)
+	}
+	var res []v1alpha3.Test
+	err = jsonUnmarshalStrict([]byte(jstr), &res)
+	return res, err
+}
+
 func readJSONVersion(js string) (string, error) {
 	// Try to unmarshal only the version
 	v := struct {
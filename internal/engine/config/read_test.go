@@ -0,0 +1,259 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func TestReadTestsFile(t *testing.T) {
+	got, err := ReadTestsFile(filepath.Join("testdata", "tests.jsonnet"), ReadOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Test{
+		{
+			Name: "from boss",
+			Messages: []v1alpha3.Message{
+				{From: "boss@work.com", To: []string{"me@gmail.com"}},
+			},
+			Actions: v1alpha3.Actions{Labels: []string{"work"}},
+		},
+	}, got)
+
+	_, err = ReadTestsFile(filepath.Join("testdata", "missing.jsonnet"), ReadOptions{})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestRead(t *testing.T) {
+	want := v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "a@b.com"},
+				Actions: v1alpha3.Actions{Archive: true},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		format InputFormat
+		err    string
+	}{
+		{
+			name:  "json",
+			input: `{"version": "v1alpha3", "rules": [{"filter": {"from": "a@b.com"}, "actions": {"archive": true}}]}`,
+		},
+		{
+			name: "jsonnet",
+			input: `local from = 'a@b.com';
+{
+  version: 'v1alpha3',
+  rules: [{ filter: { from: from }, actions: { archive: true } }],
+}`,
+		},
+		{
+			name: "yaml",
+			input: `# My filters
+version: v1alpha3
+rules:
+  - filter:
+      from: a@b.com
+    actions:
+      archive: true
+`,
+		},
+		{
+			name: "yaml v1alpha2 document",
+			input: `---
+version: v1alpha2
+rules:
+  - filter:
+      from: a@b.com
+    actions:
+      archive: true
+`,
+		},
+		{
+			name:   "explicit jsonnet",
+			input:  `{ version: 'v1alpha3', rules: [{ filter: { from: 'a@b.com' }, actions: { archive: true } }] }`,
+			format: InputJsonnet,
+		},
+		{
+			name:   "explicit json",
+			input:  `{ version: 'v1alpha3', rules: [] }`,
+			format: InputJSON,
+			err:    "invalid JSON config",
+		},
+		{
+			name:   "unknown format",
+			input:  `{}`,
+			format: "toml",
+			err:    `unknown config format "toml"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, _, err := Read(strings.NewReader(tc.input), tc.format, "", ReadOptions{})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	got, _, err := ReadFile(filepath.Join("testdata", "split"), "", ReadOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, v1alpha3.Config{
+		Version: v1alpha3.Version,
+		// Duplicate labels are merged.
+		Labels: []v1alpha3.Label{{Name: "family"}, {Name: "work"}},
+		// Files are read in order.
+		Rules: []v1alpha3.Rule{
+			{
+				Filter:  v1alpha3.FilterNode{From: "@family.com"},
+				Actions: v1alpha3.Actions{Labels: []string{"family"}},
+			},
+			{
+				Filter:  v1alpha3.FilterNode{From: "boss@work.com"},
+				Actions: v1alpha3.Actions{Labels: []string{"work"}},
+			},
+		},
+		Tests: []v1alpha3.Test{
+			{
+				Messages: []v1alpha3.Message{{From: "boss@work.com"}},
+				Actions:  v1alpha3.Actions{Labels: []string{"work"}},
+			},
+		},
+	}, got)
+}
+
+func TestReadDirOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.jsonnet": `{version: 'v1alpha3', rules: [{filter: {from: 'a'}, actions: {archive: true}}]}`,
+		"lib.libsonnet":  "{}",
+		"README.md":      "# Filters",
+	}
+	for name, contents := range files {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600))
+	}
+
+	got, _, err := ReadFile(dir, "", ReadOptions{})
+	require.Nil(t, err)
+	assert.Len(t, got.Rules, 1)
+}
+
+func TestReadDirErrors(t *testing.T) {
+	write := func(dir, name, contents string) {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600))
+	}
+
+	dir := t.TempDir()
+	_, _, err := ReadFile(dir, "", ReadOptions{})
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	write(dir, "a.jsonnet", `{version: 'v1alpha3', labels: [{name: 'l'}], rules: []}`)
+	write(dir, "b.jsonnet", `{version: 'v1alpha3', labels: [{name: 'l', color: {background: '#000000', text: '#ffffff'}}], rules: []}`)
+	_, _, err = ReadFile(dir, "", ReadOptions{})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `label "l" is defined differently`)
+
+	write(dir, "b.jsonnet", `{version: 'v1alpha3', rules: [`)
+	_, _, err = ReadFile(dir, "", ReadOptions{})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "b.jsonnet")
+
+	// YAML configs are not silently ignored.
+	write(dir, "b.jsonnet", `{version: 'v1alpha3', rules: []}`)
+	write(dir, "c.yaml", "version: v1alpha2\nrules: []\n")
+	_, _, err = ReadFile(dir, "", ReadOptions{})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `YAML config "c.yaml" found in directory`)
+	assert.Contains(t, errors.Details(err), "Migrate it to Jsonnet")
+}
+
+func TestReadBundledLib(t *testing.T) {
+	// No library next to the config: the bundled one is used.
+	cfg := `
+local lib = import 'gmailctl.libsonnet';
+{
+  version: 'v1alpha3',
+  rules: [
+    {
+      filter: {
+        and: [
+          lib.fromDomain('example.com'),
+          { not: { from: 'noreply@example.com' } },
+        ],
+      },
+      actions: { labels: ['example'] },
+    },
+    {
+      filter: lib.listAny(['dev@lists.com', 'users@lists.com']),
+      actions: { archive: true },
+    },
+    {
+      filter: lib.directlyTo('me@gmail.com'),
+      actions: { markImportant: true },
+    },
+  ],
+}
+`
+	libPath := filepath.Join(t.TempDir(), "config.jsonnet")
+	got, _, err := Read(strings.NewReader(cfg), InputJsonnet, libPath, ReadOptions{})
+	require.Nil(t, err)
+
+	require.Len(t, got.Rules, 3)
+	assert.Equal(t, v1alpha3.FilterNode{From: "@example.com"}, got.Rules[0].Filter.And[0])
+	assert.Equal(t, v1alpha3.FilterNode{
+		And: []v1alpha3.FilterNode{
+			{To: "me@gmail.com"},
+			{Not: &v1alpha3.FilterNode{Cc: "me@gmail.com"}},
+			{Not: &v1alpha3.FilterNode{Bcc: "me@gmail.com"}},
+		},
+	}, got.Rules[2].Filter)
+}
+
+func TestReadExtVars(t *testing.T) {
+	opts := ReadOptions{ExtVars: ExtVars{
+		Str:  map[string]string{"domain": "work.com"},
+		Code: map[string]string{"archive": "true"},
+	}}
+
+	cfg := `
+local domain = std.extVar('domain');
+{
+  version: 'v1alpha3',
+  rules: [
+    {
+      filter: { from: '@' + domain },
+      actions: { archive: std.extVar('archive'), labels: [domain] },
+    },
+  ],
+}
+`
+	got, _, err := Read(strings.NewReader(cfg), InputJsonnet, "", opts)
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Rule{{
+		Filter:  v1alpha3.FilterNode{From: "@work.com"},
+		Actions: v1alpha3.Actions{Archive: true, Labels: []string{"work.com"}},
+	}}, got.Rules)
+
+	// Undefined variables are an error.
+	_, _, err = Read(strings.NewReader(cfg), InputJsonnet, "", ReadOptions{})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Undefined external variable")
+}
@@ -0,0 +1,3 @@
+{
+  boss: 'boss@work.com',
+}
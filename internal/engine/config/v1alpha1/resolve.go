@@ -37,6 +37,7 @@ func resolveFilters(f Filters, consts Consts) (Filters, error) {
 	// Join the non const configuration with the resolved one
 	res.MatchFilters = joinMatchFilters(f.MatchFilters, cm)
 	res.Not = joinMatchFilters(f.Not, ncm)
+	res.Query = f.Query
 
 	return res, nil
 }
@@ -0,0 +1,55 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveConsts(t *testing.T) {
+	cfg := Config{
+		Version: "v1alpha1",
+		Consts: Consts{
+			"friends": {Values: []string{"a@b.com", "b@c.com"}},
+		},
+		Rules: []Rule{
+			{
+				Filters: Filters{
+					CompositeFilters: CompositeFilters{
+						MatchFilters: MatchFilters{From: []string{"c@d.com"}},
+					},
+					Consts: CompositeFilters{
+						MatchFilters: MatchFilters{From: []string{"friends"}},
+					},
+					Query: "has:attachment",
+				},
+				Actions: Actions{Archive: true},
+			},
+		},
+	}
+	res, err := ResolveConsts(cfg)
+	require.Nil(t, err)
+	assert.Empty(t, res.Consts)
+	require.Len(t, res.Rules, 1)
+	f := res.Rules[0].Filters
+	assert.Equal(t, []string{"c@d.com", "a@b.com", "b@c.com"}, f.From)
+	// The raw query is kept as well.
+	assert.Equal(t, "has:attachment", f.Query)
+}
+
+func TestResolveUnknownConst(t *testing.T) {
+	cfg := Config{
+		Rules: []Rule{
+			{
+				Filters: Filters{
+					Consts: CompositeFilters{
+						MatchFilters: MatchFilters{From: []string{"unknown"}},
+					},
+				},
+			},
+		},
+	}
+	_, err := ResolveConsts(cfg)
+	assert.EqualError(t, err, "in rule #0: resolving 'from' clause: failed to resolve const 'unknown'")
+}
@@ -5,7 +5,7 @@ import (
 	"reflect"
 	"strings"
 
-	"github.com/mbrt/gmailctl/cmd/gmailctl-config-migrate/v1alpha1"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha1"
 	"github.com/mbrt/gmailctl/internal/engine/gmail"
 )
 
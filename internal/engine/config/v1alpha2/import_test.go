@@ -12,7 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 
-	"github.com/mbrt/gmailctl/cmd/gmailctl-config-migrate/v1alpha1"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha1"
 )
 
 func read(path string) io.Reader {
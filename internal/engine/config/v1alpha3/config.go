@@ -1,6 +1,7 @@
 package v1alpha3
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 
@@ -30,15 +31,40 @@ type FilterNode struct {
 	Or  []FilterNode `json:"or,omitempty"`
 	Not *FilterNode  `json:"not,omitempty"`
 
-	From    string `json:"from,omitempty"`
-	To      string `json:"to,omitempty"`
-	Cc      string `json:"cc,omitempty"`
-	Bcc     string `json:"bcc,omitempty"`
-	ReplyTo string `json:"replyto,omitempty"`
-	Subject string `json:"subject,omitempty"`
-	List    string `json:"list,omitempty"`
-	Has     string `json:"has,omitempty"`
-	Query   string `json:"query,omitempty"`
+	From        string `json:"from,omitempty"`
+	To          string `json:"to,omitempty"`
+	Cc          string `json:"cc,omitempty"`
+	Bcc         string `json:"bcc,omitempty"`
+	ReplyTo     string `json:"replyto,omitempty"`
+	DeliveredTo string `json:"deliveredTo,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	List        string `json:"list,omitempty"`
+	Has         string `json:"has,omitempty"`
+	Larger      string `json:"larger,omitempty"`
+	Smaller     string `json:"smaller,omitempty"`
+	NewerThan   string `json:"newerThan,omitempty"`
+	OlderThan   string `json:"olderThan,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	In          string `json:"in,omitempty"`
+	Is          string `json:"is,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Query       string `json:"query,omitempty"`
+
+	// RawQuery is like Query, but it's not checked for unbalanced
+	// parentheses or top-level operators, which may break the composition
+	// with other criteria.
+	RawQuery string `json:"rawQuery,omitempty"`
+
+	// Op is a Gmail search operator not natively supported, passed
+	// verbatim to the query as '<name>:<arg>'.
+	Op *RawFunction `json:"op,omitempty"`
+
+	// SubjectExact is like Subject, but the value is always quoted, so
+	// that Gmail matches it as an exact phrase.
+	SubjectExact string `json:"subjectExact,omitempty"`
+
+	// HasAttachment matches messages with at least one attachment.
+	HasAttachment bool `json:"hasAttachment,omitempty"`
 
 	// IsEscaped specifies that the given parameters don't need any
 	// further escaping.
@@ -47,6 +73,14 @@ type FilterNode struct {
 	IsEscaped bool `json:"isEscaped,omitempty"`
 }
 
+// RawFunction is a generic Gmail search operator, like 'label:work'.
+//
+// It allows using operators that are not supported natively yet.
+type RawFunction struct {
+	Name string `json:"name"`
+	Arg  string `json:"arg"`
+}
+
 // NonEmptyFields returns the names of the fields with a value.
 func (f FilterNode) NonEmptyFields() []string {
 	// Use reflection to minimize maintenance work.
@@ -73,8 +107,10 @@ func (f FilterNode) NonEmptyFields() []string {
 				continue
 			}
 		case reflect.Bool:
-			// Ignore the 'IsEscaped' marker
-			continue
+			// Ignore unset flags and the 'IsEscaped' marker
+			if !field.Bool() || name == "isEscaped" {
+				continue
+			}
 		}
 
 		res = append(res, name)
@@ -83,13 +119,62 @@ func (f FilterNode) NonEmptyFields() []string {
 	return res
 }
 
+// Validate returns an error if a logical operator is specified, but contains
+// no children.
+//
+// Empty operators would be otherwise ignored, so they are most likely a
+// mistake. Children are not validated.
+func (f FilterNode) Validate() error {
+	if f.And != nil && len(f.And) == 0 {
+		return errors.New("'and' specified but contains no children")
+	}
+	if f.Or != nil && len(f.Or) == 0 {
+		return errors.New("'or' specified but contains no children")
+	}
+	if f.Not != nil && len(f.Not.NonEmptyFields()) == 0 &&
+		f.Not.And == nil && f.Not.Or == nil && f.Not.Not == nil {
+		return errors.New("'not' specified but contains no children")
+	}
+	return nil
+}
+
 // Rule is the actual complete Gmail filter.
 //
 // For every email, if the filter applies correctly, then the specified actions
 // will be applied to it.
 type Rule struct {
+	// Name optionally identifies the rule in diffs and warnings. Gmail
+	// filters have no names, so it's not stored upstream.
+	Name    string     `json:"name,omitempty"`
 	Filter  FilterNode `json:"filter"`
 	Actions Actions    `json:"actions"`
+
+	// ActionGroups is an alternative to Actions: every group is applied
+	// independently, as a separate rule with the same filter. It's useful
+	// to express actions that can't be in the same Gmail filter, like
+	// forwarding to multiple addresses, without repeating the filter.
+	ActionGroups []Actions `json:"actionGroups,omitempty"`
+
+	// Guard optionally makes the rule depend on the current state of the
+	// account: the rule is skipped if the guard is not satisfied.
+	Guard *RuleGuard `json:"guard,omitempty"`
+
+	// CatchAll marks a rule that intentionally matches every message, e.g.
+	// to archive everything. Without it, such a rule is an error, as well
+	// as marking a rule that doesn't match every message.
+	CatchAll bool `json:"catchAll,omitempty"`
+}
+
+// RuleGuard is a condition on the state of the account, required for a
+// rule to be applied. Exactly one condition has to be specified.
+//
+// Label names are compared case insensitively.
+type RuleGuard struct {
+	// LabelExists is satisfied if the label already exists.
+	LabelExists string `json:"labelExists,omitempty"`
+	// LabelMissing is satisfied if the label doesn't exist yet, e.g.
+	// because it's not managed elsewhere.
+	LabelMissing string `json:"labelMissing,omitempty"`
 }
 
 // Author represents the owner of the gmail account.
@@ -106,10 +191,16 @@ type Actions struct {
 	Star     bool `json:"star,omitempty"`
 
 	// MarkSpam can be used to disallow mails to be marked as spam.
-	// This however is not allowed to be set to true by Gmail.
+	// This however is not allowed to be set to true by Gmail: the API
+	// rejects the filters adding the SPAM label.
 	MarkSpam      *bool `json:"markSpam,omitempty"`
 	MarkImportant *bool `json:"markImportant,omitempty"`
 
+	// NeverMarkSpam and NeverMarkImportant are more explicit alternatives
+	// to 'markSpam: false' and 'markImportant: false'.
+	NeverMarkSpam      bool `json:"neverMarkSpam,omitempty"`
+	NeverMarkImportant bool `json:"neverMarkImportant,omitempty"`
+
 	Category gmail.Category `json:"category,omitempty"`
 	Labels   []string       `json:"labels,omitempty"`
 
@@ -124,8 +215,9 @@ func (a Actions) Empty() bool {
 
 // Label represents a Gmail label.
 type Label struct {
-	Name  string      `json:"name"`
-	Color *LabelColor `json:"color,omitempty"`
+	Name       string           `json:"name"`
+	Color      *LabelColor      `json:"color,omitempty"`
+	Visibility *LabelVisibility `json:"visibility,omitempty"`
 }
 
 // LabelColor is the color of a label.
@@ -137,6 +229,18 @@ type LabelColor struct {
 	Text       string `json:"text"`
 }
 
+// LabelVisibility controls where a label is shown in Gmail.
+//
+// Unspecified settings are left as they are.
+type LabelVisibility struct {
+	// LabelList is the visibility in the list of labels: 'show',
+	// 'showIfUnread' or 'hide'.
+	LabelList string `json:"labelList,omitempty"`
+	// MessageList is the visibility in the list of messages: 'show' or
+	// 'hide'.
+	MessageList string `json:"messageList,omitempty"`
+}
+
 // Test represents the intended actions applied to a set of emails.
 type Test struct {
 	// Name is an optional name used for error reporting.
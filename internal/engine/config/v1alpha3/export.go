@@ -0,0 +1,84 @@
+package v1alpha3
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
+)
+
+// Export converts a v3 config into a v2, the last version of the YAML
+// configs.
+//
+// Labels are left out, because v2 configs don't manage them. Tests and
+// rules that v2 can't represent, e.g. because they forward emails, are
+// reported as errors.
+func Export(cfg Config) (v1alpha2.Config, error) {
+	res := v1alpha2.Config{
+		Version: v1alpha2.Version,
+		Author: v1alpha2.Author{
+			Name:  cfg.Author.Name,
+			Email: cfg.Author.Email,
+		},
+		// Rules are mandatory in the config.
+		Rules: []v1alpha2.Rule{},
+	}
+	if len(cfg.Tests) > 0 {
+		return res, fmt.Errorf("tests are not supported by %s", v1alpha2.Version)
+	}
+
+	for i, r := range cfg.Rules {
+		er := exportRule(r)
+		// Whatever doesn't survive the way back can't be represented.
+		back, err := Import(v1alpha2.Config{Rules: []v1alpha2.Rule{er}})
+		if err != nil || !reflect.DeepEqual(back.Rules[0], r) {
+			return res, fmt.Errorf("rule #%d uses features not supported by %s", i, v1alpha2.Version)
+		}
+		res.Rules = append(res.Rules, er)
+	}
+	return res, nil
+}
+
+func exportRule(r Rule) v1alpha2.Rule {
+	return v1alpha2.Rule{
+		Filter: exportFilter(r.Filter),
+		Actions: v1alpha2.Actions{
+			Archive:       r.Actions.Archive,
+			Delete:        r.Actions.Delete,
+			MarkRead:      r.Actions.MarkRead,
+			Star:          r.Actions.Star,
+			MarkSpam:      r.Actions.MarkSpam,
+			MarkImportant: r.Actions.MarkImportant,
+			Category:      r.Actions.Category,
+			Labels:        r.Actions.Labels,
+		},
+	}
+}
+
+func exportFilter(f FilterNode) v1alpha2.FilterNode {
+	var not *v1alpha2.FilterNode
+	if f.Not != nil {
+		nf := exportFilter(*f.Not)
+		not = &nf
+	}
+	return v1alpha2.FilterNode{
+		And:     exportFilters(f.And),
+		Or:      exportFilters(f.Or),
+		Not:     not,
+		From:    f.From,
+		To:      f.To,
+		Cc:      f.Cc,
+		Subject: f.Subject,
+		List:    f.List,
+		Has:     f.Has,
+		Query:   f.Query,
+	}
+}
+
+func exportFilters(fs []FilterNode) []v1alpha2.FilterNode {
+	var res []v1alpha2.FilterNode
+	for _, f := range fs {
+		res = append(res, exportFilter(f))
+	}
+	return res
+}
@@ -0,0 +1,52 @@
+package v1alpha3
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	for _, path := range testFiles(t, "v3.json") {
+		t.Run(path, func(t *testing.T) {
+			cfg := parseV3(t, path)
+			v2cfg, err := Export(cfg)
+			require.NoError(t, err)
+			assert.Equal(t, "v1alpha2", v2cfg.Version)
+
+			// Importing the result gives back the same config.
+			got, err := Import(v2cfg)
+			require.NoError(t, err)
+			assert.Equal(t, dump(t, cfg), dump(t, got))
+		})
+	}
+}
+
+func TestExportUnsupported(t *testing.T) {
+	cfg := Config{
+		Version: Version,
+		Labels:  []Label{{Name: "work"}},
+		Rules: []Rule{
+			{Filter: FilterNode{From: "a"}, Actions: Actions{Labels: []string{"work"}}},
+			{Filter: FilterNode{From: "b"}, Actions: Actions{Forward: "c@d.com"}},
+		},
+	}
+	_, err := Export(cfg)
+	assert.EqualError(t, err, "rule #1 uses features not supported by v1alpha2")
+
+	// Labels are not managed, so they are just left out.
+	cfg.Rules = cfg.Rules[:1]
+	res, err := Export(cfg)
+	require.NoError(t, err)
+	assert.Len(t, res.Rules, 1)
+
+	cfg.Rules[0].Filter = FilterNode{Bcc: "a"}
+	_, err = Export(cfg)
+	assert.EqualError(t, err, "rule #0 uses features not supported by v1alpha2")
+
+	cfg.Rules = nil
+	cfg.Tests = []Test{{}}
+	_, err = Export(cfg)
+	assert.EqualError(t, err, "tests are not supported by v1alpha2")
+}
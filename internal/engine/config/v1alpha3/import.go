@@ -5,14 +5,13 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 
-	"github.com/mbrt/gmailctl/cmd/gmailctl-config-migrate/v1alpha2"
-	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
 )
 
-var dummyFilter = v1alpha3.FilterNode{}
+var dummyFilter = FilterNode{}
 
 // Import converts a v2 config into a v3.
-func Import(cfg v1alpha2.Config) (v1alpha3.Config, error) {
+func Import(cfg v1alpha2.Config) (Config, error) {
 	i := importer{}
 	return i.Import(cfg)
 }
@@ -22,11 +21,11 @@ type importer struct {
 	err  error
 }
 
-func (i *importer) Import(cfg v1alpha2.Config) (v1alpha3.Config, error) {
+func (i *importer) Import(cfg v1alpha2.Config) (Config, error) {
 	i.importNamedFilters(cfg.Filters)
 	finalErr := i.resetError()
 
-	var rules []v1alpha3.Rule
+	var rules []Rule
 	for _, r := range cfg.Rules {
 		rules = append(rules, i.importRule(r))
 		if err := i.resetError(); err != nil {
@@ -36,9 +35,9 @@ func (i *importer) Import(cfg v1alpha2.Config) (v1alpha3.Config, error) {
 		}
 	}
 
-	return v1alpha3.Config{
-		Version: v1alpha3.Version,
-		Author: v1alpha3.Author{
+	return Config{
+		Version: Version,
+		Author: Author{
 			Name:  cfg.Author.Name,
 			Email: cfg.Author.Email,
 		},
@@ -62,10 +61,10 @@ func (i *importer) importNamedFilters(fs []v1alpha2.NamedFilter) {
 	i.err = finalErr
 }
 
-func (i *importer) importRule(r v1alpha2.Rule) v1alpha3.Rule {
-	return v1alpha3.Rule{
+func (i *importer) importRule(r v1alpha2.Rule) Rule {
+	return Rule{
 		Filter: i.importFilter(r.Filter),
-		Actions: v1alpha3.Actions{
+		Actions: Actions{
 			Archive:       r.Actions.Archive,
 			Delete:        r.Actions.Delete,
 			MarkRead:      r.Actions.MarkRead,
@@ -78,17 +77,17 @@ func (i *importer) importRule(r v1alpha2.Rule) v1alpha3.Rule {
 	}
 }
 
-func (i *importer) importFilter(f v1alpha2.FilterNode) v1alpha3.FilterNode {
+func (i *importer) importFilter(f v1alpha2.FilterNode) FilterNode {
 	if f.RefName != "" {
 		return i.importRefName(f.RefName)
 	}
 
-	var not *v1alpha3.FilterNode
+	var not *FilterNode
 	if f.Not != nil {
 		nf := i.importFilter(*f.Not)
 		not = &nf
 	}
-	return v1alpha3.FilterNode{
+	return FilterNode{
 		And:     i.importFilters(f.And),
 		Or:      i.importFilters(f.Or),
 		Not:     not,
@@ -102,15 +101,15 @@ func (i *importer) importFilter(f v1alpha2.FilterNode) v1alpha3.FilterNode {
 	}
 }
 
-func (i *importer) importFilters(ns []v1alpha2.FilterNode) []v1alpha3.FilterNode {
-	var res []v1alpha3.FilterNode
+func (i *importer) importFilters(ns []v1alpha2.FilterNode) []FilterNode {
+	var res []FilterNode
 	for _, f := range ns {
 		res = append(res, i.importFilter(f))
 	}
 	return res
 }
 
-func (i *importer) importRefName(name string) v1alpha3.FilterNode {
+func (i *importer) importRefName(name string) FilterNode {
 	if n, ok := i.nmap[name]; ok {
 		return n
 	}
@@ -125,4 +124,4 @@ func (i *importer) resetError() error {
 	return err
 }
 
-type namedFilterMap map[string]v1alpha3.FilterNode
+type namedFilterMap map[string]FilterNode
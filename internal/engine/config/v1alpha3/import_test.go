@@ -13,8 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 
-	"github.com/mbrt/gmailctl/cmd/gmailctl-config-migrate/v1alpha2"
-	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
 )
 
 func read(path string) io.Reader {
@@ -35,8 +34,8 @@ func parseV2(t *testing.T, path string) v1alpha2.Config {
 	return res
 }
 
-func parseV3(t *testing.T, path string) v1alpha3.Config {
-	var res v1alpha3.Config
+func parseV3(t *testing.T, path string) Config {
+	var res Config
 	dec := json.NewDecoder(read(path))
 	if err := dec.Decode(&res); err != nil {
 		t.Fatal(err)
@@ -44,7 +43,7 @@ func parseV3(t *testing.T, path string) v1alpha3.Config {
 	return res
 }
 
-func dump(t *testing.T, cfg v1alpha3.Config) string {
+func dump(t *testing.T, cfg Config) string {
 	b, err := yaml.Marshal(cfg)
 	if err != nil {
 		t.Fatal(err)
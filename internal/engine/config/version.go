@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// SupportedVersions are the versions of the config format a config can be
+// converted to. The oldest version is only migrated from, so it's not
+// supported.
+var SupportedVersions = []string{v1alpha3.Version, v1alpha2.Version}
+
+// CheckVersion returns an error if the version of the config format is not
+// supported.
+func CheckVersion(version string) error {
+	for _, v := range SupportedVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return errors.WithDetails(fmt.Errorf("unsupported config version: %s", version),
+		fmt.Sprintf("The supported versions are: %s.", strings.Join(SupportedVersions, ", ")))
+}
+
+// ConvertTo converts the config to the given version of the format. The
+// result is meant to be marshaled, e.g. by 'gmailctl download': v1alpha2
+// configs in YAML and the later ones in Jsonnet.
+//
+// Converting to an older version fails if the config uses features that it
+// doesn't support (see v1alpha3.Export).
+func ConvertTo(cfg v1alpha3.Config, version string) (interface{}, error) {
+	if err := CheckVersion(version); err != nil {
+		return nil, err
+	}
+	if version == v1alpha2.Version {
+		return v1alpha3.Export(cfg)
+	}
+	// The latest version is the one used internally.
+	cfg.Version = v1alpha3.Version
+	return cfg, nil
+}
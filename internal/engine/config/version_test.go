@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha2"
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func TestConvertTo(t *testing.T) {
+	cfg := v1alpha3.Config{
+		Rules: []v1alpha3.Rule{{
+			Filter:  v1alpha3.FilterNode{From: "a@b.com"},
+			Actions: v1alpha3.Actions{Archive: true},
+		}},
+	}
+	got, err := ConvertTo(cfg, LatestVersion)
+	require.Nil(t, err)
+	cfg.Version = LatestVersion
+	assert.Equal(t, cfg, got)
+
+	got, err = ConvertTo(cfg, "v1alpha2")
+	require.Nil(t, err)
+	assert.Equal(t, v1alpha2.Config{
+		Version: "v1alpha2",
+		Rules: []v1alpha2.Rule{{
+			Filter:  v1alpha2.FilterNode{From: "a@b.com"},
+			Actions: v1alpha2.Actions{Archive: true},
+		}},
+	}, got)
+
+	for _, v := range []string{"v1alpha1", "v2", ""} {
+		_, err := ConvertTo(cfg, v)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "unsupported config version")
+		assert.Contains(t, errors.Details(err), "v1alpha3")
+	}
+}
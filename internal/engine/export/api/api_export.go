@@ -51,6 +51,17 @@ func export(filter filter.Filter, lmap LabelMap) (*gmailv1.Filter, error) {
 	}, nil
 }
 
+// LabelChanges returns the IDs of the labels to add to and remove from a
+// message to perform the given actions on it. Forwarding is ignored, as it's
+// not a change of labels.
+func LabelChanges(action filter.Actions, lmap LabelMap) (add, remove []string, err error) {
+	a, err := exportAction(action, lmap)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.AddLabelIds, a.RemoveLabelIds, nil
+}
+
 func exportAction(action filter.Actions, lmap LabelMap) (*gmailv1.FilterAction, error) {
 	lops := labelOps{}
 	exportFlags(action, &lops)
@@ -166,3 +166,53 @@ func TestExportLabels(t *testing.T) {
 	_, err = Export(filters, emptyLabelMap())
 	assert.NotNil(t, err)
 }
+
+func TestExportCategories(t *testing.T) {
+	cases := map[gmail.Category]string{
+		gmail.CategoryPersonal:   labelIDCategoryPersonal,
+		gmail.CategorySocial:     labelIDCategorySocial,
+		gmail.CategoryUpdates:    labelIDCategoryUpdates,
+		gmail.CategoryForums:     labelIDCategoryForums,
+		gmail.CategoryPromotions: labelIDCategoryPromotions,
+	}
+	for cat, id := range cases {
+		t.Run(string(cat), func(t *testing.T) {
+			filters := filter.Filters{
+				{
+					Action:   filter.Actions{Category: cat},
+					Criteria: filter.Criteria{From: "foo@bar.com"},
+				},
+			}
+			exported, err := Export(filters, emptyLabelMap())
+			assert.Nil(t, err)
+			assert.Equal(t, []string{id}, exported[0].Action.AddLabelIds)
+		})
+	}
+}
+
+func TestExportInvalidCategory(t *testing.T) {
+	filters := filter.Filters{
+		{
+			Action:   filter.Actions{Category: "primary"},
+			Criteria: filter.Criteria{From: "foo@bar.com"},
+		},
+	}
+	_, err := Export(filters, emptyLabelMap())
+	assert.NotNil(t, err)
+}
+
+func TestLabelChanges(t *testing.T) {
+	lmap := NewLabelMap(label.Labels{{ID: "ID1", Name: "work"}})
+	add, remove, err := LabelChanges(filter.Actions{
+		Archive:  true,
+		MarkRead: true,
+		AddLabel: "work",
+		Forward:  "baz@zuz.it",
+	}, lmap)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ID1"}, add)
+	assert.Equal(t, []string{labelIDInbox, labelIDUnread}, remove)
+
+	_, _, err = LabelChanges(filter.Actions{AddLabel: "missing"}, lmap)
+	assert.NotNil(t, err)
+}
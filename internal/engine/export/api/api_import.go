@@ -1,7 +1,6 @@
 package api
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -11,8 +10,13 @@ import (
 
 	"github.com/mbrt/gmailctl/internal/engine/filter"
 	"github.com/mbrt/gmailctl/internal/engine/gmail"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
+// ErrUnsupportedFilter is the cause of the errors about the filters skipped by
+// the import, e.g. because they use actions gmailctl doesn't model.
+var ErrUnsupportedFilter = errors.New("unsupported filter")
+
 var (
 	// keep sorted
 	knownCriteriaFields = map[string]bool{
@@ -49,7 +53,10 @@ var (
 // Import exports Gmail filters into Gmail API objects.
 //
 // If some filter is invalid, the import skips it and returns only the
-// valid ones, but records and returns the error in the end.
+// valid ones, but records and returns the error in the end. The error
+// describes every skipped filter, including its criteria when they can be
+// read. The errors about actions that can't be imported, e.g. unknown
+// labels, have ErrUnsupportedFilter as cause, while invalid criteria don't.
 func Import(filters []*gmailv1.Filter, lmap LabelMap) (filter.Filters, error) {
 	res := filter.Filters{}
 	var reserr error
@@ -58,8 +65,11 @@ func Import(filters []*gmailv1.Filter, lmap LabelMap) (filter.Filters, error) {
 		impFilter, err := importFilter(gfilter, lmap)
 		if err != nil {
 			// We don't want to return here, but continue and skip the problematic filter
-			err = fmt.Errorf("importing filter %q: %w", gfilter.Id, err)
-			reserr = multierror.Append(reserr, err)
+			name := fmt.Sprintf("%q", gfilter.Id)
+			if q := impFilter.Criteria.ToGmailSearch(); q != "" {
+				name = fmt.Sprintf("%q (%s)", gfilter.Id, q)
+			}
+			reserr = multierror.Append(reserr, fmt.Errorf("importing filter %s: %w", name, err))
 		} else {
 			res = append(res, impFilter)
 		}
@@ -68,15 +78,19 @@ func Import(filters []*gmailv1.Filter, lmap LabelMap) (filter.Filters, error) {
 	return res, reserr
 }
 
+// importFilter converts a Gmail filter. On errors the criteria are still
+// returned, if they could be converted, to help identifying the filter.
+// Errors importing the action have ErrUnsupportedFilter as cause.
 func importFilter(gf *gmailv1.Filter, lmap LabelMap) (filter.Filter, error) {
-	action, err := importAction(gf.Action, lmap)
-	if err != nil {
-		return filter.Filter{}, fmt.Errorf("importing action: %w", err)
-	}
 	criteria, err := importCriteria(gf.Criteria)
 	if err != nil {
 		return filter.Filter{}, fmt.Errorf("importing criteria: %w", err)
 	}
+	action, err := importAction(gf.Action, lmap)
+	if err != nil {
+		return filter.Filter{Criteria: criteria}, errors.WithCause(
+			fmt.Errorf("importing action: %w", err), ErrUnsupportedFilter)
+	}
 	return filter.Filter{
 		ID:       gf.Id,
 		Action:   action,
@@ -150,7 +164,7 @@ func importRemoveLabels(res *filter.Actions, removeLabelIDs []string) error {
 			res.MarkNotSpam = true
 		default:
 			// filters not added by us are not supported
-			return fmt.Errorf("unupported label to remove %q", labelID)
+			return fmt.Errorf("unsupported label to remove %q", labelID)
 		}
 	}
 	return nil
@@ -4,11 +4,13 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	gmailv1 "google.golang.org/api/gmail/v1"
 
 	"github.com/mbrt/gmailctl/internal/engine/filter"
 	"github.com/mbrt/gmailctl/internal/engine/gmail"
 	"github.com/mbrt/gmailctl/internal/engine/label"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
 func TestImportActions(t *testing.T) {
@@ -196,3 +198,106 @@ func TestImportBad(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Len(t, imported, 1)
 }
+
+func TestImportUnknownLabels(t *testing.T) {
+	filters := []*gmailv1.Filter{
+		{
+			// Removing the star is not modeled.
+			Id: "unstar",
+			Action: &gmailv1.FilterAction{
+				RemoveLabelIds: []string{labelIDInbox, labelIDStar},
+			},
+			Criteria: &gmailv1.FilterCriteria{From: "foo@bar.com"},
+		},
+		{
+			Id: "unknown",
+			Action: &gmailv1.FilterAction{
+				AddLabelIds: []string{"Label_42"},
+			},
+			Criteria: &gmailv1.FilterCriteria{Query: "list:dev"},
+		},
+		{
+			Id: "ok",
+			Action: &gmailv1.FilterAction{
+				AddLabelIds: []string{labelIDTrash},
+			},
+			Criteria: &gmailv1.FilterCriteria{From: "spam@bar.com"},
+		},
+	}
+	imported, err := Import(filters, emptyLabelMap())
+	assert.Equal(t, filter.Filters{{
+		ID:       "ok",
+		Action:   filter.Actions{Delete: true},
+		Criteria: filter.Criteria{From: "spam@bar.com"},
+	}}, imported)
+
+	// The skipped filters are reported with their criteria.
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupportedFilter))
+	assert.Contains(t, err.Error(), `importing filter "unstar" (from:foo@bar.com): unsupported filter: importing action: unsupported label to remove "STARRED"`)
+	assert.Contains(t, err.Error(), `importing filter "unknown" (list:dev): unsupported filter: importing action: unknown label ID 'Label_42'`)
+
+	// Skipped filters are never silent, even when nothing is left.
+	imported, err = Import(filters[:1], emptyLabelMap())
+	assert.Empty(t, imported)
+	assert.True(t, errors.Is(err, ErrUnsupportedFilter))
+
+	// Invalid criteria are not only unsupported.
+	imported, err = Import([]*gmailv1.Filter{{
+		Id:       "size",
+		Action:   &gmailv1.FilterAction{AddLabelIds: []string{labelIDTrash}},
+		Criteria: &gmailv1.FilterCriteria{Size: 1000},
+	}}, emptyLabelMap())
+	assert.Empty(t, imported)
+	require.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrUnsupportedFilter))
+	assert.Contains(t, err.Error(), `importing filter "size": importing criteria`)
+}
+
+func TestImportanceRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		action filter.Actions
+		add    []string
+		remove []string
+	}{
+		{
+			name:   "mark important",
+			action: filter.Actions{MarkImportant: true},
+			add:    []string{labelIDImportant},
+		},
+		{
+			name:   "never mark important",
+			action: filter.Actions{MarkNotImportant: true},
+			remove: []string{labelIDImportant},
+		},
+		{
+			name:   "never mark important and spam",
+			action: filter.Actions{MarkNotImportant: true, MarkNotSpam: true, Archive: true},
+			remove: []string{labelIDInbox, labelIDImportant, labelIDSpam},
+		},
+		{
+			name:   "mark important with category",
+			action: filter.Actions{MarkImportant: true, Category: gmail.CategoryPersonal},
+			add:    []string{labelIDImportant, labelIDCategoryPersonal},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := filter.Filters{{
+				Criteria: filter.Criteria{From: "foo@bar.com"},
+				Action:   tc.action,
+			}}
+			exported, err := Export(fs, emptyLabelMap())
+			require.Nil(t, err)
+			require.Len(t, exported, 1)
+			assert.Equal(t, tc.add, exported[0].Action.AddLabelIds)
+			assert.Equal(t, tc.remove, exported[0].Action.RemoveLabelIds)
+
+			imported, err := Import(exported, emptyLabelMap())
+			require.Nil(t, err)
+			assert.Equal(t, fs, imported)
+		})
+	}
+}
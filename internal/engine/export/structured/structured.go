@@ -0,0 +1,102 @@
+// Package structured exports filters as JSON or YAML documents.
+//
+// The documents are meant to be kept in version control, so the output is
+// deterministic: filters are in the order of the rules generating them, keys
+// are sorted and only the fields with a value are written.
+package structured
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+)
+
+// Format is a supported output format.
+type Format string
+
+// Supported formats.
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// Export writes the given filters in the given format.
+func Export(filters filter.Filters, format Format, w io.Writer) error {
+	doc := map[string]interface{}{
+		"filters": filterValues(filters),
+	}
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// filterValues converts the filters into maps, which both encoders write with
+// sorted keys.
+func filterValues(filters filter.Filters) []interface{} {
+	// An empty list is written instead of null.
+	res := []interface{}{}
+	for _, f := range filters {
+		v := map[string]interface{}{
+			"criteria": criteriaValues(f.Criteria),
+			"actions":  actionValues(f.Action),
+		}
+		if f.RuleName != "" {
+			v["rule"] = f.RuleName
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+func criteriaValues(c filter.Criteria) map[string]interface{} {
+	res := map[string]interface{}{}
+	setString(res, "from", c.From)
+	setString(res, "to", c.To)
+	setString(res, "subject", c.Subject)
+	setString(res, "query", c.Query)
+	return res
+}
+
+func actionValues(a filter.Actions) map[string]interface{} {
+	res := map[string]interface{}{}
+	setString(res, "label", a.AddLabel)
+	setString(res, "category", string(a.Category))
+	setBool(res, "archive", a.Archive)
+	setBool(res, "delete", a.Delete)
+	setBool(res, "markImportant", a.MarkImportant)
+	setBool(res, "markNotImportant", a.MarkNotImportant)
+	setBool(res, "markRead", a.MarkRead)
+	setBool(res, "markNotSpam", a.MarkNotSpam)
+	setBool(res, "star", a.Star)
+	setString(res, "forward", a.Forward)
+	return res
+}
+
+func setString(m map[string]interface{}, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+func setBool(m map[string]interface{}, key string, value bool) {
+	if value {
+		m[key] = true
+	}
+}
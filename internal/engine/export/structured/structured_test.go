@@ -0,0 +1,63 @@
+package structured
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+// update is useful to regenerate the golden files
+// Make sure the new version makes sense!!
+var update = flag.Bool("update", false, "update golden files")
+
+func readFilters(t *testing.T) filter.Filters {
+	t.Helper()
+	cfg, _, err := config.ReadFile(filepath.Join("testdata", "config.jsonnet"), "", config.ReadOptions{})
+	require.Nil(t, err)
+	rules, err := parser.Parse(cfg)
+	require.Nil(t, err)
+	fs, err := filter.FromRules(rules)
+	require.Nil(t, err)
+	return fs
+}
+
+func TestExport(t *testing.T) {
+	fs := readFilters(t)
+
+	for _, format := range []Format{FormatJSON, FormatYAML} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.Nil(t, Export(fs, format, &buf))
+
+			golden := filepath.Join("testdata", "filters."+string(format))
+			if *update {
+				require.Nil(t, os.WriteFile(golden, buf.Bytes(), 0o600))
+			}
+			b, err := os.ReadFile(golden)
+			require.Nil(t, err)
+			assert.Equal(t, string(b), buf.String())
+
+			// The output is deterministic.
+			var buf2 bytes.Buffer
+			require.Nil(t, Export(fs, format, &buf2))
+			assert.Equal(t, buf.String(), buf2.String())
+		})
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, Export(nil, FormatJSON, &buf))
+	assert.Equal(t, "{\n  \"filters\": []\n}\n", buf.String())
+
+	assert.NotNil(t, Export(nil, Format("xml"), &buf))
+}
@@ -0,0 +1,214 @@
+// Package terraform exports filters as Terraform resources.
+//
+// The resources follow the schema of the Gmail API: filters are
+// 'google_gmail_filter' resources with 'criteria' and 'action' blocks and
+// labels referenced by them are 'google_gmail_label' resources.
+package terraform
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gmailv1 "google.golang.org/api/gmail/v1"
+
+	"github.com/mbrt/gmailctl/internal/engine/export/api"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+)
+
+const (
+	filterResource = "google_gmail_filter"
+	labelResource  = "google_gmail_label"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// Export writes the given filters as Terraform resources.
+func Export(filters filter.Filters, w io.Writer) error {
+	labels := newLabelResources(filters)
+	gfilters, err := api.Export(filters, labels.lmap)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	hw := &hclWriter{w: bw}
+	for _, l := range labels.names {
+		hw.OpenBlock(fmt.Sprintf("resource %q %q", labelResource, labels.resources[l]))
+		hw.Attributes(attribute{"name", quote(l)})
+		hw.CloseBlock()
+		hw.Newline()
+	}
+	for i, f := range gfilters {
+		if i > 0 {
+			hw.Newline()
+		}
+		writeFilter(hw, fmt.Sprintf("filter_%d", i), f, labels)
+	}
+	if hw.err != nil {
+		return hw.err
+	}
+	return bw.Flush()
+}
+
+func writeFilter(hw *hclWriter, name string, f *gmailv1.Filter, labels labelResources) {
+	hw.OpenBlock(fmt.Sprintf("resource %q %q", filterResource, name))
+
+	hw.OpenBlock("criteria")
+	hw.Attributes(
+		stringAttribute("from", f.Criteria.From),
+		stringAttribute("to", f.Criteria.To),
+		stringAttribute("subject", f.Criteria.Subject),
+		stringAttribute("query", f.Criteria.Query),
+	)
+	hw.CloseBlock()
+
+	hw.OpenBlock("action")
+	hw.Attributes(
+		listAttribute("add_label_ids", labels.references(f.Action.AddLabelIds)),
+		listAttribute("remove_label_ids", labels.references(f.Action.RemoveLabelIds)),
+		stringAttribute("forward", f.Action.Forward),
+	)
+	hw.CloseBlock()
+
+	hw.CloseBlock()
+}
+
+// labelResources maps the user labels to Terraform resources.
+type labelResources struct {
+	// names of the labels, in order of appearance.
+	names []string
+	// resources maps label names to resource names.
+	resources map[string]string
+	// lmap maps label names to resource references, used in place of IDs.
+	lmap api.LabelMap
+	// refs contains the references to the label IDs.
+	refs map[string]bool
+}
+
+func newLabelResources(filters filter.Filters) labelResources {
+	res := labelResources{
+		resources: map[string]string{},
+		lmap:      api.NewLabelMap(nil),
+		refs:      map[string]bool{},
+	}
+	used := map[string]bool{}
+
+	for _, f := range filters {
+		l := f.Action.AddLabel
+		if l == "" {
+			continue
+		}
+		if _, ok := res.resources[l]; ok {
+			continue
+		}
+		name := resourceName(l)
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", resourceName(l), i)
+		}
+		used[name] = true
+		ref := fmt.Sprintf("%s.%s.id", labelResource, name)
+
+		res.names = append(res.names, l)
+		res.resources[l] = name
+		res.lmap.AddLabel(ref, l)
+		res.refs[ref] = true
+	}
+
+	return res
+}
+
+// references returns the HCL expressions of the given label IDs: either
+// references to label resources or quoted system label IDs.
+func (r labelResources) references(ids []string) []string {
+	var res []string
+	for _, id := range ids {
+		if r.refs[id] {
+			res = append(res, id)
+		} else {
+			res = append(res, quote(id))
+		}
+	}
+	return res
+}
+
+func resourceName(label string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(label), "_")
+	return "label_" + strings.Trim(name, "_")
+}
+
+// quote returns the given string as an HCL string literal.
+func quote(s string) string {
+	q := strconv.Quote(s)
+	// Template sequences need to be escaped in HCL.
+	q = strings.ReplaceAll(q, "${", "$${")
+	return strings.ReplaceAll(q, "%{", "%%{")
+}
+
+type attribute struct {
+	name  string
+	value string
+}
+
+func stringAttribute(name, value string) attribute {
+	if value == "" {
+		return attribute{}
+	}
+	return attribute{name, quote(value)}
+}
+
+func listAttribute(name string, values []string) attribute {
+	if len(values) == 0 {
+		return attribute{}
+	}
+	return attribute{name, fmt.Sprintf("[%s]", strings.Join(values, ", "))}
+}
+
+type hclWriter struct {
+	w     io.Writer
+	level int
+	err   error
+}
+
+func (h *hclWriter) OpenBlock(header string) {
+	h.writeLine(header + " {")
+	h.level++
+}
+
+func (h *hclWriter) CloseBlock() {
+	h.level--
+	h.writeLine("}")
+}
+
+func (h *hclWriter) Newline() {
+	h.writeLine("")
+}
+
+// Attributes writes the non-empty attributes, aligned as 'terraform fmt' does.
+func (h *hclWriter) Attributes(as ...attribute) {
+	width := 0
+	for _, a := range as {
+		if len(a.name) > width {
+			width = len(a.name)
+		}
+	}
+	for _, a := range as {
+		if a.name == "" {
+			continue
+		}
+		h.writeLine(fmt.Sprintf("%-*s = %s", width, a.name, a.value))
+	}
+}
+
+func (h *hclWriter) writeLine(s string) {
+	if h.err != nil {
+		return
+	}
+	if s != "" {
+		s = strings.Repeat("  ", h.level) + s
+	}
+	_, h.err = io.WriteString(h.w, s+"\n")
+}
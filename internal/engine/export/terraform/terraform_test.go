@@ -0,0 +1,104 @@
+package terraform
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+const expectedHCL = `resource "google_gmail_label" "label_work_projects" {
+  name = "Work/Projects"
+}
+
+resource "google_gmail_filter" "filter_0" {
+  criteria {
+    from = "boss@work.com"
+  }
+  action {
+    add_label_ids    = ["IMPORTANT", google_gmail_label.label_work_projects.id]
+    remove_label_ids = ["INBOX"]
+  }
+}
+
+resource "google_gmail_filter" "filter_1" {
+  criteria {
+    subject = "digest"
+    query   = "list:{news@example.com \"$${weekly}\"}"
+  }
+  action {
+    add_label_ids    = ["CATEGORY_UPDATES"]
+    remove_label_ids = ["UNREAD"]
+    forward          = "me@example.com"
+  }
+}
+`
+
+func TestExport(t *testing.T) {
+	important := true
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{From: "boss@work.com"},
+				Actions: cfg.Actions{
+					Archive:       true,
+					MarkImportant: &important,
+					Labels:        []string{"Work/Projects"},
+				},
+			},
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{
+							{List: "news@example.com"},
+							{List: "${weekly}"},
+						}},
+						{Subject: "digest"},
+					},
+				},
+				Actions: cfg.Actions{
+					MarkRead: true,
+					Category: "updates",
+					Forward:  "me@example.com",
+				},
+			},
+		},
+	}
+	rules, err := parser.Parse(config)
+	require.Nil(t, err)
+	filters, err := filter.FromRules(rules)
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = Export(filters, &buf)
+	require.Nil(t, err)
+	assert.Equal(t, expectedHCL, buf.String())
+}
+
+func TestExportLabelNameCollision(t *testing.T) {
+	filters := filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "a"},
+			Action:   filter.Actions{AddLabel: "foo bar"},
+		},
+		{
+			Criteria: filter.Criteria{From: "b"},
+			Action:   filter.Actions{AddLabel: "foo/bar"},
+		},
+		{
+			Criteria: filter.Criteria{From: "c"},
+			Action:   filter.Actions{AddLabel: "foo bar"},
+		},
+	}
+	labels := newLabelResources(filters)
+	assert.Equal(t, []string{"foo bar", "foo/bar"}, labels.names)
+	assert.Equal(t, map[string]string{
+		"foo bar": "label_foo_bar",
+		"foo/bar": "label_foo_bar_1",
+	}, labels.resources)
+}
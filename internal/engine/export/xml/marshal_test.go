@@ -0,0 +1,43 @@
+package xml
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+// update is useful to regenerate the golden files
+// Make sure the new version makes sense!!
+var update = flag.Bool("update", false, "update golden files")
+
+func TestExport(t *testing.T) {
+	cfg, _, err := config.ReadFile(filepath.Join("testdata", "config.jsonnet"), "", config.ReadOptions{})
+	require.Nil(t, err)
+	rules, err := parser.Parse(cfg)
+	require.Nil(t, err)
+	fs, err := filter.FromRules(rules)
+	require.Nil(t, err)
+
+	now := time.Date(2018, 3, 8, 17, 0, 0, 0, time.UTC)
+	exp := NewWithTime(func() time.Time { return now })
+	var buf bytes.Buffer
+	require.Nil(t, exp.Export(cfg.Author, fs, &buf))
+
+	golden := filepath.Join("testdata", "filters.xml")
+	if *update {
+		require.Nil(t, os.WriteFile(golden, buf.Bytes(), 0o600))
+	}
+	b, err := os.ReadFile(golden)
+	require.Nil(t, err)
+	assert.Equal(t, string(b), buf.String())
+}
@@ -1,30 +1,46 @@
 package filter
 
 import (
-	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
 )
 
-// There's no documented limit on filter size on Gmail, but this educated guess
-// is better than nothing.
-const defaultSizeLimit = 20
+const (
+	// There's no documented limit on filter size on Gmail, but this educated guess
+	// is better than nothing.
+	defaultSizeLimit = 20
+	// Gmail silently truncates or rejects criteria longer than this.
+	maxQueryLength = 1500
+)
 
 // FromRules translates rules into entries that map directly into Gmail filters.
 func FromRules(rs []parser.Rule) (Filters, error) {
 	return FromRulesWithLimit(rs, defaultSizeLimit)
 }
 
+// FromRulesIndexed is like FromRules, but reports the errors with the given
+// indexes of the rules, e.g. the config rules they come from.
+func FromRulesIndexed(rs []parser.Rule, indexes []int) (Filters, error) {
+	return fromRules(rs, defaultSizeLimit, func(i int) int { return indexes[i] })
+}
+
 // FromRulesWithLimit translates rules into entries that map directly into
 // Gmail, but uses a custom size limit.
 func FromRulesWithLimit(rs []parser.Rule, sizeLimit int) (Filters, error) {
+	return fromRules(rs, sizeLimit, func(i int) int { return i })
+}
+
+func fromRules(rs []parser.Rule, sizeLimit int, index func(int) int) (Filters, error) {
 	res := Filters{}
 	for i, rule := range rs {
 		filters, err := FromRule(rule, sizeLimit)
 		if err != nil {
-			return res, fmt.Errorf("generating rule #%d: %w", i, err)
+			return res, fmt.Errorf("generating rule #%d: %w", index(i), err)
+		}
+		if err := checkQueryLength(filters); err != nil {
+			return res, fmt.Errorf("generating rule #%d: %w", index(i), err)
 		}
 		res = append(res, filters...)
 	}
@@ -47,7 +63,11 @@ func FromRule(rule parser.Rule, sizeLimit int) (Filters, error) {
 		return nil, fmt.Errorf("generating actions: %w", err)
 	}
 
-	return combineCriteriaWithActions(crits, actions), nil
+	res := combineCriteriaWithActions(crits, actions)
+	for i := range res {
+		res[i].RuleName = rule.Name
+	}
+	return res, nil
 }
 
 // GenerateCriteria translates a rule criteria into an entry that maps
@@ -63,202 +83,48 @@ func GenerateCriteria(crit parser.CriteriaAST) (Criteria, error) {
 }
 
 func generateNode(node *parser.Node) (Criteria, error) {
-	switch node.Operation {
-	case parser.OperationOr:
-		query := ""
-		for _, child := range node.Children {
-			cq, err := generateCriteriaAsString(child)
-			if err != nil {
-				return Criteria{}, err
-			}
-			query = joinQueries(query, cq)
-		}
-		return Criteria{
-			Query: fmt.Sprintf("{%s}", query),
-		}, nil
-
-	case parser.OperationAnd:
-		res := Criteria{}
-		for _, child := range node.Children {
-			crit, err := GenerateCriteria(child)
-			if err != nil {
-				return res, err
-			}
-			res = joinCriteria(res, crit)
-
-		}
-		return res, nil
+	if node.Operation != parser.OperationAnd {
+		// Only the conjunction of criteria can be split across the fields.
+		query, err := parser.GenerateQuery(node)
+		return Criteria{Query: query}, err
+	}
 
-	case parser.OperationNot:
-		if ln := len(node.Children); ln != 1 {
-			return Criteria{}, fmt.Errorf("after 'not' got %d children, expected 1", ln)
+	res := Criteria{}
+	for _, child := range node.Children {
+		crit, err := GenerateCriteria(child)
+		if err != nil {
+			return res, err
 		}
-		cq, err := generateCriteriaAsString(node.Children[0])
-		return Criteria{
-			Query: fmt.Sprintf("-%s", cq),
-		}, err
+		res = joinCriteria(res, crit)
 	}
-
-	return Criteria{}, fmt.Errorf("unknown node operation %d", node.Operation)
+	return res, nil
 }
 
 func generateLeaf(leaf *parser.Leaf) (Criteria, error) {
-	needEscape := leaf.Function != parser.FunctionQuery && !leaf.IsRaw
-	query, err := joinStrings(needEscape, leaf.Args...)
-	if err != nil {
-		return Criteria{}, err
-	}
-	if len(leaf.Args) > 1 {
-		if query, err = groupWithOperation(query, leaf.Grouping); err != nil {
-			return Criteria{}, err
-		}
-	}
-
 	switch leaf.Function {
 	case parser.FunctionFrom:
-		return Criteria{
-			From: query,
-		}, nil
+		query, err := leaf.ArgsQuery()
+		return Criteria{From: query}, err
 	case parser.FunctionTo:
-		return Criteria{
-			To: query,
-		}, nil
+		query, err := leaf.ArgsQuery()
+		return Criteria{To: query}, err
 	case parser.FunctionSubject:
-		return Criteria{
-			Subject: query,
-		}, nil
-	case parser.FunctionCc:
-		return Criteria{
-			Query: fmt.Sprintf("cc:%s", query),
-		}, nil
-	case parser.FunctionBcc:
-		return Criteria{
-			Query: fmt.Sprintf("bcc:%s", query),
-		}, nil
-	case parser.FunctionReplyTo:
-		return Criteria{
-			Query: fmt.Sprintf("replyto:%s", query),
-		}, nil
-	case parser.FunctionList:
-		return Criteria{
-			Query: fmt.Sprintf("list:%s", query),
-		}, nil
-	case parser.FunctionHas, parser.FunctionQuery:
-		return Criteria{
-			Query: query,
-		}, nil
+		query, err := leaf.ArgsQuery()
+		return Criteria{Subject: query}, err
 	default:
-		return Criteria{}, fmt.Errorf("unknown function type %d", leaf.Function)
-	}
-}
-
-func generateCriteriaAsString(crit parser.CriteriaAST) (string, error) {
-	if node, ok := crit.(*parser.Node); ok {
-		return generateNodeAsString(node)
-	}
-	if leaf, ok := crit.(*parser.Leaf); ok {
-		return generateLeafAsString(leaf)
-	}
-	return "", errors.New("found unknown criteria node")
-}
-
-func generateNodeAsString(node *parser.Node) (string, error) {
-	query := ""
-	for _, child := range node.Children {
-		cq, err := generateCriteriaAsString(child)
-		if err != nil {
-			return "", err
-		}
-		query = joinQueries(query, cq)
-	}
-	return groupWithOperation(query, node.Operation)
-}
-
-func generateLeafAsString(leaf *parser.Leaf) (string, error) {
-	needEscape := leaf.Function != parser.FunctionQuery && !leaf.IsRaw
-	query, err := joinStrings(needEscape, leaf.Args...)
-	if err != nil {
-		return "", err
-	}
-	if len(leaf.Args) > 1 {
-		if query, err = groupWithOperation(query, leaf.Grouping); err != nil {
-			return "", err
-		}
-	}
-
-	switch leaf.Function {
-	case parser.FunctionHas, parser.FunctionQuery:
-		return query, nil
-	default:
-		return fmt.Sprintf("%v:%s", leaf.Function, query), nil
-	}
-}
-
-func groupWithOperation(query string, op parser.OperationType) (string, error) {
-	switch op {
-	case parser.OperationOr:
-		return fmt.Sprintf("{%s}", query), nil
-
-	case parser.OperationAnd:
-		return fmt.Sprintf("(%s)", query), nil
-
-	case parser.OperationNot:
-		return fmt.Sprintf("-%s", query), nil
-	default:
-		return "", fmt.Errorf("unknown node operation %d", op)
+		// The other functions can only be expressed in the query.
+		query, err := parser.GenerateQuery(leaf)
+		return Criteria{Query: query}, err
 	}
 }
 
 func joinCriteria(c1, c2 Criteria) Criteria {
 	return Criteria{
-		From:    joinQueries(c1.From, c2.From),
-		To:      joinQueries(c1.To, c2.To),
-		Subject: joinQueries(c1.Subject, c2.Subject),
-		Query:   joinQueries(c1.Query, c2.Query),
-	}
-}
-
-func joinQueries(f1, f2 string) string {
-	// No need to escape queries because they are either logical operations
-	// or functions.
-	if f1 == "" {
-		return f2
-	}
-	if f2 == "" {
-		return f1
+		From:    parser.JoinQueries(c1.From, c2.From),
+		To:      parser.JoinQueries(c1.To, c2.To),
+		Subject: parser.JoinQueries(c1.Subject, c2.Subject),
+		Query:   parser.JoinQueries(c1.Query, c2.Query),
 	}
-	return fmt.Sprintf("%s %s", f1, f2)
-}
-
-func joinStrings(escape bool, a ...string) (string, error) {
-	if escape {
-		for _, a := range a {
-			if strings.Contains(a, `"`) {
-				return "", fmt.Errorf("invalid quote in %q", a)
-			}
-		}
-		return joinEscaped(a...), nil
-	}
-	return strings.Join(a, " "), nil
-}
-
-func joinEscaped(a ...string) string {
-	return strings.Join(escapeStrings(a...), " ")
-}
-
-func escapeStrings(a ...string) []string {
-	res := make([]string, len(a))
-	for i, s := range a {
-		res[i] = escape(s)
-	}
-	return res
-}
-
-func escape(a string) string {
-	if strings.ContainsAny(a, " \t{}()") {
-		return fmt.Sprintf(`"%s"`, a)
-	}
-	return a
 }
 
 func splitCriteria(tree parser.CriteriaAST, limit int) []parser.CriteriaAST {
@@ -297,6 +163,7 @@ func (v *splitVisitor) VisitLeaf(n *parser.Leaf) {
 			Function: n.Function,
 			Grouping: n.Grouping,
 			IsRaw:    n.IsRaw,
+			IsPhrase: n.IsPhrase,
 			Args:     rem[:v.limit],
 		})
 		rem = rem[v.limit:]
@@ -306,6 +173,7 @@ func (v *splitVisitor) VisitLeaf(n *parser.Leaf) {
 		Function: n.Function,
 		Grouping: n.Grouping,
 		IsRaw:    n.IsRaw,
+		IsPhrase: n.IsPhrase,
 		Args:     rem,
 	})
 }
@@ -441,6 +309,19 @@ func splitRootOr(tree parser.CriteriaAST) []parser.CriteriaAST {
 	return root.Children
 }
 
+func checkQueryLength(fs Filters) error {
+	for _, f := range fs {
+		if l := len(f.Criteria.ToGmailSearch()); l > maxQueryLength {
+			return errors.WithDetails(
+				fmt.Errorf("generated query is %d bytes long, exceeding the limit of %d", l, maxQueryLength),
+				"Gmail doesn't apply filters with criteria that are too long.\n"+
+					"Consider splitting up the rule into smaller ones.",
+			)
+		}
+	}
+	return nil
+}
+
 func generateActions(actions parser.Actions) ([]Actions, error) {
 	res := []Actions{
 		{
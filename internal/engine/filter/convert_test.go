@@ -1,9 +1,12 @@
 package filter
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mbrt/gmailctl/internal/engine/gmail"
 	"github.com/mbrt/gmailctl/internal/engine/parser"
@@ -364,6 +367,24 @@ func TestActions(t *testing.T) {
 	assert.Equal(t, expected, got)
 }
 
+func TestMarkSpamError(t *testing.T) {
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Leaf{
+				Function: parser.FunctionFrom,
+				Args:     []string{"a"},
+			},
+			Actions: parser.Actions{
+				Archive:  true,
+				MarkSpam: boolptr(true),
+			},
+		},
+	}
+	_, err := FromRules(rules)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Gmail filters don't allow one to send messages to spam directly")
+}
+
 func TestDoubleQuoteError(t *testing.T) {
 	rules := []parser.Rule{
 		{
@@ -381,3 +402,491 @@ func TestDoubleQuoteError(t *testing.T) {
 	_, err := FromRules(rules)
 	assert.ErrorContains(t, err, "invalid quote")
 }
+
+func TestDeliveredTo(t *testing.T) {
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Node{
+				Operation: parser.OperationAnd,
+				Children: []parser.CriteriaAST{
+					&parser.Leaf{
+						Function: parser.FunctionDeliveredTo,
+						Grouping: parser.OperationOr,
+						Args:     []string{"a@b.com", "c@d.com"},
+					},
+					&parser.Node{
+						Operation: parser.OperationNot,
+						Children: []parser.CriteriaAST{
+							&parser.Leaf{
+								Function: parser.FunctionDeliveredTo,
+								Args:     []string{"e@f.com"},
+							},
+						},
+					},
+				},
+			},
+			Actions: parser.Actions{
+				Archive: true,
+			},
+		},
+	}
+	expected := Filters{
+		{
+			Criteria: Criteria{
+				Query: "deliveredto:{a@b.com c@d.com} -deliveredto:e@f.com",
+			},
+			Action: Actions{
+				Archive: true,
+			},
+		},
+	}
+	got, err := FromRules(rules)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestList(t *testing.T) {
+	lists := []string{
+		"announce.example.com",
+		"dev@lists.com",
+		"users@lists.com",
+		"golang-nuts.googlegroups.com",
+		"security@lists.com",
+	}
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Leaf{
+				Function: parser.FunctionList,
+				Grouping: parser.OperationOr,
+				Args:     lists,
+			},
+			Actions: parser.Actions{Archive: true},
+		},
+	}
+	expected := Filters{
+		{
+			Criteria: Criteria{
+				Query: "list:{announce.example.com dev@lists.com users@lists.com golang-nuts.googlegroups.com security@lists.com}",
+			},
+			Action: Actions{Archive: true},
+		},
+	}
+	got, err := FromRules(rules)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestSize(t *testing.T) {
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Node{
+				Operation: parser.OperationAnd,
+				Children: []parser.CriteriaAST{
+					&parser.Leaf{
+						Function: parser.FunctionLarger,
+						Args:     []string{"5M"},
+					},
+					&parser.Leaf{
+						Function: parser.FunctionSmaller,
+						Args:     []string{"10M"},
+					},
+				},
+			},
+			Actions: parser.Actions{
+				Archive: true,
+			},
+		},
+	}
+	expected := Filters{
+		{
+			Criteria: Criteria{
+				Query: "larger:5M smaller:10M",
+			},
+			Action: Actions{
+				Archive: true,
+			},
+		},
+	}
+	got, err := FromRules(rules)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestRelativeDate(t *testing.T) {
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Node{
+				Operation: parser.OperationAnd,
+				Children: []parser.CriteriaAST{
+					&parser.Leaf{
+						Function: parser.FunctionNewerThan,
+						Args:     []string{"7d"},
+					},
+					&parser.Leaf{
+						Function: parser.FunctionOlderThan,
+						Args:     []string{"1d"},
+					},
+				},
+			},
+			Actions: parser.Actions{
+				Archive: true,
+			},
+		},
+	}
+	expected := Filters{
+		{
+			Criteria: Criteria{
+				Query: "newer_than:7d older_than:1d",
+			},
+			Action: Actions{
+				Archive: true,
+			},
+		},
+	}
+	got, err := FromRules(rules)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestInIs(t *testing.T) {
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Node{
+				Operation: parser.OperationAnd,
+				Children: []parser.CriteriaAST{
+					&parser.Leaf{
+						Function: parser.FunctionIn,
+						Args:     []string{"anywhere"},
+					},
+					&parser.Node{
+						Operation: parser.OperationNot,
+						Children: []parser.CriteriaAST{
+							&parser.Leaf{
+								Function: parser.FunctionIs,
+								Args:     []string{"starred"},
+							},
+						},
+					},
+				},
+			},
+			Actions: parser.Actions{
+				Archive: true,
+			},
+		},
+	}
+	expected := Filters{
+		{
+			Criteria: Criteria{
+				Query: "in:anywhere -is:starred",
+			},
+			Action: Actions{
+				Archive: true,
+			},
+		},
+	}
+	got, err := FromRules(rules)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestAttachment(t *testing.T) {
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Node{
+				Operation: parser.OperationAnd,
+				Children: []parser.CriteriaAST{
+					&parser.Leaf{
+						Function: parser.FunctionHasAttachment,
+					},
+					&parser.Leaf{
+						Function: parser.FunctionFilename,
+						Grouping: parser.OperationOr,
+						Args:     []string{"pdf", "invoice.doc"},
+					},
+				},
+			},
+			Actions: parser.Actions{
+				Archive: true,
+			},
+		},
+		{
+			// Nested in an 'or', leaves are generated as plain strings.
+			Criteria: &parser.Node{
+				Operation: parser.OperationAnd,
+				Children: []parser.CriteriaAST{
+					&parser.Leaf{
+						Function: parser.FunctionSubject,
+						Args:     []string{"invoice"},
+					},
+					&parser.Node{
+						Operation: parser.OperationOr,
+						Children: []parser.CriteriaAST{
+							&parser.Leaf{
+								Function: parser.FunctionFrom,
+								Args:     []string{"a@b.com"},
+							},
+							&parser.Node{
+								Operation: parser.OperationNot,
+								Children: []parser.CriteriaAST{
+									&parser.Leaf{
+										Function: parser.FunctionHasAttachment,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Actions: parser.Actions{
+				Archive: true,
+			},
+		},
+	}
+	expected := Filters{
+		{
+			Criteria: Criteria{
+				Query: "has:attachment filename:{pdf invoice.doc}",
+			},
+			Action: Actions{
+				Archive: true,
+			},
+		},
+		{
+			Criteria: Criteria{
+				Subject: "invoice",
+				Query:   "{from:a@b.com -has:attachment}",
+			},
+			Action: Actions{
+				Archive: true,
+			},
+		},
+	}
+	got, err := FromRules(rules)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+// nestedCriteria builds a balanced criteria tree of the given depth, with
+// redundant groupings and double negations that need to be simplified.
+func nestedCriteria(depth, width int) parser.CriteriaAST {
+	functions := []parser.FunctionType{
+		parser.FunctionFrom,
+		parser.FunctionTo,
+		parser.FunctionSubject,
+		parser.FunctionList,
+	}
+	next := 0
+
+	var build func(level int) parser.CriteriaAST
+	build = func(level int) parser.CriteriaAST {
+		if level == depth {
+			f := functions[next%len(functions)]
+			next++
+			return &parser.Leaf{
+				Function: f,
+				Args:     []string{fmt.Sprintf("a%d", next)},
+			}
+		}
+		op := parser.OperationAnd
+		if (level/2)%2 == 1 {
+			op = parser.OperationOr
+		}
+		var res parser.CriteriaAST = &parser.Node{Operation: op}
+		for i := 0; i < width; i++ {
+			n := res.(*parser.Node)
+			n.Children = append(n.Children, build(level+1))
+		}
+		if level%3 == 2 {
+			res = &parser.Node{
+				Operation: parser.OperationNot,
+				Children: []parser.CriteriaAST{
+					&parser.Node{
+						Operation: parser.OperationNot,
+						Children:  []parser.CriteriaAST{res},
+					},
+				},
+			}
+		}
+		return res
+	}
+
+	return build(0)
+}
+
+func TestSimplifyNestedCriteria(t *testing.T) {
+	tests := []struct {
+		depth, width int
+		want         Criteria
+	}{
+		{
+			depth: 3,
+			width: 3,
+			want: Criteria{
+				Query: "{from:a1 to:a2 subject:a3} {from:a5 to:a6 list:a4} {from:a9 subject:a7 list:a8} " +
+					"{to:a10 subject:a11 list:a12} {from:a13 to:a14 subject:a15} {from:a17 to:a18 list:a16} " +
+					"{from:a21 subject:a19 list:a20} {to:a22 subject:a23 list:a24} {from:a25 to:a26 subject:a27}",
+			},
+		},
+		{
+			depth: 4,
+			width: 2,
+			want: Criteria{
+				Query: "{from:a1 to:a2 subject:a3 list:a4} {from:a5 to:a6 subject:a7 list:a8} " +
+					"{from:a9 to:a10 subject:a11 list:a12} {from:a13 to:a14 subject:a15 list:a16}",
+			},
+		},
+		{
+			depth: 5,
+			width: 2,
+			want: Criteria{
+				Query: "{(from:a1 to:a2) (subject:a3 list:a4) (from:a5 to:a6) (subject:a7 list:a8)} " +
+					"{(from:a9 to:a10) (subject:a11 list:a12) (from:a13 to:a14) (subject:a15 list:a16)} " +
+					"{(from:a17 to:a18) (subject:a19 list:a20) (from:a21 to:a22) (subject:a23 list:a24)} " +
+					"{(from:a25 to:a26) (subject:a27 list:a28) (from:a29 to:a30) (subject:a31 list:a32)}",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("depth-%d-width-%d", tc.depth, tc.width), func(t *testing.T) {
+			tree, err := parser.SimplifyCriteria(nestedCriteria(tc.depth, tc.width))
+			assert.Nil(t, err)
+			got, err := GenerateCriteria(tree)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCollapseOrGroups(t *testing.T) {
+	leaf := func(f parser.FunctionType, arg string) *parser.Leaf {
+		return &parser.Leaf{Function: f, Args: []string{arg}}
+	}
+	tests := []struct {
+		name   string
+		tree   func() parser.CriteriaAST
+		before string
+		after  string
+	}{
+		{
+			name: "from",
+			tree: func() parser.CriteriaAST {
+				return &parser.Node{
+					Operation: parser.OperationOr,
+					Children: []parser.CriteriaAST{
+						leaf(parser.FunctionFrom, "a"),
+						leaf(parser.FunctionFrom, "b"),
+						leaf(parser.FunctionFrom, "c"),
+					},
+				}
+			},
+			before: "{from:a from:b from:c}",
+			after:  "from:{a b c}",
+		},
+		{
+			name: "sizes are not grouped",
+			tree: func() parser.CriteriaAST {
+				return &parser.Node{
+					Operation: parser.OperationOr,
+					Children: []parser.CriteriaAST{
+						leaf(parser.FunctionLarger, "5M"),
+						leaf(parser.FunctionSmaller, "1K"),
+						leaf(parser.FunctionSmaller, "2K"),
+					},
+				}
+			},
+			before: "{larger:5M smaller:1K smaller:2K}",
+			after:  "{larger:5M smaller:1K smaller:2K}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := GenerateCriteria(tc.tree())
+			require.Nil(t, err)
+			assert.Equal(t, tc.before, got.ToGmailSearch())
+
+			tree, err := parser.SimplifyCriteria(tc.tree())
+			require.Nil(t, err)
+			got, err = GenerateCriteria(tree)
+			require.Nil(t, err)
+			assert.Equal(t, tc.after, got.ToGmailSearch())
+		})
+	}
+}
+
+func TestDeMorganQuery(t *testing.T) {
+	leaf := func(f parser.FunctionType, arg string) *parser.Leaf {
+		return &parser.Leaf{Function: f, Args: []string{arg}}
+	}
+	node := func(op parser.OperationType, children ...parser.CriteriaAST) *parser.Node {
+		return &parser.Node{Operation: op, Children: children}
+	}
+	tests := []struct {
+		name string
+		tree parser.CriteriaAST
+		want string
+	}{
+		{
+			name: "not or",
+			tree: node(parser.OperationNot, node(parser.OperationOr,
+				leaf(parser.FunctionFrom, "a"), leaf(parser.FunctionTo, "b"))),
+			want: "-from:a -to:b",
+		},
+		{
+			name: "not and",
+			tree: node(parser.OperationNot, node(parser.OperationAnd,
+				leaf(parser.FunctionFrom, "a"), leaf(parser.FunctionTo, "b"))),
+			want: "-(from:a to:b)",
+		},
+		{
+			name: "double negation",
+			tree: node(parser.OperationNot, node(parser.OperationNot,
+				leaf(parser.FunctionFrom, "a"))),
+			want: "from:a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := parser.SimplifyCriteria(tc.tree)
+			require.Nil(t, err)
+			got, err := GenerateCriteria(tree)
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got.ToGmailSearch())
+		})
+	}
+}
+
+func TestQueryTooLong(t *testing.T) {
+	var args []string
+	for i := 0; i < 20; i++ {
+		args = append(args, fmt.Sprintf("%s-%d@example.com", strings.Repeat("x", 80), i))
+	}
+	rules := []parser.Rule{
+		{
+			Criteria: &parser.Leaf{
+				Function: parser.FunctionFrom,
+				Args:     []string{"a@b.com"},
+			},
+			Actions: parser.Actions{Archive: true},
+		},
+		{
+			Criteria: &parser.Leaf{
+				Function: parser.FunctionList,
+				Grouping: parser.OperationOr,
+				Args:     args,
+			},
+			Actions: parser.Actions{Archive: true},
+		},
+	}
+	_, err := FromRules(rules)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "rule #1")
+	assert.Contains(t, err.Error(), "generated query is 1916 bytes long")
+
+	// Just below the limit is fine.
+	rules[1].Criteria.(*parser.Leaf).Args = args[:14]
+	_, err = FromRules(rules)
+	assert.Nil(t, err)
+}
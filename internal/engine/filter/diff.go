@@ -18,8 +18,11 @@ func Diff(upstream, local Filters) (FiltersDiff, error) {
 	// Computing the diff is very expensive, so we have to minimize the number of filters
 	// we have to analyze. To do so, we get rid of the filters that are exactly the same,
 	// by hashing them.
-	added, removed := changedFilters(upstream, local)
-	return NewMinimalFiltersDiff(added, removed), nil
+	//
+	// Gmail returns the filters in an arbitrary order, so both sides are
+	// sorted to make the result independent from it.
+	added, removed := changedFilters(upstream.Sorted(), local.Sorted())
+	return NewMinimalFiltersDiff(added.Sorted(), removed.Sorted()), nil
 }
 
 // NewMinimalFiltersDiff creates a new FiltersDiff with reordered filters, where
@@ -32,13 +35,61 @@ func NewMinimalFiltersDiff(added, removed Filters) FiltersDiff {
 	if len(added) > 0 && len(removed) > 0 {
 		added, removed = reorderWithHungarian(added, removed)
 	}
-	return FiltersDiff{added, removed}
+	return FiltersDiff{Added: added, Removed: removed}
 }
 
 // FiltersDiff contains filters that have been added and removed locally with respect to upstream.
 type FiltersDiff struct {
 	Added   Filters
 	Removed Filters
+
+	// Optional unchanged filters to show around the changes.
+	local   Filters
+	context int
+	// Whether the criteria are diffed term by term.
+	words bool
+	// Whether the Gmail query of every filter is shown.
+	queries bool
+}
+
+// WithContext returns a copy of the diff that, when rendered, also shows up
+// to n unchanged filters around each change, in the order they appear in the
+// local filters.
+func (f FiltersDiff) WithContext(local Filters, n int) FiltersDiff {
+	f.local = local
+	f.context = n
+	return f
+}
+
+// WithoutContext returns a copy of the diff that, when rendered, shows only
+// the changes, without unchanged filters around them.
+func (f FiltersDiff) WithoutContext() FiltersDiff {
+	f.local = nil
+	f.context = 0
+	return f
+}
+
+// WithQueries returns a copy of the diff that, when rendered, also shows the
+// Gmail query stored for every filter, as generated from its criteria.
+func (f FiltersDiff) WithQueries() FiltersDiff {
+	f.queries = true
+	return f
+}
+
+// Modified returns the number of filters modified by the diff. Similar added
+// and removed filters are paired at the same position (see
+// NewMinimalFiltersDiff), and a pair is a modification if the two filters
+// have the same criteria or the same actions. The other pairs replace a
+// filter with an unrelated one.
+func (f FiltersDiff) Modified() int {
+	n := 0
+	for i := 0; i < len(f.Added) && i < len(f.Removed); i++ {
+		a, r := f.Added[i], f.Removed[i]
+		if a.Criteria == r.Criteria || a.Action == r.Action {
+			n++
+		}
+	}
+	return n
 }
 
 // Empty returns true if the diff is empty.
@@ -47,20 +98,122 @@ func (f FiltersDiff) Empty() bool {
 }
 
 func (f FiltersDiff) String() string {
+	if f.words {
+		return f.wordDiffString()
+	}
+	a := difflib.SplitLines(f.filtersString(f.Removed))
+	b := difflib.SplitLines(f.filtersString(f.Added))
+	context := 5
+	if f.context > 0 {
+		a, b = f.linesWithContext()
+		// Context filters are already selected, so they have to be shown
+		// entirely.
+		context = len(a) + len(b)
+	}
 	s, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
-		A:        difflib.SplitLines(f.Removed.String()),
-		B:        difflib.SplitLines(f.Added.String()),
+		A:        a,
+		B:        b,
 		FromFile: "Current",
 		ToFile:   "TO BE APPLIED",
-		Context:  5,
+		Context:  context,
 	})
 	if err != nil {
 		// We can't get a diff apparently, let's make something up here
-		return fmt.Sprintf("Removed:\n%s\nAdded:\n%s", f.Removed, f.Added)
+		return fmt.Sprintf("Removed:\n%s\nAdded:\n%s", f.filtersString(f.Removed), f.filtersString(f.Added))
 	}
 	return s
 }
 
+// filtersString renders the filters as they are shown in the diff.
+func (f FiltersDiff) filtersString(fs Filters) string {
+	if !f.queries {
+		return fs.String()
+	}
+	res := make([]string, len(fs))
+	for i, fl := range fs {
+		res[i] = f.filterString(fl)
+	}
+	return strings.Join(res, "\n")
+}
+
+// filterString renders a filter as it's shown in the diff.
+func (f FiltersDiff) filterString(fl Filter) string {
+	if !f.queries {
+		return fl.String()
+	}
+	return fmt.Sprintf("%s  Gmail query: %s\n", fl, fl.Criteria.ToGmailSearch())
+}
+
+// contextGap separates context filters that are not adjacent.
+const contextGap = "...\n"
+
+// linesWithContext returns the lines of the removed and added filters
+// interleaved with the unchanged local filters closest to them.
+//
+// Added filters are shown in their local position, each preceded by the
+// removed filter it has been matched with, if any. The remaining removed
+// filters have no position, so they are shown at the end.
+func (f FiltersDiff) linesWithContext() ([]string, []string) {
+	added := map[string][]int{}
+	for i, af := range f.Added {
+		h := hashFilter(af).hash
+		added[h] = append(added[h], i)
+	}
+
+	// For every local filter, the index of the corresponding added filter,
+	// or -1 if it's unchanged.
+	changed := make([]int, len(f.local))
+	for i, lf := range f.local {
+		changed[i] = -1
+		h := hashFilter(lf).hash
+		if ids := added[h]; len(ids) > 0 {
+			changed[i] = ids[0]
+			added[h] = ids[1:]
+		}
+	}
+	unpairedRemoved := len(f.Removed) > len(f.Added)
+
+	nearChange := func(i int) bool {
+		for j := i - f.context; j <= i+f.context; j++ {
+			if j == len(f.local) && unpairedRemoved {
+				return true
+			}
+			if j >= 0 && j < len(f.local) && changed[j] >= 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var a, b []string
+	skipped := false
+	for i, lf := range f.local {
+		if ai := changed[i]; ai >= 0 {
+			if ai < len(f.Removed) {
+				a = append(a, difflib.SplitLines(f.filterString(f.Removed[ai]))...)
+			}
+			b = append(b, difflib.SplitLines(f.filterString(f.Added[ai]))...)
+			continue
+		}
+		if !nearChange(i) {
+			skipped = true
+			continue
+		}
+		if skipped && (len(a) > 0 || len(b) > 0) {
+			a = append(a, difflib.SplitLines(contextGap)...)
+			b = append(b, difflib.SplitLines(contextGap)...)
+		}
+		skipped = false
+		a = append(a, difflib.SplitLines(f.filterString(lf))...)
+		b = append(b, difflib.SplitLines(f.filterString(lf))...)
+	}
+	for i := len(f.Added); i < len(f.Removed); i++ {
+		a = append(a, difflib.SplitLines(f.filterString(f.Removed[i]))...)
+	}
+
+	return a, b
+}
+
 func changedFilters(upstream, local Filters) (added, removed Filters) {
 	hupstream := newHashedFilters(upstream)
 	hlocal := newHashedFilters(local)
@@ -139,13 +292,18 @@ func newHashedFilters(fs Filters) hashedFilters {
 	return res
 }
 
+// hashedContents are the contents of a filter that are hashed, leaving out
+// the ID and the rule name.
+type hashedContents struct {
+	Action   Actions
+	Criteria Criteria
+}
+
 func hashFilter(f Filter) hashedFilter {
-	// We have to hash only the contents, not the ID
-	noIDFilter := Filter{
+	h := hashStruct(hashedContents{
 		Action:   f.Action,
 		Criteria: f.Criteria,
-	}
-	h := hashStruct(noIDFilter)
+	})
 	return hashedFilter{h, f}
 }
 
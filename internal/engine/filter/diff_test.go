@@ -1,6 +1,8 @@
 package filter
 
 import (
+	"fmt"
+	"math/rand"
 	"strings"
 	"testing"
 
@@ -200,6 +202,95 @@ func TestDiffReorder(t *testing.T) {
 	assert.Len(t, fd.Removed, 0)
 }
 
+func shuffledFilters(fs Filters, seed int64) Filters {
+	res := append(Filters{}, fs...)
+	rand.New(rand.NewSource(seed)).Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	return res
+}
+
+func TestDiffShuffled(t *testing.T) {
+	fs := someFilters()
+	for i, f := range fs {
+		f.ID = fmt.Sprintf("id%d", i)
+		fs[i] = f
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		fd, err := Diff(shuffledFilters(fs, seed), shuffledFilters(fs, seed+100))
+		assert.Nil(t, err)
+		assert.True(t, fd.Empty())
+	}
+}
+
+func TestDiffStableOrder(t *testing.T) {
+	upstream := someFilters()
+	local := Filters{
+		{Criteria: Criteria{From: "a@gmail.com"}, Action: Actions{Archive: true}},
+		{Criteria: Criteria{From: "b@gmail.com"}, Action: Actions{Archive: true}},
+		{Criteria: Criteria{From: "b@gmail.com"}, Action: Actions{Star: true}},
+		upstream[0],
+	}
+	expected, err := Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, local[:3], expected.Added)
+
+	for seed := int64(0); seed < 10; seed++ {
+		fd, err := Diff(shuffledFilters(upstream, seed), shuffledFilters(local, seed))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, fd)
+		assert.Equal(t, expected.String(), fd.String())
+	}
+}
+
+func TestSorted(t *testing.T) {
+	fs := Filters{
+		{ID: "2", Criteria: Criteria{From: "b"}},
+		{ID: "3", Criteria: Criteria{From: "a"}, Action: Actions{Star: true}},
+		{ID: "1", Criteria: Criteria{From: "b"}},
+		{ID: "4", Criteria: Criteria{From: "a"}, Action: Actions{Archive: true}},
+	}
+	got := fs.Sorted()
+	var ids []string
+	for _, f := range got {
+		ids = append(ids, f.ID)
+	}
+	assert.Equal(t, []string{"3", "4", "1", "2"}, ids)
+	// The original is untouched.
+	assert.Equal(t, "2", fs[0].ID)
+}
+
+func TestSortedSameQuery(t *testing.T) {
+	// Different criteria with the same Gmail query.
+	fs := Filters{
+		{Criteria: Criteria{From: "a", Query: "b"}},
+		{Criteria: Criteria{From: "a b"}},
+	}
+	assert.Equal(t, fs[0].Criteria.ToGmailSearch(), fs[1].Criteria.ToGmailSearch())
+	assert.Equal(t, fs.Sorted(), Filters{fs[1], fs[0]}.Sorted())
+}
+
+func TestFiltersDiffModified(t *testing.T) {
+	fd := FiltersDiff{
+		Added: Filters{
+			{Criteria: Criteria{From: "a"}, Action: Actions{Star: true}},
+			{Criteria: Criteria{From: "b"}, Action: Actions{Archive: true}},
+			{Criteria: Criteria{From: "c"}, Action: Actions{Archive: true}},
+			{Criteria: Criteria{From: "d"}, Action: Actions{Archive: true}},
+		},
+		Removed: Filters{
+			// Same actions.
+			{Criteria: Criteria{From: "x"}, Action: Actions{Star: true}},
+			// Same criteria.
+			{Criteria: Criteria{From: "b"}, Action: Actions{MarkRead: true}},
+			// Unrelated.
+			{Criteria: Criteria{From: "y"}, Action: Actions{MarkRead: true}},
+		},
+	}
+	assert.Equal(t, 2, fd.Modified())
+	assert.Equal(t, 0, FiltersDiff{Added: fd.Added}.Modified())
+}
+
 func TestDiffModify(t *testing.T) {
 	old := someFilters()
 	new := Filters{
@@ -309,6 +400,136 @@ func TestDiffRemove(t *testing.T) {
 	assert.Equal(t, expected, fd)
 }
 
+func TestDiffContext(t *testing.T) {
+	mkFilter := func(from string) Filter {
+		return Filter{
+			Criteria: Criteria{From: from},
+			Action:   Actions{Archive: true},
+		}
+	}
+	old := Filters{mkFilter("a"), mkFilter("b"), mkFilter("c"), mkFilter("d"), mkFilter("e")}
+	new := Filters{mkFilter("a"), mkFilter("b"), mkFilter("c"), mkFilter("x"), mkFilter("e")}
+
+	fd, err := Diff(old, new)
+	assert.Nil(t, err)
+
+	expected := `
+--- Current
++++ TO BE APPLIED
+@@ -1,15 +1,15 @@
+ * Criteria:
+     from: c
+   Actions:
+     archive
+ 
+ * Criteria:
+-    from: d
++    from: x
+   Actions:
+     archive
+ 
+ * Criteria:
+     from: e
+   Actions:
+     archive
+`
+	assert.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(fd.WithContext(new, 1).String()))
+	assert.Equal(t, fd.String(), fd.WithContext(new, 1).WithoutContext().String())
+
+	// Unchanged filters that are not adjacent are separated.
+	new = Filters{mkFilter("y"), mkFilter("b"), mkFilter("c"), mkFilter("d"), mkFilter("x")}
+	fd, err = Diff(old, new)
+	assert.Nil(t, err)
+
+	got := fd.WithContext(new, 1).String()
+	assert.Contains(t, got, "\n * Criteria:\n     from: b\n   Actions:\n     archive\n \n ...\n")
+	assert.Contains(t, got, "\n * Criteria:\n     from: d\n")
+	assert.NotContains(t, got, "from: c")
+	// Equally similar filters are paired in their canonical order.
+	assert.Contains(t, got, "-    from: a\n+    from: x\n")
+	assert.Contains(t, got, "-    from: e\n+    from: y\n")
+}
+
+func TestDiffRuleName(t *testing.T) {
+	old := Filters{
+		{
+			ID:       "abcdefg",
+			Criteria: Criteria{From: "someone@gmail.com"},
+			Action:   Actions{MarkRead: true},
+		},
+	}
+	new := Filters{
+		{
+			RuleName: "friends",
+			Criteria: Criteria{From: "someone@gmail.com"},
+			Action:   Actions{MarkRead: true},
+		},
+		{
+			RuleName: "work",
+			Criteria: Criteria{From: "boss@work.com"},
+			Action:   Actions{Star: true},
+		},
+	}
+
+	fd, err := Diff(old, new)
+	assert.Nil(t, err)
+	// The name is not part of the filter in Gmail.
+	assert.Equal(t, Filters{new[1]}, fd.Added)
+	assert.Empty(t, fd.Removed)
+
+	expected := `
+--- Current
++++ TO BE APPLIED
+@@ -1 +1,6 @@
++# work
++* Criteria:
++    from: boss@work.com
++  Actions:
++    star
+ 
+`
+	assert.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(fd.String()))
+}
+
+func TestDiffQueries(t *testing.T) {
+	old := Filters{
+		{Criteria: Criteria{From: "a"}, Action: Actions{Archive: true}},
+	}
+	new := Filters{
+		{Criteria: Criteria{From: "a"}, Action: Actions{Archive: true}},
+		{
+			RuleName: "lists",
+			Criteria: Criteria{From: "b", Query: "-{list:x list:y}"},
+			Action:   Actions{Star: true},
+		},
+	}
+	fd, err := Diff(old, new)
+	assert.Nil(t, err)
+
+	expected := `
+--- Current
++++ TO BE APPLIED
+@@ -1 +1,12 @@
++# lists
++* Criteria:
++    from: b
++    query: 
++      -{
++        list:x
++        list:y
++      }
++  Actions:
++    star
++  Gmail query: from:b -{list:x list:y}
+`
+	assert.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(fd.WithQueries().String()))
+	assert.NotContains(t, fd.String(), "Gmail query")
+
+	// Context filters show their query as well.
+	got := fd.WithQueries().WithContext(new, 1).String()
+	assert.Contains(t, got, "\n     archive\n   Gmail query: from:a\n")
+}
+
 func TestDuplicate(t *testing.T) {
 	old := Filters{}
 	new := Filters{
@@ -377,3 +598,63 @@ func TestIndent(t *testing.T) {
 		})
 	}
 }
+
+func TestWordDiff(t *testing.T) {
+	old := Filters{
+		{
+			Criteria: Criteria{From: "a@x.com", Query: "{foo bar}"},
+			Action:   Actions{Archive: true},
+		},
+	}
+	new := Filters{
+		{
+			RuleName: "newsletters",
+			Criteria: Criteria{From: "a@x.com", Query: "{foo bar baz}"},
+			Action:   Actions{Archive: true},
+		},
+	}
+	fd, err := Diff(old, new)
+	assert.Nil(t, err)
+
+	expected := `
+# newsletters
+~ Criteria: from:a@x.com {foo bar {+baz+}}
+  Actions:
+    archive
+`
+	assert.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(fd.WithWordDiff().String()))
+}
+
+func TestWordDiffFallback(t *testing.T) {
+	old := Filters{
+		{Criteria: Criteria{From: "a"}, Action: Actions{Archive: true}},
+	}
+	new := Filters{
+		{Criteria: Criteria{From: "b"}, Action: Actions{Star: true}},
+		{Criteria: Criteria{From: "c"}, Action: Actions{Star: true}},
+	}
+	fd, err := Diff(old, new)
+	assert.Nil(t, err)
+
+	// Different actions are shown as a regular diff.
+	assert.Equal(t, fd.String(), fd.WithWordDiff().String())
+}
+
+func TestWordDiffTerms(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"from:a subject:b", "from:a subject:b", "from:a subject:b"},
+		{"from:a subject:b", "from:a", "from:a [-subject:b-]"},
+		{"from:a", "-list:x from:a", "{+-list:x+} from:a"},
+		{"from:{a b c}", "from:{a c}", "from:{a [-b-] c}"},
+		{"subject:foo", "subject:bar", "[-subject:foo-]{+subject:bar+}"},
+		{`"foo bar" baz`, `"foo qux" baz`, `[-"foo bar"-]{+"foo qux"+} baz`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.want, wordDiff(tc.a, tc.b))
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/mbrt/gmailctl/internal/engine/gmail"
@@ -37,10 +38,41 @@ func (fs Filters) HasLabel(name string) bool {
 	return false
 }
 
+// Sorted returns a copy of the filters in a canonical order: by their Gmail
+// query and then by their actions. Ties are broken by criteria, ID and rule
+// name, so the order doesn't depend on the order of the input.
+func (fs Filters) Sorted() Filters {
+	if fs == nil {
+		return nil
+	}
+	type keyed struct {
+		key    string
+		filter Filter
+	}
+	ks := make([]keyed, len(fs))
+	for i, f := range fs {
+		ks[i] = keyed{
+			key: fmt.Sprintf("%s\x00%#v\x00%#v\x00%s\x00%s",
+				f.Criteria.ToGmailSearch(), f.Action, f.Criteria, f.ID, f.RuleName),
+			filter: f,
+		}
+	}
+	sort.SliceStable(ks, func(i, j int) bool { return ks[i].key < ks[j].key })
+
+	res := make(Filters, len(fs))
+	for i, k := range ks {
+		res[i] = k.filter
+	}
+	return res
+}
+
 // Filter matches 1:1 a filter created on Gmail.
 type Filter struct {
 	// ID is an optional identifier associated with a filter.
-	ID       string
+	ID string
+	// RuleName is the name of the rule that generated the filter, if any.
+	// It's only known locally, as Gmail filters have no names.
+	RuleName string
 	Action   Actions
 	Criteria Criteria
 }
@@ -48,6 +80,11 @@ type Filter struct {
 func (f Filter) String() string {
 	w := writer{}
 
+	if f.RuleName != "" {
+		w.WriteString("# ")
+		w.WriteString(f.RuleName)
+		w.WriteRune('\n')
+	}
 	w.WriteString("* Criteria:\n")
 	w.WriteParam("from", f.Criteria.From)
 	w.WriteParam("to", f.Criteria.To)
@@ -55,21 +92,25 @@ func (f Filter) String() string {
 
 	w.WriteParam("query", indent(f.Criteria.Query, 2))
 
-	w.WriteString("  Actions:\n")
-	w.WriteBool("archive", f.Action.Archive)
-	w.WriteBool("delete", f.Action.Delete)
-	w.WriteBool("mark as important", f.Action.MarkImportant)
-	w.WriteBool("never mark as important", f.Action.MarkNotImportant)
-	w.WriteBool("never mark as spam", f.Action.MarkNotSpam)
-	w.WriteBool("mark as read", f.Action.MarkRead)
-	w.WriteBool("star", f.Action.Star)
-	w.WriteParam("categorize as", string(f.Action.Category))
-	w.WriteParam("apply label", f.Action.AddLabel)
-	w.WriteParam("forward to", f.Action.Forward)
+	writeActions(&w, f.Action)
 
 	return w.String()
 }
 
+func writeActions(w *writer, a Actions) {
+	w.WriteString("  Actions:\n")
+	w.WriteBool("archive", a.Archive)
+	w.WriteBool("delete", a.Delete)
+	w.WriteBool("mark as important", a.MarkImportant)
+	w.WriteBool("never mark as important", a.MarkNotImportant)
+	w.WriteBool("never mark as spam", a.MarkNotSpam)
+	w.WriteBool("mark as read", a.MarkRead)
+	w.WriteBool("star", a.Star)
+	w.WriteParam("categorize as", string(a.Category))
+	w.WriteParam("apply label", a.AddLabel)
+	w.WriteParam("forward to", a.Forward)
+}
+
 func indent(query string, level int) string {
 	var indented bytes.Buffer
 	if !indentInternal(strings.NewReader(query), &indented, level+1) {
@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+// WithWordDiff returns a copy of the diff that, when rendered, shows the
+// changed criteria term by term, with '[-removed-]' and '{+added+}' markers,
+// instead of line by line.
+//
+// Only the pairs of added and removed filters with the same actions are
+// rendered this way. The other changes are shown as a regular diff, with no
+// context.
+func (f FiltersDiff) WithWordDiff() FiltersDiff {
+	f.words = true
+	return f
+}
+
+func (f FiltersDiff) wordDiffString() string {
+	var res []string
+	rest := FiltersDiff{queries: f.queries}
+	for i := 0; i < len(f.Added) || i < len(f.Removed); i++ {
+		if i < len(f.Added) && i < len(f.Removed) && f.Added[i].Action == f.Removed[i].Action {
+			res = append(res, wordDiffFilter(f.Removed[i], f.Added[i]))
+			continue
+		}
+		if i < len(f.Added) {
+			rest.Added = append(rest.Added, f.Added[i])
+		}
+		if i < len(f.Removed) {
+			rest.Removed = append(rest.Removed, f.Removed[i])
+		}
+	}
+	if !rest.Empty() {
+		res = append(res, rest.String())
+	}
+	return strings.Join(res, "\n")
+}
+
+// wordDiffFilter renders a changed filter, with the criteria diffed term by
+// term.
+func wordDiffFilter(from, to Filter) string {
+	w := writer{}
+	if to.RuleName != "" {
+		w.WriteString("# ")
+		w.WriteString(to.RuleName)
+		w.WriteRune('\n')
+	}
+	w.WriteString("~ Criteria: ")
+	w.WriteString(wordDiff(criteriaString(from.Criteria), criteriaString(to.Criteria)))
+	w.WriteRune('\n')
+	writeActions(&w, to.Action)
+	return w.String()
+}
+
+// criteriaString returns the criteria as a Gmail search query, normalized
+// through its AST when it can be parsed.
+func criteriaString(c Criteria) string {
+	q := c.ToGmailSearch()
+	crit, err := parser.ParseQuery(q)
+	if err != nil {
+		return q
+	}
+	// The terms in the root 'and' don't need the parentheses.
+	if n, ok := crit.(*parser.Node); ok && n.Operation == parser.OperationAnd {
+		var terms []string
+		for _, c := range n.Children {
+			terms = append(terms, c.String())
+		}
+		return strings.Join(terms, " ")
+	}
+	return crit.String()
+}
+
+// wordDiff returns the second query, with the terms not in the first one
+// marked as added, and the terms only in the first one marked as removed.
+func wordDiff(a, b string) string {
+	ta, tb := queryTokens(a), queryTokens(b)
+	var res strings.Builder
+	for _, op := range difflib.NewMatcher(ta, tb).GetOpCodes() {
+		removed, added := ta[op.I1:op.I2], tb[op.J1:op.J2]
+		switch op.Tag {
+		case 'e':
+			res.WriteString(strings.Join(removed, ""))
+		case 'd':
+			writeMarked(&res, removed, "[-", "-]")
+		case 'i':
+			writeMarked(&res, added, "{+", "+}")
+		case 'r':
+			res.WriteString("[-" + strings.Join(removed, "") + "-]")
+			res.WriteString("{+" + strings.Join(added, "") + "+}")
+		}
+	}
+	return res.String()
+}
+
+// writeMarked writes the tokens between the given markers, leaving the
+// surrounding spaces out of them.
+func writeMarked(w *strings.Builder, tokens []string, open, close string) {
+	start, end := 0, len(tokens)
+	for start < end && tokens[start] == " " {
+		start++
+	}
+	for end > start && tokens[end-1] == " " {
+		end--
+	}
+	if start == end {
+		// Only spaces changed.
+		start, end = 0, len(tokens)
+	}
+	w.WriteString(strings.Join(tokens[:start], ""))
+	w.WriteString(open + strings.Join(tokens[start:end], "") + close)
+	w.WriteString(strings.Join(tokens[end:], ""))
+}
+
+// queryTokens splits a query into terms, spaces and grouping chars, so that
+// joining the tokens gives the query back. Quoted strings are kept whole.
+func queryTokens(q string) []string {
+	var res []string
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			res = append(res, q[start:end])
+		}
+		start = end
+	}
+	for i := 0; i < len(q); i++ {
+		switch c := q[i]; c {
+		case ' ', '{', '}', '(', ')':
+			flush(i)
+			flush(i + 1)
+		case '"':
+			if end := strings.IndexByte(q[i+1:], '"'); end >= 0 {
+				i += end + 1
+			}
+		}
+	}
+	flush(len(q))
+	return res
+}
@@ -0,0 +1,102 @@
+package label
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nearestColors is the number of allowed colors suggested for an invalid
+// one.
+const nearestColors = 3
+
+// palette contains the colors accepted by Gmail, both for the background
+// and the text of a label.
+//
+// See https://developers.google.com/gmail/api/reference/rest/v1/users.labels
+var palette = []string{
+	"#000000", "#434343", "#666666", "#999999", "#cccccc", "#efefef", "#f3f3f3", "#ffffff",
+	"#fb4c2f", "#ffad47", "#fad165", "#16a766", "#43d692", "#4a86e8", "#a479e2", "#f691b3",
+	"#f6c5be", "#ffe6c7", "#fef1d1", "#b9e4d0", "#c6f3de", "#c9daf8", "#e4d7f5", "#fcdee8",
+	"#efa093", "#ffd6a2", "#fce8b3", "#89d3b2", "#a0eac9", "#a4c2f4", "#d0bcf1", "#fbc8d9",
+	"#e66550", "#ffbc6b", "#fcda83", "#44b984", "#68dfa9", "#6d9eeb", "#b694e8", "#f7a7c0",
+	"#cc3a21", "#eaa041", "#f2c960", "#149e60", "#3dc789", "#3c78d8", "#8e63ce", "#e07798",
+	"#ac2b16", "#cf8933", "#d5ae49", "#0b804b", "#2a9c68", "#285bac", "#653e9b", "#b65775",
+	"#822111", "#a46a21", "#aa8831", "#076239", "#1a764a", "#1c4587", "#41236d", "#83334c",
+	"#464646", "#e7e7e7", "#0d3472", "#b6cff5", "#0d3b44", "#98d7e4", "#3d188e", "#e3d7ff",
+	"#711a36", "#fbd3e0", "#8a1c0a", "#f2b2a8", "#7a2e0b", "#ffc8af", "#7a4706", "#ffdeb5",
+	"#594c05", "#fbe983", "#684e07", "#fdedc1", "#0b4f30", "#b3efd3", "#04502e", "#a2dcc1",
+	"#c2c2c2", "#4986e7", "#2da2bb", "#b99aff", "#994a64", "#f691b2", "#ff7537", "#ffad46",
+	"#662e37", "#ebdbde", "#cca6ac", "#094228", "#42d692", "#16a765",
+}
+
+var paletteSet = func() map[string]bool {
+	res := map[string]bool{}
+	for _, c := range palette {
+		res[c] = true
+	}
+	return res
+}()
+
+// Validate checks that both the background and the text colors are allowed
+// by Gmail.
+//
+// Colors are compared case insensitively. For colors not in the palette,
+// the error suggests the nearest allowed ones.
+func (c Color) Validate() error {
+	if err := validateColor(c.Background); err != nil {
+		return fmt.Errorf("background: %w", err)
+	}
+	if err := validateColor(c.Text); err != nil {
+		return fmt.Errorf("text: %w", err)
+	}
+	return nil
+}
+
+func validateColor(c string) error {
+	lc := strings.ToLower(c)
+	if paletteSet[lc] {
+		return nil
+	}
+	rgb, ok := parseHexColor(lc)
+	if !ok {
+		return fmt.Errorf("color %q is not in the '#rrggbb' format, e.g. %q", c, palette[8])
+	}
+	return fmt.Errorf("color %q is not allowed by Gmail, the nearest allowed are: %s",
+		c, strings.Join(nearest(rgb, nearestColors), ", "))
+}
+
+// nearest returns the n allowed colors closest to the given one.
+func nearest(rgb [3]int, n int) []string {
+	dist := func(c string) int {
+		other, _ := parseHexColor(c)
+		res := 0
+		for i := range rgb {
+			d := rgb[i] - other[i]
+			res += d * d
+		}
+		return res
+	}
+
+	res := append([]string{}, palette...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return dist(res[i]) < dist(res[j])
+	})
+	return res[:n]
+}
+
+func parseHexColor(c string) ([3]int, bool) {
+	var res [3]int
+	if len(c) != 7 || c[0] != '#' {
+		return res, false
+	}
+	for i := range res {
+		v, err := strconv.ParseUint(c[1+2*i:3+2*i], 16, 8)
+		if err != nil {
+			return res, false
+		}
+		res[i] = int(v)
+	}
+	return res, true
+}
@@ -13,10 +13,12 @@ import (
 // Diff computes the diff between two lists of labels.
 //
 // To compute the diff, IDs are ignored, only the properties of the labels are
-// actually considered.
+// actually considered. The given lists are left untouched.
 func Diff(upstream, local Labels) (LabelsDiff, error) {
-	sort.Sort(byName(upstream))
-	sort.Sort(byName(local))
+	upstream = append(Labels{}, upstream...)
+	local = append(Labels{}, local...)
+	sort.Stable(byName(upstream))
+	sort.Stable(byName(local))
 
 	res := LabelsDiff{}
 	i, j := 0, 0
@@ -79,8 +81,9 @@ func (d LabelsDiff) String() string {
 	cleanup := func(l Label) Label {
 		// Get rid of distracting information in the diff.
 		return Label{
-			Name:  l.Name,
-			Color: l.Color,
+			Name:       l.Name,
+			Color:      l.Color,
+			Visibility: l.Visibility,
 		}
 	}
 
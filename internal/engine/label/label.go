@@ -3,6 +3,7 @@ package label
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
@@ -38,6 +39,16 @@ func (ls Labels) Validate() error {
 			return fmt.Errorf("label %q provided multiple times", n)
 		}
 		lmap[n] = struct{}{}
+		if l.Color != nil {
+			if err := l.Color.Validate(); err != nil {
+				return fmt.Errorf("label %q: %w", n, err)
+			}
+		}
+		if l.Visibility != nil {
+			if err := l.Visibility.Validate(); err != nil {
+				return fmt.Errorf("label %q: %w", n, err)
+			}
+		}
 	}
 
 	return nil
@@ -45,11 +56,71 @@ func (ls Labels) Validate() error {
 
 type stringset map[string]struct{}
 
+// WithParents returns the given labels, together with all their ancestors
+// that are missing from the list.
+//
+// Gmail nested labels are identified by their full path (e.g. 'a/b/c'), and
+// their parents ('a' and 'a/b') need to exist before they can be created.
+// The result is sorted by nesting depth, so parents always come before their
+// children.
+func WithParents(ls Labels) Labels {
+	names := stringset{}
+	for _, l := range ls {
+		names[l.Name] = struct{}{}
+	}
+
+	res := append(Labels{}, ls...)
+	for _, l := range ls {
+		for _, p := range parents(l.Name) {
+			if _, ok := names[p]; ok {
+				continue
+			}
+			names[p] = struct{}{}
+			res = append(res, Label{Name: p})
+		}
+	}
+
+	SortByDepth(res)
+	return res
+}
+
+// SortByDepth sorts the labels by their nesting depth, so that parents are
+// always placed before their children.
+//
+// Labels at the same depth are sorted by name.
+func SortByDepth(ls Labels) {
+	sort.SliceStable(ls, func(i, j int) bool {
+		di, dj := depth(ls[i].Name), depth(ls[j].Name)
+		if di != dj {
+			return di < dj
+		}
+		return ls[i].Name < ls[j].Name
+	})
+}
+
+// parents returns all the ancestors of the given label, from the outermost.
+//
+// Example: 'a/b/c' => ['a', 'a/b'].
+func parents(name string) []string {
+	var res []string
+	for i, c := range name {
+		if c == '/' && i > 0 {
+			res = append(res, name[:i])
+		}
+	}
+	return res
+}
+
+func depth(name string) int {
+	return strings.Count(name, "/")
+}
+
 // Label contains information about a Gmail label.
 type Label struct {
-	ID    string
-	Name  string
-	Color *Color
+	ID         string
+	Name       string
+	Color      *Color
+	Visibility *Visibility
 }
 
 func (l Label) String() string {
@@ -64,6 +135,9 @@ func (l Label) String() string {
 		ss = append(ss, fmt.Sprintf("color: %s, %s",
 			l.Color.Background, l.Color.Text))
 	}
+	if l.Visibility != nil {
+		ss = append(ss, "visibility: "+l.Visibility.String())
+	}
 
 	return strings.Join(ss, "; ")
 }
@@ -80,13 +154,18 @@ type Color struct {
 // Equivalent returns true if two labels can be considered equal, despite a
 // different ID.
 //
-// Unspecified color is also ignored.
+// Unspecified color and visibility settings are also ignored. Colors are
+// compared case insensitively, because Gmail always returns them in
+// lowercase.
 func Equivalent(upstream, local Label) bool {
 	// Ignore ID
 	if upstream.Name != local.Name {
 		return false
 	}
+	return equivalentColor(upstream, local) && equivalentVisibility(upstream, local)
+}
 
+func equivalentColor(upstream, local Label) bool {
 	upsHasColor := upstream.Color != nil
 	locHasColor := local.Color != nil
 	if !locHasColor {
@@ -98,7 +177,8 @@ func Equivalent(upstream, local Label) bool {
 		return false
 	}
 	// Need to check if the color is the same
-	return *upstream.Color == *local.Color
+	return strings.EqualFold(upstream.Color.Background, local.Color.Background) &&
+		strings.EqualFold(upstream.Color.Text, local.Color.Text)
 }
 
 // FromConfig creates labels from the config format.
@@ -113,9 +193,17 @@ func FromConfig(ls []v1alpha3.Label) Labels {
 				Text:       l.Color.Text,
 			}
 		}
+		var visibility *Visibility
+		if l.Visibility != nil {
+			visibility = &Visibility{
+				LabelList:   l.Visibility.LabelList,
+				MessageList: l.Visibility.MessageList,
+			}
+		}
 		res = append(res, Label{
-			Name:  l.Name,
-			Color: color,
+			Name:       l.Name,
+			Color:      color,
+			Visibility: visibility,
 		})
 	}
 
@@ -98,3 +98,198 @@ func TestValidateUsed(t *testing.T) {
 	err := Validate(d, fs)
 	assert.NotNil(t, err)
 }
+
+func TestWithParents(t *testing.T) {
+	color := &Color{Background: "#fb4c2f", Text: "#ffffff"}
+	ls := Labels{
+		{Name: "Work/Projects/Alpha", Color: color},
+		{Name: "Personal"},
+	}
+	expected := Labels{
+		{Name: "Personal"},
+		{Name: "Work"},
+		{Name: "Work/Projects"},
+		{Name: "Work/Projects/Alpha", Color: color},
+	}
+	got := WithParents(ls)
+	assert.Equal(t, expected, got)
+	assert.Nil(t, got.Validate())
+}
+
+func TestWithParentsExisting(t *testing.T) {
+	// Parents already present are not duplicated, nor changed.
+	color := &Color{Background: "red", Text: "blue"}
+	ls := Labels{
+		{Name: "a/b/c"},
+		{Name: "a", Color: color},
+		{Name: "a/b/d"},
+	}
+	expected := Labels{
+		{Name: "a", Color: color},
+		{Name: "a/b"},
+		{Name: "a/b/c"},
+		{Name: "a/b/d"},
+	}
+	assert.Equal(t, expected, WithParents(ls))
+}
+
+func TestDiffCreatesParents(t *testing.T) {
+	local := WithParents(Labels{{Name: "Work/Projects/Alpha"}})
+	d, err := Diff(nil, local)
+	assert.Nil(t, err)
+	assert.Equal(t, Labels{
+		{Name: "Work"},
+		{Name: "Work/Projects"},
+		{Name: "Work/Projects/Alpha"},
+	}, d.Added)
+
+	expected := `--- Current
++++ TO BE APPLIED
+@@ -0,0 +1,3 @@
++Work
++Work/Projects
++Work/Projects/Alpha
+`
+	assert.Equal(t, expected, d.String())
+}
+
+func TestDiffColorCase(t *testing.T) {
+	upstream := Labels{{ID: "1", Name: "a", Color: &Color{Background: "#fb4c2f", Text: "#ffffff"}}}
+	local := Labels{{Name: "a", Color: &Color{Background: "#FB4C2F", Text: "#FFFFFF"}}}
+	d, err := Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.True(t, d.Empty())
+
+	local[0].Color.Text = "#000000"
+	d, err = Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.Len(t, d.Modified, 1)
+}
+
+func TestDiffDoesNotReorder(t *testing.T) {
+	upstream := Labels{{ID: "2", Name: "b"}, {ID: "1", Name: "a"}}
+	local := Labels{{Name: "c"}, {Name: "a"}}
+	d, err := Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.Equal(t, Labels{{Name: "c"}}, d.Added)
+	assert.Equal(t, Labels{{ID: "2", Name: "b"}}, d.Removed)
+	// The inputs are untouched.
+	assert.Equal(t, Labels{{ID: "2", Name: "b"}, {ID: "1", Name: "a"}}, upstream)
+	assert.Equal(t, Labels{{Name: "c"}, {Name: "a"}}, local)
+}
+
+func TestValidColors(t *testing.T) {
+	ls := Labels{
+		{Name: "a", Color: &Color{Background: "#fb4c2f", Text: "#ffffff"}},
+		// Case doesn't matter.
+		{Name: "b", Color: &Color{Background: "#16A766", Text: "#000000"}},
+		{Name: "c"},
+	}
+	assert.Nil(t, ls.Validate())
+}
+
+func TestInvalidColors(t *testing.T) {
+	cases := []struct {
+		color Color
+		err   string
+	}{
+		{
+			color: Color{Background: "#fb4c30", Text: "#ffffff"},
+			err:   `label "a": background: color "#fb4c30" is not allowed by Gmail, the nearest allowed are: #fb4c2f, #ff7537, #e66550`,
+		},
+		{
+			color: Color{Background: "#ffffff", Text: "#010101"},
+			err:   `label "a": text: color "#010101" is not allowed by Gmail, the nearest allowed are: #000000, #094228, #0d3b44`,
+		},
+		{
+			color: Color{Background: "red", Text: "#ffffff"},
+			err:   `label "a": background: color "red" is not in the '#rrggbb' format, e.g. "#fb4c2f"`,
+		},
+		{
+			color: Color{Background: "#ffffff"},
+			err:   `label "a": text: color "" is not in the '#rrggbb' format, e.g. "#fb4c2f"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		ls := Labels{{Name: "a", Color: &c.color}}
+		err := ls.Validate()
+		if assert.NotNil(t, err) {
+			assert.Equal(t, c.err, err.Error())
+		}
+	}
+}
+
+func TestDiffColorChange(t *testing.T) {
+	upstream := Labels{
+		{ID: "1", Name: "a", Color: &Color{Background: "#fb4c2f", Text: "#ffffff"}},
+		{ID: "2", Name: "b"},
+	}
+	local := Labels{
+		{Name: "a", Color: &Color{Background: "#16a766", Text: "#ffffff"}},
+		{Name: "b", Color: &Color{Background: "#000000", Text: "#ffffff"}},
+	}
+	d, err := Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.Equal(t, []ModifiedLabel{
+		{Old: upstream[0], New: local[0]},
+		{Old: upstream[1], New: local[1]},
+	}, d.Modified)
+	assert.Empty(t, d.Added)
+	assert.Empty(t, d.Removed)
+
+	expected := `--- Current
++++ TO BE APPLIED
+@@ -1,2 +1,2 @@
+-a; color: #fb4c2f, #ffffff
+-b
++a; color: #16a766, #ffffff
++b; color: #000000, #ffffff
+`
+	assert.Equal(t, expected, d.String())
+}
+
+func TestInvalidVisibility(t *testing.T) {
+	ls := Labels{{Name: "a", Visibility: &Visibility{LabelList: "hidden"}}}
+	assert.EqualError(t, ls.Validate(),
+		`label "a": unsupported label list visibility "hidden", expected "show", "showIfUnread" or "hide"`)
+
+	ls = Labels{{Name: "a", Visibility: &Visibility{MessageList: VisibilityShowIfUnread}}}
+	assert.EqualError(t, ls.Validate(),
+		`label "a": unsupported message list visibility "showIfUnread", expected "show" or "hide"`)
+
+	ls = Labels{{Name: "a", Visibility: &Visibility{LabelList: VisibilityShowIfUnread, MessageList: VisibilityHide}}}
+	assert.Nil(t, ls.Validate())
+}
+
+func TestDiffVisibility(t *testing.T) {
+	upstream := Labels{
+		{ID: "1", Name: "a"},
+		{ID: "2", Name: "b", Visibility: &Visibility{LabelList: VisibilityHide, MessageList: VisibilityShow}},
+	}
+	// Labels are shown by default, and unspecified settings are ignored.
+	local := Labels{
+		{Name: "a", Visibility: &Visibility{LabelList: VisibilityShow}},
+		{Name: "b", Visibility: &Visibility{LabelList: VisibilityHide}},
+	}
+	d, err := Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.True(t, d.Empty())
+
+	local = Labels{
+		{Name: "a", Visibility: &Visibility{MessageList: VisibilityHide}},
+		{Name: "b"},
+	}
+	d, err = Diff(upstream, local)
+	assert.Nil(t, err)
+	assert.Equal(t, []ModifiedLabel{{Old: upstream[0], New: local[0]}}, d.Modified)
+
+	expected := `--- Current
++++ TO BE APPLIED
+@@ -1 +1 @@
+-a
++a; visibility: message list hide
+`
+	assert.Equal(t, expected, d.String())
+}
@@ -0,0 +1,71 @@
+package label
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values of the visibility settings of a label.
+const (
+	VisibilityShow         = "show"
+	VisibilityShowIfUnread = "showIfUnread"
+	VisibilityHide         = "hide"
+)
+
+// Visibility controls where a label is shown in Gmail.
+//
+// Empty settings are not managed. Gmail shows labels by default.
+type Visibility struct {
+	// LabelList is the visibility in the list of labels.
+	LabelList string
+	// MessageList is the visibility in the list of messages.
+	MessageList string
+}
+
+// Validate checks that the settings are supported by Gmail.
+func (v Visibility) Validate() error {
+	switch v.LabelList {
+	case "", VisibilityShow, VisibilityShowIfUnread, VisibilityHide:
+	default:
+		return fmt.Errorf("unsupported label list visibility %q, expected %q, %q or %q",
+			v.LabelList, VisibilityShow, VisibilityShowIfUnread, VisibilityHide)
+	}
+	switch v.MessageList {
+	case "", VisibilityShow, VisibilityHide:
+	default:
+		return fmt.Errorf("unsupported message list visibility %q, expected %q or %q",
+			v.MessageList, VisibilityShow, VisibilityHide)
+	}
+	return nil
+}
+
+func (v Visibility) String() string {
+	var ss []string
+	if v.LabelList != "" {
+		ss = append(ss, "label list "+v.LabelList)
+	}
+	if v.MessageList != "" {
+		ss = append(ss, "message list "+v.MessageList)
+	}
+	return strings.Join(ss, ", ")
+}
+
+// equivalentVisibility returns true if the upstream label has the visibility
+// settings specified locally.
+func equivalentVisibility(upstream, local Label) bool {
+	if local.Visibility == nil {
+		return true
+	}
+	var ups Visibility
+	if upstream.Visibility != nil {
+		ups = *upstream.Visibility
+	}
+	same := func(ups, loc string) bool {
+		if ups == "" {
+			ups = VisibilityShow
+		}
+		return loc == "" || ups == loc
+	}
+	return same(ups.LabelList, local.Visibility.LabelList) &&
+		same(ups.MessageList, local.Visibility.MessageList)
+}
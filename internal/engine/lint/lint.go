@@ -0,0 +1,311 @@
+// Package lint analyzes rules to find common mistakes.
+package lint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+// WarningKind is the kind of problem reported by a warning.
+type WarningKind int
+
+// Kinds of warnings.
+const (
+	// KindShadowed reports a pair of rules with overlapping criteria.
+	//
+	// Every email matched by the Shadowed rule is also matched by Rule,
+	// because the criteria of Rule are a strict subset of the ones of
+	// Shadowed.
+	KindShadowed WarningKind = iota
+	// KindRelativeDate reports a rule using relative dates in its criteria.
+	KindRelativeDate
+	// KindBroadTerm reports a rule matching on a 'subject' or 'has' term
+	// that is very short or very common, like 'subject:re'.
+	KindBroadTerm
+	// KindDomainFrom reports a rule matching on a 'from' address that is
+	// only a domain, like 'from:example.com'.
+	KindDomainFrom
+)
+
+// DefaultMinTermLength is the minimum length of the 'subject' and 'has'
+// terms not reported as too broad.
+const DefaultMinTermLength = 3
+
+// stopWords are terms so common that they match most emails.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "fw": true, "fwd": true,
+	"hello": true, "hi": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "re": true, "the": true, "this": true, "to": true,
+	"with": true, "you": true, "your": true,
+}
+
+func (k WarningKind) String() string {
+	switch k {
+	case KindShadowed:
+		return "shadowed"
+	case KindRelativeDate:
+		return "relative-date"
+	case KindBroadTerm:
+		return "broad-term"
+	case KindDomainFrom:
+		return "domain-from"
+	default:
+		return fmt.Sprintf("<unknown kind %d>", int(k))
+	}
+}
+
+// Warning reports a problem in one or two rules.
+type Warning struct {
+	Kind WarningKind
+	// Rule is the index of the rule with the problem. For shadowed rules,
+	// this is the more generic one.
+	Rule int
+	// Shadowed is the index of the more specific rule, only for
+	// KindShadowed.
+	Shadowed int
+	// SameActions is true if both rules apply the same actions, only for
+	// KindShadowed.
+	SameActions bool
+	// Term is the offending term, e.g. 'subject:re', only for
+	// KindBroadTerm and KindDomainFrom.
+	Term string
+	// Suggestion is the term to use instead, only for KindDomainFrom.
+	Suggestion string
+}
+
+// Explanation returns a short description of the problem and how to fix it.
+func (w Warning) Explanation() string {
+	if w.Kind == KindDomainFrom {
+		return fmt.Sprintf("rule #%d matches on %q, which is only a domain and doesn't "+
+			"match anyone at it: consider using %q instead", w.Rule, w.Term, w.Suggestion)
+	}
+	if w.Kind == KindBroadTerm {
+		return fmt.Sprintf("rule #%d matches on %q, which is too short or common and "+
+			"likely matches a lot of emails: consider narrowing the criteria", w.Rule, w.Term)
+	}
+	if w.Kind == KindRelativeDate {
+		return fmt.Sprintf("rule #%d uses relative dates, but filters only apply to "+
+			"incoming emails, which are always newer than any date: "+
+			"the criteria may behave unexpectedly", w.Rule)
+	}
+	if w.SameActions {
+		return fmt.Sprintf("rule #%d only matches emails already matched by rule #%d, "+
+			"with the same actions: it is redundant and can be removed",
+			w.Shadowed, w.Rule)
+	}
+	return fmt.Sprintf("every email matched by rule #%d is also matched by rule #%d, "+
+		"so the actions of both rules apply to it: consider consolidating them",
+		w.Shadowed, w.Rule)
+}
+
+// Lint returns the pairs of rules whose criteria overlap, followed by the
+// rules using relative dates, the ones matching on broad terms and the ones
+// matching on 'from' domains.
+//
+// The analysis works on the simplified criteria: a rule is considered to be
+// shadowed by another when its criteria are a conjunction including all the
+// terms of the other rule, plus some more. Rules are referenced by their
+// index in the given slice.
+func Lint(rules []parser.Rule) []Warning {
+	return LintWithMinTermLength(rules, DefaultMinTermLength)
+}
+
+// LintWithMinTermLength is like Lint, but reports as broad the 'subject' and
+// 'has' terms shorter than the given length, instead of the default one.
+func LintWithMinTermLength(rules []parser.Rule, minTermLength int) []Warning {
+	terms := make([][]parser.CriteriaAST, len(rules))
+	for i, r := range rules {
+		terms[i] = conjuncts(r.Criteria)
+	}
+
+	var res []Warning
+	for i := range rules {
+		for j := range rules {
+			if i == j || !strictSubset(terms[i], terms[j]) {
+				continue
+			}
+			res = append(res, Warning{
+				Rule:        i,
+				Shadowed:    j,
+				SameActions: reflect.DeepEqual(rules[i].Actions, rules[j].Actions),
+			})
+		}
+	}
+	for i, r := range rules {
+		if hasRelativeDate(r.Criteria) {
+			res = append(res, Warning{Kind: KindRelativeDate, Rule: i})
+		}
+	}
+	for i, r := range rules {
+		for _, t := range broadTerms(r.Criteria, minTermLength) {
+			res = append(res, Warning{Kind: KindBroadTerm, Rule: i, Term: t})
+		}
+	}
+	for i, r := range rules {
+		for _, d := range fromDomains(r.Criteria) {
+			addr, _ := parser.DomainAddress(d)
+			res = append(res, Warning{
+				Kind:       KindDomainFrom,
+				Rule:       i,
+				Term:       fmt.Sprintf("%v:%s", parser.FunctionFrom, d),
+				Suggestion: fmt.Sprintf("%v:%s", parser.FunctionFrom, addr),
+			})
+		}
+	}
+	return res
+}
+
+// fromDomains returns the 'from' arguments of the criteria that are only a
+// domain.
+func fromDomains(c parser.CriteriaAST) []string {
+	var res []string
+	parser.Walk(c, func(c parser.CriteriaAST) bool {
+		n, ok := c.(*parser.Leaf)
+		if !ok || n.Function != parser.FunctionFrom || n.IsRaw {
+			return true
+		}
+		for _, arg := range n.Args {
+			if _, ok := parser.DomainAddress(arg); ok {
+				res = append(res, arg)
+			}
+		}
+		return false
+	})
+	return res
+}
+
+// broadTerms returns the broad terms the criteria can match on alone.
+//
+// A broad term in a conjunction is not reported if the other terms narrow it
+// down, like in 'from:boss subject:re'. Negated terms are never reported,
+// because they only exclude emails.
+func broadTerms(c parser.CriteriaAST, minLength int) []string {
+	switch n := c.(type) {
+	case *parser.Node:
+		var res []string
+		for _, child := range n.Children {
+			switch {
+			case n.Operation == parser.OperationNot:
+				return nil
+			case n.Operation == parser.OperationAnd && isNot(child):
+				continue
+			}
+			terms := broadTerms(child, minLength)
+			if terms == nil && n.Operation == parser.OperationAnd {
+				return nil
+			}
+			res = append(res, terms...)
+		}
+		return res
+	case *parser.Leaf:
+		if n.Function != parser.FunctionSubject && n.Function != parser.FunctionHas {
+			return nil
+		}
+		var res []string
+		for _, arg := range n.Args {
+			if !isBroad(arg, minLength) {
+				if n.Grouping == parser.OperationAnd {
+					return nil
+				}
+				continue
+			}
+			if n.Function == parser.FunctionHas {
+				res = append(res, arg)
+			} else {
+				res = append(res, fmt.Sprintf("%v:%s", n.Function, arg))
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func isNot(c parser.CriteriaAST) bool {
+	n, ok := c.(*parser.Node)
+	return ok && n.Operation == parser.OperationNot
+}
+
+func isBroad(term string, minLength int) bool {
+	t := strings.ToLower(strings.TrimSpace(term))
+	return len([]rune(t)) < minLength || stopWords[t]
+}
+
+func hasRelativeDate(c parser.CriteriaAST) bool {
+	res := false
+	parser.Walk(c, func(c parser.CriteriaAST) bool {
+		fn := c.RootFunction()
+		res = res || fn == parser.FunctionNewerThan || fn == parser.FunctionOlderThan
+		return !res
+	})
+	return res
+}
+
+// conjuncts returns the terms that need to be all satisfied for the
+// criteria to match, without duplicates (see parser.CriteriaAST.Equal).
+//
+// Example:
+//
+//	from:a subject:(b c) => {from:a, subject:b, subject:c}
+func conjuncts(c parser.CriteriaAST) []parser.CriteriaAST {
+	var res []parser.CriteriaAST
+	add := func(t parser.CriteriaAST) {
+		if !containsTerm(res, t) {
+			res = append(res, t)
+		}
+	}
+
+	var children []parser.CriteriaAST
+	if n, ok := c.(*parser.Node); ok && n.Operation == parser.OperationAnd {
+		children = n.Children
+	} else {
+		children = []parser.CriteriaAST{c}
+	}
+
+	for _, child := range children {
+		leaf, ok := child.(*parser.Leaf)
+		if !ok || leaf.Grouping != parser.OperationAnd {
+			add(child)
+			continue
+		}
+		// Split grouped arguments into separate terms.
+		for _, arg := range leaf.Args {
+			add(&parser.Leaf{
+				Function: leaf.Function,
+				Args:     []string{arg},
+				IsRaw:    leaf.IsRaw,
+				IsPhrase: leaf.IsPhrase,
+			})
+		}
+		if len(leaf.Args) == 0 {
+			// Boolean functions have no arguments.
+			add(leaf)
+		}
+	}
+
+	return res
+}
+
+func containsTerm(ts []parser.CriteriaAST, t parser.CriteriaAST) bool {
+	for _, x := range ts {
+		if x.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func strictSubset(a, b []parser.CriteriaAST) bool {
+	if len(a) >= len(b) {
+		return false
+	}
+	for _, t := range a {
+		if !containsTerm(b, t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,309 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+func parse(t *testing.T, rules ...cfg.Rule) []parser.Rule {
+	t.Helper()
+	res, err := parser.Parse(cfg.Config{Rules: rules})
+	require.Nil(t, err)
+	return res
+}
+
+func TestLint(t *testing.T) {
+	archive := cfg.Actions{Archive: true}
+	label := cfg.Actions{Labels: []string{"foo"}}
+
+	tests := []struct {
+		name  string
+		rules []cfg.Rule
+		want  []Warning
+	}{
+		{
+			name: "shadowed with different actions",
+			rules: []cfg.Rule{
+				{Filter: cfg.FilterNode{From: "a"}, Actions: archive},
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{From: "a"},
+						{Subject: "b"},
+					}},
+					Actions: label,
+				},
+			},
+			want: []Warning{{Rule: 0, Shadowed: 1}},
+		},
+		{
+			name: "shadowed with same actions",
+			rules: []cfg.Rule{
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{From: "a"},
+						{Subject: "b"},
+					}},
+					Actions: archive,
+				},
+				{Filter: cfg.FilterNode{From: "a"}, Actions: archive},
+			},
+			want: []Warning{{Rule: 1, Shadowed: 0, SameActions: true}},
+		},
+		{
+			name: "written differently",
+			rules: []cfg.Rule{
+				{
+					Filter: cfg.FilterNode{Not: &cfg.FilterNode{Not: &cfg.FilterNode{
+						Or: []cfg.FilterNode{{List: "x"}, {List: "y"}},
+					}}},
+					Actions: archive,
+				},
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{And: []cfg.FilterNode{{Subject: "b"}, {Subject: "c"}}},
+						{Or: []cfg.FilterNode{{List: "y"}, {List: "x"}}},
+					}},
+					Actions: label,
+				},
+			},
+			want: []Warning{{Rule: 0, Shadowed: 1}},
+		},
+		{
+			name: "grouped arguments",
+			rules: []cfg.Rule{
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{Subject: "b"},
+						{Subject: "c"},
+					}},
+					Actions: archive,
+				},
+				{Filter: cfg.FilterNode{Subject: "c"}, Actions: label},
+			},
+			want: []Warning{{Rule: 1, Shadowed: 0}},
+		},
+		{
+			name: "equivalent criteria",
+			rules: []cfg.Rule{
+				{Filter: cfg.FilterNode{From: "a"}, Actions: archive},
+				{Filter: cfg.FilterNode{From: "a"}, Actions: label},
+			},
+		},
+		{
+			name: "partial overlap",
+			rules: []cfg.Rule{
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{From: "a"},
+						{Subject: "b"},
+					}},
+					Actions: archive,
+				},
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{From: "a"},
+						{To: "c"},
+					}},
+					Actions: label,
+				},
+			},
+		},
+		{
+			name: "exact phrase",
+			rules: []cfg.Rule{
+				{Filter: cfg.FilterNode{SubjectExact: "foo"}, Actions: archive},
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{SubjectExact: "foo"},
+						{From: "a"},
+					}},
+					Actions: label,
+				},
+			},
+			want: []Warning{{Rule: 0, Shadowed: 1}},
+		},
+		{
+			name: "quoted term is not an exact phrase",
+			// Exact phrases are stored quoted as well.
+			rules: []cfg.Rule{
+				{Filter: cfg.FilterNode{SubjectExact: "foo"}, Actions: archive},
+				{
+					Filter: cfg.FilterNode{And: []cfg.FilterNode{
+						{Subject: `"foo"`},
+						{From: "a"},
+					}},
+					Actions: label,
+				},
+			},
+		},
+		{
+			name: "or is not a conjunction",
+			rules: []cfg.Rule{
+				{Filter: cfg.FilterNode{From: "a"}, Actions: archive},
+				{
+					Filter: cfg.FilterNode{Or: []cfg.FilterNode{
+						{From: "a"},
+						{Subject: "b"},
+					}},
+					Actions: label,
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Short terms are not reported, to focus on overlaps.
+			got := LintWithMinTermLength(parse(t, tc.rules...), 0)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestExplanation(t *testing.T) {
+	w := Warning{Rule: 0, Shadowed: 2}
+	assert.Equal(t, "every email matched by rule #2 is also matched by rule #0, "+
+		"so the actions of both rules apply to it: consider consolidating them",
+		w.Explanation())
+	w.SameActions = true
+	assert.Contains(t, w.Explanation(), "it is redundant")
+}
+
+func TestLintRelativeDate(t *testing.T) {
+	rules := parse(t,
+		cfg.Rule{
+			Filter:  cfg.FilterNode{From: "a"},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			Filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{From: "b"},
+				{Not: &cfg.FilterNode{NewerThan: "7d"}},
+			}},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			Filter:  cfg.FilterNode{OlderThan: "1y"},
+			Actions: cfg.Actions{Delete: true},
+		},
+	)
+	got := Lint(rules)
+	assert.Equal(t, []Warning{
+		{Kind: KindRelativeDate, Rule: 1},
+		{Kind: KindRelativeDate, Rule: 2},
+	}, got)
+	assert.Contains(t, got[0].Explanation(), "rule #1 uses relative dates")
+}
+
+func TestLintBroadTerm(t *testing.T) {
+	rules := parse(t,
+		cfg.Rule{
+			// Reasonable.
+			Filter:  cfg.FilterNode{Subject: "invoice"},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			// Too short.
+			Filter:  cfg.FilterNode{Subject: "ok"},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			// Stop-words are case insensitive.
+			Filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{Subject: "Re"},
+				{Has: "the"},
+				{Has: "newsletter"},
+			}},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			// Narrowed down by the other terms.
+			Filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{From: "boss@work.com"},
+				{Subject: "re"},
+			}},
+			Actions: cfg.Actions{Star: true},
+		},
+		cfg.Rule{
+			// Negated terms only exclude emails.
+			Filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{Subject: "report"},
+				{Not: &cfg.FilterNode{Subject: "fwd"}},
+			}},
+			Actions: cfg.Actions{Star: true},
+		},
+		cfg.Rule{
+			// Negations don't narrow down the other terms.
+			Filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{Has: "hi"},
+				{Not: &cfg.FilterNode{From: "mom@home.com"}},
+			}},
+			Actions: cfg.Actions{Star: true},
+		},
+	)
+	got := Lint(rules)
+	assert.Equal(t, []Warning{
+		{Kind: KindBroadTerm, Rule: 1, Term: "subject:ok"},
+		{Kind: KindBroadTerm, Rule: 2, Term: "subject:Re"},
+		{Kind: KindBroadTerm, Rule: 2, Term: "the"},
+		{Kind: KindBroadTerm, Rule: 5, Term: "hi"},
+	}, got)
+	assert.Contains(t, got[0].Explanation(), `rule #1 matches on "subject:ok"`)
+
+	// The minimum length is configurable.
+	got = LintWithMinTermLength(rules[:2], 8)
+	assert.Equal(t, []Warning{
+		{Kind: KindBroadTerm, Rule: 0, Term: "subject:invoice"},
+		{Kind: KindBroadTerm, Rule: 1, Term: "subject:ok"},
+	}, got)
+}
+
+func TestLintDomainFrom(t *testing.T) {
+	rules := parse(t,
+		cfg.Rule{
+			// Only a domain.
+			Filter:  cfg.FilterNode{From: "example.com"},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			// Full address and wildcard.
+			Filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{From: "alice@example.com"},
+				{From: "*@example.org"},
+			}},
+			Actions: cfg.Actions{Archive: true},
+		},
+		cfg.Rule{
+			// Grouped with other addresses.
+			Filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{From: "bob@work.com"},
+				{From: "news.example.net"},
+			}},
+			Actions: cfg.Actions{Star: true},
+		},
+		cfg.Rule{
+			// Other functions are fine.
+			Filter:  cfg.FilterNode{To: "example.com"},
+			Actions: cfg.Actions{Star: true},
+		},
+	)
+	got := Lint(rules)
+	assert.Equal(t, []Warning{
+		{Kind: KindDomainFrom, Rule: 0, Term: "from:example.com", Suggestion: "from:@example.com"},
+		{Kind: KindDomainFrom, Rule: 2, Term: "from:news.example.net", Suggestion: "from:@news.example.net"},
+	}, got)
+	assert.Equal(t, `rule #0 matches on "from:example.com", which is only a domain and doesn't `+
+		`match anyone at it: consider using "from:@example.com" instead`, got[0].Explanation())
+}
+
+func TestWarningKindString(t *testing.T) {
+	assert.Equal(t, "broad-term", KindBroadTerm.String())
+	assert.Equal(t, "shadowed", KindShadowed.String())
+	assert.Equal(t, "relative-date", KindRelativeDate.String())
+	assert.Equal(t, "domain-from", KindDomainFrom.String())
+}
@@ -2,8 +2,6 @@ package parser
 
 import "sort"
 
-const maxSimplifyPasses = 4
-
 // Logical operations.
 const (
 	OperationNone OperationType = iota
@@ -36,9 +34,19 @@ const (
 	FunctionCc
 	FunctionBcc
 	FunctionReplyTo
+	FunctionDeliveredTo
 	FunctionSubject
 	FunctionList
 	FunctionHas
+	FunctionLarger
+	FunctionSmaller
+	FunctionNewerThan
+	FunctionOlderThan
+	FunctionFilename
+	FunctionIn
+	FunctionIs
+	FunctionCategory
+	FunctionHasAttachment
 	FunctionQuery
 )
 
@@ -59,12 +67,32 @@ func (f FunctionType) String() string {
 		return "bcc"
 	case FunctionReplyTo:
 		return "replyto"
+	case FunctionDeliveredTo:
+		return "deliveredto"
 	case FunctionSubject:
 		return "subject"
 	case FunctionList:
 		return "list"
 	case FunctionHas:
 		return "has"
+	case FunctionLarger:
+		return "larger"
+	case FunctionSmaller:
+		return "smaller"
+	case FunctionNewerThan:
+		return "newer_than"
+	case FunctionOlderThan:
+		return "older_than"
+	case FunctionFilename:
+		return "filename"
+	case FunctionIn:
+		return "in"
+	case FunctionIs:
+		return "is"
+	case FunctionCategory:
+		return "category"
+	case FunctionHasAttachment:
+		return "hasattachment"
 	case FunctionQuery:
 		return "query"
 	default:
@@ -86,6 +114,12 @@ type CriteriaAST interface {
 	AcceptVisitor(v Visitor)
 	// Clone returns a deep copy of the tree.
 	Clone() CriteriaAST
+	// Equal returns true if the two trees are logically the same, regardless
+	// of the order of the children of 'and' and 'or' nodes, or of the
+	// grouped arguments of the leaves.
+	Equal(other CriteriaAST) bool
+	// String returns the tree in Gmail search syntax.
+	String() string
 }
 
 // Node is an AST node with children nodes. It can only be a logical operator.
@@ -126,16 +160,51 @@ func (n *Node) Clone() CriteriaAST {
 	}
 }
 
+// Equal returns true if the other tree is a node with the same operation and
+// equal children, in any order.
+func (n *Node) Equal(other CriteriaAST) bool {
+	o, ok := other.(*Node)
+	if !ok || o == nil || n.Operation != o.Operation || len(n.Children) != len(o.Children) {
+		return false
+	}
+	// Every child has to match a different child of the other node.
+	used := make([]bool, len(o.Children))
+	for _, c := range n.Children {
+		found := false
+		for j, oc := range o.Children {
+			if !used[j] && c.Equal(oc) {
+				used[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the tree in Gmail search syntax.
+func (n *Node) String() string {
+	return queryString(n)
+}
+
 // Leaf is an AST node with no children.
 //
 // If the function has multiple arguments, they are grouped together with a
 // logical operator. For example: from:{a b} has two arguments grouped with
 // an OR and from:(a b) is grouped with an AND.
+//
+// Boolean functions, like 'has:attachment', have no arguments at all.
 type Leaf struct {
 	Function FunctionType
 	Grouping OperationType
 	Args     []string
 	IsRaw    bool
+	// IsPhrase is true if the arguments are exact phrases, already quoted,
+	// e.g. '"foo bar"'.
+	IsPhrase bool
 }
 
 // RootOperation returns the grouping of the leaf.
@@ -163,9 +232,39 @@ func (n *Leaf) Clone() CriteriaAST {
 	return &Leaf{
 		Function: n.Function,
 		Grouping: n.Grouping,
-		Args:     n.Args,
+		Args:     append([]string(nil), n.Args...),
 		IsRaw:    n.IsRaw,
+		IsPhrase: n.IsPhrase,
+	}
+}
+
+// Equal returns true if the other tree is a leaf with the same function and
+// arguments, in any order.
+func (n *Leaf) Equal(other CriteriaAST) bool {
+	o, ok := other.(*Leaf)
+	if !ok || o == nil || n.Function != o.Function || n.IsRaw != o.IsRaw || n.IsPhrase != o.IsPhrase ||
+		len(n.Args) != len(o.Args) {
+		return false
 	}
+	// The grouping doesn't matter with a single argument.
+	if len(n.Args) > 1 && n.Grouping != o.Grouping {
+		return false
+	}
+	a := append([]string(nil), n.Args...)
+	b := append([]string(nil), o.Args...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the leaf in Gmail search syntax.
+func (n *Leaf) String() string {
+	return queryString(n)
 }
 
 // Visitor implements the visitor pattern for CriteriaAST.
@@ -174,55 +273,62 @@ type Visitor interface {
 	VisitLeaf(n *Leaf)
 }
 
+// Walk traverses the tree in pre-order, calling visit for every node and
+// leaf. The children of a node are only visited if visit returns true for
+// it.
+func Walk(tree CriteriaAST, visit func(CriteriaAST) bool) {
+	if !visit(tree) {
+		return
+	}
+	if n, ok := tree.(*Node); ok {
+		for _, c := range n.Children {
+			Walk(c, visit)
+		}
+	}
+}
+
 // SimplifyCriteria applies multiple simplifications to a criteria.
 func SimplifyCriteria(tree CriteriaAST) (CriteriaAST, error) {
-	res, err := simplify(tree)
-	// We use maps, so the resulting tree is non-deterministic.
-	// To fix that we sort the trees.
+	res := simplify(tree)
+	// Sort the trees to make the result easier to compare and the
+	// generated filters stable.
 	sortTree(res)
-	return res, err
-}
-
-func simplify(tree CriteriaAST) (CriteriaAST, error) {
-	changes := 1 // Avoid stopping before the first round
-
-	// We want to apply the passes multiple times, because one
-	// simplification can unlock another. We can block when no
-	// further progress can be made.
-	for i := 0; changes > 0 && i < maxSimplifyPasses; i++ {
-		changes = logicalGrouping(tree)
-		changes += functionsGrouping(tree)
-		newTree, c := removeRedundancy(tree)
-		changes += c
-		tree = newTree
-	}
-
-	return tree, nil
+	return res, nil
 }
 
-func logicalGrouping(tree CriteriaAST) int {
+// simplify rewrites the tree bottom-up in a single pass.
+//
+// Since children are always fully simplified before their parent, one
+// simplification can only unlock others in the parent node, which are
+// applied right away. This makes the whole process linear in the size of
+// the tree.
+func simplify(tree CriteriaAST) CriteriaAST {
 	root, ok := tree.(*Node)
 	if !ok {
 		// Leaves don't apply
-		return 0
+		return tree
 	}
 
 	// Recurse to children first.
-	count := 0
-	for _, child := range root.Children {
-		count += logicalGrouping(child)
+	for i, child := range root.Children {
+		root.Children[i] = simplify(child)
 	}
 
-	// The not operator does not apply.
 	if root.Operation == OperationNot {
-		return count
+		return simplifyNot(root)
 	}
+	logicalGrouping(root)
+	functionsGrouping(root)
+	return removeRedundancy(root)
+}
 
+func logicalGrouping(root *Node) {
 	// Try to find child nodes with my same operation and squash them.
+	// Children are already simplified, so there's no need to go deeper.
 	//
 	// Example:
 	// and(foo, and(bar, baz), quax) => and(foo, bar, baz, quax)
-	newChildren := []CriteriaAST{}
+	newChildren := make([]CriteriaAST, 0, len(root.Children))
 	for _, child := range root.Children {
 		childNode, ok := child.(*Node)
 		if !ok || childNode.Operation != root.Operation {
@@ -233,124 +339,182 @@ func logicalGrouping(tree CriteriaAST) int {
 		// The operation of the child is the same, get rid of it
 		// and add its children here.
 		newChildren = append(newChildren, childNode.Children...)
-		count++
 	}
 	root.Children = newChildren
-
-	return count
 }
 
-func functionsGrouping(tree CriteriaAST) int {
-	root, ok := tree.(*Node)
-	if !ok {
-		// Leaves don't apply
-		return 0
-	}
-
-	// Recurse to children first.
-	count := 0
-	for _, child := range root.Children {
-		count += functionsGrouping(child)
-	}
-
+func functionsGrouping(root *Node) {
 	// If there's only one child, then there's no need to proceed.
 	if len(root.Children) <= 1 {
-		return count
+		return
 	}
 
 	// Group leaf nodes together and squash them.
 	//
 	// Example:
 	// and(foo:x bar:y foo:z) => and(foo:(x z) bar:z)
+	//
+	// Exact phrases are only grouped together, as their arguments are
+	// already quoted.
+	type groupKey struct {
+		fn     FunctionType
+		phrase bool
+	}
 	newChildren := []CriteriaAST{}
-	functions := map[FunctionType][]string{}
-	rawFunctions := map[FunctionType]bool{}
+	grouped := map[groupKey]*Leaf{}
+	var order []groupKey
 	for _, child := range root.Children {
 		leaf, ok := child.(*Leaf)
-		if !ok || (len(leaf.Args) > 1 && leaf.Grouping != root.Operation) {
-			// Non-leaves and leaves grouped by a different operator have to
-			// stay as-is.
+		if !ok || !supportsGrouping(leaf.Function) ||
+			(len(leaf.Args) > 1 && leaf.Grouping != root.Operation) {
+			// Non-leaves, leaves that can't be grouped and leaves grouped by
+			// a different operator have to stay as-is.
 			newChildren = append(newChildren, child)
 			continue
 		}
-		functions[leaf.Function] = append(functions[leaf.Function], leaf.Args...)
-		// When grouping preserve the 'raw' modifier.
-		if leaf.IsRaw {
-			rawFunctions[leaf.Function] = true
+		key := groupKey{leaf.Function, leaf.IsPhrase}
+		g, ok := grouped[key]
+		if !ok {
+			g = &Leaf{
+				Function: leaf.Function,
+				Grouping: root.Operation,
+				IsPhrase: leaf.IsPhrase,
+			}
+			grouped[key] = g
+			order = append(order, key)
 		}
+		g.Args = append(g.Args, leaf.Args...)
+		// When grouping preserve the 'raw' modifier.
+		g.IsRaw = g.IsRaw || leaf.IsRaw
 	}
 
-	// Re-construct the grouped children
-	for ft, args := range functions {
-		_, raw := rawFunctions[ft]
-		newChildren = append(newChildren, &Leaf{
-			Function: ft,
-			Grouping: root.Operation,
-			Args:     args,
-			IsRaw:    raw,
-		})
-		count++
+	// Re-construct the grouped children, in order of appearance.
+	for _, k := range order {
+		newChildren = append(newChildren, grouped[k])
 	}
 
 	root.Children = newChildren
-
-	return count
 }
 
-func removeRedundancy(tree CriteriaAST) (CriteriaAST, int) {
-	root, ok := tree.(*Node)
-	if !ok {
-		// Leaves don't apply
-		return tree, 0
-	}
-
-	// Recurse to children first.
-	count := 0
-	newChildren := []CriteriaAST{}
-	for _, child := range root.Children {
-		newChild, c := removeRedundancy(child)
-		count += c
-		newChildren = append(newChildren, newChild)
-	}
-	root.Children = newChildren
+// groupingSupport tells, for each function, whether Gmail accepts multiple
+// arguments grouped together, as in 'from:{a b}' or 'from:(a b)'. Functions
+// that don't are combined with explicit operations instead, as in
+// '{in:inbox in:spam}'.
+var groupingSupport = map[FunctionType]bool{
+	FunctionFrom:          true,
+	FunctionTo:            true,
+	FunctionCc:            true,
+	FunctionBcc:           true,
+	FunctionReplyTo:       true,
+	FunctionDeliveredTo:   true,
+	FunctionSubject:       true,
+	FunctionList:          true,
+	FunctionHas:           true,
+	FunctionFilename:      true,
+	FunctionHasAttachment: true,
+	FunctionQuery:         true,
+	// Sizes, dates and locations only accept a single value.
+	FunctionLarger:    false,
+	FunctionSmaller:   false,
+	FunctionNewerThan: false,
+	FunctionOlderThan: false,
+	FunctionIn:        false,
+	FunctionIs:        false,
+	FunctionCategory:  false,
+}
 
-	if root.Operation == OperationNot {
-		newRoot, c := simplifyNot(root)
-		return newRoot, count + c
-	}
+// supportsGrouping returns true if Gmail accepts multiple arguments for the
+// given function.
+func supportsGrouping(f FunctionType) bool {
+	return groupingSupport[f]
+}
 
+func removeRedundancy(root *Node) CriteriaAST {
 	// All good, this operator is useful
 	if len(root.Children) != 1 {
 		// Side note: this catches also the case where we have no children
 		// which means the tree is invalid, but at least we don't crash.
-		return root, count
+		return root
 	}
 
 	// A single child means this operator is not useful
 	//
 	// Example:
 	// or(a) => a
-	return root.Children[0], count + 1
+	return root.Children[0]
 }
 
-func simplifyNot(root *Node) (CriteriaAST, int) {
-	// If the child is another 'not', we can get rid of both.
+func simplifyNot(root *Node) CriteriaAST {
 	if len(root.Children) != 1 {
 		// Something is wrong here: let's just return the tree as is
-		return root, 0
+		return root
 	}
 
 	child, ok := root.Children[0].(*Node)
-	if !ok || child.Operation != OperationNot {
-		return root, 0
+	if !ok {
+		return root
+	}
+	switch child.Operation {
+	case OperationNot:
+		// If the child is another 'not', we can get rid of both.
+		if len(child.Children) != 1 {
+			// Something is wrong here: let's just return the tree as is
+			return root
+		}
+		return child.Children[0]
+	case OperationOr:
+		return deMorganOr(root, child)
+	case OperationAnd:
+		return deMorganAnd(root, child)
+	default:
+		return root
 	}
+}
 
-	if len(child.Children) != 1 {
-		// Something is wrong here: let's just return the tree as is
-		return root, 0
+// deMorganOr distributes a 'not' over its 'or' child, when the result is
+// shorter.
+//
+// Example:
+// not(or(a, b)) => and(not(a), not(b))
+//
+// In Gmail this is '-a -b' instead of '-{a b}'. Every negated child costs
+// one character, while braces cost three, so this only pays off with up to
+// three children. The opposite ('not' of an 'and') is only shorter when all
+// the children are negated (see deMorganAnd).
+func deMorganOr(root, child *Node) CriteriaAST {
+	if len(child.Children) > 3 {
+		return root
+	}
+	res := &Node{Operation: OperationAnd}
+	for _, c := range child.Children {
+		// Children might be negations themselves.
+		res.Children = append(res.Children, simplifyNot(&Node{
+			Operation: OperationNot,
+			Children:  []CriteriaAST{c},
+		}))
 	}
+	logicalGrouping(res)
+	functionsGrouping(res)
+	return removeRedundancy(res)
+}
 
-	return child.Children[0], 1
+// deMorganAnd distributes a 'not' over its 'and' child, when all the
+// children are negated, removing the double negations.
+//
+// Example:
+// not(and(not(a), not(b))) => or(a, b)
+func deMorganAnd(root, child *Node) CriteriaAST {
+	res := &Node{Operation: OperationOr}
+	for _, c := range child.Children {
+		n, ok := c.(*Node)
+		if !ok || n.Operation != OperationNot || len(n.Children) != 1 {
+			return root
+		}
+		res.Children = append(res.Children, n.Children[0])
+	}
+	logicalGrouping(res)
+	functionsGrouping(res)
+	return removeRedundancy(res)
 }
 
 func sortTreeNodes(nodes []CriteriaAST) {
@@ -359,7 +523,7 @@ func sortTreeNodes(nodes []CriteriaAST) {
 		sortTree(child)
 	}
 
-	sort.Slice(nodes, func(i, j int) bool {
+	sort.SliceStable(nodes, func(i, j int) bool {
 		// ordering will be:
 		// - leaves in grouping and function order, then
 		// - nodes in operation order
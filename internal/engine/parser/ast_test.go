@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,12 +34,30 @@ func TestSimplify(t *testing.T) {
 	got, err := SimplifyCriteria(expr)
 	assert.Nil(t, err)
 
-	// Maps make the children sorting pseudo-random. We have to sort
-	// the trees to be able to find make it deterministic.
-	sortTree(expected)
-	sortTree(got)
-	assert.Equal(t, expected, got)
+	// The order of the grouped arguments doesn't matter here.
+	assert.True(t, expected.Equal(got), got.String())
+}
+
+func TestSimplifyArgsOrder(t *testing.T) {
+	// Grouped arguments are kept in order of appearance, even when they
+	// come from different levels of the tree.
+	expr := and(
+		fn1(FunctionList, "d"),
+		and(
+			fn1(FunctionFrom, "e"),
+			not(not(
+				fn1(FunctionList, "f"),
+			)),
+		),
+	)
 
+	expected := and(
+		fn(FunctionFrom, OperationAnd, "e"),
+		fn(FunctionList, OperationAnd, "d", "f"),
+	)
+	got, err := SimplifyCriteria(expr)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
 }
 
 func and(children ...CriteriaAST) *Node {
@@ -73,3 +92,310 @@ func fn(ftype FunctionType, op OperationType, args ...string) *Leaf {
 func fn1(ftype FunctionType, arg string) *Leaf {
 	return fn(ftype, OperationNone, arg)
 }
+
+// balancedTree builds a balanced criteria tree of the given depth, where
+// every node has the given number of children. Consecutive levels share the
+// same operation and double negations are sprinkled around, so that all the
+// simplification passes have something to do.
+func balancedTree(depth, width int) CriteriaAST {
+	functions := []FunctionType{FunctionFrom, FunctionTo, FunctionSubject, FunctionList}
+	next := 0
+
+	var build func(level int) CriteriaAST
+	build = func(level int) CriteriaAST {
+		if level == depth {
+			f := functions[next%len(functions)]
+			next++
+			return fn1(f, fmt.Sprintf("a%d", next))
+		}
+		var children []CriteriaAST
+		for i := 0; i < width; i++ {
+			children = append(children, build(level+1))
+		}
+		var res CriteriaAST = and(children...)
+		if (level/2)%2 == 1 {
+			res = or(children...)
+		}
+		if level%3 == 2 {
+			res = not(not(res))
+		}
+		return res
+	}
+
+	return build(0)
+}
+
+func BenchmarkSimplifyCriteria(b *testing.B) {
+	for _, depth := range []int{2, 4, 6, 8} {
+		b.Run(fmt.Sprintf("depth-%d", depth), func(b *testing.B) {
+			trees := make([]CriteriaAST, b.N)
+			for i := range trees {
+				trees[i] = balancedTree(depth, 3)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := SimplifyCriteria(trees[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSimplifyNoGrouping(t *testing.T) {
+	// Sizes can't be grouped in Gmail.
+	expr := or(
+		fn1(FunctionLarger, "5M"),
+		fn1(FunctionLarger, "1M"),
+		fn1(FunctionFrom, "a"),
+		fn1(FunctionFrom, "b"),
+	)
+	expected := or(
+		fn1(FunctionLarger, "5M"),
+		fn1(FunctionLarger, "1M"),
+		fn(FunctionFrom, OperationOr, "a", "b"),
+	)
+	got, err := SimplifyCriteria(expr)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestSimplifyGroupingByFunction(t *testing.T) {
+	tests := []struct {
+		fn      FunctionType
+		grouped bool
+	}{
+		{FunctionFrom, true},
+		{FunctionTo, true},
+		{FunctionCc, true},
+		{FunctionBcc, true},
+		{FunctionReplyTo, true},
+		{FunctionDeliveredTo, true},
+		{FunctionSubject, true},
+		{FunctionList, true},
+		{FunctionFilename, true},
+		{FunctionLarger, false},
+		{FunctionSmaller, false},
+		{FunctionNewerThan, false},
+		{FunctionOlderThan, false},
+		{FunctionIn, false},
+		{FunctionIs, false},
+		{FunctionCategory, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.fn.String(), func(t *testing.T) {
+			expr := or(fn1(tc.fn, "a"), fn1(tc.fn, "b"))
+			expected := CriteriaAST(or(fn1(tc.fn, "a"), fn1(tc.fn, "b")))
+			if tc.grouped {
+				expected = fn(tc.fn, OperationOr, "a", "b")
+			}
+			got, err := SimplifyCriteria(expr)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, got)
+		})
+	}
+}
+
+func TestSimplifyDeMorgan(t *testing.T) {
+	tests := []struct {
+		name string
+		expr CriteriaAST
+		want CriteriaAST
+	}{
+		{
+			name: "not or",
+			expr: not(or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"))),
+			want: and(
+				not(fn(FunctionFrom, OperationOr, "a")),
+				not(fn(FunctionTo, OperationOr, "b")),
+			),
+		},
+		{
+			name: "not or nested in and",
+			expr: and(
+				fn1(FunctionList, "l"),
+				not(or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"))),
+			),
+			want: and(
+				fn(FunctionList, OperationAnd, "l"),
+				not(fn(FunctionFrom, OperationOr, "a")),
+				not(fn(FunctionTo, OperationOr, "b")),
+			),
+		},
+		{
+			name: "not or too long",
+			expr: not(or(
+				fn1(FunctionFrom, "a"),
+				fn1(FunctionTo, "b"),
+				fn1(FunctionCc, "c"),
+				fn1(FunctionBcc, "d"),
+			)),
+			want: not(or(
+				fn(FunctionFrom, OperationOr, "a"),
+				fn(FunctionTo, OperationOr, "b"),
+				fn(FunctionCc, OperationOr, "c"),
+				fn(FunctionBcc, OperationOr, "d"),
+			)),
+		},
+		{
+			name: "not and",
+			expr: not(and(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"))),
+			want: not(and(
+				fn(FunctionFrom, OperationAnd, "a"),
+				fn(FunctionTo, OperationAnd, "b"),
+			)),
+		},
+		{
+			name: "not and of negations",
+			expr: not(and(not(fn1(FunctionFrom, "a")), not(fn1(FunctionTo, "b")))),
+			want: or(
+				fn(FunctionFrom, OperationOr, "a"),
+				fn(FunctionTo, OperationOr, "b"),
+			),
+		},
+		{
+			name: "double negation",
+			expr: not(not(or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")))),
+			want: or(
+				fn(FunctionFrom, OperationOr, "a"),
+				fn(FunctionTo, OperationOr, "b"),
+			),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := SimplifyCriteria(tc.expr)
+			assert.Nil(t, err)
+			sortTree(tc.want)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  CriteriaAST
+		equal bool
+	}{
+		{
+			name:  "or in any order",
+			a:     or(fn1(FunctionFrom, "a"), fn1(FunctionFrom, "b")),
+			b:     or(fn1(FunctionFrom, "b"), fn1(FunctionFrom, "a")),
+			equal: true,
+		},
+		{
+			name: "or and and",
+			a:    or(fn1(FunctionFrom, "a"), fn1(FunctionFrom, "b")),
+			b:    and(fn1(FunctionFrom, "a"), fn1(FunctionFrom, "b")),
+		},
+		{
+			name:  "grouped args in any order",
+			a:     fn(FunctionFrom, OperationOr, "a", "b", "c"),
+			b:     fn(FunctionFrom, OperationOr, "c", "a", "b"),
+			equal: true,
+		},
+		{
+			name: "different grouping",
+			a:    fn(FunctionFrom, OperationOr, "a", "b"),
+			b:    fn(FunctionFrom, OperationAnd, "a", "b"),
+		},
+		{
+			name:  "grouping of a single arg",
+			a:     fn(FunctionFrom, OperationOr, "a"),
+			b:     fn1(FunctionFrom, "a"),
+			equal: true,
+		},
+		{
+			name: "different function",
+			a:    fn1(FunctionFrom, "a"),
+			b:    fn1(FunctionTo, "a"),
+		},
+		{
+			name: "raw and escaped",
+			a:    fn1(FunctionFrom, "a"),
+			b:    &Leaf{Function: FunctionFrom, Grouping: OperationNone, Args: []string{"a"}, IsRaw: true},
+		},
+		{
+			name: "repeated children",
+			a:    and(fn1(FunctionFrom, "a"), fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")),
+			b:    and(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"), fn1(FunctionTo, "b")),
+		},
+		{
+			name: "nested",
+			a: and(
+				not(or(fn1(FunctionList, "x"), fn1(FunctionList, "y"))),
+				fn(FunctionFrom, OperationOr, "a", "b"),
+			),
+			b: and(
+				fn(FunctionFrom, OperationOr, "b", "a"),
+				not(or(fn1(FunctionList, "y"), fn1(FunctionList, "x"))),
+			),
+			equal: true,
+		},
+		{
+			name: "leaf and node",
+			a:    fn1(FunctionFrom, "a"),
+			b:    not(fn1(FunctionFrom, "a")),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.equal, tc.a.Equal(tc.b))
+			assert.Equal(t, tc.equal, tc.b.Equal(tc.a))
+		})
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tree := and(
+		fn1(FunctionFrom, "a"),
+		or(fn1(FunctionTo, "b"), not(fn1(FunctionSubject, "c"))),
+		fn1(FunctionList, "d"),
+	)
+
+	var got []string
+	Walk(tree, func(c CriteriaAST) bool {
+		got = append(got, c.String())
+		return true
+	})
+	// Every node is visited before its children, in order.
+	assert.Equal(t, []string{
+		"(from:a {to:b -subject:c} list:d)",
+		"from:a",
+		"{to:b -subject:c}",
+		"to:b",
+		"-subject:c",
+		"subject:c",
+		"list:d",
+	}, got)
+
+	// The children of the 'or' are skipped, but not its siblings.
+	got = nil
+	Walk(tree, func(c CriteriaAST) bool {
+		got = append(got, c.String())
+		return c.RootOperation() != OperationOr
+	})
+	assert.Equal(t, []string{
+		"(from:a {to:b -subject:c} list:d)",
+		"from:a",
+		"{to:b -subject:c}",
+		"list:d",
+	}, got)
+
+	// Stopping at the root visits nothing else.
+	got = nil
+	Walk(tree, func(c CriteriaAST) bool {
+		got = append(got, c.String())
+		return false
+	})
+	assert.Equal(t, []string{"(from:a {to:b -subject:c} list:d)"}, got)
+}
+
+func TestAllChildrenLeaves(t *testing.T) {
+	assert.True(t, allChildrenLeaves(and(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"))))
+	assert.False(t, allChildrenLeaves(and(fn1(FunctionFrom, "a"), not(fn1(FunctionTo, "b")))))
+	assert.False(t, allChildrenLeaves(fn1(FunctionFrom, "a")))
+}
@@ -0,0 +1,98 @@
+package parser
+
+import "fmt"
+
+// MaxDepth is the maximum nesting depth of logical operations that Gmail
+// reliably handles. Deeper queries are accepted, but can silently misbehave.
+const MaxDepth = 4
+
+// DepthWarning reports a rule whose criteria were nested too deeply.
+type DepthWarning struct {
+	// Rule is the index of the rule in the given rules.
+	Rule int
+	// Depth is the nesting depth of the original criteria.
+	Depth int
+	// Flattened is the nesting depth after flattening.
+	Flattened int
+	// Before and After are the queries before and after flattening.
+	Before, After string
+}
+
+func (w DepthWarning) String() string {
+	if w.Flattened > MaxDepth {
+		return fmt.Sprintf("rule #%d is nested %d levels deep, more than the %d Gmail handles reliably",
+			w.Rule, w.Flattened, MaxDepth)
+	}
+	return fmt.Sprintf("rule #%d was nested %d levels deep and was flattened from %q to %q",
+		w.Rule, w.Depth, w.Before, w.After)
+}
+
+// Depth returns the nesting depth of logical operations in the tree. Leaves
+// have depth zero.
+func Depth(tree CriteriaAST) int {
+	node, ok := tree.(*Node)
+	if !ok {
+		return 0
+	}
+	max := 0
+	for _, child := range node.Children {
+		if d := Depth(child); d > max {
+			max = d
+		}
+	}
+	return max + 1
+}
+
+// FlattenDeep flattens the criteria of the rules nested more than MaxDepth
+// levels, by squashing nested 'and' and 'or' operations into their parents.
+// Rules are returned in the same order, with a warning for each rule that
+// was too deep.
+//
+// Simplified criteria never nest an operation into the same one, so they
+// can only be reported as too deep, e.g. when alternating 'and' and 'or'.
+// Criteria are squashed only when parsed without simplification.
+func FlattenDeep(rules []Rule) ([]Rule, []DepthWarning) {
+	res := make([]Rule, len(rules))
+	var warnings []DepthWarning
+
+	for i, r := range rules {
+		res[i] = r
+		depth := Depth(r.Criteria)
+		if depth <= MaxDepth {
+			continue
+		}
+		flat := flatten(r.Criteria.Clone())
+		w := DepthWarning{
+			Rule:      i,
+			Depth:     depth,
+			Flattened: Depth(flat),
+			Before:    queryString(r.Criteria),
+			After:     queryString(flat),
+		}
+		if w.Before != w.After {
+			res[i].Criteria = flat
+		}
+		warnings = append(warnings, w)
+	}
+
+	return res, warnings
+}
+
+// flatten squashes the associative operations nested into the same
+// operation, bottom-up.
+//
+// Example:
+// and(a, and(b, and(c, d))) => and(a, b, c, d)
+func flatten(tree CriteriaAST) CriteriaAST {
+	root, ok := tree.(*Node)
+	if !ok {
+		return tree
+	}
+	for i, child := range root.Children {
+		root.Children[i] = flatten(child)
+	}
+	if root.Operation == OperationAnd || root.Operation == OperationOr {
+		logicalGrouping(root)
+	}
+	return root
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+// nestedAnd returns 'levels' nested 'and' operations, each with a leaf.
+func nestedAnd(levels int) *Node {
+	var tree CriteriaAST = fn1(FunctionFrom, "f0")
+	for i := 1; i < levels; i++ {
+		tree = and(fn1(FunctionFrom, "f"+string(rune('0'+i))), tree)
+	}
+	return and(fn1(FunctionTo, "me"), tree)
+}
+
+func TestDepth(t *testing.T) {
+	assert.Equal(t, 0, Depth(fn1(FunctionFrom, "a")))
+	assert.Equal(t, 1, Depth(and(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"))))
+	assert.Equal(t, 2, Depth(or(fn1(FunctionFrom, "a"), not(fn1(FunctionTo, "b")))))
+	assert.Equal(t, 6, Depth(nestedAnd(6)))
+}
+
+func TestFlattenDeep(t *testing.T) {
+	actions := Actions{Archive: true}
+	rules := []Rule{
+		{Criteria: and(fn1(FunctionFrom, "a"), and(fn1(FunctionTo, "b"), fn1(FunctionCc, "c"))), Actions: actions},
+		{Criteria: nestedAnd(6), Actions: actions},
+	}
+	got, warnings := FlattenDeep(rules)
+
+	require.Len(t, got, 2)
+	// Shallow rules are left alone.
+	assert.Equal(t, rules[0], got[0])
+	assert.Equal(t, and(
+		fn1(FunctionTo, "me"),
+		fn1(FunctionFrom, "f5"),
+		fn1(FunctionFrom, "f4"),
+		fn1(FunctionFrom, "f3"),
+		fn1(FunctionFrom, "f2"),
+		fn1(FunctionFrom, "f1"),
+		fn1(FunctionFrom, "f0"),
+	), got[1].Criteria)
+	assert.Equal(t, 1, Depth(got[1].Criteria))
+	assert.Equal(t, actions, got[1].Actions)
+	// The original rules are untouched.
+	assert.Equal(t, 6, Depth(rules[1].Criteria))
+
+	assert.Equal(t, []DepthWarning{
+		{
+			Rule:      1,
+			Depth:     6,
+			Flattened: 1,
+			Before:    "(to:me (from:f5 (from:f4 (from:f3 (from:f2 (from:f1 from:f0))))))",
+			After:     "(to:me from:f5 from:f4 from:f3 from:f2 from:f1 from:f0)",
+		},
+	}, warnings)
+	assert.Equal(t,
+		`rule #1 was nested 6 levels deep and was flattened from `+
+			`"(to:me (from:f5 (from:f4 (from:f3 (from:f2 (from:f1 from:f0))))))" to `+
+			`"(to:me from:f5 from:f4 from:f3 from:f2 from:f1 from:f0)"`,
+		warnings[0].String())
+}
+
+func TestFlattenDeepAlternating(t *testing.T) {
+	// Alternating operations can't be flattened.
+	crit := and(fn1(FunctionFrom, "a"), or(fn1(FunctionTo, "b"), and(fn1(FunctionCc, "c"),
+		or(fn1(FunctionBcc, "d"), and(fn1(FunctionList, "e"), fn1(FunctionSubject, "f"))))))
+	rules := []Rule{{Criteria: crit, Actions: Actions{Archive: true}}}
+
+	got, warnings := FlattenDeep(rules)
+	assert.Equal(t, rules, got)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, 5, warnings[0].Flattened)
+	assert.Equal(t, "rule #0 is nested 5 levels deep, more than the 4 Gmail handles reliably",
+		warnings[0].String())
+}
+
+func TestParseNestedAnd(t *testing.T) {
+	// Six levels of nested 'and' in the config.
+	filter := cfg.FilterNode{From: "f0"}
+	for i := 1; i < 6; i++ {
+		filter = cfg.FilterNode{And: []cfg.FilterNode{
+			{From: "f" + string(rune('0'+i))},
+			filter,
+		}}
+	}
+	config := cfg.Config{
+		Rules: []cfg.Rule{{Filter: filter, Actions: cfg.Actions{Archive: true}}},
+	}
+	got, err := Parse(config)
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+	// Simplification already collapses them into a single grouped leaf.
+	assert.Equal(t, 0, Depth(got[0].Criteria))
+	assert.Equal(t, "from:(f5 f4 f3 f2 f1 f0)", got[0].Criteria.String())
+
+	_, warnings := FlattenDeep(got)
+	assert.Empty(t, warnings)
+}
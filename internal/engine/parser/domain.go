@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// domainRe matches a bare domain name, like 'example.com'.
+var domainRe = regexp.MustCompile(`(?i)^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+
+// DomainAddress returns the address matching anyone at the domain, e.g.
+// '@example.com' like lib.fromDomain, if the given address is only a
+// domain, like 'example.com'. The domain has to end with a known top level
+// domain, so that names like 'first.last' are not taken for one.
+//
+// Gmail doesn't treat a bare domain as the domain of the sender, so it
+// likely doesn't match what the user expects.
+func DomainAddress(addr string) (string, bool) {
+	if !domainRe.MatchString(addr) {
+		return "", false
+	}
+	if _, icann := publicsuffix.PublicSuffix(strings.ToLower(addr)); !icann {
+		return "", false
+	}
+	return "@" + addr, true
+}
+
+// normalizeDomains replaces the bare domains in the 'from' criteria of the
+// tree with the addresses matching anyone at them.
+func normalizeDomains(tree CriteriaAST) {
+	Walk(tree, func(c CriteriaAST) bool {
+		n, ok := c.(*Leaf)
+		if !ok || n.Function != FunctionFrom || n.IsRaw {
+			return true
+		}
+		for i, arg := range n.Args {
+			if a, ok := DomainAddress(arg); ok {
+				n.Args[i] = a
+			}
+		}
+		return false
+	})
+}
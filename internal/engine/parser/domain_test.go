@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+func TestDomainAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "example.com", want: "@example.com"},
+		{addr: "mail.Example.co.uk", want: "@mail.Example.co.uk"},
+		{addr: "my-company.io", want: "@my-company.io"},
+		// Full addresses.
+		{addr: "alice@example.com"},
+		{addr: "@example.com"},
+		// Wildcards.
+		{addr: "*@example.com"},
+		{addr: "*.example.com"},
+		// Not domains.
+		{addr: "alice"},
+		{addr: "-example.com"},
+		{addr: "example.c0m"},
+		{addr: `"example.com"`},
+		// Unknown top level domains, like in names.
+		{addr: "first.last"},
+		{addr: "john.doe"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.addr, func(t *testing.T) {
+			got, ok := DomainAddress(tc.addr)
+			assert.Equal(t, tc.want != "", ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseNormalizeDomains(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{{From: "example.com"}, {From: "bob@work.com"}}},
+						{Not: &cfg.FilterNode{From: "spam.org"}},
+						{To: "example.com"},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+
+	rules, err := Parse(config)
+	require.Nil(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "(to:example.com from:{example.com bob@work.com} -from:spam.org)",
+		rules[0].Criteria.String())
+
+	// Only the 'from' domains are replaced.
+	rules, err = ParseWithOptions(config, Options{NormalizeDomains: true})
+	require.Nil(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "(to:example.com from:{@example.com bob@work.com} -from:@spam.org)",
+		rules[0].Criteria.String())
+}
@@ -0,0 +1,224 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// queryFunctions are the functions that can be parsed back from a Gmail
+// query, by name.
+var queryFunctions = func() map[string]FunctionType {
+	res := map[string]FunctionType{}
+	for f := FunctionFrom; f <= FunctionCategory; f++ {
+		if f != FunctionHas {
+			res[f.String()] = f
+		}
+	}
+	return res
+}()
+
+// ParseQuery parses a Gmail search query, like the ones generated by
+// GenerateQuery, into its criteria.
+//
+// Only a subset of the Gmail syntax is supported: functions with a single
+// value or a grouped one, like 'from:{a b}', bare words, '{}' and '()'
+// grouping and '-' negation. Anything else, like the 'OR' operator or
+// unknown functions, is an error, so that the query can be kept verbatim.
+func ParseQuery(query string) (CriteriaAST, error) {
+	p := queryParser{s: query}
+	terms, err := p.parseTerms(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.s[p.pos], p.pos)
+	}
+	return joinTerms(OperationAnd, terms)
+}
+
+type queryParser struct {
+	s   string
+	pos int
+}
+
+// parseTerms parses the terms up to the given closing char, or to the end of
+// the query if zero.
+func (p *queryParser) parseTerms(closing byte) ([]CriteriaAST, error) {
+	var res []CriteriaAST
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.s) {
+			if closing != 0 {
+				return nil, fmt.Errorf("missing %q", closing)
+			}
+			return res, nil
+		}
+		if c := p.s[p.pos]; c == closing {
+			p.pos++
+			return res, nil
+		} else if c == '}' || c == ')' {
+			return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+		}
+		t, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+}
+
+func (p *queryParser) parseTerm() (CriteriaAST, error) {
+	switch p.s[p.pos] {
+	case '-':
+		p.pos++
+		if p.pos >= len(p.s) || isQuerySpace(p.s[p.pos]) {
+			return nil, errors.New("'-' must be followed by a term")
+		}
+		t, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Operation: OperationNot, Children: []CriteriaAST{t}}, nil
+	case '{':
+		p.pos++
+		terms, err := p.parseTerms('}')
+		if err != nil {
+			return nil, err
+		}
+		return joinTerms(OperationOr, terms)
+	case '(':
+		p.pos++
+		terms, err := p.parseTerms(')')
+		if err != nil {
+			return nil, err
+		}
+		return joinTerms(OperationAnd, terms)
+	case '"':
+		arg, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &Leaf{Function: FunctionHas, Grouping: OperationNone, Args: []string{arg}}, nil
+	}
+
+	word := p.parseWord()
+	if queryOperators[word] {
+		return nil, fmt.Errorf("operator %q is not supported", word)
+	}
+	i := strings.Index(word, ":")
+	if i < 0 {
+		return &Leaf{Function: FunctionHas, Grouping: OperationNone, Args: []string{word}}, nil
+	}
+	name, value := word[:i], word[i+1:]
+	if word == hasAttachmentQuery {
+		return &Leaf{Function: FunctionHasAttachment, Grouping: OperationNone}, nil
+	}
+	fn, ok := queryFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("function %q is not supported", name)
+	}
+	if value != "" {
+		return &Leaf{Function: fn, Grouping: OperationNone, Args: []string{value}}, nil
+	}
+	return p.parseFunctionValue(fn)
+}
+
+// parseFunctionValue parses the value of a function right after the colon,
+// which is either quoted or grouped.
+func (p *queryParser) parseFunctionValue(fn FunctionType) (CriteriaAST, error) {
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("missing value for %q", fn)
+	}
+	var grouping OperationType
+	var closing byte
+	switch p.s[p.pos] {
+	case '"':
+		arg, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &Leaf{Function: fn, Grouping: OperationNone, Args: []string{arg}}, nil
+	case '{':
+		grouping, closing = OperationOr, '}'
+	case '(':
+		grouping, closing = OperationAnd, ')'
+	default:
+		return nil, fmt.Errorf("missing value for %q", fn)
+	}
+	p.pos++
+
+	var args []string
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("missing %q", closing)
+		}
+		switch p.s[p.pos] {
+		case closing:
+			p.pos++
+			if len(args) == 0 {
+				return nil, fmt.Errorf("empty group for %q", fn)
+			}
+			if len(args) == 1 {
+				grouping = OperationNone
+			}
+			return &Leaf{Function: fn, Grouping: grouping, Args: args}, nil
+		case '"':
+			arg, err := p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		case '{', '}', '(', ')', '-':
+			return nil, fmt.Errorf("unsupported %q in the value of %q", p.s[p.pos], fn)
+		default:
+			word := p.parseWord()
+			if queryOperators[word] {
+				return nil, fmt.Errorf("operator %q is not supported", word)
+			}
+			args = append(args, word)
+		}
+	}
+}
+
+func (p *queryParser) parseQuoted() (string, error) {
+	end := strings.IndexByte(p.s[p.pos+1:], '"')
+	if end < 0 {
+		return "", errors.New("unbalanced quotes")
+	}
+	res := p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return res, nil
+}
+
+// parseWord reads up to the next space, grouping or quote char.
+func (p *queryParser) parseWord() string {
+	start := p.pos
+	for p.pos < len(p.s) && !isQuerySpace(p.s[p.pos]) && !strings.ContainsRune(`{}()"`, rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *queryParser) skipSpaces() {
+	for p.pos < len(p.s) && isQuerySpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func joinTerms(op OperationType, terms []CriteriaAST) (CriteriaAST, error) {
+	switch len(terms) {
+	case 0:
+		return nil, errors.New("empty query")
+	case 1:
+		return terms[0], nil
+	default:
+		return &Node{Operation: op, Children: terms}, nil
+	}
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  CriteriaAST
+	}{
+		{
+			name:  "single function",
+			query: "from:a@b.com",
+			want:  fn1(FunctionFrom, "a@b.com"),
+		},
+		{
+			name:  "grouped or",
+			query: "from:{a b c}",
+			want:  fn(FunctionFrom, OperationOr, "a", "b", "c"),
+		},
+		{
+			name:  "grouped and",
+			query: `subject:(foo "bar baz")`,
+			want:  fn(FunctionSubject, OperationAnd, "foo", "bar baz"),
+		},
+		{
+			name:  "quoted",
+			query: `subject:"Re: [ACME] update (v2)"`,
+			want:  fn1(FunctionSubject, "Re: [ACME] update (v2)"),
+		},
+		{
+			name:  "bare words",
+			query: `("exact phrase" word)`,
+			want:  and(fn1(FunctionHas, "exact phrase"), fn1(FunctionHas, "word")),
+		},
+		{
+			name:  "has attachment",
+			query: "has:attachment",
+			want:  &Leaf{Function: FunctionHasAttachment, Grouping: OperationNone},
+		},
+		{
+			name:  "nested",
+			query: "(list:dev@lists.com {to:me -cc:(a b)} -{from:x is:starred})",
+			want: and(
+				fn1(FunctionList, "dev@lists.com"),
+				or(fn1(FunctionTo, "me"), not(fn(FunctionCc, OperationAnd, "a", "b"))),
+				not(or(fn1(FunctionFrom, "x"), fn1(FunctionIs, "starred"))),
+			),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseQuery(tc.query)
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+			// Generating the query again gives back the original, where
+			// the root 'and' is explicit.
+			assert.Equal(t, tc.query, got.String())
+		})
+	}
+}
+
+func TestParseQueryUnsupported(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "or operator", query: "from:a OR from:b", want: `operator "OR" is not supported`},
+		{name: "unknown function", query: "label:work", want: `function "label" is not supported`},
+		{name: "unbalanced group", query: "{from:a from:b", want: `missing '}'`},
+		{name: "unbalanced quotes", query: `subject:"foo`, want: "unbalanced quotes"},
+		{name: "stray closing", query: "from:a)", want: `unexpected ')' at position 6`},
+		{name: "empty", query: " ", want: "empty query"},
+		{name: "missing value", query: "from: a", want: `missing value for "from"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseQuery(tc.query)
+			require.NotNil(t, err)
+			assert.Equal(t, tc.want, err.Error())
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MergeConflict reports a rule with the same criteria of a previous one,
+// that couldn't be merged with it because their actions conflict.
+type MergeConflict struct {
+	// Rule is the index of the first rule with the criteria.
+	Rule int
+	// Duplicate is the index of the rule that was left separate.
+	Duplicate int
+	// Reason describes the conflicting actions.
+	Reason string
+}
+
+func (c MergeConflict) String() string {
+	return fmt.Sprintf("rule #%d has the same criteria of rule #%d, but can't be merged: %s",
+		c.Duplicate, c.Rule, c.Reason)
+}
+
+// MergeDuplicates merges the rules with equal criteria (see CriteriaAST.Equal)
+// into a single rule, applying all the actions.
+//
+// Gmail applies all the matching filters, so the result is equivalent, but
+// requires fewer filters. Rules that both apply labels are not merged,
+// because every label requires its own filter anyway. Rules whose actions
+// conflict (e.g. two different categories) are left separate and reported.
+// Indexes refer to the given rules and the merged rules take the place, and
+// the name if any, of the first one.
+func MergeDuplicates(rules []Rule) ([]Rule, []MergeConflict) {
+	res, _, conflicts := MergeDuplicatesIndexed(rules)
+	return res, conflicts
+}
+
+// MergeDuplicatesIndexed is like MergeDuplicates, but also returns the index
+// of the first of the given rules each result comes from.
+func MergeDuplicatesIndexed(rules []Rule) ([]Rule, []int, []MergeConflict) {
+	var (
+		res       []Rule
+		conflicts []MergeConflict
+		// origin maps the results to the index of their first rule.
+		origin []int
+	)
+
+	for i, r := range rules {
+		merged := false
+		var conflict *MergeConflict
+
+		for j := range res {
+			if !res[j].Criteria.Equal(r.Criteria) {
+				continue
+			}
+			// Every label requires a separate Gmail filter, so there's
+			// nothing to gain by merging rules that both apply labels.
+			if len(res[j].Actions.Labels) > 0 && len(r.Actions.Labels) > 0 {
+				continue
+			}
+			actions, err := mergeActions(res[j].Actions, r.Actions)
+			if err != nil {
+				// Another rule with the same criteria might still be
+				// compatible, so only the first conflict is kept.
+				if conflict == nil {
+					conflict = &MergeConflict{
+						Rule:      origin[j],
+						Duplicate: i,
+						Reason:    err.Error(),
+					}
+				}
+				continue
+			}
+			res[j].Actions = actions
+			if res[j].Name == "" {
+				res[j].Name = r.Name
+			}
+			merged = true
+			break
+		}
+
+		if merged {
+			continue
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+		}
+		res = append(res, r)
+		origin = append(origin, i)
+	}
+
+	return res, origin, conflicts
+}
+
+// mergeActions merges all the fields of the actions: flags are combined,
+// values that differ are a conflict and labels are appended.
+func mergeActions(a1, a2 Actions) (Actions, error) {
+	// Use reflection, so that new actions can't be silently dropped.
+	var res Actions
+	v1, v2 := reflect.ValueOf(a1), reflect.ValueOf(a2)
+	rv := reflect.ValueOf(&res).Elem()
+	t := rv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		f1, f2, field := v1.Field(i), v2.Field(i), rv.Field(i)
+
+		switch f1.Kind() {
+		case reflect.Bool:
+			field.SetBool(f1.Bool() || f2.Bool())
+		case reflect.String:
+			v, err := mergeString(name, f1.String(), f2.String())
+			if err != nil {
+				return res, err
+			}
+			field.SetString(v)
+		case reflect.Slice:
+			field.Set(reflect.AppendSlice(reflect.AppendSlice(field, f1), f2))
+		default:
+			if b1, ok := f1.Interface().(*bool); ok {
+				b, err := mergeTribool(name, b1, f2.Interface().(*bool))
+				if err != nil {
+					return res, err
+				}
+				field.Set(reflect.ValueOf(b))
+				continue
+			}
+			// Unknown actions can only be merged if they are the same.
+			if !reflect.DeepEqual(f1.Interface(), f2.Interface()) {
+				return res, fmt.Errorf("conflicting values for '%s'", name)
+			}
+			field.Set(f1)
+		}
+	}
+
+	return res, nil
+}
+
+func mergeTribool(field string, b1, b2 *bool) (*bool, error) {
+	if b1 == nil {
+		return b2, nil
+	}
+	if b2 != nil && *b1 != *b2 {
+		return nil, fmt.Errorf("conflicting values for '%s'", field)
+	}
+	return b1, nil
+}
+
+func mergeString(field, s1, s2 string) (string, error) {
+	if s1 == "" {
+		return s2, nil
+	}
+	if s2 != "" && s1 != s2 {
+		return "", fmt.Errorf("conflicting values for '%s': %q and %q", field, s1, s2)
+	}
+	return s1, nil
+}
@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mbrt/gmailctl/internal/engine/gmail"
+)
+
+func TestMergeDuplicates(t *testing.T) {
+	boolPtr := func(b bool) *bool { return &b }
+
+	tests := []struct {
+		name      string
+		rules     []Rule
+		want      []Rule
+		conflicts []MergeConflict
+	}{
+		{
+			name: "mergeable",
+			rules: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Archive: true}},
+				{Criteria: fn1(FunctionFrom, "b"), Actions: Actions{Star: true}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Labels: []string{"l1", "l2"}}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{MarkImportant: boolPtr(false)}},
+			},
+			want: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{
+					Archive:       true,
+					Labels:        []string{"l1", "l2"},
+					MarkImportant: boolPtr(false),
+				}},
+				{Criteria: fn1(FunctionFrom, "b"), Actions: Actions{Star: true}},
+			},
+		},
+		{
+			name: "all actions",
+			rules: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{
+					Archive:       true,
+					MarkSpam:      boolPtr(false),
+					Category:      gmail.CategoryUpdates,
+					Forward:       "me@example.com",
+					NeverMarkSpam: true,
+				}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{
+					Delete:             true,
+					MarkRead:           true,
+					Star:               true,
+					MarkImportant:      boolPtr(true),
+					Category:           gmail.CategoryUpdates,
+					Labels:             []string{"l1"},
+					NeverMarkImportant: true,
+				}},
+			},
+			want: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{
+					Archive:            true,
+					Delete:             true,
+					MarkRead:           true,
+					Star:               true,
+					MarkSpam:           boolPtr(false),
+					MarkImportant:      boolPtr(true),
+					NeverMarkSpam:      true,
+					NeverMarkImportant: true,
+					Category:           gmail.CategoryUpdates,
+					Labels:             []string{"l1"},
+					Forward:            "me@example.com",
+				}},
+			},
+		},
+		{
+			name: "names",
+			rules: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Archive: true}},
+				{Name: "second", Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Star: true}},
+				{Name: "third", Criteria: fn1(FunctionFrom, "a"), Actions: Actions{MarkRead: true}},
+			},
+			want: []Rule{
+				{Name: "second", Criteria: fn1(FunctionFrom, "a"), Actions: Actions{
+					Archive:  true,
+					Star:     true,
+					MarkRead: true,
+				}},
+			},
+		},
+		{
+			name: "reordered criteria",
+			rules: []Rule{
+				{Criteria: or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")), Actions: Actions{Archive: true}},
+				{Criteria: or(fn1(FunctionTo, "b"), fn1(FunctionFrom, "a")), Actions: Actions{Star: true}},
+			},
+			want: []Rule{
+				{Criteria: or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")), Actions: Actions{
+					Archive: true,
+					Star:    true,
+				}},
+			},
+		},
+		{
+			name: "both with labels",
+			rules: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Labels: []string{"l1"}, Archive: true}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Labels: []string{"l2"}}},
+			},
+			want: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Labels: []string{"l1"}, Archive: true}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Labels: []string{"l2"}}},
+			},
+		},
+		{
+			name: "structurally identical",
+			rules: []Rule{
+				{Criteria: and(fn1(FunctionFrom, "a"), not(fn1(FunctionTo, "b"))), Actions: Actions{Archive: true}},
+				{Criteria: and(fn1(FunctionFrom, "a"), not(fn1(FunctionTo, "b"))), Actions: Actions{MarkRead: true}},
+			},
+			want: []Rule{
+				{Criteria: and(fn1(FunctionFrom, "a"), not(fn1(FunctionTo, "b"))), Actions: Actions{
+					Archive:  true,
+					MarkRead: true,
+				}},
+			},
+		},
+		{
+			name: "conflicting categories",
+			rules: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Category: gmail.CategorySocial}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Category: gmail.CategoryForums}},
+			},
+			want: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Category: gmail.CategorySocial}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{Category: gmail.CategoryForums}},
+			},
+			conflicts: []MergeConflict{
+				{Rule: 0, Duplicate: 1, Reason: `conflicting values for 'category': "social" and "forums"`},
+			},
+		},
+		{
+			name: "conflicting tribools",
+			rules: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{MarkImportant: boolPtr(true)}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{MarkSpam: boolPtr(false)}},
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{MarkImportant: boolPtr(false)}},
+			},
+			want: []Rule{
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{
+					MarkImportant: boolPtr(true),
+					MarkSpam:      boolPtr(false),
+				}},
+				// The last rule conflicts with the first.
+				{Criteria: fn1(FunctionFrom, "a"), Actions: Actions{MarkImportant: boolPtr(false)}},
+			},
+			conflicts: []MergeConflict{
+				{Rule: 0, Duplicate: 2, Reason: "conflicting values for 'markImportant'"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, conflicts := MergeDuplicates(tc.rules)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.conflicts, conflicts)
+		})
+	}
+}
@@ -2,15 +2,34 @@ package parser
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
+	"unicode"
 
+	"github.com/mbrt/gmailctl/internal/engine/config"
 	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/gmail"
 	"github.com/mbrt/gmailctl/internal/errors"
 	"github.com/mbrt/gmailctl/internal/reporting"
 )
 
+var (
+	sizeRe         = regexp.MustCompile(`^[0-9]+[kKmM]?$`)
+	relativeDateRe = regexp.MustCompile(`^[0-9]+[dDmMyY]$`)
+	opNameRe       = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+	// Values accepted by the 'in:' and 'is:' Gmail operators.
+	inValues = []string{"anywhere", "inbox", "trash", "spam", "sent", "drafts", "snoozed", "chats"}
+	isValues = []string{"starred", "unread", "read", "important", "snoozed", "muted"}
+	// Values accepted by the 'category:' Gmail operator. They differ from
+	// the categories of the actions: 'personal' is called 'primary' here.
+	categoryValues = []string{"primary", "social", "promotions", "updates", "forums", "reservations", "purchases"}
+)
+
 // Rule is an intermediate representation of a Gmail filter.
 type Rule struct {
+	// Name is the optional name of the config rule.
+	Name     string
 	Criteria CriteriaAST
 	Actions  Actions
 }
@@ -31,110 +50,278 @@ func allChildrenLeaves(tree CriteriaAST) bool {
 	return true
 }
 
+// RuleError is an error in parsing a specific rule of the config.
+type RuleError struct {
+	// Index is the position of the rule in the config.
+	Index int
+	// Filter is the criteria of the offending rule.
+	Filter cfg.FilterNode
+	// Err is the underlying cause.
+	Err error
+}
+
+func (e RuleError) Error() string {
+	return fmt.Sprintf("rule #%d: %v", e.Index, e.Err)
+}
+
+func (e RuleError) Unwrap() error {
+	return e.Err
+}
+
+// Options control how the rules are parsed.
+type Options struct {
+	// NoSimplify keeps the criteria as they are written, skipping the
+	// simplifications and the split of the rules. The resulting queries
+	// might be longer, or split differently, but they are more predictable.
+	NoSimplify bool
+	// NormalizeDomains replaces the bare domains in the 'from' criteria,
+	// like 'example.com', with the addresses matching anyone at them, like
+	// '@example.com'.
+	NormalizeDomains bool
+}
+
 // Parse parses config file rules into their intermediate representation.
 //
 // Note that the number of rules and their contents might be different than the
 // original, because symplifications will be performed on the data.
 func Parse(config cfg.Config) ([]Rule, error) {
+	return ParseWithOptions(config, Options{})
+}
+
+// ParseString reads a config from its text and parses its rules.
+//
+// The format is detected from the content, like in config.Read. Imports
+// are resolved relative to the current directory.
+func ParseString(s string) ([]Rule, error) {
+	c, _, err := config.Read(strings.NewReader(s), config.InputAuto, "", config.ReadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return Parse(c)
+}
+
+// ParseWithOptions is like Parse, with the given options.
+func ParseWithOptions(config cfg.Config, opts Options) ([]Rule, error) {
+	res, _, err := ParseIndexed(config, opts)
+	return res, err
+}
+
+// ParseIndexed is like ParseWithOptions, but it also returns, for every
+// parsed rule, the index of the config rule it comes from.
+func ParseIndexed(config cfg.Config, opts Options) ([]Rule, []int, error) {
 	res := []Rule{}
+	var indexes []int
 	for i, rule := range config.Rules {
-		r, err := parseRule(rule)
+		rs, err := parseRuleGroup(rule, opts)
 		if err != nil {
-			return nil, errors.WithDetails(
-				fmt.Errorf("rule #%d: %w", i, err),
+			return nil, nil, errors.WithDetails(
+				RuleError{Index: i, Filter: rule.Filter, Err: err},
 				fmt.Sprintf("Rule: %s", reporting.Prettify(rule, false)),
 			)
 		}
 
-		root, ok := r.Criteria.(*Node)
-		if ok {
-			left, ok := root.Children[0].(*Node)
-			// {a b c} -{x y z} ->
-			//    a -{x y z}
-			//    b -{x y z}
-			//    c -{x y z}
-			if ok &&
-				allChildrenLeaves(root.Children[0]) &&
-				len(root.Children) == 2 &&
-				root.RootOperation() == OperationAnd &&
-				len(left.Children) > 1 &&
-				root.Children[0].RootOperation() == OperationOr &&
-				root.Children[1].RootOperation() == OperationNot {
-				for _, child := range left.Children {
-					var children []CriteriaAST
-					children = append(children, child)
-					children = append(children, root.Children[1].(*Node).Clone())
-					res = append(res, Rule{
-						Criteria: &Node{
-							Children:  children,
-							Operation: OperationAnd,
-						},
-						Actions: r.Actions,
-					})
-				}
-			} else {
-
-				fmt.Println("left leaf?")
-				leaf, ok := root.Children[0].(*Leaf)
-
-				if ok &&
-					root.RootOperation() == OperationAnd &&
-					root.Children[0].RootOperation() == OperationOr &&
-					len(leaf.Args) > 1 {
-					fmt.Println("yep")
-
-					for _, arg := range leaf.Args {
-						var children []CriteriaAST
-						children = append(children, &Leaf{
-							Function: FunctionList,
-							Grouping: OperationOr,
-							Args:     []string{arg},
-							IsRaw:    leaf.IsRaw,
-						})
-						for _, rem_child := range root.Children[1:] {
-							children = append(children, rem_child.(*Node).Clone())
-						}
-						res = append(res, Rule{
-							Criteria: &Node{
-								Children:  children,
-								Operation: OperationAnd,
-							},
-							Actions: r.Actions,
-						})
+		for _, r := range rs {
+			if root, ok := r.Criteria.(*Node); ok && !opts.NoSimplify {
+				if rules, ok := distributeOrOverAnd(root, r.Actions); ok {
+					for j := range rules {
+						rules[j].Name = r.Name
+						indexes = append(indexes, i)
 					}
-
-				} else {
-					fmt.Println("left is not leaf")
+					res = append(res, rules...)
+					continue
 				}
 			}
-		} else {
 			res = append(res, r)
+			indexes = append(indexes, i)
 		}
 	}
+	return res, indexes, nil
+}
+
+// parseRuleGroup parses a config rule into one rule per group of actions,
+// all sharing the same criteria. Rules without action groups produce a
+// single rule.
+func parseRuleGroup(rule cfg.Rule, opts Options) ([]Rule, error) {
+	if err := checkGuard(rule.Guard); err != nil {
+		return nil, err
+	}
+	if rule.ActionGroups == nil {
+		r, err := parseRule(rule, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []Rule{r}, nil
+	}
+	if !rule.Actions.Empty() {
+		return nil, errors.New("'actions' and 'actionGroups' are mutually exclusive")
+	}
+	if len(rule.ActionGroups) == 0 {
+		return nil, errors.New("'actionGroups' specified but contains no groups")
+	}
+
+	var res []Rule
+	for i, actions := range rule.ActionGroups {
+		r, err := parseRule(cfg.Rule{Name: rule.Name, Filter: rule.Filter, Actions: actions, CatchAll: rule.CatchAll}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("action group #%d: %w", i, err)
+		}
+		res = append(res, r)
+	}
 	return res, nil
 }
 
-func parseRule(rule cfg.Rule) (Rule, error) {
+// distributeOrOverAnd splits a rule with an AND root, whose first child is an
+// OR, into multiple rules, one per element of the OR. Each rule gets its own
+// copy of all the remaining children of the AND.
+//
+// Example:
+//
+//	{a b c} -{x y z} =>
+//	  a -{x y z}
+//	  b -{x y z}
+//	  c -{x y z}
+//
+// An OR of nodes is only distributed if at least one of the remaining
+// children is a 'not', e.g. '{a b} d -{x} -{y}'.
+//
+// The returned boolean is false if the transformation doesn't apply, in which
+// case the rule should be kept as-is.
+func distributeOrOverAnd(root *Node, actions Actions) ([]Rule, bool) {
+	if root.Operation != OperationAnd || len(root.Children) == 0 {
+		return nil, false
+	}
+
+	var (
+		heads []CriteriaAST
+		tail  = root.Children[1:]
+	)
+	switch first := root.Children[0].(type) {
+	case *Node:
+		// or(a, b, c) as first child: the tail must contain a 'not'.
+		if first.Operation != OperationOr ||
+			len(first.Children) <= 1 ||
+			!allChildrenLeaves(first) ||
+			!hasNot(tail) {
+			return nil, false
+		}
+		heads = first.Children
+	case *Leaf:
+		// fn:{a b c} as first child.
+		if first.Grouping != OperationOr || len(first.Args) <= 1 {
+			return nil, false
+		}
+		for _, arg := range first.Args {
+			heads = append(heads, &Leaf{
+				Function: first.Function,
+				Grouping: OperationNone,
+				Args:     []string{arg},
+				IsRaw:    first.IsRaw,
+				IsPhrase: first.IsPhrase,
+			})
+		}
+	default:
+		return nil, false
+	}
+
+	var res []Rule
+	for _, head := range heads {
+		children := []CriteriaAST{head.Clone()}
+		for _, c := range tail {
+			children = append(children, c.Clone())
+		}
+		res = append(res, Rule{
+			Criteria: &Node{
+				Operation: OperationAnd,
+				Children:  children,
+			},
+			Actions: actions,
+		})
+	}
+	return res, true
+}
+
+func hasNot(children []CriteriaAST) bool {
+	for _, c := range children {
+		if c.RootOperation() == OperationNot {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRule(rule cfg.Rule, opts Options) (Rule, error) {
 	res := Rule{}
 
 	crit, err := parseCriteria(rule.Filter)
 	if err != nil {
 		return res, fmt.Errorf("parsing criteria: %w", err)
 	}
-	scrit, err := SimplifyCriteria(crit)
-	if err != nil {
-		return res, fmt.Errorf("simplifying criteria: %w", err)
+	if opts.NormalizeDomains {
+		normalizeDomains(crit)
+	}
+	scrit := crit
+	if !opts.NoSimplify {
+		if scrit, err = SimplifyCriteria(crit); err != nil {
+			return res, fmt.Errorf("simplifying criteria: %w", err)
+		}
+	}
+	// The actions would be applied to every incoming message.
+	if MatchesAll(scrit) && !rule.CatchAll {
+		return res, errors.WithDetails(fmt.Errorf("criteria %q matches all messages", scrit),
+			"If the rule is meant to apply to every message, mark it with 'catchAll: true'.")
+	}
+	if rule.CatchAll && !MatchesAll(scrit) {
+		return res, errors.WithDetails(fmt.Errorf("rule marked as catch-all, but criteria %q don't match all messages", scrit),
+			"Remove 'catchAll: true' from the rule, unless the criteria are wrong.")
 	}
 	if rule.Actions.Empty() {
 		return res, errors.New("empty action")
 	}
+	actions, err := ParseActions(rule.Actions)
+	if err != nil {
+		return res, err
+	}
 
 	return Rule{
+		Name:     rule.Name,
 		Criteria: scrit,
-		Actions:  Actions(rule.Actions),
+		Actions:  actions,
 	}, nil
 }
 
+// checkGuard returns an error if the guard is specified, but doesn't have
+// exactly one condition.
+//
+// Guards depend on the state of the account, so they are not evaluated
+// here. Rules with a guard are parsed as if it was satisfied.
+func checkGuard(g *cfg.RuleGuard) error {
+	if g == nil {
+		return nil
+	}
+	if (g.LabelExists == "") == (g.LabelMissing == "") {
+		return errors.New("'guard' requires exactly one of 'labelExists' and 'labelMissing'")
+	}
+	return nil
+}
+
+// ParseActions validates the given actions and normalizes them, by
+// replacing 'neverMarkSpam' and 'neverMarkImportant' with the equivalent
+// 'markSpam: false' and 'markImportant: false'.
+func ParseActions(a cfg.Actions) (Actions, error) {
+	if err := checkCategory(a.Category); err != nil {
+		return Actions{}, err
+	}
+	var err error
+	if a.MarkSpam, err = foldNever("markSpam", a.MarkSpam, a.NeverMarkSpam); err != nil {
+		return Actions{}, err
+	}
+	if a.MarkImportant, err = foldNever("markImportant", a.MarkImportant, a.NeverMarkImportant); err != nil {
+		return Actions{}, err
+	}
+	a.NeverMarkSpam, a.NeverMarkImportant = false, false
+	return Actions(a), nil
+}
+
 func parseCriteria(f cfg.FilterNode) (CriteriaAST, error) {
 	if err := checkSyntax(f); err != nil {
 		return nil, err
@@ -156,19 +343,29 @@ func parseCriteria(f cfg.FilterNode) (CriteriaAST, error) {
 			Children:  astchildren,
 		}, nil
 	}
-	if fn, arg := parseFunction(f); fn != FunctionNone {
+	if fn, args := parseFunction(f); fn != FunctionNone {
 		return &Leaf{
 			Function: fn,
 			Grouping: OperationNone,
-			Args:     []string{arg},
+			Args:     args,
 			IsRaw:    f.IsEscaped,
+			IsPhrase: f.SubjectExact != "",
 		}, nil
 	}
 
 	return nil, errors.New("empty filter node")
 }
 
+// CheckNode returns the first problem found in the given filter node, like
+// multiple fields or invalid values. Children are not checked.
+func CheckNode(f cfg.FilterNode) error {
+	return checkSyntax(f)
+}
+
 func checkSyntax(f cfg.FilterNode) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
 	fs := f.NonEmptyFields()
 	if len(fs) != 1 {
 		if len(fs) == 0 {
@@ -177,18 +374,159 @@ func checkSyntax(f cfg.FilterNode) error {
 		return fmt.Errorf("multiple fields specified in the same filter node: %s",
 			strings.Join(fs, ","))
 	}
+	if err := checkSize("larger", f.Larger); err != nil {
+		return err
+	}
+	if err := checkSize("smaller", f.Smaller); err != nil {
+		return err
+	}
+	if err := checkRelativeDate("newerThan", f.NewerThan); err != nil {
+		return err
+	}
+	if err := checkRelativeDate("olderThan", f.OlderThan); err != nil {
+		return err
+	}
+	if err := checkOneOf("in", f.In, inValues); err != nil {
+		return err
+	}
+	if err := checkOneOf("is", f.Is, isValues); err != nil {
+		return err
+	}
+	if err := checkOneOf("category", f.Category, categoryValues); err != nil {
+		return err
+	}
+	if strings.Contains(f.SubjectExact, `"`) {
+		return fmt.Errorf("invalid quote in 'subjectExact' value %q", f.SubjectExact)
+	}
+	if f.Query != "" {
+		if err := config.ValidateQuery(f.Query); err != nil {
+			return errors.WithDetails(err,
+				"Use 'rawQuery' instead of 'query' to skip the checks.")
+		}
+	}
+	if f.Op != nil {
+		if err := checkRawFunction(*f.Op); err != nil {
+			return err
+		}
+	}
 	if !f.IsEscaped {
 		return nil
 	}
 
 	// Check that 'isRaw' is used correctly
 	allowed := []string{"from", "to", "subject"}
-	for _, s := range allowed {
+	values := []string{f.From, f.To, f.Subject}
+	value := ""
+	for i, s := range allowed {
 		if fs[0] == s {
+			value = values[i]
+		}
+	}
+	if value == "" {
+		return fmt.Errorf("'isRaw' can be used only with fields %s", strings.Join(allowed, ", "))
+	}
+	// Raw values are not escaped, so a stray quote would break the query.
+	if strings.Count(value, `"`)%2 != 0 {
+		return fmt.Errorf("unbalanced quotes in raw '%s' value %q: "+
+			"raw values are passed as-is, so quotes have to be paired", fs[0], value)
+	}
+	return nil
+}
+
+// checkRawFunction makes sure that a generic operator is well formed. The
+// argument is passed verbatim, so only obvious syntax errors are caught.
+func checkRawFunction(op cfg.RawFunction) error {
+	if op.Name == "" {
+		return errors.New("empty name for 'op'")
+	}
+	if !opNameRe.MatchString(op.Name) {
+		return fmt.Errorf("invalid name %q for 'op': expected letters, digits or underscores", op.Name)
+	}
+	if op.Arg == "" {
+		return fmt.Errorf("empty argument for 'op' %q", op.Name)
+	}
+	if err := config.ValidateQuery(op.Arg); err != nil {
+		return err
+	}
+	// Unless quoted or grouped, a space would end the argument.
+	depth, inQuote := 0, false
+	for _, c := range op.Arg {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+		case depth == 0 && unicode.IsSpace(c):
+			return fmt.Errorf("argument %q of 'op' %q contains spaces: "+
+				"quote it or group it with parentheses", op.Arg, op.Name)
+		}
+	}
+	return nil
+}
+
+// checkSize makes sure that the given size is in a format supported by Gmail:
+// a number of bytes, optionally followed by a 'K' or 'M' unit.
+func checkSize(field, size string) error {
+	if size == "" || sizeRe.MatchString(size) {
+		return nil
+	}
+	return fmt.Errorf("invalid size %q for '%s': expected a number of bytes, "+
+		"optionally followed by 'K' or 'M' (e.g. 500K, 5M)", size, field)
+}
+
+// checkRelativeDate makes sure that the given relative date is in a format
+// supported by Gmail: a number followed by a day, month or year unit.
+func checkRelativeDate(field, date string) error {
+	if date == "" || relativeDateRe.MatchString(date) {
+		return nil
+	}
+	return fmt.Errorf("invalid relative date %q for '%s': expected a number "+
+		"followed by 'd', 'm' or 'y' (e.g. 7d, 2m, 1y)", date, field)
+}
+
+// checkOneOf makes sure that the given value, if present, is one of the
+// valid ones (case insensitive).
+func checkOneOf(field, value string, valid []string) error {
+	if value == "" {
+		return nil
+	}
+	for _, v := range valid {
+		if strings.EqualFold(value, v) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value %q for '%s': expected one of %s",
+		value, field, strings.Join(valid, ", "))
+}
+
+func checkCategory(c gmail.Category) error {
+	if c == "" {
+		return nil
+	}
+	for _, valid := range gmail.PossibleCategoryValues() {
+		if string(c) == valid {
 			return nil
 		}
 	}
-	return fmt.Errorf("'isRaw' can be used only with fields %s", strings.Join(allowed, ", "))
+	return fmt.Errorf("invalid category %q: expected one of %s",
+		c, strings.Join(gmail.PossibleCategoryValues(), ", "))
+}
+
+// foldNever returns the tribool equivalent to the given action together with
+// its 'never' counterpart, which cannot be both enabled.
+func foldNever(name string, b *bool, never bool) (*bool, error) {
+	if !never {
+		return b, nil
+	}
+	if b != nil && *b {
+		return nil, fmt.Errorf("'%s' and 'never%s' cannot be both enabled",
+			name, strings.ToUpper(name[:1])+name[1:])
+	}
+	no := false
+	return &no, nil
 }
 
 func parseOperation(f cfg.FilterNode) (OperationType, []cfg.FilterNode) {
@@ -204,33 +542,74 @@ func parseOperation(f cfg.FilterNode) (OperationType, []cfg.FilterNode) {
 	return OperationNone, nil
 }
 
-func parseFunction(f cfg.FilterNode) (FunctionType, string) {
+// parseFunction returns the function in the node, together with its
+// arguments. Boolean functions have no arguments.
+func parseFunction(f cfg.FilterNode) (FunctionType, []string) {
 	if f.From != "" {
-		return FunctionFrom, f.From
+		return FunctionFrom, []string{f.From}
 	}
 	if f.To != "" {
-		return FunctionTo, f.To
+		return FunctionTo, []string{f.To}
 	}
 	if f.Cc != "" {
-		return FunctionCc, f.Cc
+		return FunctionCc, []string{f.Cc}
 	}
 	if f.Bcc != "" {
-		return FunctionBcc, f.Bcc
+		return FunctionBcc, []string{f.Bcc}
 	}
 	if f.ReplyTo != "" {
-		return FunctionReplyTo, f.ReplyTo
+		return FunctionReplyTo, []string{f.ReplyTo}
+	}
+	if f.DeliveredTo != "" {
+		return FunctionDeliveredTo, []string{f.DeliveredTo}
 	}
 	if f.Subject != "" {
-		return FunctionSubject, f.Subject
+		return FunctionSubject, []string{f.Subject}
+	}
+	if f.SubjectExact != "" {
+		return FunctionSubject, []string{fmt.Sprintf(`"%s"`, f.SubjectExact)}
 	}
 	if f.List != "" {
-		return FunctionList, f.List
+		return FunctionList, []string{f.List}
 	}
 	if f.Has != "" {
-		return FunctionHas, f.Has
+		return FunctionHas, []string{f.Has}
+	}
+	if f.Larger != "" {
+		return FunctionLarger, []string{strings.ToUpper(f.Larger)}
+	}
+	if f.Smaller != "" {
+		return FunctionSmaller, []string{strings.ToUpper(f.Smaller)}
+	}
+	if f.NewerThan != "" {
+		return FunctionNewerThan, []string{strings.ToLower(f.NewerThan)}
+	}
+	if f.OlderThan != "" {
+		return FunctionOlderThan, []string{strings.ToLower(f.OlderThan)}
+	}
+	if f.Filename != "" {
+		return FunctionFilename, []string{f.Filename}
+	}
+	if f.In != "" {
+		return FunctionIn, []string{strings.ToLower(f.In)}
+	}
+	if f.Is != "" {
+		return FunctionIs, []string{strings.ToLower(f.Is)}
+	}
+	if f.Category != "" {
+		return FunctionCategory, []string{strings.ToLower(f.Category)}
+	}
+	if f.HasAttachment {
+		return FunctionHasAttachment, nil
 	}
 	if f.Query != "" {
-		return FunctionQuery, f.Query
+		return FunctionQuery, []string{f.Query}
+	}
+	if f.RawQuery != "" {
+		return FunctionQuery, []string{f.RawQuery}
+	}
+	if f.Op != nil {
+		return FunctionQuery, []string{f.Op.Name + ":" + f.Op.Arg}
 	}
-	return FunctionNone, ""
+	return FunctionNone, nil
 }
@@ -0,0 +1,1135 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/gmail"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func TestParseNoOutput(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{From: "a"},
+						{Subject: "b"},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{{From: "a"}, {From: "b"}}},
+						{Not: &cfg.FilterNode{List: "c"}},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	rules, err := Parse(config)
+	os.Stdout = stdout
+	require.Nil(t, w.Close())
+
+	out, rerr := io.ReadAll(r)
+	require.Nil(t, rerr)
+	require.Nil(t, err)
+	assert.Empty(t, string(out))
+	// The first rule is kept as-is, the second is split in two.
+	assert.Len(t, rules, 3)
+}
+
+func TestDistributeOrOverAnd(t *testing.T) {
+	actions := Actions{Archive: true}
+
+	tests := []struct {
+		name    string
+		root    *Node
+		want    []CriteriaAST
+		applied bool
+	}{
+		{
+			name: "leaf or",
+			root: and(
+				fn(FunctionFrom, OperationOr, "a", "b"),
+				fn1(FunctionSubject, "c"),
+			),
+			want: []CriteriaAST{
+				and(fn1(FunctionFrom, "a"), fn1(FunctionSubject, "c")),
+				and(fn1(FunctionFrom, "b"), fn1(FunctionSubject, "c")),
+			},
+			applied: true,
+		},
+		{
+			name: "node or",
+			root: and(
+				or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")),
+				not(fn(FunctionList, OperationOr, "x", "y")),
+			),
+			want: []CriteriaAST{
+				and(fn1(FunctionFrom, "a"), not(fn(FunctionList, OperationOr, "x", "y"))),
+				and(fn1(FunctionTo, "b"), not(fn(FunctionList, OperationOr, "x", "y"))),
+			},
+			applied: true,
+		},
+		{
+			name: "node or with many negations",
+			root: and(
+				or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b"), fn1(FunctionCc, "c")),
+				not(fn1(FunctionList, "x")),
+				not(fn1(FunctionSubject, "y")),
+			),
+			want: []CriteriaAST{
+				and(fn1(FunctionFrom, "a"), not(fn1(FunctionList, "x")), not(fn1(FunctionSubject, "y"))),
+				and(fn1(FunctionTo, "b"), not(fn1(FunctionList, "x")), not(fn1(FunctionSubject, "y"))),
+				and(fn1(FunctionCc, "c"), not(fn1(FunctionList, "x")), not(fn1(FunctionSubject, "y"))),
+			},
+			applied: true,
+		},
+		{
+			name: "node or with three children",
+			root: and(
+				or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")),
+				fn1(FunctionSubject, "c"),
+				not(fn1(FunctionList, "x")),
+			),
+			want: []CriteriaAST{
+				and(fn1(FunctionFrom, "a"), fn1(FunctionSubject, "c"), not(fn1(FunctionList, "x"))),
+				and(fn1(FunctionTo, "b"), fn1(FunctionSubject, "c"), not(fn1(FunctionList, "x"))),
+			},
+			applied: true,
+		},
+		{
+			name: "leaf or with three children",
+			root: and(
+				fn(FunctionFrom, OperationOr, "a", "b"),
+				fn1(FunctionSubject, "c"),
+				not(fn1(FunctionList, "x")),
+			),
+			want: []CriteriaAST{
+				and(fn1(FunctionFrom, "a"), fn1(FunctionSubject, "c"), not(fn1(FunctionList, "x"))),
+				and(fn1(FunctionFrom, "b"), fn1(FunctionSubject, "c"), not(fn1(FunctionList, "x"))),
+			},
+			applied: true,
+		},
+		{
+			name: "no-op with and grouping",
+			root: and(
+				fn(FunctionFrom, OperationAnd, "a", "b"),
+				fn1(FunctionSubject, "c"),
+			),
+		},
+		{
+			name: "no-op with or root",
+			root: or(
+				fn1(FunctionFrom, "a"),
+				fn1(FunctionSubject, "c"),
+			),
+		},
+		{
+			name: "no-op with node or without not",
+			root: and(
+				or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")),
+				fn1(FunctionSubject, "c"),
+			),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := distributeOrOverAnd(tc.root, actions)
+			assert.Equal(t, tc.applied, ok)
+			if !tc.applied {
+				assert.Nil(t, got)
+				return
+			}
+			var crits []CriteriaAST
+			for _, r := range got {
+				assert.Equal(t, actions, r.Actions)
+				crits = append(crits, r.Criteria)
+			}
+			assert.Equal(t, tc.want, crits)
+		})
+	}
+}
+
+func TestDistributeOrOverAndClones(t *testing.T) {
+	root := and(
+		or(fn1(FunctionFrom, "a"), fn1(FunctionTo, "b")),
+		not(fn1(FunctionList, "x")),
+		not(fn1(FunctionSubject, "y")),
+	)
+	got, ok := distributeOrOverAnd(root, Actions{Archive: true})
+	require.True(t, ok)
+	require.Len(t, got, 2)
+
+	// Changing one of the rules doesn't affect the others, nor the original.
+	n := got[0].Criteria.(*Node).Children[2].(*Node).Children[0].(*Leaf)
+	n.Args[0] = "changed"
+	assert.Equal(t, not(fn1(FunctionSubject, "y")), got[1].Criteria.(*Node).Children[2])
+	assert.Equal(t, not(fn1(FunctionSubject, "y")), root.Children[2])
+}
+
+func TestParseDistributeMultipleNegations(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{{From: "a"}, {To: "b"}, {Cc: "c"}}},
+						{Not: &cfg.FilterNode{List: "x"}},
+						{Not: &cfg.FilterNode{Subject: "y"}},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+	rules, err := Parse(config)
+	require.Nil(t, err)
+
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.Criteria.String())
+	}
+	assert.Equal(t, []string{
+		"(from:a -list:x -subject:y)",
+		"(to:b -list:x -subject:y)",
+		"(cc:c -list:x -subject:y)",
+	}, got)
+}
+
+func TestParseNoSimplify(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{{From: "a"}, {From: "b"}}},
+						{Or: []cfg.FilterNode{{To: "c"}, {Not: &cfg.FilterNode{List: "x"}}}},
+						{Not: &cfg.FilterNode{List: "y"}},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+	criteria := func(rules []Rule) []string {
+		var res []string
+		for _, r := range rules {
+			res = append(res, r.Criteria.String())
+		}
+		return res
+	}
+
+	rules, err := Parse(config)
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"(from:a {to:c -list:x} -list:y)",
+		"(from:b {to:c -list:x} -list:y)",
+	}, criteria(rules))
+
+	// The criteria are kept as they are written, in a single rule.
+	rules, err = ParseWithOptions(config, Options{NoSimplify: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"({from:a from:b} {to:c -list:x} -list:y)",
+	}, criteria(rules))
+}
+
+func TestParseDeliveredTo(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter:  cfg.FilterNode{DeliveredTo: "foo@example.com"},
+				Actions: cfg.Actions{Archive: true},
+			},
+			{
+				Filter: cfg.FilterNode{
+					Or: []cfg.FilterNode{
+						{DeliveredTo: "foo@example.com"},
+						{DeliveredTo: "bar@example.com"},
+						{Not: &cfg.FilterNode{To: "baz@example.com"}},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+	got, err := Parse(config)
+	require.Nil(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, &Leaf{
+		Function: FunctionDeliveredTo,
+		Grouping: OperationNone,
+		Args:     []string{"foo@example.com"},
+	}, got[0].Criteria)
+	assert.Equal(t, or(
+		fn(FunctionDeliveredTo, OperationOr, "foo@example.com", "bar@example.com"),
+		not(fn1(FunctionTo, "baz@example.com")),
+	), got[1].Criteria)
+}
+
+func TestParseList(t *testing.T) {
+	lists := []string{
+		"announce.example.com",
+		"dev@lists.com",
+		"users@lists.com",
+		"golang-nuts.googlegroups.com",
+		"security@lists.com",
+	}
+	var anyList []cfg.FilterNode
+	for _, l := range lists {
+		anyList = append(anyList, cfg.FilterNode{List: l})
+	}
+	notMe := &cfg.FilterNode{From: "me@gmail.com"}
+
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter:  cfg.FilterNode{Or: anyList},
+				Actions: cfg.Actions{Archive: true},
+			},
+			{
+				// Grouped lists in an 'and' are expanded into one rule each.
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{{Not: notMe}, {Or: anyList}},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+	got, err := Parse(config)
+	require.Nil(t, err)
+	require.Len(t, got, 1+len(lists))
+
+	assert.Equal(t, fn(FunctionList, OperationOr, lists...), got[0].Criteria)
+	for i, l := range lists {
+		assert.Equal(t, and(
+			fn1(FunctionList, l),
+			not(fn1(FunctionFrom, "me@gmail.com")),
+		), got[1+i].Criteria)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		want   CriteriaAST
+		err    string
+	}{
+		{
+			name:   "megabytes",
+			filter: cfg.FilterNode{Larger: "5M"},
+			want:   fn1(FunctionLarger, "5M"),
+		},
+		{
+			name:   "lowercase unit",
+			filter: cfg.FilterNode{Smaller: "500k"},
+			want:   fn1(FunctionSmaller, "500K"),
+		},
+		{
+			name:   "bytes",
+			filter: cfg.FilterNode{Larger: "1048576"},
+			want:   fn1(FunctionLarger, "1048576"),
+		},
+		{
+			name:   "invalid unit",
+			filter: cfg.FilterNode{Larger: "5Gigabytes"},
+			err:    `invalid size "5Gigabytes" for 'larger'`,
+		},
+		{
+			name:   "missing number",
+			filter: cfg.FilterNode{Smaller: "M"},
+			err:    `invalid size "M" for 'smaller'`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCriteria(tc.filter)
+			if tc.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseCategory(t *testing.T) {
+	for _, c := range gmail.PossibleCategoryValues() {
+		t.Run(c, func(t *testing.T) {
+			config := cfg.Config{
+				Rules: []cfg.Rule{
+					{
+						Filter: cfg.FilterNode{From: "a"},
+						// A category alone is a valid action.
+						Actions: cfg.Actions{Category: gmail.Category(c)},
+					},
+				},
+			}
+			got, err := Parse(config)
+			require.Nil(t, err)
+			require.Len(t, got, 1)
+			assert.Equal(t, gmail.Category(c), got[0].Actions.Category)
+		})
+	}
+}
+
+func TestParseInvalidCategory(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter:  cfg.FilterNode{From: "a"},
+				Actions: cfg.Actions{Category: "primary"},
+			},
+		},
+	}
+	_, err := Parse(config)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `invalid category "primary"`)
+}
+
+func TestParseAttachment(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		want   CriteriaAST
+		err    string
+	}{
+		{
+			name:   "has attachment",
+			filter: cfg.FilterNode{HasAttachment: true},
+			want: &Leaf{
+				Function: FunctionHasAttachment,
+				Grouping: OperationNone,
+			},
+		},
+		{
+			name:   "filename",
+			filter: cfg.FilterNode{Filename: "pdf"},
+			want:   fn1(FunctionFilename, "pdf"),
+		},
+		{
+			name:   "unset flag",
+			filter: cfg.FilterNode{HasAttachment: false},
+			err:    "empty filter node",
+		},
+		{
+			name:   "flag with other fields",
+			filter: cfg.FilterNode{HasAttachment: true, From: "a"},
+			err:    "multiple fields specified in the same filter node: from,hasAttachment",
+		},
+		{
+			name:   "flag with raw",
+			filter: cfg.FilterNode{HasAttachment: true, IsEscaped: true},
+			err:    "'isRaw' can be used only with fields",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCriteria(tc.filter)
+			if tc.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSimplifyAttachment(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{HasAttachment: true},
+						{Filename: "pdf"},
+						{Not: &cfg.FilterNode{Not: &cfg.FilterNode{HasAttachment: true}}},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+	got, err := Parse(config)
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+	// The two (boolean) leaves are merged without any arguments.
+	assert.Equal(t, and(
+		fn(FunctionFilename, OperationAnd, "pdf"),
+		&Leaf{
+			Function: FunctionHasAttachment,
+			Grouping: OperationAnd,
+		},
+	), got[0].Criteria)
+}
+
+func TestParseNeverActions(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		actions cfg.Actions
+		want    Actions
+		err     string
+	}{
+		{
+			name:    "never spam",
+			actions: cfg.Actions{NeverMarkSpam: true},
+			want:    Actions{MarkSpam: &no},
+		},
+		{
+			name:    "never important",
+			actions: cfg.Actions{NeverMarkImportant: true},
+			want:    Actions{MarkImportant: &no},
+		},
+		{
+			name:    "never spam and important",
+			actions: cfg.Actions{NeverMarkSpam: true, MarkImportant: &yes},
+			want:    Actions{MarkSpam: &no, MarkImportant: &yes},
+		},
+		{
+			name:    "spam and never important",
+			actions: cfg.Actions{MarkSpam: &yes, NeverMarkImportant: true},
+			want:    Actions{MarkSpam: &yes, MarkImportant: &no},
+		},
+		{
+			name:    "redundant never spam",
+			actions: cfg.Actions{MarkSpam: &no, NeverMarkSpam: true},
+			want:    Actions{MarkSpam: &no},
+		},
+		{
+			name:    "spam and never spam",
+			actions: cfg.Actions{MarkSpam: &yes, NeverMarkSpam: true},
+			err:     "'markSpam' and 'neverMarkSpam' cannot be both enabled",
+		},
+		{
+			name:    "important and never important",
+			actions: cfg.Actions{MarkImportant: &yes, NeverMarkImportant: true},
+			err:     "'markImportant' and 'neverMarkImportant' cannot be both enabled",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.False(t, tc.actions.Empty())
+			config := cfg.Config{
+				Rules: []cfg.Rule{
+					{
+						Filter:  cfg.FilterNode{From: "a"},
+						Actions: tc.actions,
+					},
+				},
+			}
+			got, err := Parse(config)
+			if tc.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.Nil(t, err)
+			require.Len(t, got, 1)
+			assert.Equal(t, tc.want, got[0].Actions)
+		})
+	}
+}
+
+func TestParseEmptyOperators(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		err    string
+	}{
+		{
+			name:   "empty and",
+			filter: cfg.FilterNode{And: []cfg.FilterNode{}},
+			err:    "rule #0: parsing criteria: 'and' specified but contains no children",
+		},
+		{
+			name:   "empty or",
+			filter: cfg.FilterNode{Or: []cfg.FilterNode{}},
+			err:    "rule #0: parsing criteria: 'or' specified but contains no children",
+		},
+		{
+			name:   "empty not",
+			filter: cfg.FilterNode{Not: &cfg.FilterNode{}},
+			err:    "rule #0: parsing criteria: 'not' specified but contains no children",
+		},
+		{
+			name: "nested empty or",
+			filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{From: "a"},
+				{Not: &cfg.FilterNode{Or: []cfg.FilterNode{}}},
+			}},
+			err: "rule #0: parsing criteria: 'or' specified but contains no children",
+		},
+		{
+			name:   "empty and with other fields",
+			filter: cfg.FilterNode{From: "a", And: []cfg.FilterNode{}},
+			err:    "rule #0: parsing criteria: 'and' specified but contains no children",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := cfg.Config{
+				Rules: []cfg.Rule{
+					{
+						Filter:  tc.filter,
+						Actions: cfg.Actions{Archive: true},
+					},
+				},
+			}
+			_, err := Parse(config)
+			require.NotNil(t, err)
+			assert.Equal(t, tc.err, err.Error())
+		})
+	}
+}
+
+func TestParseRelativeDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		want   CriteriaAST
+		err    string
+	}{
+		{
+			name:   "days",
+			filter: cfg.FilterNode{NewerThan: "7d"},
+			want:   fn1(FunctionNewerThan, "7d"),
+		},
+		{
+			name:   "uppercase unit",
+			filter: cfg.FilterNode{OlderThan: "2M"},
+			want:   fn1(FunctionOlderThan, "2m"),
+		},
+		{
+			name:   "years",
+			filter: cfg.FilterNode{OlderThan: "1y"},
+			want:   fn1(FunctionOlderThan, "1y"),
+		},
+		{
+			name:   "missing unit",
+			filter: cfg.FilterNode{NewerThan: "7"},
+			err:    `invalid relative date "7" for 'newerThan'`,
+		},
+		{
+			name:   "invalid unit",
+			filter: cfg.FilterNode{OlderThan: "3w"},
+			err:    `invalid relative date "3w" for 'olderThan'`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCriteria(tc.filter)
+			if tc.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseRuleError(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter:  cfg.FilterNode{From: "a"},
+				Actions: cfg.Actions{Archive: true},
+			},
+			{
+				Filter:  cfg.FilterNode{Larger: "10G"},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+	_, err := Parse(config)
+	require.NotNil(t, err)
+
+	// The error is still found after further wrapping.
+	err = fmt.Errorf("parsing config: %w", err)
+	var rerr RuleError
+	require.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 1, rerr.Index)
+	assert.Equal(t, cfg.FilterNode{Larger: "10G"}, rerr.Filter)
+	require.NotNil(t, rerr.Unwrap())
+	assert.Contains(t, rerr.Unwrap().Error(), "invalid size")
+	assert.Contains(t, err.Error(), "rule #1: ")
+}
+
+func TestParseName(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Name:    "bosses",
+				Filter:  cfg.FilterNode{From: "a"},
+				Actions: cfg.Actions{Star: true},
+			},
+			{
+				// Rules split in multiple ones keep the name.
+				Name: "lists",
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{{List: "l1"}, {List: "l2"}}},
+						{Not: &cfg.FilterNode{From: "b"}},
+					},
+				},
+				Actions: cfg.Actions{Archive: true},
+			},
+			{
+				Filter:  cfg.FilterNode{To: "c"},
+				Actions: cfg.Actions{MarkRead: true},
+			},
+		},
+	}
+	rules, err := Parse(config)
+	require.Nil(t, err)
+
+	var names []string
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	assert.Equal(t, []string{"bosses", "lists", "lists", ""}, names)
+}
+
+func TestParseActionGroups(t *testing.T) {
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Name:   "invoices",
+				Filter: cfg.FilterNode{Subject: "invoice"},
+				ActionGroups: []cfg.Actions{
+					{Forward: "accounting@example.com"},
+					{Forward: "me@example.com"},
+					{Labels: []string{"invoices"}, Archive: true},
+				},
+			},
+		},
+	}
+	rules, err := Parse(config)
+	require.Nil(t, err)
+
+	expectedCrit := &Leaf{
+		Function: FunctionSubject,
+		Grouping: OperationNone,
+		Args:     []string{"invoice"},
+	}
+	assert.Equal(t, []Rule{
+		{
+			Name:     "invoices",
+			Criteria: expectedCrit,
+			Actions:  Actions{Forward: "accounting@example.com"},
+		},
+		{
+			Name:     "invoices",
+			Criteria: expectedCrit,
+			Actions:  Actions{Forward: "me@example.com"},
+		},
+		{
+			Name:     "invoices",
+			Criteria: expectedCrit,
+			Actions:  Actions{Labels: []string{"invoices"}, Archive: true},
+		},
+	}, rules)
+}
+
+func TestParseActionGroupsDistributed(t *testing.T) {
+	// Every group is split independently.
+	config := cfg.Config{
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{Or: []cfg.FilterNode{{List: "l1"}, {List: "l2"}}},
+						{Not: &cfg.FilterNode{From: "b"}},
+					},
+				},
+				ActionGroups: []cfg.Actions{{Archive: true}, {Star: true}},
+			},
+		},
+	}
+	rules, err := Parse(config)
+	require.Nil(t, err)
+	require.Len(t, rules, 4)
+	assert.Equal(t, []Actions{{Archive: true}, {Archive: true}, {Star: true}, {Star: true}},
+		[]Actions{rules[0].Actions, rules[1].Actions, rules[2].Actions, rules[3].Actions})
+	assert.True(t, rules[0].Criteria.Equal(rules[2].Criteria))
+	assert.True(t, rules[1].Criteria.Equal(rules[3].Criteria))
+}
+
+func TestParseActionGroupsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule cfg.Rule
+		err  string
+	}{
+		{
+			name: "both",
+			rule: cfg.Rule{
+				Filter:       cfg.FilterNode{From: "a"},
+				Actions:      cfg.Actions{Archive: true},
+				ActionGroups: []cfg.Actions{{Star: true}},
+			},
+			err: "'actions' and 'actionGroups' are mutually exclusive",
+		},
+		{
+			name: "no groups",
+			rule: cfg.Rule{
+				Filter:       cfg.FilterNode{From: "a"},
+				ActionGroups: []cfg.Actions{},
+			},
+			err: "'actionGroups' specified but contains no groups",
+		},
+		{
+			name: "empty group",
+			rule: cfg.Rule{
+				Filter:       cfg.FilterNode{From: "a"},
+				ActionGroups: []cfg.Actions{{Star: true}, {}},
+			},
+			err: "action group #1: empty action",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(cfg.Config{Rules: []cfg.Rule{tc.rule}})
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestParseGuardErrors(t *testing.T) {
+	for _, g := range []*cfg.RuleGuard{
+		{},
+		{LabelExists: "a", LabelMissing: "b"},
+	} {
+		_, err := Parse(cfg.Config{Rules: []cfg.Rule{{
+			Filter:  cfg.FilterNode{From: "a"},
+			Actions: cfg.Actions{Archive: true},
+			Guard:   g,
+		}}})
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "'guard' requires exactly one of")
+	}
+}
+
+func TestParseInIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		want   CriteriaAST
+		err    string
+	}{
+		{
+			name:   "in anywhere",
+			filter: cfg.FilterNode{In: "anywhere"},
+			want:   fn1(FunctionIn, "anywhere"),
+		},
+		{
+			name:   "is starred",
+			filter: cfg.FilterNode{Is: "Starred"},
+			want:   fn1(FunctionIs, "starred"),
+		},
+		{
+			name:   "invalid in",
+			filter: cfg.FilterNode{In: "somewhere"},
+			err:    `invalid value "somewhere" for 'in': expected one of anywhere, inbox,`,
+		},
+		{
+			name:   "invalid is",
+			filter: cfg.FilterNode{Is: "anywhere"},
+			err:    `invalid value "anywhere" for 'is': expected one of starred, unread,`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCriteria(tc.filter)
+			if tc.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseCategoryCriteria(t *testing.T) {
+	crit, err := parseCriteria(cfg.FilterNode{
+		And: []cfg.FilterNode{
+			{From: "shop@example.com"},
+			{Category: "Promotions"},
+			{Not: &cfg.FilterNode{Category: "primary"}},
+		},
+	})
+	require.Nil(t, err)
+	scrit, err := SimplifyCriteria(crit)
+	require.Nil(t, err)
+	got, err := GenerateQuery(scrit)
+	require.Nil(t, err)
+	assert.Equal(t, "(category:promotions from:shop@example.com -category:primary)", got)
+
+	// Categories can't be grouped.
+	crit, err = parseCriteria(cfg.FilterNode{
+		Or: []cfg.FilterNode{{Category: "social"}, {Category: "forums"}},
+	})
+	require.Nil(t, err)
+	scrit, err = SimplifyCriteria(crit)
+	require.Nil(t, err)
+	got, err = GenerateQuery(scrit)
+	require.Nil(t, err)
+	assert.Equal(t, "{category:social category:forums}", got)
+
+	// The names of the search operator are different from the action ones.
+	_, err = parseCriteria(cfg.FilterNode{Category: "personal"})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `invalid value "personal" for 'category': expected one of primary, social,`)
+}
+
+func TestParseRawQuery(t *testing.T) {
+	_, err := parseCriteria(cfg.FilterNode{Query: "from:a OR from:b"})
+	require.NotNil(t, err)
+	assert.Contains(t, errors.Details(err), "Use 'rawQuery'")
+
+	got, err := parseCriteria(cfg.FilterNode{RawQuery: "from:a OR from:b"})
+	require.Nil(t, err)
+	assert.Equal(t, fn1(FunctionQuery, "from:a OR from:b"), got)
+}
+
+func TestParseRawQuotes(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		err    string
+	}{
+		{
+			name:   "balanced",
+			filter: cfg.FilterNode{Subject: `"hello world" OR bye`, IsEscaped: true},
+		},
+		{
+			name:   "no quotes",
+			filter: cfg.FilterNode{From: "{a@b.com c@d.com}", IsEscaped: true},
+		},
+		{
+			name:   "stray quote",
+			filter: cfg.FilterNode{To: `me"@example.com`, IsEscaped: true},
+			err:    `unbalanced quotes in raw 'to' value "me\"@example.com"`,
+		},
+		{
+			name:   "not raw",
+			filter: cfg.FilterNode{Subject: `say "hi`},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseCriteria(tc.filter)
+			if tc.err == "" {
+				assert.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestParseSubjectExact(t *testing.T) {
+	tests := []struct {
+		filter cfg.FilterNode
+		want   string
+	}{
+		// A single word is matched anywhere in the subject, also as part of
+		// longer words for Gmail.
+		{filter: cfg.FilterNode{Subject: "invoice"}, want: "subject:invoice"},
+		{filter: cfg.FilterNode{SubjectExact: "invoice"}, want: `subject:"invoice"`},
+		// Multiple words are quoted in both cases.
+		{filter: cfg.FilterNode{Subject: "new invoice"}, want: `subject:"new invoice"`},
+		{filter: cfg.FilterNode{SubjectExact: "new invoice"}, want: `subject:"new invoice"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.want, func(t *testing.T) {
+			crit, err := parseCriteria(tc.filter)
+			require.Nil(t, err)
+			got, err := GenerateQuery(crit)
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := parseCriteria(cfg.FilterNode{SubjectExact: `say "hi"`})
+	assert.ErrorContains(t, err, "invalid quote in 'subjectExact'")
+
+	// Only exact subjects can be quoted.
+	crit, err := parseCriteria(cfg.FilterNode{From: `"foo"`})
+	require.Nil(t, err)
+	_, err = GenerateQuery(crit)
+	assert.ErrorContains(t, err, "invalid quote")
+
+	// Exact phrases are not grouped with the other subjects.
+	rules, err := Parse(cfg.Config{Rules: []cfg.Rule{{
+		Filter: cfg.FilterNode{Or: []cfg.FilterNode{
+			{Subject: "a"},
+			{SubjectExact: "b c"},
+			{Subject: "d"},
+		}},
+		Actions: cfg.Actions{Archive: true},
+	}}})
+	require.Nil(t, err)
+	require.Len(t, rules, 1)
+	got, err := GenerateQuery(rules[0].Criteria)
+	require.Nil(t, err)
+	assert.Equal(t, `{subject:{a d} subject:"b c"}`, got)
+}
+
+func TestParseRawFunction(t *testing.T) {
+	crit, err := parseCriteria(cfg.FilterNode{
+		And: []cfg.FilterNode{
+			{From: "a@b.com"},
+			{Op: &cfg.RawFunction{Name: "newop", Arg: "some-value"}},
+			{Op: &cfg.RawFunction{Name: "other", Arg: `"with spaces"`}},
+			{Op: &cfg.RawFunction{Name: "rfc822msgid", Arg: "id@example.com"}},
+			{Op: &cfg.RawFunction{Name: "deliveredto_x", Arg: "x"}},
+		},
+	})
+	require.Nil(t, err)
+	got, err := GenerateQuery(crit)
+	require.Nil(t, err)
+	assert.Equal(t, `(from:a@b.com newop:some-value other:"with spaces" rfc822msgid:id@example.com deliveredto_x:x)`, got)
+}
+
+func TestParseRawFunctionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   cfg.RawFunction
+		err  string
+	}{
+		{
+			name: "empty name",
+			op:   cfg.RawFunction{Arg: "value"},
+			err:  "empty name for 'op'",
+		},
+		{
+			name: "invalid name",
+			op:   cfg.RawFunction{Name: "new:op", Arg: "value"},
+			err:  `invalid name "new:op" for 'op'`,
+		},
+		{
+			name: "invalid name with dash",
+			op:   cfg.RawFunction{Name: "new-op", Arg: "value"},
+			err:  `invalid name "new-op" for 'op'`,
+		},
+		{
+			name: "empty arg",
+			op:   cfg.RawFunction{Name: "newop"},
+			err:  `empty argument for 'op' "newop"`,
+		},
+		{
+			name: "spaces",
+			op:   cfg.RawFunction{Name: "newop", Arg: "a b"},
+			err:  `argument "a b" of 'op' "newop" contains spaces`,
+		},
+		{
+			name: "unbalanced",
+			op:   cfg.RawFunction{Name: "newop", Arg: "(a"},
+			err:  "unbalanced",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			op := tc.op
+			_, err := parseCriteria(cfg.FilterNode{Op: &op})
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{
+			name: "json",
+			cfg: `{
+  "version": "v1alpha3",
+  "rules": [
+    {
+      "filter": {"or": [{"from": "a@example.com"}, {"from": "b@example.com"}]},
+      "actions": {"archive": true}
+    }
+  ]
+}`,
+		},
+		{
+			name: "jsonnet",
+			cfg: `local senders = ['a@example.com', 'b@example.com'];
+{
+  version: 'v1alpha3',
+  rules: [
+    {
+      filter: { or: [{ from: s } for s in senders] },
+      actions: { archive: true },
+    },
+  ],
+}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := ParseString(tc.cfg)
+			require.Nil(t, err)
+			require.Len(t, rules, 1)
+			assert.Equal(t, "from:{a@example.com b@example.com}", rules[0].Criteria.String())
+			assert.True(t, rules[0].Actions.Archive)
+		})
+	}
+}
+
+func TestParseStringErrors(t *testing.T) {
+	// YAML is detected, but the version is unknown.
+	_, err := ParseString("version: v9\nrules: []\n")
+	require.NotNil(t, err)
+	assert.Equal(t, "migrating the YAML config: unsupported config version: v9", err.Error())
+	assert.Contains(t, errors.Details(err), "migrating-yaml-configs")
+
+	// The rules are parsed as well.
+	_, err = ParseString(`{"version": "v1alpha3", "rules": [{"filter": {"from": "a"}}]}`)
+	require.NotNil(t, err)
+	assert.Equal(t, "rule #0: empty action", err.Error())
+}
@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// The 'hasAttachment' function is boolean, so the query is fixed.
+const hasAttachmentQuery = "has:attachment"
+
+// GenerateQuery renders the criteria in Gmail search syntax.
+//
+// Every logical operation is explicit, so a root 'and' is wrapped in
+// parenthesis, as it would appear when nested in a bigger query.
+func GenerateQuery(crit CriteriaAST) (string, error) {
+	if node, ok := crit.(*Node); ok {
+		return generateNodeQuery(node)
+	}
+	if leaf, ok := crit.(*Leaf); ok {
+		return generateLeafQuery(leaf)
+	}
+	return "", errors.New("found unknown criteria node")
+}
+
+func generateNodeQuery(node *Node) (string, error) {
+	if node.Operation == OperationNot {
+		if ln := len(node.Children); ln != 1 {
+			return "", fmt.Errorf("after 'not' got %d children, expected 1", ln)
+		}
+		if leaf, ok := node.Children[0].(*Leaf); ok {
+			return generateNotLeafQuery(leaf)
+		}
+	}
+	query := ""
+	for _, child := range node.Children {
+		cq, err := GenerateQuery(child)
+		if err != nil {
+			return "", err
+		}
+		query = JoinQueries(query, cq)
+	}
+	return groupWithOperation(query, node.Operation)
+}
+
+// generateNotLeafQuery negates a leaf in the compact form, e.g.
+// '-from:a'. Raw queries made of multiple terms are grouped first, because
+// '-' only applies to the term right after it.
+//
+// Alternative mailing lists are the exception: Gmail doesn't reliably
+// exclude all the lists of '-list:{a b}', so every list is spelled out, as
+// in '-{list:a list:b}'.
+func generateNotLeafQuery(leaf *Leaf) (string, error) {
+	if leaf.Function == FunctionList && len(leaf.Args) > 1 && leaf.Grouping == OperationOr {
+		query, err := generateNodeQuery(splitLeaf(leaf))
+		if err != nil {
+			return "", err
+		}
+		return groupWithOperation(query, OperationNot)
+	}
+	query, err := generateLeafQuery(leaf)
+	if err != nil {
+		return "", err
+	}
+	if !isSingleTerm(query) {
+		query = fmt.Sprintf("(%s)", query)
+	}
+	return groupWithOperation(query, OperationNot)
+}
+
+// splitLeaf returns a node with a leaf for every argument, combined with the
+// grouping operation of the leaf.
+func splitLeaf(leaf *Leaf) *Node {
+	res := &Node{Operation: leaf.Grouping}
+	for _, a := range leaf.Args {
+		res.Children = append(res.Children, &Leaf{
+			Function: leaf.Function,
+			Grouping: leaf.Grouping,
+			Args:     []string{a},
+			IsRaw:    leaf.IsRaw,
+			IsPhrase: leaf.IsPhrase,
+		})
+	}
+	return res
+}
+
+// isSingleTerm returns true if the query is a single term, e.g. 'a',
+// 'from:{a b}' or '"a b"', which can be negated as is.
+func isSingleTerm(query string) bool {
+	if query == "" || query[0] == '-' {
+		return false
+	}
+	depth, quoted := 0, false
+	for _, c := range query {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{' || c == '(':
+			depth++
+		case c == '}' || c == ')':
+			depth--
+		case depth == 0 && (c == ' ' || c == '\t' || c == '\n'):
+			return false
+		}
+	}
+	return true
+}
+
+func generateLeafQuery(leaf *Leaf) (string, error) {
+	query, err := leaf.ArgsQuery()
+	if err != nil {
+		return "", err
+	}
+
+	switch leaf.Function {
+	case FunctionHas, FunctionQuery:
+		return query, nil
+	case FunctionHasAttachment:
+		return hasAttachmentQuery, nil
+	default:
+		if leaf.Function == FunctionNone || leaf.Function > FunctionQuery {
+			return "", fmt.Errorf("unknown function type %d", leaf.Function)
+		}
+		return fmt.Sprintf("%v:%s", leaf.Function, query), nil
+	}
+}
+
+// ArgsQuery returns the arguments of the leaf in Gmail search syntax,
+// escaped and grouped by the leaf operation when needed.
+func (n *Leaf) ArgsQuery() (string, error) {
+	needEscape := n.Function != FunctionQuery && !n.IsRaw
+	query, err := joinStrings(needEscape, n.IsPhrase, n.Args...)
+	if err != nil {
+		return "", err
+	}
+	if len(n.Args) > 1 {
+		return groupWithOperation(query, n.Grouping)
+	}
+	return query, nil
+}
+
+// JoinQueries returns the conjunction of two queries. Empty queries are
+// ignored.
+func JoinQueries(q1, q2 string) string {
+	// No need to escape queries because they are either logical operations
+	// or functions.
+	if q1 == "" {
+		return q2
+	}
+	if q2 == "" {
+		return q1
+	}
+	return fmt.Sprintf("%s %s", q1, q2)
+}
+
+func groupWithOperation(query string, op OperationType) (string, error) {
+	switch op {
+	case OperationOr:
+		return fmt.Sprintf("{%s}", query), nil
+
+	case OperationAnd:
+		return fmt.Sprintf("(%s)", query), nil
+
+	case OperationNot:
+		return fmt.Sprintf("-%s", query), nil
+	default:
+		return "", fmt.Errorf("unknown node operation %d", op)
+	}
+}
+
+// joinStrings joins the arguments, escaping them if requested. Exact phrases
+// are already quoted, so they are kept as they are.
+func joinStrings(needEscape, phrase bool, a ...string) (string, error) {
+	if !needEscape {
+		return strings.Join(a, " "), nil
+	}
+	res := make([]string, len(a))
+	for i, s := range a {
+		if phrase && isPhrase(s) {
+			res[i] = s
+			continue
+		}
+		if strings.Contains(s, `"`) {
+			return "", fmt.Errorf("invalid quote in %q", s)
+		}
+		res[i] = escape(s)
+	}
+	return strings.Join(res, " "), nil
+}
+
+// queryOperators are the words that Gmail interprets as operators, even as
+// function arguments.
+var queryOperators = map[string]bool{
+	"OR":     true,
+	"AND":    true,
+	"AROUND": true,
+}
+
+// escape quotes the argument if Gmail would interpret it as more than a
+// single term, e.g. because it contains spaces, brackets or operators.
+func escape(a string) string {
+	if strings.ContainsAny(a, " \t{}()[]") || queryOperators[a] {
+		return fmt.Sprintf(`"%s"`, a)
+	}
+	return a
+}
+
+// isPhrase returns true if the argument is quoted as a whole, as exact
+// phrases are, e.g. '"foo bar"'.
+func isPhrase(a string) bool {
+	return len(a) > 2 && a[0] == '"' && a[len(a)-1] == '"' &&
+		!strings.Contains(a[1:len(a)-1], `"`)
+}
+
+// queryString renders the tree for debugging purposes, where errors can't be
+// returned.
+func queryString(crit CriteriaAST) string {
+	q, err := GenerateQuery(crit)
+	if err != nil {
+		return fmt.Sprintf("<invalid: %v>", err)
+	}
+	return q
+}
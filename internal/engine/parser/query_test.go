@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name string
+		tree CriteriaAST
+		want string
+	}{
+		{
+			name: "leaf",
+			tree: fn1(FunctionFrom, "a@b.com"),
+			want: "from:a@b.com",
+		},
+		{
+			name: "grouped leaf",
+			tree: fn(FunctionSubject, OperationOr, "foo", "bar baz"),
+			want: `subject:{foo "bar baz"}`,
+		},
+		{
+			name: "subject with spaces",
+			tree: fn1(FunctionSubject, "Re: [ACME] update (v2)"),
+			want: `subject:"Re: [ACME] update (v2)"`,
+		},
+		{
+			name: "subject with brackets",
+			tree: fn1(FunctionSubject, "[ACME]"),
+			want: `subject:"[ACME]"`,
+		},
+		{
+			name: "subject with embedded or",
+			tree: fn(FunctionSubject, OperationOr, "cats OR dogs", "OR", "ORDER"),
+			want: `subject:{"cats OR dogs" "OR" ORDER}`,
+		},
+		{
+			name: "raw subject",
+			tree: &Leaf{Function: FunctionSubject, Args: []string{"[ACME] OR (v2)"}, IsRaw: true},
+			want: `subject:[ACME] OR (v2)`,
+		},
+		{
+			name: "raw query",
+			tree: &Leaf{Function: FunctionQuery, Args: []string{`"exact phrase" OR x`}},
+			want: `"exact phrase" OR x`,
+		},
+		{
+			name: "has attachment",
+			tree: &Leaf{Function: FunctionHasAttachment},
+			want: "has:attachment",
+		},
+		{
+			name: "nested",
+			tree: and(
+				fn1(FunctionList, "dev@lists.com"),
+				or(
+					fn1(FunctionTo, "me"),
+					not(fn(FunctionCc, OperationAnd, "a", "b")),
+				),
+			),
+			want: "(list:dev@lists.com {to:me -cc:(a b)})",
+		},
+		{
+			name: "not of group",
+			tree: not(or(fn1(FunctionFrom, "a"), fn1(FunctionIs, "starred"))),
+			want: "-{from:a is:starred}",
+		},
+		{
+			name: "invalid not",
+			tree: &Node{Operation: OperationNot},
+			want: "<invalid: after 'not' got 0 children, expected 1>",
+		},
+		{
+			name: "phrase",
+			tree: &Leaf{
+				Function: FunctionSubject,
+				Grouping: OperationOr,
+				Args:     []string{`"a b"`, `"c"`},
+				IsPhrase: true,
+			},
+			want: `subject:{"a b" "c"}`,
+		},
+		{
+			name: "quoted not phrase",
+			tree: fn1(FunctionFrom, `"a"`),
+			want: `<invalid: invalid quote in "\"a\"">`,
+		},
+		{
+			name: "invalid quote",
+			tree: fn1(FunctionFrom, `a"b`),
+			want: `<invalid: invalid quote in "a\"b">`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.tree.String())
+		})
+	}
+}
+
+func TestNotLeafQuery(t *testing.T) {
+	// Every function is negated in the compact form, without parentheses.
+	for f := FunctionFrom; f <= FunctionCategory; f++ {
+		t.Run(f.String(), func(t *testing.T) {
+			want := fmt.Sprintf("-%v:x", f)
+			if f == FunctionHas {
+				want = "-x"
+			}
+			assert.Equal(t, want, not(fn1(f, "x")).String())
+		})
+	}
+
+	tests := []struct {
+		name string
+		tree CriteriaAST
+		want string
+	}{
+		{
+			name: "escaped",
+			tree: not(fn1(FunctionFrom, "a b")),
+			want: `-from:"a b"`,
+		},
+		{
+			name: "grouped",
+			tree: not(fn(FunctionFrom, OperationOr, "a", "b")),
+			want: "-from:{a b}",
+		},
+		{
+			name: "grouped lists",
+			tree: not(fn(FunctionList, OperationOr, "a", "b")),
+			want: "-{list:a list:b}",
+		},
+		{
+			name: "lists grouped by and",
+			tree: not(fn(FunctionList, OperationAnd, "a", "b")),
+			want: "-list:(a b)",
+		},
+		{
+			name: "has attachment",
+			tree: not(&Leaf{Function: FunctionHasAttachment}),
+			want: "-has:attachment",
+		},
+		{
+			name: "single term query",
+			tree: not(fn1(FunctionQuery, "a")),
+			want: "-a",
+		},
+		{
+			name: "grouped query",
+			tree: not(fn1(FunctionQuery, "{a b}")),
+			want: "-{a b}",
+		},
+		{
+			name: "quoted query",
+			tree: not(fn1(FunctionQuery, `"a b"`)),
+			want: `-"a b"`,
+		},
+		{
+			name: "multiple terms query",
+			tree: not(fn1(FunctionQuery, "a b")),
+			want: "-(a b)",
+		},
+		{
+			name: "query with operator",
+			tree: not(fn1(FunctionQuery, "a OR b")),
+			want: "-(a OR b)",
+		},
+		{
+			name: "negated query",
+			tree: not(fn1(FunctionQuery, "-a")),
+			want: "-(-a)",
+		},
+		{
+			name: "raw subject",
+			tree: not(&Leaf{Function: FunctionSubject, Args: []string{"[ACME] OR (v2)"}, IsRaw: true}),
+			want: "-(subject:[ACME] OR (v2))",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.tree.String())
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package parser
+
+import "strings"
+
+// literal is a function with a single argument, like 'from:a'.
+type literal struct {
+	function FunctionType
+	arg      string
+	raw      bool
+}
+
+// MatchesAll returns true if the criteria is known to match every message,
+// like '{from:a -from:a}' or 'in:anywhere'. The criteria is expected to be
+// simplified.
+//
+// Only obvious cases are detected: a false result doesn't mean that the
+// criteria excludes some messages.
+func MatchesAll(tree CriteriaAST) bool {
+	switch n := tree.(type) {
+	case *Leaf:
+		return n.Function == FunctionIn && n.Grouping == OperationNone &&
+			len(n.Args) == 1 && strings.EqualFold(n.Args[0], "anywhere")
+	case *Node:
+		switch n.Operation {
+		case OperationAnd:
+			for _, c := range n.Children {
+				if !MatchesAll(c) {
+					return false
+				}
+			}
+			return len(n.Children) > 0
+		case OperationOr:
+			for _, c := range n.Children {
+				if MatchesAll(c) {
+					return true
+				}
+			}
+			return contradicts(n.Children, OperationOr)
+		case OperationNot:
+			return len(n.Children) == 1 && matchesNone(n.Children[0])
+		}
+	}
+	return false
+}
+
+// matchesNone returns true if the criteria is known to match no message,
+// like '(from:a -from:a)'.
+func matchesNone(tree CriteriaAST) bool {
+	n, ok := tree.(*Node)
+	if !ok {
+		return false
+	}
+	switch n.Operation {
+	case OperationAnd:
+		for _, c := range n.Children {
+			if matchesNone(c) {
+				return true
+			}
+		}
+		return contradicts(n.Children, OperationAnd)
+	case OperationOr:
+		for _, c := range n.Children {
+			if !matchesNone(c) {
+				return false
+			}
+		}
+		return len(n.Children) > 0
+	case OperationNot:
+		return len(n.Children) == 1 && MatchesAll(n.Children[0])
+	}
+	return false
+}
+
+// contradicts returns true if the children, combined with the given
+// operation, contain both a criteria and its negation.
+func contradicts(children []CriteriaAST, op OperationType) bool {
+	positive := map[literal]bool{}
+	var negated []CriteriaAST
+	for _, c := range children {
+		if c.RootOperation() == OperationNot && !c.IsLeaf() {
+			negated = append(negated, c.(*Node).Children[0])
+			continue
+		}
+		for _, l := range literals(c, op) {
+			positive[l] = true
+		}
+	}
+
+	// The negation of an 'and' is an 'or' of negations and vice versa.
+	negOp := OperationAnd
+	if op == OperationAnd {
+		negOp = OperationOr
+	}
+	for _, neg := range negated {
+		for _, l := range literals(neg, negOp) {
+			if positive[l] {
+				return true
+			}
+		}
+		for _, c := range children {
+			if c.Equal(neg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// literals returns the arguments of the leaf, if they are combined with the
+// given operation.
+func literals(tree CriteriaAST, op OperationType) []literal {
+	leaf, ok := tree.(*Leaf)
+	if !ok || (leaf.Grouping != OperationNone && leaf.Grouping != op) {
+		return nil
+	}
+	var res []literal
+	for _, a := range leaf.Args {
+		res = append(res, literal{leaf.Function, a, leaf.IsRaw})
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+func TestMatchesAll(t *testing.T) {
+	notFrom := func(a string) cfg.FilterNode {
+		return cfg.FilterNode{Not: &cfg.FilterNode{From: a}}
+	}
+	tests := []struct {
+		name   string
+		filter cfg.FilterNode
+		all    bool
+	}{
+		{
+			name:   "in anywhere",
+			filter: cfg.FilterNode{In: "anywhere"},
+			all:    true,
+		},
+		{
+			name: "or with negation",
+			filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{From: "a"},
+				notFrom("a"),
+			}},
+			all: true,
+		},
+		{
+			name: "negation of a grouped value",
+			filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{From: "a"},
+				{From: "b"},
+				notFrom("b"),
+			}},
+			all: true,
+		},
+		{
+			name: "negated contradiction",
+			filter: cfg.FilterNode{Not: &cfg.FilterNode{And: []cfg.FilterNode{
+				{Subject: "x"},
+				{Not: &cfg.FilterNode{Subject: "x"}},
+			}}},
+			all: true,
+		},
+		{
+			name: "negation of a complex criteria",
+			filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{And: []cfg.FilterNode{{From: "a"}, {HasAttachment: true}}},
+				{Not: &cfg.FilterNode{And: []cfg.FilterNode{{From: "a"}, {HasAttachment: true}}}},
+			}},
+			all: true,
+		},
+		{
+			name: "nested in and",
+			filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{In: "anywhere"},
+				{Or: []cfg.FilterNode{{To: "me"}, {Not: &cfg.FilterNode{To: "me"}}}},
+			}},
+			all: true,
+		},
+		{
+			name: "restricted anywhere",
+			filter: cfg.FilterNode{And: []cfg.FilterNode{
+				{In: "anywhere"},
+				{From: "a"},
+			}},
+		},
+		{
+			name: "different negation",
+			filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{From: "a"},
+				notFrom("b"),
+			}},
+		},
+		{
+			name: "different function",
+			filter: cfg.FilterNode{Or: []cfg.FilterNode{
+				{From: "a"},
+				{Not: &cfg.FilterNode{To: "a"}},
+			}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := Parse(cfg.Config{Rules: []cfg.Rule{
+				{Filter: cfg.FilterNode{From: "x"}, Actions: cfg.Actions{Archive: true}},
+				{Filter: tc.filter, Actions: cfg.Actions{Archive: true}},
+			}})
+			if !tc.all {
+				assert.Nil(t, err)
+				assert.NotEmpty(t, rules)
+				return
+			}
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), "rule #1: ")
+			assert.Contains(t, err.Error(), "matches all messages")
+		})
+	}
+}
+
+func TestCatchAll(t *testing.T) {
+	all := cfg.FilterNode{In: "anywhere"}
+
+	// The marker is required for a universal rule.
+	_, err := Parse(cfg.Config{Rules: []cfg.Rule{
+		{Filter: all, Actions: cfg.Actions{Archive: true}},
+	}})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "matches all messages")
+	assert.Contains(t, errors.Details(err), "catchAll: true")
+
+	rules, err := Parse(cfg.Config{Rules: []cfg.Rule{
+		{Filter: all, Actions: cfg.Actions{Archive: true}, CatchAll: true},
+		{
+			Filter:       all,
+			ActionGroups: []cfg.Actions{{Archive: true}, {MarkRead: true}},
+			CatchAll:     true,
+		},
+	}})
+	require.Nil(t, err)
+	assert.Len(t, rules, 3)
+}
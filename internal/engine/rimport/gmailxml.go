@@ -0,0 +1,203 @@
+package rimport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	gxml "github.com/mbrt/gmailctl/internal/engine/export/xml"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/gmail"
+	"github.com/mbrt/gmailctl/internal/engine/label"
+)
+
+// Properties of the Gmail XML format that are never generated by gmailctl.
+const (
+	propertyDoesNotHave   = "doesNotHaveTheWord"
+	propertyHasAttachment = "hasAttachment"
+	propertyExcludeChats  = "excludeChats"
+	propertySize          = "size"
+	propertySizeOperator  = "sizeOperator"
+	propertySizeUnit      = "sizeUnit"
+)
+
+const smartLabelPrefix = "^smartlabel_"
+
+var (
+	smartLabelCategories = map[string]gmail.Category{
+		gxml.SmartLabelPersonal:     gmail.CategoryPersonal,
+		gxml.SmartLabelSocial:       gmail.CategorySocial,
+		gxml.SmartLabelNotification: gmail.CategoryUpdates,
+		gxml.SmartLabelGroup:        gmail.CategoryForums,
+		gxml.SmartLabelPromo:        gmail.CategoryPromotions,
+	}
+	sizeOperators = map[string]string{
+		"s_sl": "larger",
+		"s_ss": "smaller",
+	}
+	sizeUnits = map[string]string{
+		"s_sb":  "",
+		"s_skb": "k",
+		"s_smb": "m",
+	}
+	sizeValueRe = regexp.MustCompile(`^[0-9]+$`)
+)
+
+type gmailXMLFeed struct {
+	Entries []gmailXMLEntry `xml:"entry"`
+}
+
+type gmailXMLEntry struct {
+	Properties []gmailXMLProperty `xml:"property"`
+}
+
+type gmailXMLProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// ImportGmailXML converts the filters exported by the Gmail settings, in the
+// mailFilters.xml format, into a config, best effort quality.
+//
+// Properties without a gmailctl equivalent are reported in the returned
+// warnings. Filters with unsupported criteria are skipped, because dropping
+// them would make the filters match more messages, while unsupported actions
+// are dropped.
+func ImportGmailXML(r io.Reader) (v1alpha3.Config, []string, error) {
+	var feed gmailXMLFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return v1alpha3.Config{}, nil, fmt.Errorf("decoding XML: %w", err)
+	}
+
+	var (
+		fs       filter.Filters
+		ls       label.Labels
+		seen     = map[string]bool{}
+		warnings []string
+	)
+	for i, e := range feed.Entries {
+		f, ws, ok := e.toFilter()
+		for _, w := range ws {
+			warnings = append(warnings, fmt.Sprintf("filter #%d: %s", i, w))
+		}
+		if !ok {
+			continue
+		}
+		if l := f.Action.AddLabel; l != "" && !seen[l] {
+			seen[l] = true
+			ls = append(ls, label.Label{Name: l})
+		}
+		fs = append(fs, f)
+	}
+
+	cfg, err := Import(fs, ls)
+	return cfg, warnings, err
+}
+
+func (e gmailXMLEntry) toFilter() (filter.Filter, []string, bool) {
+	var (
+		res      filter.Filter
+		query    []string
+		size     = map[string]string{}
+		warnings []string
+		skip     bool
+	)
+
+	for _, p := range e.Properties {
+		a := &res.Action
+		switch p.Name {
+		case gxml.PropertyFrom:
+			res.Criteria.From = p.Value
+		case gxml.PropertyTo:
+			res.Criteria.To = p.Value
+		case gxml.PropertySubject:
+			res.Criteria.Subject = p.Value
+		case gxml.PropertyHas:
+			query = append(query, p.Value)
+		case propertyDoesNotHave:
+			// Like the negated query of the API, the words are in OR.
+			query = append(query, fmt.Sprintf("-{%s}", p.Value))
+		case propertyHasAttachment:
+			if p.Value == "true" {
+				query = append(query, "has:attachment")
+			}
+		case propertySize, propertySizeOperator, propertySizeUnit:
+			size[p.Name] = p.Value
+		case propertyExcludeChats:
+			if p.Value == "true" {
+				warnings = append(warnings, "dropped unsupported property 'excludeChats'")
+			}
+
+		case gxml.PropertyApplyLabel:
+			a.AddLabel = p.Value
+		case gxml.PropertyArchive:
+			a.Archive = p.Value == "true"
+		case gxml.PropertyDelete:
+			a.Delete = p.Value == "true"
+		case gxml.PropertyMarkImportant:
+			a.MarkImportant = p.Value == "true"
+		case gxml.PropertyMarkNotImportant:
+			a.MarkNotImportant = p.Value == "true"
+		case gxml.PropertyMarkRead:
+			a.MarkRead = p.Value == "true"
+		case gxml.PropertyMarkNotSpam:
+			a.MarkNotSpam = p.Value == "true"
+		case gxml.PropertyStar:
+			a.Star = p.Value == "true"
+		case gxml.PropertyForward:
+			a.Forward = p.Value
+		case gxml.PropertyApplyCategory:
+			cat, ok := smartLabelCategories[strings.TrimPrefix(p.Value, smartLabelPrefix)]
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("dropped unknown category %q", p.Value))
+				continue
+			}
+			a.Category = cat
+
+		default:
+			warnings = append(warnings, fmt.Sprintf("dropped unsupported property %q", p.Name))
+		}
+	}
+
+	// Gmail always exports the size operator and unit, even without a size.
+	if size[propertySize] != "" {
+		if q, err := sizeQuery(size); err != nil {
+			warnings = append(warnings, err.Error())
+			skip = true
+		} else {
+			query = append(query, q)
+		}
+	}
+	res.Criteria.Query = strings.Join(query, " ")
+
+	switch {
+	case skip:
+		return res, append(warnings, "skipped, because it has unsupported criteria"), false
+	case res.Criteria.Empty():
+		return res, append(warnings, "skipped, because it has no criteria"), false
+	case res.Action.Empty():
+		return res, append(warnings, "skipped, because it has no supported actions"), false
+	}
+	return res, warnings, true
+}
+
+// sizeQuery converts the size properties into a 'larger' or 'smaller'
+// operator.
+func sizeQuery(props map[string]string) (string, error) {
+	op, ok := sizeOperators[props[propertySizeOperator]]
+	if !ok {
+		return "", fmt.Errorf("unsupported size operator %q", props[propertySizeOperator])
+	}
+	unit, ok := sizeUnits[props[propertySizeUnit]]
+	if !ok {
+		return "", fmt.Errorf("unsupported size unit %q", props[propertySizeUnit])
+	}
+	size := props[propertySize]
+	if !sizeValueRe.MatchString(size) {
+		return "", fmt.Errorf("invalid size %q", size)
+	}
+	return fmt.Sprintf("%s:%s%s", op, size, unit), nil
+}
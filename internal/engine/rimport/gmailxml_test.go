@@ -0,0 +1,144 @@
+package rimport
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	gxml "github.com/mbrt/gmailctl/internal/engine/export/xml"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+func TestImportGmailXML(t *testing.T) {
+	f, err := os.Open("testdata/mailFilters.xml")
+	require.Nil(t, err)
+	defer f.Close()
+
+	cfg, warnings, err := ImportGmailXML(f)
+	require.Nil(t, err)
+
+	assert.Equal(t, []v1alpha3.Label{{Name: "Work/Reports"}}, cfg.Labels)
+	assert.Equal(t, []v1alpha3.Rule{
+		{
+			Filter: v1alpha3.FilterNode{From: "boss@work.com"},
+			Actions: v1alpha3.Actions{
+				Labels:        []string{"Work/Reports"},
+				Archive:       true,
+				MarkImportant: boolPtr(true),
+			},
+		},
+		{
+			Filter: v1alpha3.FilterNode{
+				And: []v1alpha3.FilterNode{
+					{List: "news@lists.com"},
+					{Not: &v1alpha3.FilterNode{
+						Or: []v1alpha3.FilterNode{{Has: "urgent"}, {Has: "important"}},
+					}},
+				},
+			},
+			Actions: v1alpha3.Actions{
+				Star:     true,
+				MarkSpam: boolPtr(false),
+				Category: "updates",
+			},
+		},
+		{
+			Filter: v1alpha3.FilterNode{
+				And: []v1alpha3.FilterNode{
+					{Subject: "invoice"},
+					// Not reconstructed, because it would generate a
+					// different query.
+					{Query: "has:attachment larger:5m"},
+				},
+			},
+			Actions: v1alpha3.Actions{
+				MarkRead: true,
+				Forward:  "accounting@example.com",
+			},
+		},
+		{
+			Filter: v1alpha3.FilterNode{To: "me+spam@gmail.com"},
+			Actions: v1alpha3.Actions{
+				Delete:        true,
+				MarkImportant: boolPtr(false),
+			},
+		},
+	}, cfg.Rules)
+	assert.Equal(t, []string{
+		"filter #2: dropped unsupported property 'excludeChats'",
+		`filter #2: dropped unsupported property "cannedResponse"`,
+		`filter #4: dropped unsupported property "cannedResponse"`,
+		"filter #4: skipped, because it has no supported actions",
+	}, warnings)
+
+	// The result is a valid config.
+	_, err = parser.Parse(cfg)
+	assert.Nil(t, err)
+}
+
+func TestImportGmailXMLSkipped(t *testing.T) {
+	doc := `<feed xmlns='http://www.w3.org/2005/Atom' xmlns:apps='http://schemas.google.com/apps/2006'>
+	<entry>
+		<apps:property name='from' value='a@example.com'/>
+		<apps:property name='size' value='1'/>
+		<apps:property name='sizeOperator' value='s_eq'/>
+		<apps:property name='sizeUnit' value='s_smb'/>
+		<apps:property name='shouldArchive' value='true'/>
+	</entry>
+	<entry>
+		<apps:property name='shouldArchive' value='true'/>
+	</entry>
+	<entry>
+		<apps:property name='from' value='b@example.com'/>
+		<apps:property name='smartLabelToApply' value='^smartlabel_unknown'/>
+		<apps:property name='shouldArchive' value='true'/>
+	</entry>
+</feed>`
+	cfg, warnings, err := ImportGmailXML(strings.NewReader(doc))
+	require.Nil(t, err)
+	assert.Equal(t, []v1alpha3.Rule{
+		{
+			Filter:  v1alpha3.FilterNode{From: "b@example.com"},
+			Actions: v1alpha3.Actions{Archive: true},
+		},
+	}, cfg.Rules)
+	assert.Equal(t, []string{
+		`filter #0: unsupported size operator "s_eq"`,
+		"filter #0: skipped, because it has unsupported criteria",
+		"filter #1: skipped, because it has no criteria",
+		`filter #2: dropped unknown category "^smartlabel_unknown"`,
+	}, warnings)
+
+	_, _, err = ImportGmailXML(strings.NewReader("<feed>"))
+	assert.NotNil(t, err)
+}
+
+func TestImportGmailXMLRoundTrip(t *testing.T) {
+	fs := filter.Filters{
+		{
+			Criteria: filter.Criteria{From: "boss@work.com", Query: "-subject:lunch"},
+			Action:   filter.Actions{AddLabel: "work", MarkImportant: true},
+		},
+		{
+			Criteria: filter.Criteria{Query: "list:{a@lists.com b@lists.com}"},
+			Action:   filter.Actions{Archive: true, Category: "forums"},
+		},
+	}
+	var buf bytes.Buffer
+	require.Nil(t, gxml.DefaultExporter().Export(v1alpha3.Author{}, fs, &buf))
+
+	cfg, warnings, err := ImportGmailXML(&buf)
+	require.Nil(t, err)
+	assert.Empty(t, warnings)
+	rules, err := parser.Parse(cfg)
+	require.Nil(t, err)
+	got, err := filter.FromRules(rules)
+	require.Nil(t, err)
+	assert.Equal(t, fs, got)
+}
@@ -0,0 +1,148 @@
+package rimport
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// parseCriteria reconstructs the structure of the filter criteria, e.g.
+// 'from:{a b}' becomes an 'or' of two 'from' nodes.
+//
+// The result is checked to generate exactly the same criteria, so that
+// importing never changes the meaning of a filter. An error is returned if
+// the criteria can't be parsed, or reconstructed faithfully.
+func parseCriteria(c filter.Criteria) (v1alpha3.FilterNode, error) {
+	var terms []string
+	add := func(fn parser.FunctionType, value string) {
+		if value != "" {
+			terms = append(terms, fmt.Sprintf("%v:%s", fn, value))
+		}
+	}
+	add(parser.FunctionFrom, c.From)
+	add(parser.FunctionTo, c.To)
+	add(parser.FunctionSubject, c.Subject)
+	if c.Query != "" {
+		terms = append(terms, c.Query)
+	}
+
+	crit, err := parser.ParseQuery(strings.Join(terms, " "))
+	if err != nil {
+		return v1alpha3.FilterNode{}, err
+	}
+	res, err := toFilterNode(crit)
+	if err != nil {
+		return v1alpha3.FilterNode{}, err
+	}
+
+	// Make sure that nothing is lost, by generating the criteria again.
+	rules, err := parser.Parse(v1alpha3.Config{
+		Rules: []v1alpha3.Rule{{Filter: res, Actions: v1alpha3.Actions{Archive: true}}},
+	})
+	if err != nil {
+		return v1alpha3.FilterNode{}, err
+	}
+	fs, err := filter.FromRules(rules)
+	if err != nil {
+		return v1alpha3.FilterNode{}, err
+	}
+	if len(fs) != 1 || !reflect.DeepEqual(fs[0].Criteria, c) {
+		return v1alpha3.FilterNode{}, errors.New("the criteria can't be reconstructed faithfully")
+	}
+	return res, nil
+}
+
+func toFilterNode(crit parser.CriteriaAST) (v1alpha3.FilterNode, error) {
+	if node, ok := crit.(*parser.Node); ok {
+		var children []v1alpha3.FilterNode
+		for _, c := range node.Children {
+			fn, err := toFilterNode(c)
+			if err != nil {
+				return v1alpha3.FilterNode{}, err
+			}
+			children = append(children, fn)
+		}
+		switch node.Operation {
+		case parser.OperationAnd:
+			return v1alpha3.FilterNode{And: children}, nil
+		case parser.OperationOr:
+			return v1alpha3.FilterNode{Or: children}, nil
+		case parser.OperationNot:
+			if len(children) != 1 {
+				return v1alpha3.FilterNode{}, fmt.Errorf("after 'not' got %d children, expected 1", len(children))
+			}
+			return v1alpha3.FilterNode{Not: &children[0]}, nil
+		}
+		return v1alpha3.FilterNode{}, fmt.Errorf("unknown node operation %d", node.Operation)
+	}
+
+	leaf, ok := crit.(*parser.Leaf)
+	if !ok {
+		return v1alpha3.FilterNode{}, errors.New("found unknown criteria node")
+	}
+	if leaf.Function == parser.FunctionHasAttachment {
+		return v1alpha3.FilterNode{HasAttachment: true}, nil
+	}
+	var nodes []v1alpha3.FilterNode
+	for _, arg := range leaf.Args {
+		n, err := functionNode(leaf.Function, arg)
+		if err != nil {
+			return v1alpha3.FilterNode{}, err
+		}
+		nodes = append(nodes, n)
+	}
+	switch {
+	case len(nodes) == 1:
+		return nodes[0], nil
+	case leaf.Grouping == parser.OperationOr:
+		return v1alpha3.FilterNode{Or: nodes}, nil
+	case leaf.Grouping == parser.OperationAnd:
+		return v1alpha3.FilterNode{And: nodes}, nil
+	}
+	return v1alpha3.FilterNode{}, fmt.Errorf("unexpected arguments for %q", leaf.Function)
+}
+
+func functionNode(fn parser.FunctionType, arg string) (v1alpha3.FilterNode, error) {
+	switch fn {
+	case parser.FunctionFrom:
+		return v1alpha3.FilterNode{From: arg}, nil
+	case parser.FunctionTo:
+		return v1alpha3.FilterNode{To: arg}, nil
+	case parser.FunctionCc:
+		return v1alpha3.FilterNode{Cc: arg}, nil
+	case parser.FunctionBcc:
+		return v1alpha3.FilterNode{Bcc: arg}, nil
+	case parser.FunctionReplyTo:
+		return v1alpha3.FilterNode{ReplyTo: arg}, nil
+	case parser.FunctionDeliveredTo:
+		return v1alpha3.FilterNode{DeliveredTo: arg}, nil
+	case parser.FunctionSubject:
+		return v1alpha3.FilterNode{Subject: arg}, nil
+	case parser.FunctionList:
+		return v1alpha3.FilterNode{List: arg}, nil
+	case parser.FunctionHas:
+		return v1alpha3.FilterNode{Has: arg}, nil
+	case parser.FunctionLarger:
+		return v1alpha3.FilterNode{Larger: arg}, nil
+	case parser.FunctionSmaller:
+		return v1alpha3.FilterNode{Smaller: arg}, nil
+	case parser.FunctionNewerThan:
+		return v1alpha3.FilterNode{NewerThan: arg}, nil
+	case parser.FunctionOlderThan:
+		return v1alpha3.FilterNode{OlderThan: arg}, nil
+	case parser.FunctionFilename:
+		return v1alpha3.FilterNode{Filename: arg}, nil
+	case parser.FunctionIn:
+		return v1alpha3.FilterNode{In: arg}, nil
+	case parser.FunctionIs:
+		return v1alpha3.FilterNode{Is: arg}, nil
+	case parser.FunctionCategory:
+		return v1alpha3.FilterNode{Category: arg}, nil
+	}
+	return v1alpha3.FilterNode{}, fmt.Errorf("unsupported function %q", fn)
+}
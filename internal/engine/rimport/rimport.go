@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/mbrt/gmailctl/internal/engine/config"
 	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
 	"github.com/mbrt/gmailctl/internal/engine/filter"
 	"github.com/mbrt/gmailctl/internal/engine/label"
@@ -49,9 +50,17 @@ func fromLabel(l label.Label) v1alpha3.Label {
 			Text:       l.Color.Text,
 		}
 	}
+	var visibility *v1alpha3.LabelVisibility
+	if l.Visibility != nil {
+		visibility = &v1alpha3.LabelVisibility{
+			LabelList:   l.Visibility.LabelList,
+			MessageList: l.Visibility.MessageList,
+		}
+	}
 	return v1alpha3.Label{
-		Name:  l.Name,
-		Color: color,
+		Name:       l.Name,
+		Color:      color,
+		Visibility: visibility,
 	}
 }
 
@@ -68,6 +77,11 @@ func fromFilter(f filter.Filter) (v1alpha3.Rule, error) {
 }
 
 func fromCriteria(c filter.Criteria) (v1alpha3.FilterNode, error) {
+	if n, err := parseCriteria(c); err == nil {
+		return n, nil
+	}
+
+	// The criteria can't be reconstructed, so they are kept as they are.
 	nodes := []v1alpha3.FilterNode{}
 	// Reduce the need for raw nodes as much as we can, by using regular
 	// operators when no problematic chars are found.
@@ -96,9 +110,11 @@ func fromCriteria(c filter.Criteria) (v1alpha3.FilterNode, error) {
 		nodes = append(nodes, n)
 	}
 	if c.Query != "" {
-		n := v1alpha3.FilterNode{
-			Query: c.Query,
-			// IsRaw is implicit for query nodes
+		// IsRaw is implicit for query nodes, but queries that can't be
+		// safely composed need the checks to be disabled.
+		n := v1alpha3.FilterNode{Query: c.Query}
+		if config.ValidateQuery(c.Query) != nil {
+			n = v1alpha3.FilterNode{RawQuery: c.Query}
 		}
 		nodes = append(nodes, n)
 	}
@@ -137,7 +153,8 @@ func fromActions(c filter.Actions) (v1alpha3.Actions, error) {
 		return res, fmt.Errorf("in 'mark important': %w", err)
 	}
 	if c.MarkNotSpam {
-		res.MarkSpam = boolPtr(false)
+		no := false
+		res.MarkSpam = &no
 	}
 
 	return res, nil
@@ -156,7 +173,3 @@ func handleTribool(isTrue, isFalse bool) (*bool, error) {
 	// Neither is specified
 	return nil, nil
 }
-
-func boolPtr(v bool) *bool {
-	return &v
-}
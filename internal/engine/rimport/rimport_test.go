@@ -0,0 +1,106 @@
+package rimport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+)
+
+func TestFromCriteria(t *testing.T) {
+	tests := []struct {
+		name string
+		crit filter.Criteria
+		want v1alpha3.FilterNode
+	}{
+		{
+			name: "grouped from",
+			crit: filter.Criteria{From: "{a@x.com b@x.com c@x.com}"},
+			want: v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{
+				{From: "a@x.com"},
+				{From: "b@x.com"},
+				{From: "c@x.com"},
+			}},
+		},
+		{
+			name: "negated query",
+			crit: filter.Criteria{From: "boss@work.com", Query: `-subject:"weekly report"`},
+			want: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{
+				{From: "boss@work.com"},
+				{Not: &v1alpha3.FilterNode{Subject: "weekly report"}},
+			}},
+		},
+		{
+			name: "negated grouped lists",
+			crit: filter.Criteria{Query: "-{list:a@x.com list:b@x.com}"},
+			want: v1alpha3.FilterNode{Not: &v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{
+				{List: "a@x.com"},
+				{List: "b@x.com"},
+			}}},
+		},
+		{
+			// Gmail operators are kept as they are.
+			name: "unsupported operator",
+			crit: filter.Criteria{Query: "from:a OR from:b"},
+			want: v1alpha3.FilterNode{RawQuery: "from:a OR from:b"},
+		},
+		{
+			// Reconstructing would reorder the query.
+			name: "not faithful",
+			crit: filter.Criteria{Query: "-to:me list:dev"},
+			want: v1alpha3.FilterNode{Query: "-to:me list:dev"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := fromCriteria(tc.crit)
+			require.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNegatedListsRoundTrip(t *testing.T) {
+	lists := v1alpha3.FilterNode{Not: &v1alpha3.FilterNode{Or: []v1alpha3.FilterNode{
+		{List: "a@x.com"},
+		{List: "b@x.com"},
+	}}}
+	tests := []struct {
+		name   string
+		filter v1alpha3.FilterNode
+		want   filter.Criteria
+	}{
+		{
+			name:   "alone",
+			filter: lists,
+			want:   filter.Criteria{Query: "-{list:a@x.com list:b@x.com}"},
+		},
+		{
+			name:   "with other criteria",
+			filter: v1alpha3.FilterNode{And: []v1alpha3.FilterNode{{From: "me@x.com"}, lists}},
+			want:   filter.Criteria{From: "me@x.com", Query: "-{list:a@x.com list:b@x.com}"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := parser.Parse(v1alpha3.Config{
+				Rules: []v1alpha3.Rule{{Filter: tc.filter, Actions: v1alpha3.Actions{Archive: true}}},
+			})
+			require.Nil(t, err)
+			fs, err := filter.FromRules(rules)
+			require.Nil(t, err)
+			require.Len(t, fs, 1)
+			assert.Equal(t, tc.want, fs[0].Criteria)
+
+			got, err := fromCriteria(fs[0].Criteria)
+			require.Nil(t, err)
+			assert.Equal(t, tc.filter, got)
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package rimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// ImportThunderbird converts the filters of a Thunderbird msgFilterRules.dat
+// file into config rules, best effort quality.
+//
+// Conditions and actions without a Gmail equivalent are reported in the
+// returned warnings. Unsupported 'or' conditions are dropped, while filters
+// with unsupported 'and' conditions are skipped entirely, because dropping
+// the condition would make them match more messages.
+func ImportThunderbird(r io.Reader) (v1alpha3.Config, []string, error) {
+	tfs, err := parseThunderbird(r)
+	if err != nil {
+		return v1alpha3.Config{}, nil, err
+	}
+
+	var (
+		rules    []v1alpha3.Rule
+		labels   []v1alpha3.Label
+		seen     = map[string]bool{}
+		warnings []string
+	)
+	for _, tf := range tfs {
+		rule, ws, ok := tf.toRule()
+		for _, w := range ws {
+			warnings = append(warnings, fmt.Sprintf("filter %q: %s", tf.name, w))
+		}
+		if !ok {
+			continue
+		}
+		for _, l := range rule.Actions.Labels {
+			if !seen[l] {
+				seen[l] = true
+				labels = append(labels, v1alpha3.Label{Name: l})
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	return v1alpha3.Config{
+		Version: v1alpha3.Version,
+		Author: v1alpha3.Author{
+			Name:  "YOUR NAME HERE (auto imported)",
+			Email: "your-email@gmail.com",
+		},
+		Labels: labels,
+		Rules:  rules,
+	}, warnings, nil
+}
+
+type thunderbirdFilter struct {
+	name      string
+	enabled   bool
+	actions   []thunderbirdAction
+	condition string
+}
+
+type thunderbirdAction struct {
+	name  string
+	value string
+}
+
+type thunderbirdCondition struct {
+	field    string
+	operator string
+	value    string
+}
+
+func (c thunderbirdCondition) String() string {
+	return fmt.Sprintf("(%s,%s,%s)", c.field, c.operator, c.value)
+}
+
+func parseThunderbird(r io.Reader) ([]thunderbirdFilter, error) {
+	var res []thunderbirdFilter
+	scanner := bufio.NewScanner(r)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, value, err := parseThunderbirdLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+
+		if key == "name" {
+			res = append(res, thunderbirdFilter{name: value, enabled: true})
+			continue
+		}
+		if len(res) == 0 {
+			// Global settings, like the version.
+			continue
+		}
+		f := &res[len(res)-1]
+
+		switch key {
+		case "enabled":
+			f.enabled = value == "yes"
+		case "action":
+			f.actions = append(f.actions, thunderbirdAction{name: value})
+		case "actionValue":
+			if len(f.actions) == 0 {
+				return nil, fmt.Errorf("line %d: 'actionValue' without an action", lineno)
+			}
+			f.actions[len(f.actions)-1].value = value
+		case "condition":
+			f.condition = value
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+// parseThunderbirdLine parses a key="value" line. Quotes and backslashes
+// inside the value are escaped with a backslash.
+func parseThunderbirdLine(line string) (string, string, error) {
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected key=\"value\", got %q", line)
+	}
+	key, quoted := line[:i], line[i+1:]
+	if len(quoted) < 2 || quoted[0] != '"' || quoted[len(quoted)-1] != '"' {
+		return "", "", fmt.Errorf("expected quoted value for %q", key)
+	}
+	return key, unescapeThunderbird(quoted[1 : len(quoted)-1]), nil
+}
+
+func unescapeThunderbird(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// parseThunderbirdConditions parses a filter condition, in the form:
+//
+//	AND (from,contains,a@b.com) AND (subject,is,"quoted, value")
+//
+// It returns whether all the conditions have to match, or just any.
+func parseThunderbirdConditions(s string) ([]thunderbirdCondition, bool, error) {
+	var (
+		res      []thunderbirdCondition
+		matchAll = true
+	)
+	s = strings.TrimSpace(s)
+
+	for i := 0; s != ""; i++ {
+		sep := strings.Index(s, " ")
+		if sep < 0 {
+			return nil, false, fmt.Errorf("expected condition after %q", s)
+		}
+		op, rest := s[:sep], s[sep+1:]
+		switch op {
+		case "AND":
+		case "OR":
+			if i == 0 {
+				matchAll = false
+			}
+		default:
+			return nil, false, fmt.Errorf("unknown operator %q", op)
+		}
+
+		cond, rest, err := parseThunderbirdTerm(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, false, err
+		}
+		res = append(res, cond)
+		s = strings.TrimSpace(rest)
+	}
+
+	return res, matchAll, nil
+}
+
+// parseThunderbirdTerm parses a single (field,operator,value) term at the
+// beginning of s and returns the rest of the string.
+func parseThunderbirdTerm(s string) (thunderbirdCondition, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return thunderbirdCondition{}, "", fmt.Errorf("expected '(' in %q", s)
+	}
+
+	var (
+		parts   []string
+		current strings.Builder
+		quoted  bool
+		escaped bool
+	)
+	for i, r := range s[1:] {
+		switch {
+		case escaped:
+			escaped = false
+			current.WriteRune(r)
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && r == ',' && len(parts) < 2:
+			parts = append(parts, current.String())
+			current.Reset()
+		case !quoted && r == ')':
+			parts = append(parts, current.String())
+			if len(parts) != 3 {
+				return thunderbirdCondition{}, "", fmt.Errorf("expected (field,operator,value), got %q", s[:i+2])
+			}
+			cond := thunderbirdCondition{
+				field:    strings.ToLower(parts[0]),
+				operator: strings.ToLower(parts[1]),
+				value:    parts[2],
+			}
+			return cond, s[i+2:], nil
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	return thunderbirdCondition{}, "", fmt.Errorf("unterminated condition %q", s)
+}
+
+func (f thunderbirdFilter) toRule() (v1alpha3.Rule, []string, bool) {
+	if !f.enabled {
+		return v1alpha3.Rule{}, []string{"skipped, because it's disabled"}, false
+	}
+
+	filter, warnings, ok := f.toFilterNode()
+	if !ok {
+		return v1alpha3.Rule{}, warnings, false
+	}
+	actions, aws := f.toActions()
+	warnings = append(warnings, aws...)
+	if actions.Empty() {
+		return v1alpha3.Rule{}, append(warnings, "skipped, because it has no supported actions"), false
+	}
+
+	return v1alpha3.Rule{Filter: filter, Actions: actions}, warnings, true
+}
+
+func (f thunderbirdFilter) toFilterNode() (v1alpha3.FilterNode, []string, bool) {
+	if strings.TrimSpace(f.condition) == "ALL" {
+		return v1alpha3.FilterNode{}, []string{"skipped, because Gmail filters can't match all messages"}, false
+	}
+	conds, matchAll, err := parseThunderbirdConditions(f.condition)
+	if err != nil {
+		return v1alpha3.FilterNode{}, []string{fmt.Sprintf("skipped, invalid condition: %v", err)}, false
+	}
+
+	var (
+		nodes    []v1alpha3.FilterNode
+		warnings []string
+	)
+	for _, c := range conds {
+		n, err := c.toFilterNode()
+		if err == nil {
+			nodes = append(nodes, n)
+			if c.operator == "is" || c.operator == "isn't" {
+				warnings = append(warnings, fmt.Sprintf(
+					"approximated condition %v with 'contains', because Gmail can't match whole values", c))
+			}
+			continue
+		}
+		if matchAll {
+			return v1alpha3.FilterNode{}, []string{
+				fmt.Sprintf("skipped, unsupported condition %v: %v", c, err),
+			}, false
+		}
+		warnings = append(warnings, fmt.Sprintf("dropped unsupported condition %v: %v", c, err))
+	}
+
+	switch {
+	case len(nodes) == 0:
+		return v1alpha3.FilterNode{}, append(warnings, "skipped, because it has no supported conditions"), false
+	case len(nodes) == 1:
+		return nodes[0], warnings, true
+	case matchAll:
+		return v1alpha3.FilterNode{And: nodes}, warnings, true
+	default:
+		return v1alpha3.FilterNode{Or: nodes}, warnings, true
+	}
+}
+
+// toFilterNode converts the condition into a filter node. Exact matches are
+// approximated with the equivalent contains.
+func (c thunderbirdCondition) toFilterNode() (v1alpha3.FilterNode, error) {
+	negate := false
+	switch c.operator {
+	case "contains", "is":
+	case "doesn't contain", "isn't":
+		negate = true
+	default:
+		return v1alpha3.FilterNode{}, errors.New("unsupported operator")
+	}
+
+	var n v1alpha3.FilterNode
+	switch c.field {
+	case "from":
+		n.From = c.value
+	case "to":
+		n.To = c.value
+	case "cc":
+		n.Cc = c.value
+	case "to or cc":
+		n.Or = []v1alpha3.FilterNode{{To: c.value}, {Cc: c.value}}
+	case "subject":
+		n.Subject = c.value
+	case "body":
+		n.Has = c.value
+	default:
+		return v1alpha3.FilterNode{}, errors.New("unsupported field")
+	}
+
+	if negate {
+		return v1alpha3.FilterNode{Not: &n}, nil
+	}
+	return n, nil
+}
+
+func (f thunderbirdFilter) toActions() (v1alpha3.Actions, []string) {
+	var (
+		res      v1alpha3.Actions
+		warnings []string
+	)
+	for _, a := range f.actions {
+		switch a.name {
+		case "Move to folder", "Copy to folder":
+			if err := folderAction(&res, a.value, a.name == "Move to folder"); err != nil {
+				warnings = append(warnings, fmt.Sprintf("dropped action %q: %v", a.name, err))
+			}
+		case "Mark read":
+			res.MarkRead = true
+		case "Mark flagged":
+			res.Star = true
+		case "Delete":
+			res.Delete = true
+		case "Forward":
+			res.Forward = a.value
+		case "JunkScore":
+			if a.value == "0" {
+				spam := false
+				res.MarkSpam = &spam
+			} else {
+				warnings = append(warnings, fmt.Sprintf("dropped action %q: %v", a.name, errSpam))
+			}
+		default:
+			warnings = append(warnings, fmt.Sprintf("dropped unsupported action %q", a.name))
+		}
+	}
+	return res, warnings
+}
+
+// errSpam is reported for the actions sending messages to spam, which Gmail
+// filters don't allow.
+var errSpam = errors.New("Gmail filters can't send messages to spam")
+
+// folderAction translates moving or copying to a folder into the equivalent
+// Gmail actions. Messages moved away from the inbox are archived.
+func folderAction(res *v1alpha3.Actions, folderURI string, move bool) error {
+	// Folders are URIs like mailbox://nobody@Local%20Folders/Work, but the
+	// escaped host makes them invalid URLs, so the path is extracted here.
+	i := strings.Index(folderURI, "://")
+	if i < 0 {
+		return fmt.Errorf("invalid folder %q", folderURI)
+	}
+	path := folderURI[i+len("://"):]
+	if i = strings.Index(path, "/"); i < 0 {
+		return fmt.Errorf("invalid folder %q", folderURI)
+	}
+	folder, err := url.PathUnescape(strings.Trim(path[i:], "/"))
+	if err != nil {
+		return fmt.Errorf("invalid folder %q", folderURI)
+	}
+	// IMAP folders can be nested into the inbox, but Gmail labels can't.
+	if f, ok := cutPrefixFold(folder, "INBOX/"); ok {
+		folder = f
+	}
+
+	switch strings.ToLower(folder) {
+	case "":
+		return fmt.Errorf("invalid folder %q", folderURI)
+	case "inbox":
+		// Nothing to do.
+	case "trash":
+		res.Delete = true
+	case "junk", "spam":
+		return errSpam
+	case "archive", "archives":
+		res.Archive = true
+	default:
+		res.Labels = append(res.Labels, folder)
+		res.Archive = res.Archive || move
+	}
+	return nil
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,163 @@
+package rimport
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+func TestImportThunderbird(t *testing.T) {
+	f, err := os.Open("testdata/msgFilterRules.dat")
+	require.Nil(t, err)
+	defer f.Close()
+
+	cfg, warnings, err := ImportThunderbird(f)
+	require.Nil(t, err)
+
+	assert.Equal(t, []v1alpha3.Label{
+		{Name: "Work/Reports"},
+		{Name: "News Letters"},
+	}, cfg.Labels)
+	assert.Equal(t, []v1alpha3.Rule{
+		{
+			Filter: v1alpha3.FilterNode{
+				And: []v1alpha3.FilterNode{
+					{From: "boss@work.com"},
+					{Subject: "weekly, report"},
+				},
+			},
+			Actions: v1alpha3.Actions{
+				Labels:   []string{"Work/Reports"},
+				Archive:  true,
+				MarkRead: true,
+			},
+		},
+		{
+			Filter: v1alpha3.FilterNode{
+				Or: []v1alpha3.FilterNode{
+					{Or: []v1alpha3.FilterNode{{To: "news@lists.com"}, {Cc: "news@lists.com"}}},
+					{Has: "unsubscribe"},
+				},
+			},
+			Actions: v1alpha3.Actions{
+				Labels: []string{"News Letters"},
+				Star:   true,
+			},
+		},
+		{
+			Filter: v1alpha3.FilterNode{
+				And: []v1alpha3.FilterNode{
+					{Not: &v1alpha3.FilterNode{From: "example.com"}},
+					{Subject: "WIN"},
+				},
+			},
+			Actions: v1alpha3.Actions{Delete: true},
+		},
+	}, cfg.Rules)
+	assert.Equal(t, []string{
+		`filter "Newsletters": dropped unsupported condition (date,is before,01-Jan-2020): unsupported operator`,
+		`filter "Spam": approximated condition (subject,is,WIN) with 'contains', because Gmail can't match whole values`,
+		`filter "Custom header": skipped, unsupported condition (x-spam,contains,yes): unsupported field`,
+		`filter "Unsupported action": dropped unsupported action "Reply with Template"`,
+		`filter "Unsupported action": skipped, because it has no supported actions`,
+		`filter "Disabled": skipped, because it's disabled`,
+	}, warnings)
+}
+
+func TestImportThunderbirdFolders(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		folder  string
+		want    v1alpha3.Actions
+		warning string
+	}{
+		{
+			name:   "move",
+			action: "Move to folder",
+			folder: "mailbox://nobody@Local%20Folders/Work",
+			want:   v1alpha3.Actions{Labels: []string{"Work"}, Archive: true},
+		},
+		{
+			name:   "copy",
+			action: "Copy to folder",
+			folder: "mailbox://nobody@Local%20Folders/Work",
+			want:   v1alpha3.Actions{Labels: []string{"Work"}},
+		},
+		{
+			name:   "trash",
+			action: "Move to folder",
+			folder: "imap://me@imap.example.com/Trash",
+			want:   v1alpha3.Actions{Delete: true},
+		},
+		{
+			name:    "junk",
+			action:  "Move to folder",
+			folder:  "imap://me@imap.example.com/Junk",
+			warning: `filter "test": dropped action "Move to folder": Gmail filters can't send messages to spam`,
+		},
+		{
+			name:   "archive",
+			action: "Move to folder",
+			folder: "imap://me@imap.example.com/Archives",
+			want:   v1alpha3.Actions{Archive: true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules := strings.Join([]string{
+				`name="test"`,
+				`action="` + tc.action + `"`,
+				`actionValue="` + tc.folder + `"`,
+				`action="Mark read"`,
+				`condition="AND (from,contains,a@b.com)"`,
+			}, "\n")
+			cfg, warnings, err := ImportThunderbird(strings.NewReader(rules))
+			require.Nil(t, err)
+			if tc.warning == "" {
+				assert.Empty(t, warnings)
+			} else {
+				assert.Equal(t, []string{tc.warning}, warnings)
+			}
+			require.Len(t, cfg.Rules, 1)
+			tc.want.MarkRead = true
+			assert.Equal(t, tc.want, cfg.Rules[0].Actions)
+		})
+	}
+}
+
+func TestImportThunderbirdApproximated(t *testing.T) {
+	rules := strings.Join([]string{
+		`name="exact"`,
+		`action="Mark read"`,
+		`condition="AND (subject,is,Hello) AND (from,isn't,a@b.com)"`,
+	}, "\n")
+	cfg, warnings, err := ImportThunderbird(strings.NewReader(rules))
+	require.Nil(t, err)
+	require.Len(t, cfg.Rules, 1)
+	assert.Equal(t, v1alpha3.FilterNode{
+		And: []v1alpha3.FilterNode{
+			{Subject: "Hello"},
+			{Not: &v1alpha3.FilterNode{From: "a@b.com"}},
+		},
+	}, cfg.Rules[0].Filter)
+	assert.Equal(t, []string{
+		`filter "exact": approximated condition (subject,is,Hello) with 'contains', because Gmail can't match whole values`,
+		`filter "exact": approximated condition (from,isn't,a@b.com) with 'contains', because Gmail can't match whole values`,
+	}, warnings)
+}
+
+func TestImportThunderbirdInvalid(t *testing.T) {
+	_, _, err := ImportThunderbird(strings.NewReader("name=\"a\"\nenabled=yes\n"))
+	assert.EqualError(t, err, `line 2: expected quoted value for "enabled"`)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
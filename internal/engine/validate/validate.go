@@ -0,0 +1,163 @@
+// Package validate checks configurations and reports all the problems
+// found, in a machine readable format.
+package validate
+
+import (
+	"fmt"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+	"github.com/mbrt/gmailctl/internal/engine/filter"
+	"github.com/mbrt/gmailctl/internal/engine/parser"
+	"github.com/mbrt/gmailctl/internal/errors"
+)
+
+// Severity is the importance of a diagnostic.
+type Severity int
+
+// Severities of a diagnostic.
+const (
+	// SeverityError prevents the config from being applied.
+	SeverityError Severity = iota
+	// SeverityWarning reports a config that is applied, but likely doesn't
+	// behave as expected.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// MarshalText encodes the severity by its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic is a problem found in a rule of the config.
+type Diagnostic struct {
+	// RuleIndex is the position of the rule in the config.
+	RuleIndex int `json:"ruleIndex"`
+	// Field is the path of the offending field in the rule, e.g.
+	// 'filter.and[1]' or 'actions'.
+	Field    string   `json:"field"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("rule #%d: %s: %s: %s", d.RuleIndex, d.Field, d.Severity, d.Message)
+}
+
+// Validate returns all the problems found in the rules of the config.
+//
+// Unlike parsing, which stops at the first error, every filter node and
+// rule is checked independently. The checks that need a valid rule, like
+// the length of the generated queries, are only performed on the rules with
+// no other errors.
+func Validate(config cfg.Config) []Diagnostic {
+	var res []Diagnostic
+	for i, rule := range config.Rules {
+		res = append(res, validateRule(i, rule)...)
+	}
+	return res
+}
+
+func validateRule(index int, rule cfg.Rule) []Diagnostic {
+	var res []Diagnostic
+	report := func(field string, sev Severity, msg string) {
+		res = append(res, Diagnostic{
+			RuleIndex: index,
+			Field:     field,
+			Severity:  sev,
+			Message:   msg,
+		})
+	}
+
+	checkFilter("filter", rule.Filter, func(field string, err error) {
+		report(field, SeverityError, err.Error())
+	})
+	switch {
+	case rule.ActionGroups == nil:
+		checkActions("actions", rule.Actions, report)
+	case !rule.Actions.Empty():
+		report("actions", SeverityError, "'actions' and 'actionGroups' are mutually exclusive")
+	case len(rule.ActionGroups) == 0:
+		report("actionGroups", SeverityError, "'actionGroups' specified but contains no groups")
+	default:
+		for i, a := range rule.ActionGroups {
+			checkActions(fmt.Sprintf("actionGroups[%d]", i), a, report)
+		}
+	}
+	if len(res) > 0 {
+		return res
+	}
+
+	rules, err := parser.Parse(cfg.Config{Rules: []cfg.Rule{rule}})
+	if err != nil {
+		var rerr parser.RuleError
+		if errors.As(err, &rerr) {
+			err = rerr.Err
+		}
+		report("filter", SeverityError, err.Error())
+		return res
+	}
+
+	rules, warnings := parser.FlattenDeep(rules)
+	for _, w := range warnings {
+		if w.Flattened > parser.MaxDepth {
+			report("filter", SeverityWarning, fmt.Sprintf(
+				"nested %d levels deep, more than the %d Gmail handles reliably",
+				w.Flattened, parser.MaxDepth))
+		} else {
+			report("filter", SeverityWarning, fmt.Sprintf(
+				"nested %d levels deep, flattened to %q", w.Depth, w.After))
+		}
+	}
+
+	// Rules can be split by parsing, but the problems are reported once.
+	seen := map[string]bool{}
+	for _, r := range rules {
+		if _, err := filter.FromRules([]parser.Rule{r}); err != nil {
+			// Drop the index of the split rule.
+			msg := errors.Unwrap(err).Error()
+			if !seen[msg] {
+				seen[msg] = true
+				report("filter", SeverityError, msg)
+			}
+		}
+	}
+
+	return res
+}
+
+func checkActions(field string, a cfg.Actions, report func(string, Severity, string)) {
+	if a.Empty() {
+		report(field, SeverityError, "empty action")
+	} else if _, err := parser.ParseActions(a); err != nil {
+		report(field, SeverityError, err.Error())
+	}
+}
+
+// checkFilter reports the problems of the node and all its children, with
+// the path of the offending node.
+func checkFilter(field string, f cfg.FilterNode, report func(string, error)) {
+	if err := parser.CheckNode(f); err != nil {
+		report(field, err)
+	}
+	for i, c := range f.And {
+		checkFilter(fmt.Sprintf("%s.and[%d]", field, i), c, report)
+	}
+	for i, c := range f.Or {
+		checkFilter(fmt.Sprintf("%s.or[%d]", field, i), c, report)
+	}
+	// An empty 'not' is already reported by its parent.
+	if f.Not != nil && !isEmpty(*f.Not) {
+		checkFilter(field+".not", *f.Not, report)
+	}
+}
+
+func isEmpty(f cfg.FilterNode) bool {
+	return len(f.NonEmptyFields()) == 0 && f.And == nil && f.Or == nil && f.Not == nil
+}
@@ -0,0 +1,175 @@
+package validate
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/mbrt/gmailctl/internal/engine/config/v1alpha3"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestValidate(t *testing.T) {
+	config := cfg.Config{
+		Version: cfg.Version,
+		Rules: []cfg.Rule{
+			{
+				// Valid.
+				Filter:  cfg.FilterNode{From: "boss@work.com"},
+				Actions: cfg.Actions{Star: true},
+			},
+			{
+				Filter: cfg.FilterNode{
+					And: []cfg.FilterNode{
+						{From: "a@b.com"},
+						{From: "c@d.com", To: "me@gmail.com"},
+						{Not: &cfg.FilterNode{List: "dev@lists.com", IsEscaped: true}},
+					},
+				},
+			},
+			{
+				Filter: cfg.FilterNode{Subject: "spam"},
+				Actions: cfg.Actions{
+					MarkSpam:      boolPtr(true),
+					MarkImportant: boolPtr(true),
+				},
+			},
+			{
+				Filter:  cfg.FilterNode{Subject: strings.Repeat("x", 1600)},
+				Actions: cfg.Actions{Archive: true},
+			},
+			{
+				// Alternating operations can't be flattened.
+				Filter: cfg.FilterNode{And: []cfg.FilterNode{
+					{From: "a"},
+					{Or: []cfg.FilterNode{
+						{To: "b"},
+						{And: []cfg.FilterNode{
+							{Cc: "c"},
+							{Or: []cfg.FilterNode{
+								{Bcc: "d"},
+								{Not: &cfg.FilterNode{Subject: "e"}},
+							}},
+						}},
+					}},
+				}},
+				Actions: cfg.Actions{Archive: true},
+			},
+		},
+	}
+
+	got := Validate(config)
+	require.Len(t, got, 6)
+	assert.Equal(t, []Diagnostic{
+		{
+			RuleIndex: 1,
+			Field:     "filter.and[1]",
+			Message:   "multiple fields specified in the same filter node: from,to",
+		},
+		{
+			RuleIndex: 1,
+			Field:     "filter.and[2].not",
+			Message:   "'isRaw' can be used only with fields from, to, subject",
+		},
+		{
+			RuleIndex: 1,
+			Field:     "actions",
+			Message:   "empty action",
+		},
+		{
+			RuleIndex: 2,
+			Field:     "filter",
+			Message:   "generating actions: Gmail filters don't allow one to send messages to spam directly",
+		},
+		{
+			RuleIndex: 3,
+			Field:     "filter",
+			Message:   "generated query is 1608 bytes long, exceeding the limit of 1500",
+		},
+		{
+			RuleIndex: 4,
+			Field:     "filter",
+			Severity:  SeverityWarning,
+			Message:   "nested 5 levels deep, more than the 4 Gmail handles reliably",
+		},
+	}, got)
+}
+
+func TestValidateActionGroups(t *testing.T) {
+	config := cfg.Config{
+		Version: cfg.Version,
+		Rules: []cfg.Rule{
+			{
+				Filter: cfg.FilterNode{From: "a@b.com"},
+				ActionGroups: []cfg.Actions{
+					{Forward: "me@example.com"},
+					{},
+					{MarkSpam: boolPtr(true), NeverMarkSpam: true},
+				},
+			},
+			{
+				Filter:       cfg.FilterNode{From: "c@d.com"},
+				Actions:      cfg.Actions{Star: true},
+				ActionGroups: []cfg.Actions{{Archive: true}},
+			},
+			{
+				Filter:       cfg.FilterNode{From: "e@f.com"},
+				ActionGroups: []cfg.Actions{{Archive: true}, {Star: true}},
+			},
+		},
+	}
+	assert.Equal(t, []Diagnostic{
+		{
+			RuleIndex: 0,
+			Field:     "actionGroups[1]",
+			Message:   "empty action",
+		},
+		{
+			RuleIndex: 0,
+			Field:     "actionGroups[2]",
+			Message:   "'markSpam' and 'neverMarkSpam' cannot be both enabled",
+		},
+		{
+			RuleIndex: 1,
+			Field:     "actions",
+			Message:   "'actions' and 'actionGroups' are mutually exclusive",
+		},
+	}, Validate(config))
+}
+
+func TestValidateValid(t *testing.T) {
+	config := cfg.Config{
+		Version: cfg.Version,
+		Rules: []cfg.Rule{
+			{
+				Filter:  cfg.FilterNode{From: "boss@work.com"},
+				Actions: cfg.Actions{Star: true},
+			},
+		},
+	}
+	assert.Empty(t, Validate(config))
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	d := Diagnostic{
+		RuleIndex: 2,
+		Field:     "filter.not",
+		Severity:  SeverityWarning,
+		Message:   "something odd",
+	}
+	b, err := json.Marshal(d)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{
+		"ruleIndex": 2,
+		"field": "filter.not",
+		"severity": "warning",
+		"message": "something odd"
+	}`, string(b))
+	assert.Equal(t, "rule #2: filter.not: warning: something odd", d.String())
+}
@@ -10,9 +10,10 @@ import (
 
 // Aliases to the standard errors package.
 var (
-	New = errors.New
-	Is  = errors.Is
-	As  = errors.As
+	New    = errors.New
+	Is     = errors.Is
+	As     = errors.As
+	Unwrap = errors.Unwrap
 )
 
 // bufferPool is a pool of bytes.Buffers.
@@ -57,6 +58,8 @@ func WriteDetails(w io.Writer, err error) {
 
 func Details(err error) string {
 	buffer := bufferPool.Get().(*bytes.Buffer)
+	// Pooled buffers still contain the details of the previous errors.
+	buffer.Reset()
 	WriteDetails(buffer, err)
 	res := buffer.String()
 	bufferPool.Put(buffer)
@@ -114,6 +117,27 @@ func Errors(err error) []error {
 	return merr
 }
 
+// WithIndex annotates the failure of an operation on multiple items, as the
+// failure of the item at the given index.
+//
+// The index can be found with Index, while the message is unchanged.
+func WithIndex(err error, index int) error {
+	if err == nil {
+		return nil
+	}
+	return indexed{err, index}
+}
+
+// Index returns the index of the failed item the error was annotated with,
+// if any. See WithIndex.
+func Index(err error) (int, bool) {
+	var iErr indexed
+	if errors.As(err, &iErr) {
+		return iErr.index, true
+	}
+	return 0, false
+}
+
 type multi []error
 
 func (m multi) Error() string {
@@ -163,6 +187,10 @@ type detailed struct {
 	details []string
 }
 
+func (d detailed) Unwrap() error {
+	return d.error
+}
+
 func (d detailed) Format(f fmt.State, c rune) {
 	if (c == 'v' || c == 'w') && f.Flag('+') {
 		d.writeMultiline(f)
@@ -213,6 +241,24 @@ func (e annotated) As(target interface{}) bool {
 	return errors.As(e.cause, target)
 }
 
+type indexed struct {
+	error
+	index int
+}
+
+func (e indexed) Unwrap() error {
+	return e.error
+}
+
+func (e indexed) Format(f fmt.State, c rune) {
+	if (c == 'v' || c == 'w') && f.Flag('+') {
+		fmt.Fprintf(f, "%+v", e.error)
+	} else {
+		//nolint:errcheck
+		io.WriteString(f, e.Error())
+	}
+}
+
 type indentWriter struct {
 	io.Writer
 }
@@ -58,6 +58,11 @@ func TestErrWithDetails(t *testing.T) {
   - another
     descr`
 	assert.Equal(t, details, Details(err4))
+	// The details of previous errors are not repeated.
+	assert.Equal(t, "\n  - other", Details(WithDetails(err1, "other")))
+
+	// The wrapped errors can still be found.
+	assert.True(t, errors.Is(err4, err1))
 }
 
 func TestCombine(t *testing.T) {
@@ -88,3 +93,19 @@ func TestCombine(t *testing.T) {
 - err2`
 	assert.Equal(t, fmt.Sprintf("%+v", err4), verbose)
 }
+
+func TestWithIndex(t *testing.T) {
+	err1 := errors.New("err1")
+	assert.NoError(t, WithIndex(nil, 1))
+
+	err := WithIndex(WithDetails(err1, "details"), 2)
+	assert.EqualError(t, err, "err1")
+	assert.Equal(t, "err1\nNote:\n- details", fmt.Sprintf("%+v", err))
+	assert.True(t, errors.Is(err, err1))
+	i, ok := Index(fmt.Errorf("wrapped: %w", err))
+	assert.True(t, ok)
+	assert.Equal(t, 2, i)
+
+	_, ok = Index(err1)
+	assert.False(t, ok)
+}
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 
@@ -60,6 +61,8 @@ func NewService(ctx context.Context, t *testing.T) *gmailv1.Service {
 		http.HandlerFunc(srv.HandleLabelsGet)).Methods(http.MethodGet)
 	mux.Handle("/gmail/v1/users/me/labels",
 		http.HandlerFunc(srv.HandleLabelsPost)).Methods(http.MethodPost)
+	mux.Handle("/gmail/v1/users/me/labels/{id}",
+		http.HandlerFunc(srv.HandleLabelGet)).Methods(http.MethodGet)
 	mux.Handle("/gmail/v1/users/me/labels/{id}",
 		http.HandlerFunc(srv.HandleLabelDelete)).Methods(http.MethodDelete)
 	mux.Handle("/gmail/v1/users/me/labels/{id}",
@@ -109,6 +112,16 @@ func (g *gmailServer) HandleLabelsPost(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, res)
 }
 
+func (g *gmailServer) HandleLabelGet(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	res, err := g.Label(mux.Vars(r)["id"])
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeResponse(w, res)
+}
+
 func (g *gmailServer) HandleLabelDelete(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	id := mux.Vars(r)["id"]
@@ -219,6 +232,21 @@ func (g *gmail) Labels() []*gmailv1.Label {
 	return res
 }
 
+func (g *gmail) Label(id string) (*gmailv1.Label, error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	l, ok := g.labels[id]
+	if !ok {
+		return nil, statusError{404, fmt.Errorf("id %q not found", id)}
+	}
+	if l == nil {
+		// Default labels have no details.
+		return &gmailv1.Label{Id: id, Name: id, Type: "system"}, nil
+	}
+	return l, nil
+}
+
 func (g *gmail) CreateLabel(l *gmailv1.Label) (*gmailv1.Label, error) {
 	g.m.Lock()
 	defer g.m.Unlock()
@@ -229,6 +257,7 @@ func (g *gmail) CreateLabel(l *gmailv1.Label) (*gmailv1.Label, error) {
 	if g.labelNames.Has(l.Name) {
 		return nil, statusError{http.StatusBadRequest, fmt.Errorf("label with name %q is already present", l.Name)}
 	}
+	normalizeColor(l.Color)
 	l.Id = fmt.Sprintf("ID%d", g.labelNextID)
 	g.labelNextID++
 	g.labels[l.Id] = l
@@ -261,8 +290,15 @@ func (g *gmail) UpdateLabel(l *gmailv1.Label) (*gmailv1.Label, error) {
 	target := g.labels[l.Id]
 	if l.Color != nil {
 		// Only update the color if it was passed in.
+		normalizeColor(l.Color)
 		target.Color = l.Color
 	}
+	if l.LabelListVisibility != "" {
+		target.LabelListVisibility = l.LabelListVisibility
+	}
+	if l.MessageListVisibility != "" {
+		target.MessageListVisibility = l.MessageListVisibility
+	}
 	if target.Name != l.Name {
 		delete(g.labelNames, target.Name)
 		g.labelNames.Add(l.Name)
@@ -320,6 +356,15 @@ func (g *gmail) DeleteFilter(id string) error {
 	return nil
 }
 
+// normalizeColor stores colors in lowercase, like Gmail does.
+func normalizeColor(c *gmailv1.LabelColor) {
+	if c == nil {
+		return
+	}
+	c.BackgroundColor = strings.ToLower(c.BackgroundColor)
+	c.TextColor = strings.ToLower(c.TextColor)
+}
+
 type statusError struct {
 	StatusCode int
 	Err        error
@@ -32,6 +32,13 @@ func TestLabels(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, ls, 2)
 
+	// Count messages.
+	n, err := api.CountLabelMessages(ls[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+	_, err = api.CountLabelMessages("missing")
+	assert.NotNil(t, err)
+
 	// Add duplicate.
 	err = api.AddLabels(label.Labels{{Name: "Label2"}})
 	assert.NotNil(t, err)